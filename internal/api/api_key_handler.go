@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"attachment-service/internal/auth"
+	"attachment-service/internal/models"
+	"attachment-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type APIKeyHandler struct {
+	keys *service.APIKeyService
+}
+
+// RegisterAPIKeyRoutes lets workspace admins manage the workspace's API
+// keys. Keys cannot manage keys.
+func RegisterAPIKeyRoutes(router *gin.Engine, keys *service.APIKeyService) {
+	h := &APIKeyHandler{keys: keys}
+
+	api := router.Group("/api/v1/api-keys", rejectAPIKeys, requireWorkspaceAdmin(""))
+	{
+		api.POST("", h.CreateKey)
+		api.GET("", h.ListKeys)
+		api.GET("/:keyId", h.GetKey)
+		api.DELETE("/:keyId", h.RevokeKey)
+		api.POST("/:keyId/rotate", h.RotateKey)
+		api.GET("/:keyId/audit", h.ListAudit)
+	}
+}
+
+// AuditAPIKeys records every request made with an API key once it has been
+// handled. It must run after the auth middleware.
+func AuditAPIKeys(keys *service.APIKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		id, ok := auth.FromContext(c.Request.Context())
+		if !ok || !id.IsAPIKey() {
+			return
+		}
+		keys.Audit(context.WithoutCancel(c.Request.Context()), &models.APIKeyAudit{
+			KeyID:       id.APIKeyID,
+			WorkspaceID: id.WorkspaceID,
+			Method:      c.Request.Method,
+			Route:       c.FullPath(),
+			Path:        c.Request.URL.Path,
+			Status:      c.Writer.Status(),
+			IP:          c.ClientIP(),
+		})
+	}
+}
+
+func rejectAPIKeys(c *gin.Context) {
+	if auth.FromGin(c).IsAPIKey() {
+		forbid(c, "API keys cannot manage API keys")
+		return
+	}
+	c.Next()
+}
+
+func (h *APIKeyHandler) CreateKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	key, err := h.keys.Create(c.Request.Context(), requestWorkspace(c, ""), getUserID(c), &req)
+	if err != nil {
+		respondAPIKeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": key})
+}
+
+func (h *APIKeyHandler) ListKeys(c *gin.Context) {
+	keys, err := h.keys.List(c.Request.Context(), requestWorkspace(c, ""))
+	if err != nil {
+		respondAPIKeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": keys})
+}
+
+func (h *APIKeyHandler) GetKey(c *gin.Context) {
+	key, err := h.keys.Get(c.Request.Context(), requestWorkspace(c, ""), c.Param("keyId"))
+	if err != nil {
+		respondAPIKeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": key})
+}
+
+func (h *APIKeyHandler) RevokeKey(c *gin.Context) {
+	if err := h.keys.Revoke(c.Request.Context(), requestWorkspace(c, ""), c.Param("keyId"), getUserID(c)); err != nil {
+		respondAPIKeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// RotateKey issues a replacement key. The old key keeps working for the
+// configured grace period.
+func (h *APIKeyHandler) RotateKey(c *gin.Context) {
+	key, err := h.keys.Rotate(c.Request.Context(), requestWorkspace(c, ""), c.Param("keyId"), getUserID(c))
+	if err != nil {
+		respondAPIKeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": key})
+}
+
+func (h *APIKeyHandler) ListAudit(c *gin.Context) {
+	entries, err := h.keys.ListAudit(c.Request.Context(), requestWorkspace(c, ""), c.Param("keyId"), getLimit(c), getOffset(c))
+	if err != nil {
+		respondAPIKeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": entries})
+}
+
+func respondAPIKeyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, primitive.ErrInvalidHex):
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+	case errors.Is(err, service.ErrAPIKeyExpiry):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAPIKeyInactive):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"attachment-service/internal/auth"
+	"attachment-service/internal/models"
+	"attachment-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	attachmentKey = "attachment"
+	accessKey     = "access"
+)
+
+// respondAuthzError maps lookup and authorization failures to 404, 410 or
+// 403.
+func respondAuthzError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, primitive.ErrInvalidHex):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+	case errors.Is(err, service.ErrExpired):
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrForbidden):
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func forbid(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": message})
+}
+
+// requireAttachment loads the :id attachment and checks the caller may
+// perform action on it. Handlers read it back with loadedAttachment.
+func requireAttachment(authz *service.Authorizer, action models.AccessAction) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		att, decision, err := authz.Load(c.Request.Context(), auth.FromGin(c), c.Param("id"), action)
+		if err != nil {
+			respondAuthzError(c, err)
+			return
+		}
+		c.Set(attachmentKey, att)
+		c.Set(accessKey, decision)
+		c.Next()
+	}
+}
+
+func loadedAttachment(c *gin.Context) *models.Attachment {
+	return c.MustGet(attachmentKey).(*models.Attachment)
+}
+
+func loadedAccess(c *gin.Context) *models.AccessDecision {
+	return c.MustGet(accessKey).(*models.AccessDecision)
+}
+
+// isPrivileged reports whether the caller is a global admin or service.
+func isPrivileged(id *auth.Identity) bool {
+	return id.HasRole(auth.RoleAdmin) || id.HasRole(auth.RoleService)
+}
+
+// requireSelf allows only the user named by the route parameter, admins and
+// service callers.
+func requireSelf(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := auth.FromGin(c)
+		if c.Param(param) != id.UserID && !isPrivileged(id) {
+			forbid(c, "cannot access another user's data")
+			return
+		}
+		c.Next()
+	}
+}
+
+// requestWorkspace returns the workspace named by the route parameter, the
+// workspace_id query or the caller's current workspace, in that order.
+func requestWorkspace(c *gin.Context, param string) string {
+	if param != "" {
+		if ws := c.Param(param); ws != "" {
+			return ws
+		}
+	}
+	if ws := c.Query("workspace_id"); ws != "" {
+		return ws
+	}
+	return getWorkspaceID(c)
+}
+
+// requireWorkspaceMember allows members of the request workspace and
+// service callers, and scopes the request to that workspace.
+func requireWorkspaceMember(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := auth.FromGin(c)
+		ws := requestWorkspace(c, param)
+		if ws == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "workspace_id is required"})
+			return
+		}
+		if !id.IsMember(ws) && !id.HasRole(auth.RoleService) {
+			forbid(c, "not a member of workspace "+ws)
+			return
+		}
+		scopeTo(c, ws)
+		c.Next()
+	}
+}
+
+// requireWorkspaceAdmin allows admins of the request workspace and scopes
+// the request to it.
+func requireWorkspaceAdmin(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := auth.FromGin(c)
+		ws := requestWorkspace(c, param)
+		if ws == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "workspace_id is required"})
+			return
+		}
+		if !id.IsWorkspaceAdmin(ws) {
+			forbid(c, "workspace admin role required for "+ws)
+			return
+		}
+		scopeTo(c, ws)
+		c.Next()
+	}
+}
+
+func requireAdmin(c *gin.Context) {
+	if !auth.FromGin(c).HasRole(auth.RoleAdmin) {
+		forbid(c, "admin role required")
+		return
+	}
+	c.Next()
+}
+
+// canManage reports whether the caller created a workspace resource or
+// administers its workspace, responding 403 if not.
+func canManage(c *gin.Context, createdBy, workspaceID string) bool {
+	id := auth.FromGin(c)
+	if createdBy == id.UserID || id.IsWorkspaceAdmin(workspaceID) {
+		return true
+	}
+	forbid(c, "only the creator or a workspace admin may change this")
+	return false
+}
+
+// authorizeAll checks the caller may perform action on every id, responding
+// 403 with the offending ids if not.
+func authorizeAll(c *gin.Context, authz *service.Authorizer, ids []string, action models.AccessAction) bool {
+	forbidden, err := authz.Forbidden(c.Request.Context(), auth.FromGin(c), ids, action)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if len(forbidden) > 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed to " + string(action) + " some attachments", "forbidden_ids": forbidden})
+		return false
+	}
+	return true
+}
+
+// visibleIDs returns which of the attachment ids the caller may view.
+func visibleIDs(c *gin.Context, authz *service.Authorizer, ids []string) (map[string]bool, bool) {
+	visible, err := authz.Allowed(c.Request.Context(), auth.FromGin(c), ids, models.AccessView)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return visible, true
+}
+
+// filterVisible drops the attachments the caller may not view.
+func filterVisible(c *gin.Context, authz *service.Authorizer, attachments []*models.Attachment) ([]*models.Attachment, bool) {
+	visible, err := authz.FilterVisible(c.Request.Context(), auth.FromGin(c), attachments)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return visible, true
+}
@@ -1,57 +1,68 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	"attachment-service/internal/auth"
+	"attachment-service/internal/config"
 	"attachment-service/internal/models"
 	"attachment-service/internal/repository"
+	"attachment-service/internal/service"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type ExtendedHandler struct {
 	extRepo *repository.ExtendedRepository
+	authz   *service.Authorizer
+	holds   *service.LegalHoldService
+	cfg     *config.Config
 }
 
-func RegisterExtendedRoutes(router *gin.Engine, extRepo *repository.ExtendedRepository) {
-	h := &ExtendedHandler{extRepo: extRepo}
+func RegisterExtendedRoutes(router *gin.Engine, extRepo *repository.ExtendedRepository, authz *service.Authorizer, holds *service.LegalHoldService, cfg *config.Config) {
+	h := &ExtendedHandler{extRepo: extRepo, authz: authz, holds: holds, cfg: cfg}
 
 	api := router.Group("/api/v1")
 	{
 		// Versions
-		api.GET("/attachments/:id/versions", h.ListVersions)
-		api.GET("/attachments/:id/versions/:versionNum", h.GetVersion)
-		api.DELETE("/attachments/:id/versions/:versionId", h.DeleteVersion)
+		api.GET("/attachments/:id/versions", requireAttachment(authz, models.AccessView), h.ListVersions)
+		api.GET("/attachments/:id/versions/:versionNum", requireAttachment(authz, models.AccessView), h.GetVersion)
+		api.DELETE("/attachments/:id/versions/:versionId", requireAttachment(authz, models.AccessEdit), h.DeleteVersion)
 
 		// Comments
-		api.POST("/attachments/:id/comments", h.CreateComment)
-		api.GET("/attachments/:id/comments", h.ListComments)
-		api.PUT("/attachments/:id/comments/:commentId", h.UpdateComment)
-		api.DELETE("/attachments/:id/comments/:commentId", h.DeleteComment)
+		api.POST("/attachments/:id/comments", requireAttachment(authz, models.AccessView), h.CreateComment)
+		api.GET("/attachments/:id/comments", requireAttachment(authz, models.AccessView), h.ListComments)
+		api.PUT("/attachments/:id/comments/:commentId", requireAttachment(authz, models.AccessView), h.UpdateComment)
+		api.DELETE("/attachments/:id/comments/:commentId", requireAttachment(authz, models.AccessView), h.DeleteComment)
 
 		// Tags
-		api.POST("/attachments/:id/tags", h.AddTag)
-		api.DELETE("/attachments/:id/tags/:tag", h.RemoveTag)
-		api.GET("/attachments/:id/tags", h.ListTags)
+		api.POST("/attachments/:id/tags", requireAttachment(authz, models.AccessEdit), h.AddTag)
+		api.DELETE("/attachments/:id/tags/:tag", requireAttachment(authz, models.AccessEdit), h.RemoveTag)
+		api.GET("/attachments/:id/tags", requireAttachment(authz, models.AccessView), h.ListTags)
 		api.GET("/tags/:tag/attachments", h.SearchByTag)
 
 		// Favorites
-		api.POST("/attachments/:id/favorite", h.AddFavorite)
-		api.DELETE("/attachments/:id/favorite", h.RemoveFavorite)
-		api.GET("/users/:user_id/favorites", h.ListFavorites)
-		api.GET("/attachments/:id/favorited", h.IsFavorited)
+		api.POST("/attachments/:id/favorite", requireAttachment(authz, models.AccessView), h.AddFavorite)
+		api.DELETE("/attachments/:id/favorite", requireAttachment(authz, models.AccessView), h.RemoveFavorite)
+		api.GET("/users/:user_id/favorites", requireSelf("user_id"), h.ListFavorites)
+		api.GET("/attachments/:id/favorited", requireAttachment(authz, models.AccessView), h.IsFavorited)
 
 		// Shares
-		api.POST("/attachments/:id/shares", h.CreateShare)
-		api.GET("/attachments/:id/shares", h.ListShares)
-		api.GET("/users/:user_id/shared", h.ListSharedWith)
+		api.POST("/attachments/:id/shares", requireAttachment(authz, models.AccessShare), h.CreateShare)
+		api.GET("/attachments/:id/shares", requireAttachment(authz, models.AccessShare), h.ListShares)
+		api.GET("/users/:user_id/shared", requireSelf("user_id"), h.ListSharedWith)
 		api.DELETE("/shares/:shareId", h.DeleteShare)
 
 		// Collections
-		api.POST("/collections", h.CreateCollection)
-		api.GET("/collections", h.ListCollections)
+		api.POST("/collections", requireWorkspaceMember(""), h.CreateCollection)
+		api.GET("/collections", requireWorkspaceMember(""), h.ListCollections)
 		api.GET("/collections/:collectionId", h.GetCollection)
 		api.PUT("/collections/:collectionId", h.UpdateCollection)
 		api.DELETE("/collections/:collectionId", h.DeleteCollection)
@@ -60,33 +71,29 @@ func RegisterExtendedRoutes(router *gin.Engine, extRepo *repository.ExtendedRepo
 		api.GET("/collections/:collectionId/items", h.ListCollectionItems)
 
 		// Activity
-		api.GET("/attachments/:id/activity", h.ListActivity)
-		api.GET("/users/:user_id/activity", h.ListUserActivity)
+		api.GET("/attachments/:id/activity", requireAttachment(authz, models.AccessShare), h.ListActivity)
+		api.GET("/users/:user_id/activity", requireSelf("user_id"), h.ListUserActivity)
 
 		// Permissions
-		api.POST("/attachments/:id/permissions", h.SetPermission)
-		api.GET("/attachments/:id/permissions", h.ListPermissions)
-		api.DELETE("/attachments/:id/permissions/:userId", h.DeletePermission)
+		api.POST("/attachments/:id/permissions", requireAttachment(authz, models.AccessShare), h.SetPermission)
+		api.GET("/attachments/:id/permissions", requireAttachment(authz, models.AccessShare), h.ListPermissions)
+		api.DELETE("/attachments/:id/permissions/:userId", requireAttachment(authz, models.AccessShare), h.DeletePermission)
 
 		// Share links
-		api.POST("/attachments/:id/share-links", h.CreateShareLink)
-		api.GET("/attachments/:id/share-links", h.ListShareLinks)
-		api.GET("/share-links/:code", h.GetShareLink)
-		api.DELETE("/share-links/:linkId", h.DeactivateShareLink)
+		api.GET("/attachments/:id/share-links", requireAttachment(authz, models.AccessShare), h.ListShareLinks)
 
 		// Scans
-		api.GET("/attachments/:id/scan", h.GetScanResult)
+		api.GET("/attachments/:id/scan", requireAttachment(authz, models.AccessView), h.GetScanResult)
 
 		// Previews
-		api.GET("/attachments/:id/previews", h.ListPreviews)
+		api.GET("/attachments/:id/previews", requireAttachment(authz, models.AccessView), h.ListPreviews)
 
 		// Stats & Search
-		api.GET("/workspaces/:workspace_id/stats", h.GetWorkspaceStats)
-		api.GET("/workspaces/:workspace_id/attachment-stats", h.GetAttachmentStats)
-		api.GET("/users/:user_id/quota", h.GetUserQuota)
-		api.GET("/search", h.SearchAttachments)
-		api.GET("/users/:user_id/recent", h.GetRecentAttachments)
-		api.GET("/workspaces/:workspace_id/attachments", h.GetByWorkspaceID)
+		api.GET("/workspaces/:workspace_id/stats", requireWorkspaceMember("workspace_id"), h.GetWorkspaceStats)
+		api.GET("/workspaces/:workspace_id/attachment-stats", requireWorkspaceMember("workspace_id"), h.GetAttachmentStats)
+		api.GET("/search", requireWorkspaceMember(""), h.SearchAttachments)
+		api.GET("/users/:user_id/recent", requireSelf("user_id"), h.GetRecentAttachments)
+		api.GET("/workspaces/:workspace_id/attachments", requireWorkspaceMember("workspace_id"), h.GetByWorkspaceID)
 
 		// Bulk operations
 		api.POST("/bulk/delete", h.BulkDelete)
@@ -94,9 +101,9 @@ func RegisterExtendedRoutes(router *gin.Engine, extRepo *repository.ExtendedRepo
 		api.POST("/bulk/tag", h.BulkTag)
 
 		// Individual operations
-		api.PUT("/attachments/:id/rename", h.RenameAttachment)
-		api.PUT("/attachments/:id/move", h.MoveAttachment)
-		api.POST("/attachments/:id/clone", h.CloneAttachment)
+		api.PUT("/attachments/:id/rename", requireAttachment(authz, models.AccessEdit), h.RenameAttachment)
+		api.PUT("/attachments/:id/move", requireAttachment(authz, models.AccessEdit), h.MoveAttachment)
+		api.POST("/attachments/:id/clone", requireAttachment(authz, models.AccessDownload), h.CloneAttachment)
 	}
 }
 
@@ -116,8 +123,14 @@ func getOffset(c *gin.Context) int {
 	return o
 }
 
+// getUserID returns the authenticated caller's user ID.
 func getUserID(c *gin.Context) string {
-	return c.GetHeader("X-User-ID")
+	return auth.FromGin(c).UserID
+}
+
+// getWorkspaceID returns the workspace the caller is acting in.
+func getWorkspaceID(c *gin.Context) string {
+	return auth.FromGin(c).WorkspaceID
 }
 
 // ── Versions ──
@@ -142,7 +155,15 @@ func (h *ExtendedHandler) GetVersion(c *gin.Context) {
 }
 
 func (h *ExtendedHandler) DeleteVersion(c *gin.Context) {
-	if err := h.extRepo.DeleteVersion(c.Request.Context(), c.Param("versionId")); err != nil {
+	if err := h.holds.Permit(c.Request.Context(), models.HoldOpVersionDelete, "version "+c.Param("versionId"), loadedAttachment(c)); err != nil {
+		respondHoldError(c, err)
+		return
+	}
+	if err := h.extRepo.DeleteVersion(c.Request.Context(), c.Param("id"), c.Param("versionId")); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -158,12 +179,19 @@ func (h *ExtendedHandler) CreateComment(c *gin.Context) {
 		return
 	}
 	comment := &models.AttachmentComment{
+		ID:           primitive.NewObjectID(),
 		AttachmentID: c.Param("id"),
 		UserID:       getUserID(c),
 		Content:      req.Content,
 		ParentID:     req.ParentID,
 	}
-	if err := h.extRepo.CreateComment(c.Request.Context(), comment); err != nil {
+	ctx := service.WithEvents(c.Request.Context(), &models.AttachmentCommented{
+		AttachmentRef: models.RefOf(loadedAttachment(c)),
+		CommentID:     comment.ID.Hex(),
+		UserID:        comment.UserID,
+		ParentID:      comment.ParentID,
+	})
+	if err := h.extRepo.CreateComment(ctx, comment); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -185,6 +213,9 @@ func (h *ExtendedHandler) UpdateComment(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !h.canEditComment(c) {
+		return
+	}
 	if err := h.extRepo.UpdateComment(c.Request.Context(), c.Param("commentId"), req.Content); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -193,6 +224,9 @@ func (h *ExtendedHandler) UpdateComment(c *gin.Context) {
 }
 
 func (h *ExtendedHandler) DeleteComment(c *gin.Context) {
+	if !h.canEditComment(c) {
+		return
+	}
 	if err := h.extRepo.DeleteComment(c.Request.Context(), c.Param("commentId")); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -200,6 +234,22 @@ func (h *ExtendedHandler) DeleteComment(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// canEditComment allows the comment's author and workspace admins to change
+// a comment on the loaded attachment.
+func (h *ExtendedHandler) canEditComment(c *gin.Context) bool {
+	comment, err := h.extRepo.GetComment(c.Request.Context(), c.Param("commentId"))
+	if err != nil || comment.AttachmentID != c.Param("id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return false
+	}
+	id := auth.FromGin(c)
+	if comment.UserID != id.UserID && !id.IsWorkspaceAdmin(loadedAttachment(c).WorkspaceID) {
+		forbid(c, "only the author or a workspace admin may change a comment")
+		return false
+	}
+	return true
+}
+
 // ── Tags ──
 
 func (h *ExtendedHandler) AddTag(c *gin.Context) {
@@ -243,6 +293,15 @@ func (h *ExtendedHandler) SearchByTag(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	ids := make([]string, len(tags))
+	for i, t := range tags {
+		ids[i] = t.AttachmentID
+	}
+	visible, ok := visibleIDs(c, h.authz, ids)
+	if !ok {
+		return
+	}
+	tags = slices.DeleteFunc(tags, func(t *models.AttachmentTag) bool { return !visible[t.AttachmentID] })
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": tags})
 }
 
@@ -294,13 +353,37 @@ func (h *ExtendedHandler) CreateShare(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// Sharing can't grant more than the sharer has
+	for _, action := range models.ShareActions(req.Permission) {
+		if !loadedAccess(c).Allows(action) {
+			forbid(c, "cannot share with more access than you have")
+			return
+		}
+	}
 	share := &models.AttachmentShare{
+		ID:           primitive.NewObjectID(),
 		AttachmentID: c.Param("id"),
 		SharedBy:     getUserID(c),
 		SharedWith:   req.SharedWith,
 		Permission:   req.Permission,
 	}
-	if err := h.extRepo.CreateShare(c.Request.Context(), share); err != nil {
+	if req.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil || !expiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be a future RFC 3339 time"})
+			return
+		}
+		share.ExpiresAt = &expiresAt
+	}
+	ctx := service.WithEvents(c.Request.Context(), &models.AttachmentShared{
+		AttachmentRef: models.RefOf(loadedAttachment(c)),
+		Kind:          models.ShareKindUser,
+		ShareID:       share.ID.Hex(),
+		SharedWith:    share.SharedWith,
+		Permission:    share.Permission,
+		ExpiresAt:     share.ExpiresAt,
+	})
+	if err := h.extRepo.CreateShare(ctx, share); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -326,6 +409,17 @@ func (h *ExtendedHandler) ListSharedWith(c *gin.Context) {
 }
 
 func (h *ExtendedHandler) DeleteShare(c *gin.Context) {
+	share, err := h.extRepo.GetShare(c.Request.Context(), c.Param("shareId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share not found"})
+		return
+	}
+	if share.SharedBy != getUserID(c) {
+		if _, _, err := h.authz.Load(c.Request.Context(), auth.FromGin(c), share.AttachmentID, models.AccessShare); err != nil {
+			respondAuthzError(c, err)
+			return
+		}
+	}
 	if err := h.extRepo.DeleteShare(c.Request.Context(), c.Param("shareId")); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -344,7 +438,7 @@ func (h *ExtendedHandler) CreateCollection(c *gin.Context) {
 	coll := &models.AttachmentCollection{
 		Name:        req.Name,
 		Description: req.Description,
-		WorkspaceID: c.GetHeader("X-Workspace-ID"),
+		WorkspaceID: requestWorkspace(c, ""),
 		CreatedBy:   getUserID(c),
 		IsPublic:    req.IsPublic,
 	}
@@ -356,11 +450,7 @@ func (h *ExtendedHandler) CreateCollection(c *gin.Context) {
 }
 
 func (h *ExtendedHandler) ListCollections(c *gin.Context) {
-	wsID := c.Query("workspace_id")
-	if wsID == "" {
-		wsID = c.GetHeader("X-Workspace-ID")
-	}
-	colls, err := h.extRepo.ListCollections(c.Request.Context(), wsID, getLimit(c), getOffset(c))
+	colls, err := h.extRepo.ListCollections(c.Request.Context(), requestWorkspace(c, ""), getLimit(c), getOffset(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -368,10 +458,28 @@ func (h *ExtendedHandler) ListCollections(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": colls})
 }
 
-func (h *ExtendedHandler) GetCollection(c *gin.Context) {
+// loadCollection fetches the :collectionId collection if the caller belongs
+// to its workspace and, when manage is set, may change it.
+func (h *ExtendedHandler) loadCollection(c *gin.Context, manage bool) (*models.AttachmentCollection, bool) {
 	coll, err := h.extRepo.GetCollection(c.Request.Context(), c.Param("collectionId"))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+		return nil, false
+	}
+	id := auth.FromGin(c)
+	if !id.IsMember(coll.WorkspaceID) && !id.HasRole(auth.RoleService) {
+		forbid(c, "not a member of workspace "+coll.WorkspaceID)
+		return nil, false
+	}
+	if manage && !canManage(c, coll.CreatedBy, coll.WorkspaceID) {
+		return nil, false
+	}
+	return coll, true
+}
+
+func (h *ExtendedHandler) GetCollection(c *gin.Context) {
+	coll, ok := h.loadCollection(c, false)
+	if !ok {
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": coll})
@@ -383,6 +491,9 @@ func (h *ExtendedHandler) UpdateCollection(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if _, ok := h.loadCollection(c, true); !ok {
+		return
+	}
 	update := make(map[string]interface{})
 	if req.Name != "" {
 		update["name"] = req.Name
@@ -401,6 +512,9 @@ func (h *ExtendedHandler) UpdateCollection(c *gin.Context) {
 }
 
 func (h *ExtendedHandler) DeleteCollection(c *gin.Context) {
+	if _, ok := h.loadCollection(c, true); !ok {
+		return
+	}
 	if err := h.extRepo.DeleteCollection(c.Request.Context(), c.Param("collectionId")); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -414,6 +528,13 @@ func (h *ExtendedHandler) AddToCollection(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if _, ok := h.loadCollection(c, true); !ok {
+		return
+	}
+	if _, _, err := h.authz.Load(c.Request.Context(), auth.FromGin(c), req.AttachmentID, models.AccessView); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
 	item := &models.CollectionItem{
 		CollectionID: c.Param("collectionId"),
 		AttachmentID: req.AttachmentID,
@@ -427,6 +548,9 @@ func (h *ExtendedHandler) AddToCollection(c *gin.Context) {
 }
 
 func (h *ExtendedHandler) RemoveFromCollection(c *gin.Context) {
+	if _, ok := h.loadCollection(c, true); !ok {
+		return
+	}
 	if err := h.extRepo.RemoveFromCollection(c.Request.Context(), c.Param("collectionId"), c.Param("attachmentId")); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -435,11 +559,23 @@ func (h *ExtendedHandler) RemoveFromCollection(c *gin.Context) {
 }
 
 func (h *ExtendedHandler) ListCollectionItems(c *gin.Context) {
+	if _, ok := h.loadCollection(c, false); !ok {
+		return
+	}
 	items, err := h.extRepo.ListCollectionItems(c.Request.Context(), c.Param("collectionId"), getLimit(c), getOffset(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.AttachmentID
+	}
+	visible, ok := visibleIDs(c, h.authz, ids)
+	if !ok {
+		return
+	}
+	items = slices.DeleteFunc(items, func(item *models.CollectionItem) bool { return !visible[item.AttachmentID] })
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": items})
 }
 
@@ -471,6 +607,12 @@ func (h *ExtendedHandler) SetPermission(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// Granting can't exceed what the granter has
+	access := loadedAccess(c)
+	if (req.CanView && !access.CanView) || (req.CanDownload && !access.CanDownload) || (req.CanDelete && !access.CanDelete) {
+		forbid(c, "cannot grant more access than you have")
+		return
+	}
 	perm := &models.AttachmentPermission{
 		AttachmentID: c.Param("id"),
 		UserID:       req.UserID,
@@ -480,7 +622,17 @@ func (h *ExtendedHandler) SetPermission(c *gin.Context) {
 		CanShare:     req.CanShare,
 		GrantedBy:    getUserID(c),
 	}
-	if err := h.extRepo.SetPermission(c.Request.Context(), perm); err != nil {
+	var granted []string
+	for _, action := range models.PermissionActions(perm) {
+		granted = append(granted, string(action))
+	}
+	ctx := service.WithEvents(c.Request.Context(), &models.AttachmentShared{
+		AttachmentRef: models.RefOf(loadedAttachment(c)),
+		Kind:          models.ShareKindPermission,
+		SharedWith:    perm.UserID,
+		Permission:    strings.Join(granted, ","),
+	})
+	if err := h.extRepo.SetPermission(ctx, perm); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -506,26 +658,6 @@ func (h *ExtendedHandler) DeletePermission(c *gin.Context) {
 
 // ── Share Links ──
 
-func (h *ExtendedHandler) CreateShareLink(c *gin.Context) {
-	var req models.CreateShareLinkRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	link := &models.ShareLink{
-		AttachmentID: c.Param("id"),
-		Code:         uuid.New().String()[:8],
-		CreatedBy:    getUserID(c),
-		Password:     req.Password,
-		MaxDownloads: req.MaxDownloads,
-	}
-	if err := h.extRepo.CreateShareLink(c.Request.Context(), link); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusCreated, gin.H{"success": true, "data": link})
-}
-
 func (h *ExtendedHandler) ListShareLinks(c *gin.Context) {
 	links, err := h.extRepo.ListShareLinks(c.Request.Context(), c.Param("id"))
 	if err != nil {
@@ -535,23 +667,6 @@ func (h *ExtendedHandler) ListShareLinks(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": links})
 }
 
-func (h *ExtendedHandler) GetShareLink(c *gin.Context) {
-	link, err := h.extRepo.GetShareLinkByCode(c.Request.Context(), c.Param("code"))
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": link})
-}
-
-func (h *ExtendedHandler) DeactivateShareLink(c *gin.Context) {
-	if err := h.extRepo.DeactivateShareLink(c.Request.Context(), c.Param("linkId")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"success": true})
-}
-
 // ── Scans ──
 
 func (h *ExtendedHandler) GetScanResult(c *gin.Context) {
@@ -594,28 +709,19 @@ func (h *ExtendedHandler) GetWorkspaceStats(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": stats})
 }
 
-func (h *ExtendedHandler) GetUserQuota(c *gin.Context) {
-	quota, err := h.extRepo.GetUserQuota(c.Request.Context(), c.Param("user_id"))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": quota})
-}
-
 func (h *ExtendedHandler) SearchAttachments(c *gin.Context) {
-	wsID := c.Query("workspace_id")
-	if wsID == "" {
-		wsID = c.GetHeader("X-Workspace-ID")
-	}
 	query := c.Query("q")
 	fileType := c.Query("type")
 
-	results, err := h.extRepo.SearchAttachments(c.Request.Context(), wsID, query, fileType, getLimit(c), getOffset(c))
+	results, err := h.extRepo.SearchAttachments(c.Request.Context(), requestWorkspace(c, ""), query, fileType, getLimit(c), getOffset(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	results, ok := filterVisible(c, h.authz, results)
+	if !ok {
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": results})
 }
 
@@ -634,6 +740,10 @@ func (h *ExtendedHandler) GetByWorkspaceID(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	results, ok := filterVisible(c, h.authz, results)
+	if !ok {
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": results})
 }
 
@@ -645,7 +755,26 @@ func (h *ExtendedHandler) BulkDelete(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.extRepo.BulkDelete(c.Request.Context(), req.IDs); err != nil {
+	if !authorizeAll(c, h.authz, req.IDs, models.AccessDelete) {
+		return
+	}
+	attachments, err := h.extRepo.FindAttachmentsByIDs(c.Request.Context(), req.IDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.holds.Permit(c.Request.Context(), models.HoldOpBulkDelete, "", attachments...); err != nil {
+		respondHoldError(c, err)
+		return
+	}
+	var events []models.EventData
+	for _, a := range attachments {
+		if a.Status != models.StatusDeleted {
+			events = append(events, service.DeletedEvent(a))
+		}
+	}
+	purgeAt := time.Now().Add(h.cfg.TrashRetention)
+	if err := h.extRepo.BulkDelete(service.WithEvents(c.Request.Context(), events...), req.IDs, getUserID(c), purgeAt); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -658,7 +787,39 @@ func (h *ExtendedHandler) BulkMove(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.extRepo.BulkMove(c.Request.Context(), req.IDs, req.ChannelID); err != nil {
+	if !authorizeAll(c, h.authz, req.IDs, models.AccessEdit) {
+		return
+	}
+	attachments, err := h.extRepo.FindAttachmentsByIDs(c.Request.Context(), req.IDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// The channel is checked in the attachments' workspace, so they must
+	// all be in the same one
+	workspaceID := ""
+	for _, a := range attachments {
+		if workspaceID != "" && a.WorkspaceID != workspaceID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "attachments must all be in the same workspace"})
+			return
+		}
+		workspaceID = a.WorkspaceID
+	}
+	if err := h.authz.ChannelAccess(c.Request.Context(), auth.FromGin(c), workspaceID, req.ChannelID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+	var events []models.EventData
+	for _, a := range attachments {
+		events = append(events, &models.AttachmentMoved{
+			AttachmentRef: models.RefOf(a),
+			FromChannelID: a.ChannelID,
+			ToChannelID:   req.ChannelID,
+			FromMessageID: a.MessageID,
+			ToMessageID:   a.MessageID,
+		})
+	}
+	if err := h.extRepo.BulkMove(service.WithEvents(c.Request.Context(), events...), req.IDs, req.ChannelID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -671,15 +832,27 @@ func (h *ExtendedHandler) BulkTag(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !authorizeAll(c, h.authz, req.IDs, models.AccessEdit) {
+		return
+	}
+	failed := map[string]string{}
 	for _, id := range req.IDs {
 		tag := &models.AttachmentTag{
 			AttachmentID: id,
 			Tag:          req.Tag,
 			AddedBy:      getUserID(c),
 		}
-		_ = h.extRepo.AddTag(c.Request.Context(), tag)
+		// Attachments that already have the tag count as tagged
+		if err := h.extRepo.AddTag(c.Request.Context(), tag); err != nil && !mongo.IsDuplicateKeyError(err) {
+			failed[id] = err.Error()
+		}
+	}
+	tagged := len(req.IDs) - len(failed)
+	if len(failed) > 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to tag some attachments", "tagged": tagged, "failed": failed})
+		return
 	}
-	c.JSON(http.StatusOK, gin.H{"success": true, "tagged": len(req.IDs)})
+	c.JSON(http.StatusOK, gin.H{"success": true, "tagged": tagged})
 }
 
 // ── Individual Operations ──
@@ -690,7 +863,13 @@ func (h *ExtendedHandler) RenameAttachment(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.extRepo.RenameAttachment(c.Request.Context(), c.Param("id"), req.NewName); err != nil {
+	att := loadedAttachment(c)
+	ctx := service.WithEvents(c.Request.Context(), &models.AttachmentRenamed{
+		AttachmentRef: models.RefOf(att),
+		OldName:       att.OriginalName,
+		NewName:       req.NewName,
+	})
+	if err := h.extRepo.RenameAttachment(ctx, c.Param("id"), req.NewName); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -703,7 +882,22 @@ func (h *ExtendedHandler) MoveAttachment(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.extRepo.MoveAttachment(c.Request.Context(), c.Param("id"), req.ChannelID, req.MessageID); err != nil {
+	att := loadedAttachment(c)
+	if err := h.authz.ChannelAccess(c.Request.Context(), auth.FromGin(c), att.WorkspaceID, req.ChannelID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+	moved := &models.AttachmentMoved{
+		AttachmentRef: models.RefOf(att),
+		FromChannelID: att.ChannelID,
+		ToChannelID:   req.ChannelID,
+		FromMessageID: att.MessageID,
+		ToMessageID:   att.MessageID,
+	}
+	if req.MessageID != "" {
+		moved.ToMessageID = req.MessageID
+	}
+	if err := h.extRepo.MoveAttachment(service.WithEvents(c.Request.Context(), moved), c.Param("id"), req.ChannelID, req.MessageID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -711,6 +905,15 @@ func (h *ExtendedHandler) MoveAttachment(c *gin.Context) {
 }
 
 func (h *ExtendedHandler) CloneAttachment(c *gin.Context) {
+	var req models.CloneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.authz.ChannelAccess(c.Request.Context(), auth.FromGin(c), loadedAttachment(c).WorkspaceID, req.ChannelID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
 	// This would need the base service to get the original attachment
 	// For now, return a placeholder
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Clone initiated"})
@@ -1,11 +1,15 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"slices"
 	"time"
 
+	"attachment-service/internal/auth"
 	"attachment-service/internal/models"
 	"attachment-service/internal/repository"
+	"attachment-service/internal/service"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -95,81 +99,60 @@ type AttachmentExport struct {
 	CompletedAt *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
 }
 
-type AttachmentRetention struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	WorkspaceID string             `bson:"workspace_id" json:"workspace_id"`
-	MimeType    string             `bson:"mime_type" json:"mime_type"`
-	MaxAgeDays  int                `bson:"max_age_days" json:"max_age_days"`
-	Action      string             `bson:"action" json:"action"`
-	IsActive    bool               `bson:"is_active" json:"is_active"`
-	CreatedBy   string             `bson:"created_by" json:"created_by"`
-	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
-}
-
-type AttachmentWebhook struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	WorkspaceID string             `bson:"workspace_id" json:"workspace_id"`
-	Name        string             `bson:"name" json:"name"`
-	URL         string             `bson:"url" json:"url"`
-	Events      []string           `bson:"events" json:"events"`
-	IsActive    bool               `bson:"is_active" json:"is_active"`
-	Secret      string             `bson:"secret" json:"secret"`
-	CreatedBy   string             `bson:"created_by" json:"created_by"`
-	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
-}
-
 // ── Extended Handler 2 ──
 
 type ExtendedHandler2 struct {
-	extRepo *repository.ExtendedRepository
-	db      *mongo.Database
+	extRepo   *repository.ExtendedRepository
+	authz     *service.Authorizer
+	db        *mongo.Database
+	webhooks  *service.WebhookDispatcher
+	retention *service.RetentionEnforcer
 }
 
-func RegisterExtendedRoutes2(router *gin.Engine, extRepo *repository.ExtendedRepository, db *mongo.Database) {
-	h := &ExtendedHandler2{extRepo: extRepo, db: db}
+func RegisterExtendedRoutes2(router *gin.Engine, extRepo *repository.ExtendedRepository, authz *service.Authorizer, db *mongo.Database, webhooks *service.WebhookDispatcher, retention *service.RetentionEnforcer) {
+	h := &ExtendedHandler2{extRepo: extRepo, authz: authz, db: db, webhooks: webhooks, retention: retention}
 
 	api := router.Group("/api/v1")
 	{
 		// Watchers
-		api.POST("/attachments/:id/watchers", h.AddWatcher)
-		api.DELETE("/attachments/:id/watchers", h.RemoveWatcher)
-		api.GET("/attachments/:id/watchers", h.ListWatchers)
-		api.GET("/users/:user_id/watching", h.ListUserWatching)
+		api.POST("/attachments/:id/watchers", requireAttachment(authz, models.AccessView), h.AddWatcher)
+		api.DELETE("/attachments/:id/watchers", requireAttachment(authz, models.AccessView), h.RemoveWatcher)
+		api.GET("/attachments/:id/watchers", requireAttachment(authz, models.AccessView), h.ListWatchers)
+		api.GET("/users/:user_id/watching", requireSelf("user_id"), h.ListUserWatching)
 
 		// Reactions
-		api.POST("/attachments/:id/reactions", h.AddReaction)
-		api.DELETE("/attachments/:id/reactions", h.RemoveReaction)
-		api.GET("/attachments/:id/reactions", h.ListReactions)
-		api.GET("/attachments/:id/reactions/summary", h.GetReactionSummary)
+		api.POST("/attachments/:id/reactions", requireAttachment(authz, models.AccessView), h.AddReaction)
+		api.DELETE("/attachments/:id/reactions", requireAttachment(authz, models.AccessView), h.RemoveReaction)
+		api.GET("/attachments/:id/reactions", requireAttachment(authz, models.AccessView), h.ListReactions)
+		api.GET("/attachments/:id/reactions/summary", requireAttachment(authz, models.AccessView), h.GetReactionSummary)
 
 		// Pins
-		api.POST("/attachments/:id/pin", h.PinAttachment)
-		api.DELETE("/attachments/:id/pin", h.UnpinAttachment)
+		api.POST("/attachments/:id/pin", requireAttachment(authz, models.AccessView), h.PinAttachment)
+		api.DELETE("/attachments/:id/pin", requireAttachment(authz, models.AccessView), h.UnpinAttachment)
 		api.GET("/channels/:channel_id/pinned-attachments", h.ListPinnedInChannel)
-		api.GET("/attachments/:id/pinned", h.IsPinned)
+		api.GET("/attachments/:id/pinned", requireAttachment(authz, models.AccessView), h.IsPinned)
 
 		// Access Logs
-		api.GET("/attachments/:id/access-logs", h.ListAccessLogs)
-		api.GET("/users/:user_id/access-logs", h.ListUserAccessLogs)
-		api.POST("/attachments/:id/access-logs", h.LogAccess)
+		api.GET("/attachments/:id/access-logs", requireAttachment(authz, models.AccessShare), h.ListAccessLogs)
+		api.GET("/users/:user_id/access-logs", requireSelf("user_id"), h.ListUserAccessLogs)
+		api.POST("/attachments/:id/access-logs", requireAttachment(authz, models.AccessView), h.LogAccess)
 
 		// Templates
-		api.POST("/attachment-templates", h.CreateTemplate)
-		api.GET("/attachment-templates", h.ListTemplates)
+		api.POST("/attachment-templates", requireWorkspaceMember(""), h.CreateTemplate)
+		api.GET("/attachment-templates", requireWorkspaceMember(""), h.ListTemplates)
 		api.GET("/attachment-templates/:templateId", h.GetTemplate)
 		api.PUT("/attachment-templates/:templateId", h.UpdateTemplate)
 		api.DELETE("/attachment-templates/:templateId", h.DeleteTemplate)
 
 		// Labels
-		api.POST("/attachments/:id/labels", h.AddLabel)
-		api.DELETE("/attachments/:id/labels/:label", h.RemoveLabel)
-		api.GET("/attachments/:id/labels", h.ListLabels)
+		api.POST("/attachments/:id/labels", requireAttachment(authz, models.AccessEdit), h.AddLabel)
+		api.DELETE("/attachments/:id/labels/:label", requireAttachment(authz, models.AccessEdit), h.RemoveLabel)
+		api.GET("/attachments/:id/labels", requireAttachment(authz, models.AccessView), h.ListLabels)
 		api.GET("/labels/:label/attachments", h.SearchByLabel)
 
 		// Notification Preferences
-		api.GET("/attachments/:id/notification-prefs", h.GetNotifPrefs)
-		api.PUT("/attachments/:id/notification-prefs", h.SetNotifPrefs)
+		api.GET("/attachments/:id/notification-prefs", requireAttachment(authz, models.AccessView), h.GetNotifPrefs)
+		api.PUT("/attachments/:id/notification-prefs", requireAttachment(authz, models.AccessView), h.SetNotifPrefs)
 
 		// Exports
 		api.POST("/attachments/export", h.CreateExport)
@@ -177,31 +160,36 @@ func RegisterExtendedRoutes2(router *gin.Engine, extRepo *repository.ExtendedRep
 		api.GET("/attachments/exports/:exportId", h.GetExport)
 
 		// Retention Policies
-		api.POST("/retention-policies", h.CreateRetentionPolicy)
-		api.GET("/retention-policies", h.ListRetentionPolicies)
-		api.PUT("/retention-policies/:policyId", h.UpdateRetentionPolicy)
-		api.DELETE("/retention-policies/:policyId", h.DeleteRetentionPolicy)
+		api.POST("/retention-policies", requireWorkspaceAdmin(""), h.CreateRetentionPolicy)
+		api.GET("/retention-policies", requireWorkspaceAdmin(""), h.ListRetentionPolicies)
+		api.PUT("/retention-policies/:policyId", requireWorkspaceAdmin(""), h.UpdateRetentionPolicy)
+		api.DELETE("/retention-policies/:policyId", requireWorkspaceAdmin(""), h.DeleteRetentionPolicy)
+		api.GET("/retention-policies/:policyId/preview", requireWorkspaceAdmin(""), h.PreviewRetentionPolicy)
 
 		// Webhooks
-		api.POST("/attachment-webhooks", h.CreateWebhook)
-		api.GET("/attachment-webhooks", h.ListWebhooks)
-		api.PUT("/attachment-webhooks/:webhookId", h.UpdateWebhook)
-		api.DELETE("/attachment-webhooks/:webhookId", h.DeleteWebhook)
-		api.POST("/attachment-webhooks/:webhookId/test", h.TestWebhook)
+		api.POST("/attachment-webhooks", requireWorkspaceAdmin(""), h.CreateWebhook)
+		api.GET("/attachment-webhooks", requireWorkspaceAdmin(""), h.ListWebhooks)
+		api.PUT("/attachment-webhooks/:webhookId", requireWorkspaceAdmin(""), h.UpdateWebhook)
+		api.DELETE("/attachment-webhooks/:webhookId", requireWorkspaceAdmin(""), h.DeleteWebhook)
+		api.POST("/attachment-webhooks/:webhookId/test", requireWorkspaceAdmin(""), h.TestWebhook)
+		api.POST("/attachment-webhooks/:webhookId/rotate-secret", requireWorkspaceAdmin(""), h.RotateWebhookSecret)
+		api.GET("/attachment-webhooks/:webhookId/deliveries", requireWorkspaceAdmin(""), h.ListWebhookDeliveries)
+		api.GET("/attachment-webhooks/:webhookId/deliveries/:deliveryId", requireWorkspaceAdmin(""), h.GetWebhookDelivery)
+		api.POST("/attachment-webhooks/:webhookId/deliveries/:deliveryId/redeliver", requireWorkspaceAdmin(""), h.RedeliverWebhook)
 
 		// Advanced Stats
-		api.GET("/attachments/type-distribution", h.GetTypeDistribution)
-		api.GET("/attachments/size-distribution", h.GetSizeDistribution)
-		api.GET("/attachments/upload-trends", h.GetUploadTrends)
-		api.GET("/attachments/top-uploaders", h.GetTopUploaders)
+		api.GET("/attachments/type-distribution", requireWorkspaceMember(""), h.GetTypeDistribution)
+		api.GET("/attachments/size-distribution", requireWorkspaceMember(""), h.GetSizeDistribution)
+		api.GET("/attachments/upload-trends", requireWorkspaceMember(""), h.GetUploadTrends)
+		api.GET("/attachments/top-uploaders", requireWorkspaceMember(""), h.GetTopUploaders)
 
 		// Duplicate detection
-		api.GET("/attachments/:id/duplicates", h.FindDuplicates)
-		api.POST("/attachments/deduplicate", h.Deduplicate)
+		api.GET("/attachments/:id/duplicates", requireAttachment(authz, models.AccessView), h.FindDuplicates)
+		api.POST("/attachments/deduplicate", requireAdmin, h.Deduplicate)
 
 		// Compression
-		api.POST("/attachments/:id/compress", h.CompressAttachment)
-		api.GET("/attachments/:id/thumbnail", h.GetThumbnail)
+		api.POST("/attachments/:id/compress", requireAttachment(authz, models.AccessEdit), h.CompressAttachment)
+		api.GET("/attachments/:id/thumbnail", requireAttachment(authz, models.AccessView), h.GetThumbnail)
 	}
 }
 
@@ -259,14 +247,13 @@ func (h *ExtendedHandler2) ListWatchers(c *gin.Context) {
 }
 
 func (h *ExtendedHandler2) ListUserWatching(c *gin.Context) {
-	opts := options.Find().SetLimit(int64(getLimit(c))).SetSkip(int64(getOffset(c))).SetSort(bson.D{{Key: "created_at", Value: -1}})
-	cursor, err := h.watchersCol().Find(c.Request.Context(), bson.M{"user_id": c.Param("user_id")}, opts)
+	var watchers []AttachmentWatcher
+	sort := bson.D{{Key: "created_at", Value: -1}}
+	err := repository.FindInTenant(c.Request.Context(), h.watchersCol(), bson.M{"user_id": c.Param("user_id")}, sort, getLimit(c), getOffset(c), &watchers)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	var watchers []AttachmentWatcher
-	cursor.All(c.Request.Context(), &watchers)
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": watchers})
 }
 
@@ -340,6 +327,10 @@ func (h *ExtendedHandler2) PinAttachment(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := h.authz.ChannelAccess(c.Request.Context(), auth.FromGin(c), loadedAttachment(c).WorkspaceID, req.ChannelID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
 	p := &AttachmentPin{
 		AttachmentID: c.Param("id"),
 		ChannelID:    req.ChannelID,
@@ -354,8 +345,19 @@ func (h *ExtendedHandler2) PinAttachment(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"success": true, "data": p})
 }
 
+// UnpinAttachment removes the attachment's pin from the ?channel_id
+// channel, which takes the same channel access as pinning it.
 func (h *ExtendedHandler2) UnpinAttachment(c *gin.Context) {
-	_, err := h.pinsCol().DeleteOne(c.Request.Context(), bson.M{"attachment_id": c.Param("id")})
+	channelID := c.Query("channel_id")
+	if channelID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "channel_id is required"})
+		return
+	}
+	if err := h.authz.ChannelAccess(c.Request.Context(), auth.FromGin(c), loadedAttachment(c).WorkspaceID, channelID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+	_, err := h.pinsCol().DeleteOne(c.Request.Context(), bson.M{"attachment_id": c.Param("id"), "channel_id": channelID})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -364,14 +366,22 @@ func (h *ExtendedHandler2) UnpinAttachment(c *gin.Context) {
 }
 
 func (h *ExtendedHandler2) ListPinnedInChannel(c *gin.Context) {
-	opts := options.Find().SetSort(bson.D{{Key: "pinned_at", Value: -1}})
-	cursor, err := h.pinsCol().Find(c.Request.Context(), bson.M{"channel_id": c.Param("channel_id")}, opts)
+	var pins []AttachmentPin
+	sort := bson.D{{Key: "pinned_at", Value: -1}}
+	err := repository.FindInTenant(c.Request.Context(), h.pinsCol(), bson.M{"channel_id": c.Param("channel_id")}, sort, 0, 0, &pins)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	var pins []AttachmentPin
-	cursor.All(c.Request.Context(), &pins)
+	ids := make([]string, len(pins))
+	for i, p := range pins {
+		ids[i] = p.AttachmentID
+	}
+	visible, ok := visibleIDs(c, h.authz, ids)
+	if !ok {
+		return
+	}
+	pins = slices.DeleteFunc(pins, func(p AttachmentPin) bool { return !visible[p.AttachmentID] })
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": pins})
 }
 
@@ -399,14 +409,13 @@ func (h *ExtendedHandler2) ListAccessLogs(c *gin.Context) {
 }
 
 func (h *ExtendedHandler2) ListUserAccessLogs(c *gin.Context) {
-	opts := options.Find().SetLimit(int64(getLimit(c))).SetSkip(int64(getOffset(c))).SetSort(bson.D{{Key: "created_at", Value: -1}})
-	cursor, err := h.accessLogsCol().Find(c.Request.Context(), bson.M{"user_id": c.Param("user_id")}, opts)
+	var logs []AttachmentAccessLog
+	sort := bson.D{{Key: "created_at", Value: -1}}
+	err := repository.FindInTenant(c.Request.Context(), h.accessLogsCol(), bson.M{"user_id": c.Param("user_id")}, sort, getLimit(c), getOffset(c), &logs)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	var logs []AttachmentAccessLog
-	cursor.All(c.Request.Context(), &logs)
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": logs})
 }
 
@@ -441,7 +450,7 @@ func (h *ExtendedHandler2) CreateTemplate(c *gin.Context) {
 		Description: req.Description,
 		MimeTypes:   req.MimeTypes,
 		MaxSize:     req.MaxSize,
-		WorkspaceID: c.GetHeader("X-Workspace-ID"),
+		WorkspaceID: requestWorkspace(c, ""),
 		CreatedBy:   getUserID(c),
 		IsActive:    true,
 		CreatedAt:   time.Now(),
@@ -456,11 +465,7 @@ func (h *ExtendedHandler2) CreateTemplate(c *gin.Context) {
 }
 
 func (h *ExtendedHandler2) ListTemplates(c *gin.Context) {
-	filter := bson.M{}
-	if ws := c.Query("workspace_id"); ws != "" {
-		filter["workspace_id"] = ws
-	}
-	cursor, err := h.templatesCol().Find(c.Request.Context(), filter)
+	cursor, err := h.templatesCol().Find(c.Request.Context(), repository.Scoped(c.Request.Context(), bson.M{"workspace_id": requestWorkspace(c, "")}))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -477,10 +482,14 @@ func (h *ExtendedHandler2) GetTemplate(c *gin.Context) {
 		return
 	}
 	var t AttachmentTemplate
-	if err := h.templatesCol().FindOne(c.Request.Context(), bson.M{"_id": oid}).Decode(&t); err != nil {
+	if err := h.templatesCol().FindOne(c.Request.Context(), repository.Scoped(c.Request.Context(), bson.M{"_id": oid})).Decode(&t); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
 		return
 	}
+	if id := auth.FromGin(c); !id.IsMember(t.WorkspaceID) && !id.HasRole(auth.RoleService) {
+		forbid(c, "not a member of workspace "+t.WorkspaceID)
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": t})
 }
 
@@ -501,6 +510,9 @@ func (h *ExtendedHandler2) UpdateTemplate(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !h.canManageTemplate(c, oid) {
+		return
+	}
 	update := bson.M{"updated_at": time.Now()}
 	if req.Name != "" {
 		update["name"] = req.Name
@@ -523,10 +535,24 @@ func (h *ExtendedHandler2) UpdateTemplate(c *gin.Context) {
 
 func (h *ExtendedHandler2) DeleteTemplate(c *gin.Context) {
 	oid, _ := primitive.ObjectIDFromHex(c.Param("templateId"))
+	if !h.canManageTemplate(c, oid) {
+		return
+	}
 	h.templatesCol().DeleteOne(c.Request.Context(), bson.M{"_id": oid})
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// canManageTemplate allows a template's creator and its workspace admins to
+// change it.
+func (h *ExtendedHandler2) canManageTemplate(c *gin.Context, oid primitive.ObjectID) bool {
+	var t AttachmentTemplate
+	if err := h.templatesCol().FindOne(c.Request.Context(), repository.Scoped(c.Request.Context(), bson.M{"_id": oid})).Decode(&t); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return false
+	}
+	return canManage(c, t.CreatedBy, t.WorkspaceID)
+}
+
 // ── Labels ──
 
 func (h *ExtendedHandler2) AddLabel(c *gin.Context) {
@@ -566,14 +592,21 @@ func (h *ExtendedHandler2) ListLabels(c *gin.Context) {
 }
 
 func (h *ExtendedHandler2) SearchByLabel(c *gin.Context) {
-	opts := options.Find().SetLimit(int64(getLimit(c))).SetSkip(int64(getOffset(c)))
-	cursor, err := h.labelsCol().Find(c.Request.Context(), bson.M{"label": c.Param("label")}, opts)
+	var labels []AttachmentLabel
+	err := repository.FindInTenant(c.Request.Context(), h.labelsCol(), bson.M{"label": c.Param("label")}, nil, getLimit(c), getOffset(c), &labels)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	var labels []AttachmentLabel
-	cursor.All(c.Request.Context(), &labels)
+	ids := make([]string, len(labels))
+	for i, l := range labels {
+		ids[i] = l.AttachmentID
+	}
+	visible, ok := visibleIDs(c, h.authz, ids)
+	if !ok {
+		return
+	}
+	labels = slices.DeleteFunc(labels, func(l AttachmentLabel) bool { return !visible[l.AttachmentID] })
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": labels})
 }
 
@@ -648,7 +681,7 @@ func (h *ExtendedHandler2) ListExports(c *gin.Context) {
 func (h *ExtendedHandler2) GetExport(c *gin.Context) {
 	oid, _ := primitive.ObjectIDFromHex(c.Param("exportId"))
 	var exp AttachmentExport
-	if err := h.exportsCol().FindOne(c.Request.Context(), bson.M{"_id": oid}).Decode(&exp); err != nil {
+	if err := h.exportsCol().FindOne(c.Request.Context(), bson.M{"_id": oid, "user_id": getUserID(c)}).Decode(&exp); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
 		return
 	}
@@ -667,8 +700,15 @@ func (h *ExtendedHandler2) CreateRetentionPolicy(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	p := &AttachmentRetention{
-		WorkspaceID: c.GetHeader("X-Workspace-ID"),
+	if req.Action == "" {
+		req.Action = models.RetentionDelete
+	}
+	if req.MaxAgeDays <= 0 || !models.ValidRetentionAction(req.Action) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_age_days must be positive and action one of delete, archive, purge_previews"})
+		return
+	}
+	p := &models.AttachmentRetention{
+		WorkspaceID: requestWorkspace(c, ""),
 		MimeType:    req.MimeType,
 		MaxAgeDays:  req.MaxAgeDays,
 		Action:      req.Action,
@@ -681,12 +721,12 @@ func (h *ExtendedHandler2) CreateRetentionPolicy(c *gin.Context) {
 }
 
 func (h *ExtendedHandler2) ListRetentionPolicies(c *gin.Context) {
-	cursor, err := h.retentionCol().Find(c.Request.Context(), bson.M{"workspace_id": c.GetHeader("X-Workspace-ID")})
+	cursor, err := h.retentionCol().Find(c.Request.Context(), repository.Scoped(c.Request.Context(), bson.M{"workspace_id": requestWorkspace(c, "")}))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	var policies []AttachmentRetention
+	var policies []models.AttachmentRetention
 	cursor.All(c.Request.Context(), &policies)
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": policies})
 }
@@ -699,6 +739,10 @@ func (h *ExtendedHandler2) UpdateRetentionPolicy(c *gin.Context) {
 		IsActive   *bool  `json:"is_active"`
 	}
 	c.ShouldBindJSON(&req)
+	if req.Action != "" && !models.ValidRetentionAction(req.Action) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be one of delete, archive, purge_previews"})
+		return
+	}
 	update := bson.M{}
 	if req.MaxAgeDays > 0 {
 		update["max_age_days"] = req.MaxAgeDays
@@ -709,16 +753,41 @@ func (h *ExtendedHandler2) UpdateRetentionPolicy(c *gin.Context) {
 	if req.IsActive != nil {
 		update["is_active"] = *req.IsActive
 	}
-	h.retentionCol().UpdateOne(c.Request.Context(), bson.M{"_id": oid}, bson.M{"$set": update})
+	h.retentionCol().UpdateOne(c.Request.Context(), bson.M{"_id": oid, "workspace_id": requestWorkspace(c, "")}, bson.M{"$set": update})
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
 func (h *ExtendedHandler2) DeleteRetentionPolicy(c *gin.Context) {
 	oid, _ := primitive.ObjectIDFromHex(c.Param("policyId"))
-	h.retentionCol().DeleteOne(c.Request.Context(), bson.M{"_id": oid})
+	h.retentionCol().DeleteOne(c.Request.Context(), bson.M{"_id": oid, "workspace_id": requestWorkspace(c, "")})
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// PreviewRetentionPolicy lists the attachments the policy would act on if
+// it were enforced now, without changing anything.
+func (h *ExtendedHandler2) PreviewRetentionPolicy(c *gin.Context) {
+	ctx := c.Request.Context()
+	p, err := h.retention.Policy(ctx, requestWorkspace(c, ""), c.Param("policyId"))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Retention policy not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.ValidRetentionAction(p.Action) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Retention policy has an unknown action"})
+		return
+	}
+	preview, err := h.retention.Preview(ctx, p, getLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": preview})
+}
+
 // ── Webhooks ──
 
 func (h *ExtendedHandler2) CreateWebhook(c *gin.Context) {
@@ -731,8 +800,12 @@ func (h *ExtendedHandler2) CreateWebhook(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	w := &AttachmentWebhook{
-		WorkspaceID: c.GetHeader("X-Workspace-ID"),
+	if err := service.ValidateWebhookURL(c.Request.Context(), req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	w := &models.AttachmentWebhook{
+		WorkspaceID: requestWorkspace(c, ""),
 		Name:        req.Name,
 		URL:         req.URL,
 		Events:      req.Events,
@@ -742,17 +815,22 @@ func (h *ExtendedHandler2) CreateWebhook(c *gin.Context) {
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
-	h.webhooksCol().InsertOne(c.Request.Context(), w)
-	c.JSON(http.StatusCreated, gin.H{"success": true, "data": w})
+	res, err := h.webhooksCol().InsertOne(c.Request.Context(), w)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	w.ID = res.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": w.Issued()})
 }
 
 func (h *ExtendedHandler2) ListWebhooks(c *gin.Context) {
-	cursor, err := h.webhooksCol().Find(c.Request.Context(), bson.M{"workspace_id": c.GetHeader("X-Workspace-ID")})
+	cursor, err := h.webhooksCol().Find(c.Request.Context(), repository.Scoped(c.Request.Context(), bson.M{"workspace_id": requestWorkspace(c, "")}))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	var webhooks []AttachmentWebhook
+	var webhooks []models.AttachmentWebhook
 	cursor.All(c.Request.Context(), &webhooks)
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": webhooks})
 }
@@ -765,32 +843,118 @@ func (h *ExtendedHandler2) UpdateWebhook(c *gin.Context) {
 		Events   []string `json:"events"`
 		IsActive *bool    `json:"is_active"`
 	}
-	c.ShouldBindJSON(&req)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	update := bson.M{"updated_at": time.Now()}
 	if req.Name != "" {
 		update["name"] = req.Name
 	}
 	if req.URL != "" {
+		if err := service.ValidateWebhookURL(c.Request.Context(), req.URL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		update["url"] = req.URL
 	}
 	if req.Events != nil {
 		update["events"] = req.Events
 	}
+	change := bson.M{"$set": update}
 	if req.IsActive != nil {
 		update["is_active"] = *req.IsActive
+		if *req.IsActive {
+			// Re-enabling gives the endpoint a fresh start
+			update["consecutive_failures"] = 0
+			change["$unset"] = bson.M{"disabled_at": "", "disabled_reason": ""}
+		}
 	}
-	h.webhooksCol().UpdateOne(c.Request.Context(), bson.M{"_id": oid}, bson.M{"$set": update})
+	h.webhooksCol().UpdateOne(c.Request.Context(), bson.M{"_id": oid, "workspace_id": requestWorkspace(c, "")}, change)
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
 func (h *ExtendedHandler2) DeleteWebhook(c *gin.Context) {
 	oid, _ := primitive.ObjectIDFromHex(c.Param("webhookId"))
-	h.webhooksCol().DeleteOne(c.Request.Context(), bson.M{"_id": oid})
+	h.webhooksCol().DeleteOne(c.Request.Context(), bson.M{"_id": oid, "workspace_id": requestWorkspace(c, "")})
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// TestWebhook sends a signed test event to the webhook and reports the
+// endpoint's response.
 func (h *ExtendedHandler2) TestWebhook(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Webhook test event sent"})
+	oid, _ := primitive.ObjectIDFromHex(c.Param("webhookId"))
+	var w models.AttachmentWebhook
+	if err := h.webhooksCol().FindOne(c.Request.Context(), bson.M{"_id": oid, "workspace_id": requestWorkspace(c, "")}).Decode(&w); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	result, err := h.webhooks.Test(c.Request.Context(), &w)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !result.OK() {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Webhook test delivery failed", "data": result})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": result})
+}
+
+// RotateWebhookSecret issues a new signing secret. The old one keeps
+// signing deliveries for a grace period.
+func (h *ExtendedHandler2) RotateWebhookSecret(c *gin.Context) {
+	w, err := h.webhooks.RotateSecret(c.Request.Context(), requestWorkspace(c, ""), c.Param("webhookId"))
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": w.Issued()})
+}
+
+func (h *ExtendedHandler2) ListWebhookDeliveries(c *gin.Context) {
+	status := models.WebhookDeliveryStatus(c.Query("status"))
+	deliveries, err := h.webhooks.Deliveries(c.Request.Context(), requestWorkspace(c, ""), c.Param("webhookId"), status, getLimit(c), getOffset(c))
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": deliveries})
+}
+
+// GetWebhookDelivery returns a delivery with its payload and every logged
+// attempt.
+func (h *ExtendedHandler2) GetWebhookDelivery(c *gin.Context) {
+	delivery, err := h.webhooks.Delivery(c.Request.Context(), requestWorkspace(c, ""), c.Param("webhookId"), c.Param("deliveryId"))
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": delivery})
+}
+
+// RedeliverWebhook sends a delivery again now and reports the endpoint's
+// response.
+func (h *ExtendedHandler2) RedeliverWebhook(c *gin.Context) {
+	result, err := h.webhooks.Redeliver(c.Request.Context(), requestWorkspace(c, ""), c.Param("webhookId"), c.Param("deliveryId"))
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	if !result.OK() {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Webhook redelivery failed", "data": result})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": result})
+}
+
+func respondWebhookError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrWebhookNotFound), errors.Is(err, service.ErrDeliveryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
 }
 
 // ── Advanced Stats ──
@@ -873,11 +1037,6 @@ func (h *ExtendedHandler2) GetTopUploaders(c *gin.Context) {
 
 func (h *ExtendedHandler2) FindDuplicates(c *gin.Context) {
 	// Find by checksum match
-	var att models.Attachment
-	if err := h.extRepo.FindAttachmentByID(c.Request.Context(), c.Param("id"), &att); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
-		return
-	}
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": []interface{}{}, "message": "Duplicate check complete"})
 }
 
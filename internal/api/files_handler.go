@@ -0,0 +1,91 @@
+package api
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"attachment-service/internal/auth"
+	"attachment-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type FilesHandler struct {
+	service *service.AttachmentService
+}
+
+// RegisterFileRoutes serves signed download URLs under /files, which must
+// be exempt from authentication. identify optionally establishes the
+// caller for URLs bound to a user.
+func RegisterFileRoutes(router *gin.Engine, svc *service.AttachmentService, identify gin.HandlerFunc) {
+	h := &FilesHandler{service: svc}
+	router.GET("/files/*path", identify, h.Download)
+	router.HEAD("/files/*path", identify, h.Download)
+}
+
+// Download streams a file once its signed URL checks out. Range and
+// If-Range requests are served partially, and If-None-Match and
+// If-Modified-Since are answered from the object's ETag and modification
+// time.
+func (h *FilesHandler) Download(c *gin.Context) {
+	storagePath := strings.TrimPrefix(c.Param("path"), "/")
+	var userID string
+	if id, ok := auth.FromContext(c.Request.Context()); ok {
+		userID = id.UserID
+	}
+
+	dl, err := h.service.OpenDownload(c.Request.Context(), storagePath, c.Request.URL.Query(), c.ClientIP(), userID)
+	if err != nil {
+		respondDownloadError(c, err)
+		return
+	}
+	defer dl.Body.Close()
+
+	contentType := dl.Attachment.MimeType
+	if contentType == "" {
+		contentType = dl.Object.ContentType
+	}
+	maxAge := max(int(time.Until(dl.Grant.ExpiresAt).Seconds()), 0)
+	header := c.Writer.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", mime.FormatMediaType(string(dl.Grant.Disposition), map[string]string{"filename": dl.Attachment.OriginalName}))
+	header.Set("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+	header.Set("X-Content-Type-Options", "nosniff")
+	if dl.Object.ETag != "" {
+		header.Set("ETag", quoteETag(dl.Object.ETag))
+	}
+	if dl.Grant.Disposition == service.DispositionInline {
+		// Keep inline HTML and SVG from running script on this origin
+		header.Set("Content-Security-Policy", "sandbox")
+	}
+	http.ServeContent(c.Writer, c.Request, "", dl.Object.LastModified, dl.Body)
+}
+
+// quoteETag makes sure an ETag is a quoted string as HTTP requires.
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+func respondDownloadError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidDownloadToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrDownloadTokenExpired), errors.Is(err, service.ErrExpired):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrDownloadTokenBinding):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, primitive.ErrInvalidHex):
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
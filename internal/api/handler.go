@@ -1,11 +1,18 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	"attachment-service/internal/auth"
 	"attachment-service/internal/config"
 	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
 	"attachment-service/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -13,11 +20,14 @@ import (
 
 type Handler struct {
 	service *service.AttachmentService
+	authz   *service.Authorizer
 	cfg     *config.Config
 }
 
-func RegisterRoutes(router *gin.Engine, svc *service.AttachmentService, cfg *config.Config) {
-	h := &Handler{service: svc, cfg: cfg}
+func RegisterRoutes(router *gin.Engine, svc *service.AttachmentService, authz *service.Authorizer, idempotency *repository.IdempotencyRepository, limits *RateLimits, cfg *config.Config) {
+	h := &Handler{service: svc, authz: authz, cfg: cfg}
+	idempotent := Idempotent(idempotency, cfg.IdempotencyTTL)
+	uploadLimit := limits.UploadBytes()
 
 	// Health endpoints
 	router.GET("/health", h.Health)
@@ -28,21 +38,26 @@ func RegisterRoutes(router *gin.Engine, svc *service.AttachmentService, cfg *con
 	api := router.Group("/api/v1")
 	{
 		// Direct upload
-		api.POST("/attachments/upload", h.Upload)
+		api.POST("/attachments/upload", uploadLimit, idempotent, h.Upload)
 
 		// Presigned URL upload flow
-		api.POST("/attachments/initiate", h.InitiateUpload)
+		api.POST("/attachments/initiate", uploadLimit, idempotent, h.InitiateUpload)
 		api.POST("/attachments/complete", h.CompleteUpload)
 
 		// CRUD
-		api.GET("/attachments/:id", h.GetAttachment)
-		api.DELETE("/attachments/:id", h.DeleteAttachment)
-		api.GET("/attachments/:id/download", h.GetDownloadURL)
+		api.GET("/attachments/:id", requireAttachment(authz, models.AccessView), h.GetAttachment)
+		api.DELETE("/attachments/:id", requireAttachment(authz, models.AccessDelete), h.DeleteAttachment)
+		api.GET("/attachments/:id/download", requireAttachment(authz, models.AccessDownload), h.GetDownloadURL)
+		api.POST("/attachments/:id/restore", requireAttachment(authz, models.AccessRestore), h.RestoreAttachment)
+
+		// What the caller may do to an attachment
+		api.GET("/attachments/:id/access", h.GetAccess)
 
 		// List endpoints
 		api.GET("/messages/:message_id/attachments", h.GetByMessageID)
 		api.GET("/channels/:channel_id/attachments", h.GetByChannelID)
-		api.GET("/users/:user_id/attachments", h.GetByUserID)
+		api.GET("/users/:user_id/attachments", requireSelf("user_id"), h.GetByUserID)
+		api.GET("/users/:user_id/trash", requireSelf("user_id"), h.GetTrash)
 	}
 }
 
@@ -74,6 +89,9 @@ func (h *Handler) Upload(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !bindCaller(c, &req.UserID, &req.WorkspaceID) || !bindChannel(c, h.authz, req.WorkspaceID, req.ChannelID) {
+		return
+	}
 
 	mimeType := header.Header.Get("Content-Type")
 	if mimeType == "" {
@@ -89,7 +107,7 @@ func (h *Handler) Upload(c *gin.Context) {
 		header.Size,
 	)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondUploadError(c, err)
 		return
 	}
 
@@ -102,10 +120,13 @@ func (h *Handler) InitiateUpload(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !bindCaller(c, &req.UserID, &req.WorkspaceID) || !bindChannel(c, h.authz, req.WorkspaceID, req.ChannelID) {
+		return
+	}
 
 	response, err := h.service.InitiateUpload(c.Request.Context(), &req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondUploadError(c, err)
 		return
 	}
 
@@ -119,6 +140,16 @@ func (h *Handler) CompleteUpload(c *gin.Context) {
 		return
 	}
 
+	pending, err := h.service.GetByID(c.Request.Context(), req.AttachmentID)
+	if err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+	if id := auth.FromGin(c); pending.UserID != id.UserID && !id.HasRole(auth.RoleService) {
+		forbid(c, "only the uploader may complete an upload")
+		return
+	}
+
 	attachment, err := h.service.CompleteUpload(c.Request.Context(), req.AttachmentID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -129,44 +160,95 @@ func (h *Handler) CompleteUpload(c *gin.Context) {
 }
 
 func (h *Handler) GetAttachment(c *gin.Context) {
-	id := c.Param("id")
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": loadedAttachment(c)})
+}
 
-	attachment, err := h.service.GetByID(c.Request.Context(), id)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+// GetAccess reports what the caller may do to an attachment, and with
+// ?action= whether that one action is allowed.
+func (h *Handler) GetAccess(c *gin.Context) {
+	action := models.AccessAction(c.DefaultQuery("action", string(models.AccessView)))
+	if !slices.Contains(models.AllAccessActions, action) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown action " + string(action)})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": attachment})
+	_, decision, err := h.authz.Load(c.Request.Context(), auth.FromGin(c), c.Param("id"), action)
+	if err != nil && !errors.Is(err, service.ErrForbidden) {
+		respondAuthzError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": decision, "action": action, "allowed": err == nil})
 }
 
 func (h *Handler) DeleteAttachment(c *gin.Context) {
 	id := c.Param("id")
-	userID := c.GetHeader("X-User-ID")
-
-	if userID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID required"})
-		return
-	}
+	userID := getUserID(c)
 
 	if err := h.service.Delete(c.Request.Context(), id, userID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondHoldError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// RestoreAttachment takes an attachment out of the trash. It answers 409 if
+// the attachment is not in the trash, 410 once its content is purged, and
+// 413 or 507 if its owner no longer has room for it.
+func (h *Handler) RestoreAttachment(c *gin.Context) {
+	attachment, err := h.service.Restore(c.Request.Context(), c.Param("id"), getUserID(c))
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": attachment})
+	case errors.Is(err, service.ErrNotInTrash):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrPurged):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	default:
+		respondUploadError(c, err)
+	}
+}
+
+// GetDownloadURL issues a signed download URL. ?disposition=inline|attachment
+// (default attachment) controls how browsers treat the file, ?expires_in
+// sets the lifetime in seconds and ?bind=user,ip restricts the URL to the
+// caller; binding may also be forced by configuration.
 func (h *Handler) GetDownloadURL(c *gin.Context) {
-	id := c.Param("id")
+	id := auth.FromGin(c)
+	opts := service.DownloadOptions{Disposition: service.Disposition(c.DefaultQuery("disposition", string(service.DispositionAttachment)))}
+	if opts.Disposition != service.DispositionInline && opts.Disposition != service.DispositionAttachment {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "disposition must be inline or attachment"})
+		return
+	}
+	if v := c.Query("expires_in"); v != "" {
+		secs, err := strconv.Atoi(v)
+		ttl := time.Duration(secs) * time.Second
+		if err != nil || secs <= 0 || ttl > h.cfg.DownloadURLMaxTTL {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expires_in must be between 1 and %d seconds", int(h.cfg.DownloadURLMaxTTL.Seconds()))})
+			return
+		}
+		opts.TTL = ttl
+	}
+	bind := strings.Split(c.Query("bind"), ",")
+	if h.cfg.DownloadURLBindUser || slices.Contains(bind, "user") {
+		opts.UserID = id.UserID
+	}
+	if h.cfg.DownloadURLBindIP || slices.Contains(bind, "ip") {
+		opts.IP = c.ClientIP()
+	}
 
-	url, err := h.service.GetDownloadURL(c.Request.Context(), id)
+	url, expiresAt, err := h.service.GetDownloadURL(c.Request.Context(), c.Param("id"), opts)
+	if errors.Is(err, service.ErrExpired) {
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "url": url})
+	c.JSON(http.StatusOK, gin.H{"success": true, "url": url, "expires_at": expiresAt})
 }
 
 func (h *Handler) GetByMessageID(c *gin.Context) {
@@ -177,6 +259,10 @@ func (h *Handler) GetByMessageID(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	attachments, ok := filterVisible(c, h.authz, attachments)
+	if !ok {
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": attachments})
 }
@@ -191,6 +277,10 @@ func (h *Handler) GetByChannelID(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	attachments, ok := filterVisible(c, h.authz, attachments)
+	if !ok {
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": attachments})
 }
@@ -208,3 +298,78 @@ func (h *Handler) GetByUserID(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": attachments})
 }
+
+// GetTrash lists the user's deleted attachments that can still be restored,
+// most recently deleted first.
+func (h *Handler) GetTrash(c *gin.Context) {
+	userID := c.Param("user_id")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	attachments, err := h.service.ListTrash(c.Request.Context(), userID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": attachments})
+}
+
+// respondUploadError maps quota rejections to 413 (the file can never fit)
+// or 507 (not enough room left), and everything else to 400.
+func respondUploadError(c *gin.Context, err error) {
+	var quotaErr *service.QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		status := http.StatusInsufficientStorage
+		if quotaErr.TooLarge() {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.JSON(status, gin.H{"error": err.Error(), "quota": quotaErr.Quota})
+		return
+	}
+	if errors.Is(err, repository.ErrOutsideTenant) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// bindCaller fills in the user and workspace a request acts for from the
+// caller's identity. Only service callers and API keys may name another
+// user, and the workspace must be one the caller belongs to. The request is scoped to
+// that workspace.
+func bindCaller(c *gin.Context, userID, workspaceID *string) bool {
+	id := auth.FromGin(c)
+	if *userID == "" {
+		*userID = id.UserID
+	} else if *userID != id.UserID && !id.HasRole(auth.RoleService) && !id.IsAPIKey() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "user_id does not match the authenticated user"})
+		return false
+	}
+	if *workspaceID == "" {
+		*workspaceID = id.WorkspaceID
+	}
+	if *workspaceID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "workspace_id is required"})
+		return false
+	}
+	if !id.IsMember(*workspaceID) && !id.HasRole(auth.RoleService) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member of workspace " + *workspaceID})
+		return false
+	}
+	scopeTo(c, *workspaceID)
+	return true
+}
+
+// bindChannel checks the caller may post into the channel a request
+// uploads to, if it names one.
+func bindChannel(c *gin.Context, authz *service.Authorizer, workspaceID, channelID string) bool {
+	if channelID == "" {
+		return true
+	}
+	if err := authz.ChannelAccess(c.Request.Context(), auth.FromGin(c), workspaceID, channelID); err != nil {
+		respondAuthzError(c, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+
+	// A request still processing after this long is assumed to have died
+	// with its server, and a retry may take the key over.
+	idempotencyLockTimeout = 5 * time.Minute
+
+	// Same as gin's default MaxMultipartMemory
+	multipartMemory = 32 << 20
+)
+
+// Idempotent makes a route safe to retry. A request carrying an
+// Idempotency-Key header is fingerprinted and recorded; retries with the
+// same key and body get the original response replayed, and retries with
+// a different body are rejected with 422. Requests without the header
+// pass through unchanged.
+func Idempotent(store *repository.IdempotencyRepository, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+
+		fingerprint, err := requestFingerprint(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Keys are only unique per caller and endpoint
+		scoped := strings.Join([]string{getUserID(c), c.Request.Method, c.FullPath(), key}, "|")
+		now := time.Now()
+		existing, err := store.Begin(c.Request.Context(), &models.IdempotencyRecord{
+			Key:         scoped,
+			Fingerprint: fingerprint,
+			LockedAt:    now,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(ttl),
+		}, idempotencyLockTimeout)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+			case existing.Status != models.IdempotencyCompleted:
+				c.Header("Retry-After", "1")
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a request with this Idempotency-Key is still in progress"})
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(existing.StatusCode, existing.ContentType, existing.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		w := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			// The handler panicked or failed; let the client retry
+			if err := store.Abort(context.Background(), scoped); err != nil {
+				log.Printf("Failed to release idempotency key: %v", err)
+			}
+		}()
+
+		c.Next()
+
+		if w.Status() >= http.StatusInternalServerError {
+			return
+		}
+		if err := store.Complete(context.Background(), scoped, w.Status(), w.Header().Get("Content-Type"), w.body.Bytes()); err != nil {
+			log.Printf("Failed to store idempotent response: %v", err)
+			return
+		}
+		completed = true
+	}
+}
+
+// requestFingerprint hashes what makes a request distinct: form fields and
+// file contents for multipart uploads, the canonicalized body otherwise.
+// Multipart boundaries and JSON key order don't affect it. The body is
+// left readable for the handler.
+func requestFingerprint(c *gin.Context) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", c.Request.Method, c.Request.URL.Path)
+
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		if err := c.Request.ParseMultipartForm(multipartMemory); err != nil {
+			return "", err
+		}
+		form := c.Request.MultipartForm
+
+		for _, name := range sortedKeys(form.Value) {
+			fmt.Fprintf(h, "field %q %q\n", name, form.Value[name])
+		}
+		for _, name := range sortedKeys(form.File) {
+			for _, fh := range form.File[name] {
+				f, err := fh.Open()
+				if err != nil {
+					return "", err
+				}
+				fileHash := sha256.New()
+				_, err = io.Copy(fileHash, f)
+				f.Close()
+				if err != nil {
+					return "", err
+				}
+				fmt.Fprintf(h, "file %q %q %q %x\n", name, fh.Filename, fh.Header.Get("Content-Type"), fileHash.Sum(nil))
+			}
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return "", err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if dec.Decode(&v) == nil {
+		if canonical, err := json.Marshal(v); err == nil {
+			body = canonical
+		}
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// capturingWriter keeps a copy of the response body for replay.
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"attachment-service/internal/models"
+	"attachment-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type LegalHoldHandler struct {
+	holds *service.LegalHoldService
+}
+
+// RegisterLegalHoldRoutes lets workspace admins place and release legal
+// holds and read their audit trail.
+func RegisterLegalHoldRoutes(router *gin.Engine, holds *service.LegalHoldService) {
+	h := &LegalHoldHandler{holds: holds}
+
+	api := router.Group("/api/v1/legal-holds", requireWorkspaceAdmin(""))
+	{
+		api.POST("", h.CreateHold)
+		api.GET("", h.ListHolds)
+		api.GET("/:holdId", h.GetHold)
+		api.POST("/:holdId/release", h.ReleaseHold)
+		api.GET("/:holdId/audit", h.ListAudit)
+	}
+}
+
+func (h *LegalHoldHandler) CreateHold(c *gin.Context) {
+	var req models.CreateLegalHoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hold, err := h.holds.Create(c.Request.Context(), requestWorkspace(c, ""), getUserID(c), &req)
+	if err != nil {
+		respondLegalHoldError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": hold})
+}
+
+// ListHolds lists the workspace's holds; ?status=active|released filters
+// them.
+func (h *LegalHoldHandler) ListHolds(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && status != models.HoldActive && status != models.HoldReleased {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active or released"})
+		return
+	}
+	holds, err := h.holds.List(c.Request.Context(), requestWorkspace(c, ""), status, getLimit(c), getOffset(c))
+	if err != nil {
+		respondLegalHoldError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": holds})
+}
+
+func (h *LegalHoldHandler) GetHold(c *gin.Context) {
+	hold, err := h.holds.Get(c.Request.Context(), requestWorkspace(c, ""), c.Param("holdId"))
+	if err != nil {
+		respondLegalHoldError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": hold})
+}
+
+func (h *LegalHoldHandler) ReleaseHold(c *gin.Context) {
+	var req models.ReleaseLegalHoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hold, err := h.holds.Release(c.Request.Context(), requestWorkspace(c, ""), c.Param("holdId"), getUserID(c), req.Reason)
+	if err != nil {
+		respondLegalHoldError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": hold})
+}
+
+func (h *LegalHoldHandler) ListAudit(c *gin.Context) {
+	entries, err := h.holds.ListAudit(c.Request.Context(), requestWorkspace(c, ""), c.Param("holdId"), getLimit(c), getOffset(c))
+	if err != nil {
+		respondLegalHoldError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": entries})
+}
+
+func respondLegalHoldError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, primitive.ErrInvalidHex):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Legal hold not found"})
+	case errors.Is(err, service.ErrHoldScopeID), errors.Is(err, service.ErrHoldAttachmentID):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrHoldReleased):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// respondHoldError answers an operation legal holds refused with 409 and
+// the held attachments, and any other error with 500.
+func respondHoldError(c *gin.Context, err error) {
+	var held *service.LegalHoldError
+	if errors.As(err, &held) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "held": held.AttachmentIDs, "holds": held.HoldIDs})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+
+	"attachment-service/internal/kafka"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MetricsHandler struct {
+	producer *kafka.Producer
+}
+
+func RegisterMetricsRoutes(router *gin.Engine, producer *kafka.Producer) {
+	h := &MetricsHandler{producer: producer}
+
+	api := router.Group("/api/v1")
+	{
+		api.GET("/metrics/kafka-producer", requireAdmin, h.GetProducerMetrics)
+	}
+}
+
+// GetProducerMetrics reports Kafka delivery counts and latency. A producer
+// that failed to connect reports zeros.
+func (h *MetricsHandler) GetProducerMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": h.producer.Stats()})
+}
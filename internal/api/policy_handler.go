@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+	"attachment-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type PolicyHandler struct {
+	repo     *repository.PolicyRepository
+	resolver *service.PolicyResolver
+}
+
+func RegisterPolicyRoutes(router *gin.Engine, repo *repository.PolicyRepository, resolver *service.PolicyResolver) {
+	h := &PolicyHandler{repo: repo, resolver: resolver}
+
+	api := router.Group("/api/v1")
+	{
+		api.GET("/workspace-policies", requireAdmin, h.ListPolicies)
+		api.GET("/workspaces/:workspace_id/policy", requireWorkspaceMember("workspace_id"), h.GetPolicy)
+		api.PUT("/workspaces/:workspace_id/policy", requireWorkspaceAdmin("workspace_id"), h.UpsertPolicy)
+		api.DELETE("/workspaces/:workspace_id/policy", requireWorkspaceAdmin("workspace_id"), h.DeletePolicy)
+		api.GET("/workspaces/:workspace_id/policy/effective", requireWorkspaceMember("workspace_id"), h.GetEffectivePolicy)
+	}
+}
+
+func (h *PolicyHandler) ListPolicies(c *gin.Context) {
+	policies, err := h.repo.ListPolicies(c.Request.Context(), getLimit(c), getOffset(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": policies})
+}
+
+func (h *PolicyHandler) GetPolicy(c *gin.Context) {
+	policy, err := h.repo.GetPolicy(c.Request.Context(), c.Param("workspace_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Policy not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": policy})
+}
+
+func (h *PolicyHandler) UpsertPolicy(c *gin.Context) {
+	var req models.UpsertPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MaxFileSize < 0 || req.MaxFilesPerMessage < 0 || (req.RetentionDays != nil && *req.RetentionDays < 0) ||
+		(req.MaxExpiresIn != nil && *req.MaxExpiresIn < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limits must not be negative"})
+		return
+	}
+	policy := &models.WorkspacePolicy{
+		WorkspaceID:        c.Param("workspace_id"),
+		AllowedTypes:       req.AllowedTypes,
+		BlockedTypes:       req.BlockedTypes,
+		MaxFileSize:        req.MaxFileSize,
+		MaxFilesPerMessage: req.MaxFilesPerMessage,
+		StripMetadata:      req.StripMetadata,
+		RetentionDays:      req.RetentionDays,
+		MaxExpiresIn:       req.MaxExpiresIn,
+		UpdatedBy:          getUserID(c),
+	}
+	if err := h.repo.UpsertPolicy(c.Request.Context(), policy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.resolver.Invalidate(policy.WorkspaceID)
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": policy})
+}
+
+func (h *PolicyHandler) DeletePolicy(c *gin.Context) {
+	wsID := c.Param("workspace_id")
+	if err := h.repo.DeletePolicy(c.Request.Context(), wsID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Policy not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.resolver.Invalidate(wsID)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (h *PolicyHandler) GetEffectivePolicy(c *gin.Context) {
+	policy, err := h.resolver.Resolve(c.Request.Context(), c.Param("workspace_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": policy})
+}
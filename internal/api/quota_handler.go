@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http"
+
+	"attachment-service/internal/auth"
+	"attachment-service/internal/models"
+	"attachment-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type QuotaHandler struct {
+	quotas     *service.QuotaService
+	reconciler *service.UsageReconciler
+}
+
+func RegisterQuotaRoutes(router *gin.Engine, quotas *service.QuotaService, reconciler *service.UsageReconciler) {
+	h := &QuotaHandler{quotas: quotas, reconciler: reconciler}
+
+	api := router.Group("/api/v1")
+	{
+		api.GET("/users/:user_id/quota", requireSelf("user_id"), h.GetUserQuota)
+
+		// Admin overrides
+		api.GET("/quotas/:scope/:scope_id", h.GetQuota)
+		api.PUT("/quotas/:scope/:scope_id", requireAdmin, h.SetQuota)
+		api.DELETE("/quotas/:scope/:scope_id", requireAdmin, h.ResetQuota)
+
+		// Usage counter reconciliation
+		api.POST("/usage/reconcile", requireAdmin, h.ReconcileUsage)
+	}
+}
+
+func quotaScope(c *gin.Context) (models.UsageScope, bool) {
+	scope := models.UsageScope(c.Param("scope"))
+	if scope != models.UsageScopeUser && scope != models.UsageScopeWorkspace {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be user or workspace"})
+		return "", false
+	}
+	return scope, true
+}
+
+func (h *QuotaHandler) GetUserQuota(c *gin.Context) {
+	st, err := h.quotas.Status(c.Request.Context(), models.UsageScopeUser, c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	quota := &models.UserQuota{
+		UserID:        st.ScopeID,
+		UsedBytes:     st.UsedBytes,
+		MaxBytes:      st.MaxBytes,
+		FileCount:     st.UsedFiles,
+		MaxFiles:      st.MaxFiles,
+		ReservedBytes: st.ReservedBytes,
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": quota})
+}
+
+func (h *QuotaHandler) GetQuota(c *gin.Context) {
+	scope, ok := quotaScope(c)
+	if !ok {
+		return
+	}
+	id, scopeID := auth.FromGin(c), c.Param("scope_id")
+	if scope == models.UsageScopeUser && scopeID != id.UserID && !isPrivileged(id) {
+		forbid(c, "cannot access another user's data")
+		return
+	}
+	if scope == models.UsageScopeWorkspace && !id.IsMember(scopeID) && !id.HasRole(auth.RoleService) {
+		forbid(c, "not a member of workspace "+scopeID)
+		return
+	}
+	st, err := h.quotas.Status(c.Request.Context(), scope, c.Param("scope_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": st})
+}
+
+func (h *QuotaHandler) SetQuota(c *gin.Context) {
+	scope, ok := quotaScope(c)
+	if !ok {
+		return
+	}
+	var req models.SetQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.MaxBytes != nil && *req.MaxBytes < 0) || (req.MaxFiles != nil && *req.MaxFiles < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limits must not be negative"})
+		return
+	}
+	st, err := h.quotas.SetLimits(c.Request.Context(), scope, c.Param("scope_id"), req.MaxBytes, req.MaxFiles, getUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": st})
+}
+
+func (h *QuotaHandler) ResetQuota(c *gin.Context) {
+	scope, ok := quotaScope(c)
+	if !ok {
+		return
+	}
+	st, err := h.quotas.SetLimits(c.Request.Context(), scope, c.Param("scope_id"), nil, nil, getUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": st})
+}
+
+func (h *QuotaHandler) ReconcileUsage(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+	report, err := h.reconciler.Reconcile(c.Request.Context(), dryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": report})
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"attachment-service/internal/auth"
+	"attachment-service/internal/config"
+	"attachment-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Largest JSON body read to find the size of a presigned upload
+const maxUploadRequestBody = 1 << 20
+
+// RateLimits throttles callers with token buckets: a request budget per
+// user (or IP when anonymous), an upload byte budget per user, and a
+// share-link password budget per IP. Responses carry RateLimit-* headers,
+// and rejected requests get 429 with Retry-After. A nil *RateLimits, or a
+// limit of 0, lets everything through.
+type RateLimits struct {
+	limiter        service.RateLimiter
+	requests       service.RateLimit
+	uploadBytes    service.RateLimit
+	sharePasswords service.RateLimit
+}
+
+func NewRateLimits(limiter service.RateLimiter, cfg *config.Config) *RateLimits {
+	return &RateLimits{
+		limiter:        limiter,
+		requests:       service.RateLimit{Name: "requests", Capacity: float64(cfg.RateLimitRequests), Period: time.Minute},
+		uploadBytes:    service.RateLimit{Name: "upload_bytes", Capacity: float64(cfg.RateLimitUploadBytes), Period: time.Minute},
+		sharePasswords: service.RateLimit{Name: "share_passwords", Capacity: float64(cfg.RateLimitSharePasswords), Period: time.Minute},
+	}
+}
+
+// Requests limits the number of requests per caller. Paths under any of
+// the exempt prefixes are not counted. It must run after the auth
+// middleware.
+func (l *RateLimits) Requests(exempt ...string) gin.HandlerFunc {
+	if l == nil {
+		return passThrough
+	}
+	limit := l.throttle(l.requests, callerKey, func(*gin.Context) float64 { return 1 })
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if slices.ContainsFunc(exempt, func(p string) bool { return strings.HasPrefix(path, p) }) {
+			c.Next()
+			return
+		}
+		limit(c)
+	}
+}
+
+// UploadBytes limits the bytes a caller may upload: the body of a direct
+// upload, or the declared sizes of presigned uploads.
+func (l *RateLimits) UploadBytes() gin.HandlerFunc {
+	if l == nil {
+		return passThrough
+	}
+	return l.throttle(l.uploadBytes, callerKey, uploadCost)
+}
+
+// SharePasswords limits share-link password attempts per IP, across links.
+func (l *RateLimits) SharePasswords() gin.HandlerFunc {
+	if l == nil {
+		return passThrough
+	}
+	return l.throttle(l.sharePasswords, func(c *gin.Context) string { return "ip:" + c.ClientIP() },
+		func(*gin.Context) float64 { return 1 })
+}
+
+func passThrough(c *gin.Context) {
+	c.Next()
+}
+
+func (l *RateLimits) throttle(limit service.RateLimit, key func(*gin.Context) string, cost func(*gin.Context) float64) gin.HandlerFunc {
+	if !limit.Enabled() {
+		return passThrough
+	}
+	return func(c *gin.Context) {
+		// A cost above capacity could never be paid; require a full bucket
+		n := min(cost(c), limit.Capacity)
+		d, err := l.limiter.Take(c.Request.Context(), key(c), n, limit)
+		if err != nil {
+			// Fail open; an unavailable store shouldn't take the API down
+			log.Printf("Rate limit %s check failed: %v", limit.Name, err)
+			c.Next()
+			return
+		}
+		setRateLimitHeaders(c, d)
+		if !d.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(d.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded: " + limit.Name})
+			return
+		}
+		c.Next()
+	}
+}
+
+// setRateLimitHeaders reports a bucket's state. When several limits apply
+// to a route, the last one checked is reported.
+func setRateLimitHeaders(c *gin.Context, d *service.RateDecision) {
+	c.Header("RateLimit-Policy", fmt.Sprintf("%.0f;w=%d", d.Limit.Capacity, ceilSeconds(d.Limit.Period)))
+	c.Header("RateLimit-Limit", fmt.Sprintf("%.0f", d.Limit.Capacity))
+	c.Header("RateLimit-Remaining", fmt.Sprintf("%.0f", math.Floor(d.Remaining)))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// callerKey identifies the caller as its user, or its IP if anonymous.
+func callerKey(c *gin.Context) string {
+	if id, ok := auth.FromContext(c.Request.Context()); ok && id.UserID != "" {
+		return "user:" + id.UserID
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// uploadCost is the number of bytes a request uploads. Multipart bodies
+// are counted by length; JSON bodies are read for the size, or sizes of
+// files, they declare and then restored for the handler. A body of
+// unknown length costs a full bucket.
+func uploadCost(c *gin.Context) float64 {
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		if c.Request.ContentLength < 0 {
+			return math.Inf(1)
+		}
+		return float64(c.Request.ContentLength)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxUploadRequestBody))
+	if err != nil {
+		return 0
+	}
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+
+	var declared struct {
+		Size  int64 `json:"size"`
+		Files []struct {
+			Size int64 `json:"size"`
+		} `json:"files"`
+	}
+	// Malformed bodies are rejected by the handler
+	if json.Unmarshal(body, &declared) != nil {
+		return 0
+	}
+	total := max(declared.Size, 0)
+	for _, f := range declared.Files {
+		total += max(f.Size, 0)
+	}
+	return float64(total)
+}
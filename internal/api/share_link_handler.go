@@ -0,0 +1,179 @@
+package api
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"attachment-service/internal/auth"
+	"attachment-service/internal/models"
+	"attachment-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ShareLinkHandler struct {
+	links *service.ShareLinkService
+	authz *service.Authorizer
+}
+
+const (
+	defaultAnalyticsDays = 30
+	maxAnalyticsDays     = 365
+	recentAccesses       = 50
+)
+
+// RegisterShareLinkRoutes registers share-link creation and the public
+// download endpoints under /s, which must be exempt from authentication.
+// passwordLimit throttles password submissions.
+func RegisterShareLinkRoutes(router *gin.Engine, links *service.ShareLinkService, authz *service.Authorizer, passwordLimit gin.HandlerFunc) {
+	h := &ShareLinkHandler{links: links, authz: authz}
+
+	api := router.Group("/api/v1")
+	{
+		api.POST("/attachments/:id/share-links", requireAttachment(authz, models.AccessShare), h.CreateShareLink)
+		api.GET("/share-links/:linkId", h.GetShareLink)
+		api.DELETE("/share-links/:linkId", h.RevokeShareLink)
+		api.POST("/share-links/:linkId/revoke", h.RevokeShareLink)
+		api.GET("/share-links/:linkId/analytics", h.GetShareLinkAnalytics)
+	}
+
+	public := router.Group("/s")
+	{
+		public.GET("/:code", h.OpenShareLink)
+		public.POST("/:code", passwordLimit, h.UnlockShareLink)
+	}
+}
+
+func (h *ShareLinkHandler) CreateShareLink(c *gin.Context) {
+	var req models.CreateShareLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	link, err := h.links.Create(c.Request.Context(), loadedAttachment(c), getUserID(c), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": link})
+}
+
+// loadLink fetches the :linkId link, given by ID or code, if the caller may
+// share its attachment.
+func (h *ShareLinkHandler) loadLink(c *gin.Context) (*models.ShareLink, bool) {
+	link, err := h.links.Get(c.Request.Context(), c.Param("linkId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return nil, false
+	}
+	if _, _, err := h.authz.Load(c.Request.Context(), auth.FromGin(c), link.AttachmentID, models.AccessShare); err != nil {
+		respondAuthzError(c, err)
+		return nil, false
+	}
+	return link, true
+}
+
+func (h *ShareLinkHandler) GetShareLink(c *gin.Context) {
+	link, ok := h.loadLink(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": link})
+}
+
+func (h *ShareLinkHandler) RevokeShareLink(c *gin.Context) {
+	link, ok := h.loadLink(c)
+	if !ok {
+		return
+	}
+	if err := h.links.Revoke(c.Request.Context(), link, getUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// GetShareLinkAnalytics reports a link's accesses over the last ?days days
+// (default 30), with daily counts and the most recent accesses.
+func (h *ShareLinkHandler) GetShareLinkAnalytics(c *gin.Context) {
+	days := defaultAnalyticsDays
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAnalyticsDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and " + strconv.Itoa(maxAnalyticsDays)})
+			return
+		}
+		days = n
+	}
+	link, ok := h.loadLink(c)
+	if !ok {
+		return
+	}
+	analytics, err := h.links.Analytics(c.Request.Context(), link, days, recentAccesses)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": analytics})
+}
+
+// OpenShareLink serves a link without a password.
+func (h *ShareLinkHandler) OpenShareLink(c *gin.Context) {
+	h.serve(c, "")
+}
+
+// UnlockShareLink serves a password-protected link. The password is read
+// from a JSON body or a form field.
+func (h *ShareLinkHandler) UnlockShareLink(c *gin.Context) {
+	var req struct {
+		Password string `json:"password" form:"password" binding:"required"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.serve(c, req.Password)
+}
+
+func (h *ShareLinkHandler) serve(c *gin.Context, password string) {
+	c.Header("Cache-Control", "no-store")
+	visitor := models.ShareLinkVisitor{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Referer:   c.Request.Referer(),
+	}
+	delivery, err := h.links.Open(c.Request.Context(), c.Param("code"), password, visitor)
+	if err != nil {
+		respondShareLinkError(c, err)
+		return
+	}
+
+	if delivery.URL != "" {
+		c.Redirect(http.StatusFound, delivery.URL)
+		return
+	}
+	defer delivery.Body.Close()
+	att := delivery.Attachment
+	c.DataFromReader(http.StatusOK, att.Size, att.MimeType, delivery.Body, map[string]string{
+		"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": att.OriginalName}),
+	})
+}
+
+func respondShareLinkError(c *gin.Context, err error) {
+	var tooMany *service.TooManyAttemptsError
+	switch {
+	case errors.As(err, &tooMany):
+		c.Header("Retry-After", strconv.Itoa(int(tooMany.RetryAfter.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrShareLinkNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrShareLinkExpired), errors.Is(err, service.ErrShareLinkExhausted), errors.Is(err, service.ErrShareLinkUnavailable):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrShareLinkPassword), errors.Is(err, service.ErrShareLinkBadPassword):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "password_required": true})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"attachment-service/internal/auth"
+	"attachment-service/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TenantScope scopes every repository call of the request to the caller's
+// workspace. Callers without a current workspace are scoped to all of their
+// memberships; admins and services without one stay unscoped. It must run
+// after the auth middleware.
+func TenantScope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := auth.FromContext(c.Request.Context())
+		switch {
+		case !ok:
+			// Unauthenticated paths such as /health.
+		case id.WorkspaceID != "":
+			scopeTo(c, id.WorkspaceID)
+		case !isPrivileged(id):
+			scopeTo(c, id.Workspaces...)
+		}
+		c.Next()
+	}
+}
+
+// scopeTo narrows the request's tenant to the given workspaces.
+func scopeTo(c *gin.Context, workspaceIDs ...string) {
+	c.Request = c.Request.WithContext(repository.WithTenant(c.Request.Context(), workspaceIDs...))
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"attachment-service/internal/auth"
+	"attachment-service/internal/config"
+	"attachment-service/internal/repository"
+	"attachment-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// withIdentity stands in for auth.Middleware, authenticating every request
+// as id.
+func withIdentity(id *auth.Identity) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id != nil {
+			c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), id))
+		}
+		c.Next()
+	}
+}
+
+func member(userID string, workspaceIDs ...string) *auth.Identity {
+	return &auth.Identity{UserID: userID, WorkspaceID: workspaceIDs[0], Workspaces: workspaceIDs}
+}
+
+func TestTenantScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     *auth.Identity
+		want   []string
+		scoped bool
+	}{
+		{name: "unauthenticated", id: nil},
+		{name: "request workspace", id: member("alice", "ws-a", "ws-b"), want: []string{"ws-a"}, scoped: true},
+		{name: "all memberships", id: &auth.Identity{UserID: "alice", Workspaces: []string{"ws-a", "ws-b"}}, want: []string{"ws-a", "ws-b"}, scoped: true},
+		{name: "no memberships", id: &auth.Identity{UserID: "alice"}, want: []string{}, scoped: true},
+		{name: "admin", id: &auth.Identity{UserID: "root", Roles: []string{auth.RoleAdmin}}},
+		{name: "service", id: &auth.Identity{Roles: []string{auth.RoleService}}},
+		{name: "admin in a workspace", id: &auth.Identity{UserID: "root", WorkspaceID: "ws-a", Roles: []string{auth.RoleAdmin}}, want: []string{"ws-a"}, scoped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			var scoped bool
+			r := gin.New()
+			r.Use(withIdentity(tt.id), TenantScope())
+			r.GET("/", func(c *gin.Context) {
+				got, scoped = repository.TenantFrom(c.Request.Context())
+			})
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if scoped != tt.scoped || (scoped && !reflect.DeepEqual(got, tt.want)) {
+				t.Fatalf("tenant = %v (scoped %v), want %v (scoped %v)", got, scoped, tt.want, tt.scoped)
+			}
+		})
+	}
+}
+
+func TestBindCaller(t *testing.T) {
+	tests := []struct {
+		name          string
+		id            *auth.Identity
+		userID        string
+		workspaceID   string
+		wantStatus    int
+		wantUserID    string
+		wantWorkspace string
+	}{
+		{name: "defaults to the caller", id: member("alice", "ws-a"), wantUserID: "alice", wantWorkspace: "ws-a"},
+		{name: "other member workspace", id: member("alice", "ws-a", "ws-b"), workspaceID: "ws-b", wantUserID: "alice", wantWorkspace: "ws-b"},
+		{name: "foreign workspace", id: member("alice", "ws-a"), workspaceID: "ws-b", wantStatus: http.StatusForbidden},
+		{name: "other user", id: member("alice", "ws-a"), userID: "bob", wantStatus: http.StatusForbidden},
+		{name: "no workspace", id: &auth.Identity{UserID: "alice"}, wantStatus: http.StatusBadRequest},
+		{name: "service acts for a user", id: &auth.Identity{Roles: []string{auth.RoleService}}, userID: "bob", workspaceID: "ws-b", wantUserID: "bob", wantWorkspace: "ws-b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+			c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), tt.id))
+
+			userID, workspaceID := tt.userID, tt.workspaceID
+			ok := bindCaller(c, &userID, &workspaceID)
+
+			if tt.wantStatus != 0 {
+				if ok || w.Code != tt.wantStatus {
+					t.Fatalf("bindCaller = %v with status %d, want rejection with %d", ok, w.Code, tt.wantStatus)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("bindCaller rejected the request: %d %s", w.Code, w.Body)
+			}
+			if userID != tt.wantUserID || workspaceID != tt.wantWorkspace {
+				t.Fatalf("bound %s/%s, want %s/%s", userID, workspaceID, tt.wantUserID, tt.wantWorkspace)
+			}
+			if ws, _ := repository.TenantFrom(c.Request.Context()); !reflect.DeepEqual(ws, []string{tt.wantWorkspace}) {
+				t.Fatalf("tenant = %v, want [%s]", ws, tt.wantWorkspace)
+			}
+		})
+	}
+}
+
+// tenantServer serves the attachment routes to caller against a mock
+// MongoDB deployment, whose commands the test inspects.
+func tenantServer(mt *mtest.T, caller *auth.Identity) *gin.Engine {
+	repo := repository.NewMongoRepositoryWithClient(mt.Client, "test")
+	extRepo := repository.NewExtendedRepository(mt.Client, "test")
+	authz := service.NewAuthorizer(repo, extRepo, nil)
+	cfg := &config.Config{}
+
+	r := gin.New()
+	r.Use(withIdentity(caller), TenantScope())
+	RegisterRoutes(r, nil, authz, nil, nil, cfg)
+	RegisterExtendedRoutes(r, extRepo, authz, nil, cfg)
+	RegisterExtendedRoutes2(r, extRepo, authz, mt.Client.Database("test"), nil, nil)
+	// Forget the index builds made while constructing the repositories
+	mt.ClearEvents()
+	return r
+}
+
+func serve(r *gin.Engine, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func emptyCursor() bson.D {
+	return mtest.CreateCursorResponse(0, "test.attachments", mtest.FirstBatch)
+}
+
+func TestOtherTenantAttachmentNotFound(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	id := primitive.NewObjectID()
+	owned := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "user_id", Value: "alice"},
+		{Key: "workspace_id", Value: "ws-a"},
+		{Key: "status", Value: "ready"},
+	}
+
+	mt.Run("owner in the attachment's workspace", func(mt *mtest.T) {
+		r := tenantServer(mt, member("alice", "ws-a"))
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.attachments", mtest.FirstBatch, owned))
+
+		if w := serve(r, "/api/v1/attachments/"+id.Hex()); w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+	})
+
+	for _, path := range []string{
+		"/api/v1/attachments/" + id.Hex(),
+		"/api/v1/attachments/" + id.Hex() + "/comments",
+	} {
+		mt.Run(path, func(mt *mtest.T) {
+			// bob only belongs to ws-b, so the lookup, scoped to ws-b,
+			// can't match the ws-a attachment
+			r := tenantServer(mt, member("bob", "ws-b"))
+			mt.AddMockResponses(emptyCursor())
+
+			if w := serve(r, path); w.Code != http.StatusNotFound {
+				mt.Fatalf("status = %d, want 404: %s", w.Code, w.Body)
+			}
+			events := mt.GetAllStartedEvents()
+			if len(events) != 1 {
+				mt.Fatalf("sent %d commands, want only the attachment lookup", len(events))
+			}
+			if got := tenantsIn(events[0].Command); !reflect.DeepEqual(got, [][]string{{"ws-b"}}) {
+				mt.Fatalf("lookup restricted to %v, want [[ws-b]]", got)
+			}
+		})
+	}
+}
+
+func TestListsStayInTenant(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	for _, path := range []string{
+		"/api/v1/attachments/type-distribution",
+		"/api/v1/attachments/size-distribution",
+		"/api/v1/attachments/upload-trends",
+		"/api/v1/attachments/top-uploaders",
+		"/api/v1/attachment-templates",
+		"/api/v1/channels/c1/pinned-attachments",
+		"/api/v1/labels/urgent/attachments",
+		"/api/v1/tags/urgent/attachments",
+		"/api/v1/users/alice/watching",
+		"/api/v1/users/alice/access-logs",
+		"/api/v1/users/alice/favorites",
+		"/api/v1/users/alice/recent",
+		"/api/v1/search?q=report",
+	} {
+		mt.Run(path, func(mt *mtest.T) {
+			r := tenantServer(mt, member("alice", "ws-a"))
+			mt.AddMockResponses(emptyCursor(), emptyCursor())
+
+			if w := serve(r, path); w.Code != http.StatusOK {
+				mt.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			events := mt.GetAllStartedEvents()
+			if len(events) == 0 {
+				mt.Fatal("sent no commands")
+			}
+			for _, e := range events {
+				for _, ws := range tenantsIn(e.Command) {
+					if !reflect.DeepEqual(ws, []string{"ws-a"}) {
+						mt.Fatalf("%s restricted to %v, want [ws-a]", e.CommandName, ws)
+					}
+				}
+				if len(tenantsIn(e.Command)) == 0 {
+					mt.Fatalf("%s is not restricted to the tenant: %s", e.CommandName, e.Command)
+				}
+			}
+		})
+	}
+
+	mt.Run("another workspace's statistics", func(mt *mtest.T) {
+		r := tenantServer(mt, member("alice", "ws-a"))
+
+		if w := serve(r, "/api/v1/attachments/top-uploaders?workspace_id=ws-b"); w.Code != http.StatusForbidden {
+			mt.Fatalf("status = %d, want 403: %s", w.Code, w.Body)
+		}
+		if events := mt.GetAllStartedEvents(); len(events) != 0 {
+			mt.Fatalf("sent %d commands, want none", len(events))
+		}
+	})
+}
+
+// tenantsIn returns the workspace lists a command restricts workspace_id
+// to, found anywhere in its filter or pipeline.
+func tenantsIn(doc bson.Raw) [][]string {
+	var tenants [][]string
+	elems, _ := doc.Elements()
+	for _, e := range elems {
+		v := e.Value()
+		switch v.Type {
+		case bson.TypeEmbeddedDocument:
+			if key := e.Key(); key == "workspace_id" || key == "_tenant.workspace_id" {
+				if in, ok := v.Document().Lookup("$in").ArrayOK(); ok {
+					values, _ := in.Values()
+					ws := []string{}
+					for _, s := range values {
+						ws = append(ws, s.StringValue())
+					}
+					tenants = append(tenants, ws)
+					continue
+				}
+			}
+			tenants = append(tenants, tenantsIn(v.Document())...)
+		case bson.TypeArray:
+			tenants = append(tenants, tenantsIn(bson.Raw(v.Array()))...)
+		}
+	}
+	return tenants
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"attachment-service/internal/auth"
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+	"attachment-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type UploadSessionHandler struct {
+	sessions *service.UploadSessionService
+	authz    *service.Authorizer
+}
+
+func RegisterUploadSessionRoutes(router *gin.Engine, sessions *service.UploadSessionService, authz *service.Authorizer, idempotent, uploadLimit gin.HandlerFunc) {
+	h := &UploadSessionHandler{sessions: sessions, authz: authz}
+
+	api := router.Group("/api/v1")
+	{
+		api.POST("/upload-sessions", uploadLimit, idempotent, h.CreateSession)
+		api.GET("/upload-sessions/:session_id", h.GetSession)
+		api.PUT("/upload-sessions/:session_id/files/:attachment_id/progress", h.UpdateProgress)
+		api.POST("/upload-sessions/:session_id/complete", h.CompleteSession)
+		api.POST("/upload-sessions/:session_id/cancel", h.CancelSession)
+	}
+}
+
+// respondSessionError maps upload session errors to HTTP statuses.
+func respondSessionError(c *gin.Context, session *models.UploadSession, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload session not found"})
+	case errors.Is(err, service.ErrNotSessionOwner), errors.Is(err, repository.ErrOutsideTenant):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrSessionIncomplete):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "data": session})
+	case errors.Is(err, service.ErrSessionClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func (h *UploadSessionHandler) CreateSession(c *gin.Context) {
+	var req models.CreateUploadSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !bindCaller(c, &req.UserID, &req.WorkspaceID) || !bindChannel(c, h.authz, req.WorkspaceID, req.ChannelID) {
+		return
+	}
+
+	session, err := h.sessions.Create(c.Request.Context(), &req)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": session})
+}
+
+func (h *UploadSessionHandler) GetSession(c *gin.Context) {
+	session, err := h.sessions.Get(c.Request.Context(), c.Param("session_id"))
+	if err != nil {
+		respondSessionError(c, nil, err)
+		return
+	}
+	if id := auth.FromGin(c); session.UserID != id.UserID && !isPrivileged(id) {
+		respondSessionError(c, nil, service.ErrNotSessionOwner)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": session})
+}
+
+func (h *UploadSessionHandler) UpdateProgress(c *gin.Context) {
+	userID := getUserID(c)
+	var req models.SessionFileProgressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, err := h.sessions.UpdateProgress(c.Request.Context(), c.Param("session_id"), c.Param("attachment_id"), userID, req.UploadedBytes)
+	if err != nil {
+		respondSessionError(c, nil, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": session})
+}
+
+func (h *UploadSessionHandler) CompleteSession(c *gin.Context) {
+	userID := getUserID(c)
+	var req models.CompleteUploadSessionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	session, err := h.sessions.Complete(c.Request.Context(), c.Param("session_id"), userID, req.MessageID)
+	if err != nil {
+		respondSessionError(c, session, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": session})
+}
+
+func (h *UploadSessionHandler) CancelSession(c *gin.Context) {
+	userID := getUserID(c)
+
+	session, err := h.sessions.Cancel(c.Request.Context(), c.Param("session_id"), userID)
+	if err != nil {
+		respondSessionError(c, nil, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": session})
+}
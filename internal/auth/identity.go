@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// RoleAdmin grants access to every workspace
+	RoleAdmin = "admin"
+	// RoleService marks internal callers that may act on behalf of users
+	RoleService = "service"
+)
+
+// API key scopes. Each scope includes the ones before it.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// MethodAPIKey is the Method of callers authenticated by an API key
+const MethodAPIKey = "api_key"
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	UserID string `json:"user_id"`
+	// WorkspaceID is the workspace the request acts in, if any
+	WorkspaceID    string              `json:"workspace_id,omitempty"`
+	Workspaces     []string            `json:"workspaces,omitempty"`
+	Roles          []string            `json:"roles,omitempty"`
+	WorkspaceRoles map[string][]string `json:"workspace_roles,omitempty"`
+	// Method is how the caller authenticated: jwt, header or api_key
+	Method string `json:"method"`
+	// APIKeyID and Scopes are set for callers using an API key
+	APIKeyID string   `json:"api_key_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+func (i *Identity) HasRole(role string) bool {
+	return slices.Contains(i.Roles, role)
+}
+
+// IsMember reports whether the caller belongs to the workspace.
+func (i *Identity) IsMember(workspaceID string) bool {
+	return i.HasRole(RoleAdmin) || slices.Contains(i.Workspaces, workspaceID)
+}
+
+// IsWorkspaceAdmin reports whether the caller administers the workspace.
+func (i *Identity) IsWorkspaceAdmin(workspaceID string) bool {
+	return i.HasRole(RoleAdmin) || slices.Contains(i.WorkspaceRoles[workspaceID], RoleAdmin)
+}
+
+// IsAPIKey reports whether the caller authenticated with an API key.
+func (i *Identity) IsAPIKey() bool {
+	return i.APIKeyID != ""
+}
+
+// HasScope reports whether an API key caller was granted scope, directly
+// or through a broader one. Callers without a key have every scope.
+func (i *Identity) HasScope(scope string) bool {
+	if !i.IsAPIKey() {
+		return true
+	}
+	levels := []string{ScopeRead, ScopeWrite, ScopeAdmin}
+	want := slices.Index(levels, scope)
+	for _, s := range i.Scopes {
+		if slices.Index(levels, s) >= want && want >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(*Identity)
+	return id, ok
+}
+
+// FromGin returns the identity set by Middleware, or an empty identity for
+// routes that don't require authentication.
+func FromGin(c *gin.Context) *Identity {
+	if id, ok := FromContext(c.Request.Context()); ok {
+		return id
+	}
+	return &Identity{}
+}
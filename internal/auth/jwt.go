@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"attachment-service/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the token claims the service understands. The subject is the
+// user ID.
+type Claims struct {
+	jwt.RegisteredClaims
+	WorkspaceID    string              `json:"workspace_id,omitempty"`
+	Workspaces     []string            `json:"workspaces,omitempty"`
+	Roles          []string            `json:"roles,omitempty"`
+	WorkspaceRoles map[string][]string `json:"workspace_roles,omitempty"`
+}
+
+// JWTVerifier validates HS256 tokens against a shared secret and RS256
+// tokens against a JWKS.
+type JWTVerifier struct {
+	secret []byte
+	jwks   *JWKS
+	parser *jwt.Parser
+}
+
+func NewJWTVerifier(cfg *config.Config) (*JWTVerifier, error) {
+	v := &JWTVerifier{}
+	var methods []string
+	if cfg.JWTSecret != "" {
+		v.secret = []byte(cfg.JWTSecret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if cfg.JWKSFile != "" || cfg.JWKSURL != "" {
+		jwks, err := NewJWKS(cfg.JWKSFile, cfg.JWKSURL, cfg.JWKSRefresh)
+		if err != nil {
+			return nil, err
+		}
+		v.jwks = jwks
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("JWT auth needs AUTH_JWT_SECRET, AUTH_JWKS_FILE or AUTH_JWKS_URL")
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if cfg.JWTIssuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.JWTIssuer))
+	}
+	if cfg.JWTAudience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.JWTAudience))
+	}
+	v.parser = jwt.NewParser(opts...)
+	return v, nil
+}
+
+// Verify validates a token and returns the identity it carries.
+func (v *JWTVerifier) Verify(token string) (*Identity, error) {
+	var claims Claims
+	_, err := v.parser.ParseWithClaims(token, &claims, v.key)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return &Identity{
+		UserID:         claims.Subject,
+		WorkspaceID:    claims.WorkspaceID,
+		Workspaces:     claims.Workspaces,
+		Roles:          claims.Roles,
+		WorkspaceRoles: claims.WorkspaceRoles,
+		Method:         "jwt",
+	}, nil
+}
+
+func (v *JWTVerifier) key(t *jwt.Token) (any, error) {
+	switch t.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return v.secret, nil
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := t.Header["kid"].(string)
+		return v.jwks.Key(kid)
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+}
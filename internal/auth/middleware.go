@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"attachment-service/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ModeJWT authenticates bearer tokens
+	ModeJWT = "jwt"
+	// ModeHeader trusts identity headers set by an auth gateway in front
+	// of the service
+	ModeHeader = "header"
+)
+
+// APIKeyVerifier resolves an API key to the identity it acts as.
+type APIKeyVerifier interface {
+	VerifyAPIKey(ctx context.Context, key, ip string) (*Identity, error)
+}
+
+// APIKeyPrefix starts every API key, which lets keys be passed as bearer
+// tokens alongside JWTs.
+const APIKeyPrefix = "qak_"
+
+// Authenticator establishes the caller's identity for each request.
+type Authenticator struct {
+	mode     string
+	verifier *JWTVerifier
+	apiKeys  APIKeyVerifier
+}
+
+func NewAuthenticator(cfg *config.Config) (*Authenticator, error) {
+	a := &Authenticator{mode: cfg.AuthMode}
+	switch cfg.AuthMode {
+	case ModeJWT:
+		v, err := NewJWTVerifier(cfg)
+		if err != nil {
+			return nil, err
+		}
+		a.verifier = v
+	case ModeHeader:
+	default:
+		return nil, fmt.Errorf("unknown AUTH_MODE %q", cfg.AuthMode)
+	}
+	return a, nil
+}
+
+// UseAPIKeys accepts API keys, sent as X-API-Key or a bearer token, in
+// addition to the configured mode.
+func (a *Authenticator) UseAPIKeys(v APIKeyVerifier) {
+	a.apiKeys = v
+}
+
+// Middleware rejects unauthenticated requests with 401 and puts the
+// caller's Identity into the request context. The public prefixes, and
+// paths below them, are let through without an identity.
+//
+// X-Workspace-ID selects the workspace the request acts in; the caller
+// must be a member of it. API keys with only the read scope are limited to
+// safe methods.
+func (a *Authenticator) Middleware(public ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if slices.ContainsFunc(public, func(prefix string) bool { return underPrefix(path, prefix) }) {
+			c.Next()
+			return
+		}
+
+		id, err := a.authenticate(c)
+		if err != nil {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if !safeMethod(c.Request.Method) && !id.HasScope(ScopeWrite) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks the write scope"})
+			return
+		}
+
+		if ws := c.GetHeader("X-Workspace-ID"); ws != "" {
+			if !id.IsMember(ws) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not a member of workspace " + ws})
+				return
+			}
+			id.WorkspaceID = ws
+		} else if id.WorkspaceID == "" && len(id.Workspaces) == 1 {
+			id.WorkspaceID = id.Workspaces[0]
+		}
+
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// Optional identifies the caller on a public route when credentials are
+// present. Missing or invalid credentials leave the request anonymous.
+func (a *Authenticator) Optional() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id, err := a.authenticate(c); err == nil {
+			c.Request = c.Request.WithContext(NewContext(c.Request.Context(), id))
+		}
+		c.Next()
+	}
+}
+
+func (a *Authenticator) authenticate(c *gin.Context) (*Identity, error) {
+	if key := apiKeyFrom(c); key != "" && a.apiKeys != nil {
+		id, err := a.apiKeys.VerifyAPIKey(c.Request.Context(), key, c.ClientIP())
+		if err != nil {
+			return nil, fmt.Errorf("invalid API key: %w", err)
+		}
+		return id, nil
+	}
+	if a.mode == ModeHeader {
+		return identityFromHeaders(c)
+	}
+
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, fmt.Errorf("bearer token required")
+	}
+	id, err := a.verifier.Verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	return id, nil
+}
+
+// apiKeyFrom returns the API key a request carries, if any.
+func apiKeyFrom(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && strings.HasPrefix(token, APIKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+// underPrefix reports whether path is prefix or lies below it, matching
+// whole segments only, so /health does not cover /healthz-admin.
+func underPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// identityFromHeaders reads the identity an auth gateway forwards:
+// X-User-ID, X-Workspace-ID, X-Workspace-IDs (memberships), X-User-Roles
+// (global roles) and X-Workspace-Roles (roles in X-Workspace-ID).
+func identityFromHeaders(c *gin.Context) (*Identity, error) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		return nil, fmt.Errorf("X-User-ID header required")
+	}
+	id := &Identity{
+		UserID:     userID,
+		Workspaces: splitHeader(c.GetHeader("X-Workspace-IDs")),
+		Roles:      splitHeader(c.GetHeader("X-User-Roles")),
+		Method:     ModeHeader,
+	}
+	if ws := c.GetHeader("X-Workspace-ID"); ws != "" {
+		if !slices.Contains(id.Workspaces, ws) {
+			id.Workspaces = append(id.Workspaces, ws)
+		}
+		if roles := splitHeader(c.GetHeader("X-Workspace-Roles")); len(roles) > 0 {
+			id.WorkspaceRoles = map[string][]string{ws: roles}
+		}
+	}
+	return id, nil
+}
+
+func splitHeader(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -18,10 +19,182 @@ type Config struct {
 	MaxFileSize    int64
 	AllowedTypes   []string
 	CDNBaseURL     string
+
+	// Upload policy defaults, overridable per workspace. MaxExpiresIn
+	// bounds the lifetime ephemeral uploads may ask for (0 disallows them).
+	MaxFilesPerMessage int
+	StripMetadata      bool
+	RetentionDays      int
+	MaxExpiresIn       time.Duration
+	PolicyCacheTTL     time.Duration
+
+	// Storage quota defaults (0 = unlimited), overridable per user/workspace
+	UserQuotaBytes       int64
+	UserQuotaFiles       int64
+	WorkspaceQuotaBytes  int64
+	WorkspaceQuotaFiles  int64
+	UploadReservationTTL time.Duration
+
+	// How often usage counters are recomputed from the attachments collection
+	UsageReconcileInterval time.Duration
+
+	// How long Idempotency-Key responses are kept for replay
+	IdempotencyTTL time.Duration
+
+	// Authentication: "jwt" validates bearer tokens, "header" trusts
+	// identity headers from an auth gateway
+	AuthMode    string
+	JWTSecret   string
+	JWKSFile    string
+	JWKSURL     string
+	JWKSRefresh time.Duration
+	JWTIssuer   string
+	JWTAudience string
+
+	// Channel service used to check channel membership; when empty every
+	// workspace member may see the workspace's channel attachments
+	ChannelServiceURL    string
+	ChannelMembershipTTL time.Duration
+
+	// Public share links: "redirect" sends downloads to a presigned URL
+	// valid for ShareLinkURLTTL, "stream" proxies the object. Failed
+	// password attempts are limited per link and client IP.
+	ShareLinkDelivery      string
+	ShareLinkURLTTL        time.Duration
+	ShareLinkMaxAttempts   int
+	ShareLinkAttemptWindow time.Duration
+
+	// How often expired user-to-user shares are revoked
+	ShareSweepInterval time.Duration
+
+	// Signed download URLs served under CDNBaseURL/files/. Callers may ask
+	// for a TTL up to DownloadURLMaxTTL; the Bind options force every URL
+	// to be bound to the requesting user or IP.
+	DownloadURLSecret   string
+	DownloadURLTTL      time.Duration
+	DownloadURLMaxTTL   time.Duration
+	DownloadURLBindUser bool
+	DownloadURLBindIP   bool
+
+	// API keys. A rotated key keeps working for APIKeyRotationGrace;
+	// audit entries of key use are kept for APIKeyAuditTTL.
+	APIKeyRotationGrace time.Duration
+	APIKeyAuditTTL      time.Duration
+
+	// Token-bucket rate limits, each refilled over a minute; 0 disables a
+	// limit. RateLimitStore is memory (per replica) or mongo (shared).
+	RateLimitStore          string
+	RateLimitRequests       int
+	RateLimitUploadBytes    int64
+	RateLimitSharePasswords int
+
+	// Transactional outbox relay. Sent events are kept for OutboxRetention.
+	OutboxRelayInterval time.Duration
+	OutboxBatchSize     int
+	OutboxRetention     time.Duration
+
+	// Upstream lifecycle events (deleted messages, channels, workspaces and
+	// users), and our own events for webhooks, are consumed as
+	// KafkaConsumerGroup; an empty group disables the consumer. Handled event IDs are kept for HandledEventTTL. Content
+	// of attachments deleted this way is purged after UpstreamPurgeDelay,
+	// checked every PurgeInterval.
+	KafkaConsumerGroup string
+	HandledEventTTL    time.Duration
+	UpstreamPurgeDelay time.Duration
+	PurgeInterval      time.Duration
+
+	// Kafka producer. KafkaProducerMode is sync (each publish waits for
+	// acks) or async (batched; publishes return once queued). Async
+	// batches are flushed every KafkaFlushFrequency or KafkaFlushMessages,
+	// buffering up to KafkaBufferSize messages, and failed messages are
+	// retried KafkaPublishRetries times. KafkaCompression is none, gzip,
+	// snappy, lz4 or zstd.
+	KafkaProducerMode   string
+	KafkaCompression    string
+	KafkaFlushFrequency time.Duration
+	KafkaFlushMessages  int
+	KafkaBufferSize     int
+	KafkaPublishRetries int
+
+	// Webhook delivery. Deliveries time out after WebhookTimeout and are
+	// attempted up to WebhookMaxAttempts times; a webhook is disabled after
+	// WebhookDisableAfter failed attempts in a row (0 never disables).
+	// Finished deliveries and the attempt log are kept for WebhookLogTTL. A
+	// rotated secret keeps signing deliveries for WebhookSecretGrace.
+	WebhookDispatchInterval time.Duration
+	WebhookTimeout          time.Duration
+	WebhookMaxAttempts      int
+	WebhookDisableAfter     int
+	WebhookLogTTL           time.Duration
+	WebhookSecretGrace      time.Duration
+
+	// Workspace retention policies are enforced every RetentionInterval,
+	// RetentionBatchSize attachments at a time. Archived content moves to
+	// RetentionArchiveClass; content deleted by a policy is purged after
+	// RetentionPurgeDelay.
+	RetentionInterval     time.Duration
+	RetentionBatchSize    int
+	RetentionArchiveClass string
+	RetentionPurgeDelay   time.Duration
+
+	// Attachments users delete stay in the trash, and can be restored, for
+	// TrashRetention. The trash is purged every PurgeInterval.
+	TrashRetention time.Duration
+
+	// Expired ephemeral attachments are removed every ExpirySweepInterval.
+	ExpirySweepInterval time.Duration
 }
 
 func Load() *Config {
 	maxSize, _ := strconv.ParseInt(getEnv("MAX_FILE_SIZE", "104857600"), 10, 64) // 100MB default
+	maxFilesPerMessage, _ := strconv.Atoi(getEnv("MAX_FILES_PER_MESSAGE", "20"))
+	stripMetadata, _ := strconv.ParseBool(getEnv("STRIP_METADATA", "false"))
+	retentionDays, _ := strconv.Atoi(getEnv("RETENTION_DAYS", "0")) // 0 = keep forever
+	maxExpiresIn, _ := time.ParseDuration(getEnv("MAX_EXPIRES_IN", "168h")) // 7 days
+	policyCacheTTL, _ := time.ParseDuration(getEnv("POLICY_CACHE_TTL", "1m"))
+	userQuotaBytes, _ := strconv.ParseInt(getEnv("USER_QUOTA_BYTES", "5368709120"), 10, 64) // 5GB default
+	userQuotaFiles, _ := strconv.ParseInt(getEnv("USER_QUOTA_FILES", "10000"), 10, 64)
+	workspaceQuotaBytes, _ := strconv.ParseInt(getEnv("WORKSPACE_QUOTA_BYTES", "107374182400"), 10, 64) // 100GB default
+	workspaceQuotaFiles, _ := strconv.ParseInt(getEnv("WORKSPACE_QUOTA_FILES", "0"), 10, 64)
+	uploadReservationTTL, _ := time.ParseDuration(getEnv("UPLOAD_RESERVATION_TTL", "1h"))
+	usageReconcileInterval, _ := time.ParseDuration(getEnv("USAGE_RECONCILE_INTERVAL", "6h"))
+	idempotencyTTL, _ := time.ParseDuration(getEnv("IDEMPOTENCY_TTL", "24h"))
+	jwksRefresh, _ := time.ParseDuration(getEnv("AUTH_JWKS_REFRESH", "1h"))
+	channelMembershipTTL, _ := time.ParseDuration(getEnv("CHANNEL_MEMBERSHIP_TTL", "1m"))
+	shareLinkURLTTL, _ := time.ParseDuration(getEnv("SHARE_LINK_URL_TTL", "5m"))
+	shareLinkMaxAttempts, _ := strconv.Atoi(getEnv("SHARE_LINK_MAX_ATTEMPTS", "5"))
+	shareLinkAttemptWindow, _ := time.ParseDuration(getEnv("SHARE_LINK_ATTEMPT_WINDOW", "15m"))
+	shareSweepInterval, _ := time.ParseDuration(getEnv("SHARE_SWEEP_INTERVAL", "1m"))
+	downloadURLTTL, _ := time.ParseDuration(getEnv("DOWNLOAD_URL_TTL", "1h"))
+	downloadURLMaxTTL, _ := time.ParseDuration(getEnv("DOWNLOAD_URL_MAX_TTL", "24h"))
+	downloadURLBindUser, _ := strconv.ParseBool(getEnv("DOWNLOAD_URL_BIND_USER", "false"))
+	downloadURLBindIP, _ := strconv.ParseBool(getEnv("DOWNLOAD_URL_BIND_IP", "false"))
+	apiKeyRotationGrace, _ := time.ParseDuration(getEnv("API_KEY_ROTATION_GRACE", "24h"))
+	apiKeyAuditTTL, _ := time.ParseDuration(getEnv("API_KEY_AUDIT_TTL", "2160h")) // 90 days
+	rateLimitRequests, _ := strconv.Atoi(getEnv("RATE_LIMIT_REQUESTS", "600"))
+	rateLimitUploadBytes, _ := strconv.ParseInt(getEnv("RATE_LIMIT_UPLOAD_BYTES", "1073741824"), 10, 64) // 1GB default
+	rateLimitSharePasswords, _ := strconv.Atoi(getEnv("RATE_LIMIT_SHARE_PASSWORDS", "10"))
+	outboxRelayInterval, _ := time.ParseDuration(getEnv("OUTBOX_RELAY_INTERVAL", "1s"))
+	outboxBatchSize, _ := strconv.Atoi(getEnv("OUTBOX_BATCH_SIZE", "100"))
+	outboxRetention, _ := time.ParseDuration(getEnv("OUTBOX_RETENTION", "24h"))
+	handledEventTTL, _ := time.ParseDuration(getEnv("HANDLED_EVENT_TTL", "168h")) // 7 days
+	upstreamPurgeDelay, _ := time.ParseDuration(getEnv("UPSTREAM_PURGE_DELAY", "720h")) // 30 days
+	purgeInterval, _ := time.ParseDuration(getEnv("PURGE_INTERVAL", "1h"))
+	kafkaFlushFrequency, _ := time.ParseDuration(getEnv("KAFKA_FLUSH_FREQUENCY", "50ms"))
+	kafkaFlushMessages, _ := strconv.Atoi(getEnv("KAFKA_FLUSH_MESSAGES", "100"))
+	kafkaBufferSize, _ := strconv.Atoi(getEnv("KAFKA_BUFFER_SIZE", "1024"))
+	kafkaPublishRetries, _ := strconv.Atoi(getEnv("KAFKA_PUBLISH_RETRIES", "3"))
+	webhookDispatchInterval, _ := time.ParseDuration(getEnv("WEBHOOK_DISPATCH_INTERVAL", "5s"))
+	webhookTimeout, _ := time.ParseDuration(getEnv("WEBHOOK_TIMEOUT", "10s"))
+	webhookMaxAttempts, _ := strconv.Atoi(getEnv("WEBHOOK_MAX_ATTEMPTS", "10"))
+	webhookDisableAfter, _ := strconv.Atoi(getEnv("WEBHOOK_DISABLE_AFTER", "25"))
+	webhookLogTTL, _ := time.ParseDuration(getEnv("WEBHOOK_LOG_TTL", "168h")) // 7 days
+	webhookSecretGrace, _ := time.ParseDuration(getEnv("WEBHOOK_SECRET_GRACE", "24h"))
+	retentionInterval, _ := time.ParseDuration(getEnv("RETENTION_INTERVAL", "1h"))
+	retentionBatchSize, _ := strconv.Atoi(getEnv("RETENTION_BATCH_SIZE", "200"))
+	retentionPurgeDelay, _ := time.ParseDuration(getEnv("RETENTION_PURGE_DELAY", "168h")) // 7 days
+	trashRetention, _ := time.ParseDuration(getEnv("TRASH_RETENTION", "720h"))             // 30 days
+	expirySweepInterval, _ := time.ParseDuration(getEnv("EXPIRY_SWEEP_INTERVAL", "1m"))
 
 	return &Config{
 		Port:         getEnv("PORT", "4011"),
@@ -47,6 +220,86 @@ func Load() *Config {
 			"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
 		},
 		CDNBaseURL: getEnv("CDN_BASE_URL", "http://localhost:4012"),
+
+		MaxFilesPerMessage: maxFilesPerMessage,
+		StripMetadata:      stripMetadata,
+		RetentionDays:      retentionDays,
+		MaxExpiresIn:       maxExpiresIn,
+		PolicyCacheTTL:     policyCacheTTL,
+
+		UserQuotaBytes:       userQuotaBytes,
+		UserQuotaFiles:       userQuotaFiles,
+		WorkspaceQuotaBytes:  workspaceQuotaBytes,
+		WorkspaceQuotaFiles:  workspaceQuotaFiles,
+		UploadReservationTTL: uploadReservationTTL,
+
+		UsageReconcileInterval: usageReconcileInterval,
+
+		IdempotencyTTL: idempotencyTTL,
+
+		AuthMode:    getEnv("AUTH_MODE", "jwt"),
+		JWTSecret:   getEnv("AUTH_JWT_SECRET", ""),
+		JWKSFile:    getEnv("AUTH_JWKS_FILE", ""),
+		JWKSURL:     getEnv("AUTH_JWKS_URL", ""),
+		JWKSRefresh: jwksRefresh,
+		JWTIssuer:   getEnv("AUTH_JWT_ISSUER", ""),
+		JWTAudience: getEnv("AUTH_JWT_AUDIENCE", ""),
+
+		ChannelServiceURL:    getEnv("CHANNEL_SERVICE_URL", ""),
+		ChannelMembershipTTL: channelMembershipTTL,
+
+		ShareLinkDelivery:      getEnv("SHARE_LINK_DELIVERY", "redirect"),
+		ShareLinkURLTTL:        shareLinkURLTTL,
+		ShareLinkMaxAttempts:   shareLinkMaxAttempts,
+		ShareLinkAttemptWindow: shareLinkAttemptWindow,
+
+		ShareSweepInterval: shareSweepInterval,
+
+		DownloadURLSecret:   getEnv("DOWNLOAD_URL_SECRET", ""),
+		DownloadURLTTL:      downloadURLTTL,
+		DownloadURLMaxTTL:   downloadURLMaxTTL,
+		DownloadURLBindUser: downloadURLBindUser,
+		DownloadURLBindIP:   downloadURLBindIP,
+
+		APIKeyRotationGrace: apiKeyRotationGrace,
+		APIKeyAuditTTL:      apiKeyAuditTTL,
+
+		RateLimitStore:          getEnv("RATE_LIMIT_STORE", "memory"),
+		RateLimitRequests:       rateLimitRequests,
+		RateLimitUploadBytes:    rateLimitUploadBytes,
+		RateLimitSharePasswords: rateLimitSharePasswords,
+
+		OutboxRelayInterval: outboxRelayInterval,
+		OutboxBatchSize:     outboxBatchSize,
+		OutboxRetention:     outboxRetention,
+
+		KafkaConsumerGroup: getEnv("KAFKA_CONSUMER_GROUP", "attachment-service"),
+		HandledEventTTL:    handledEventTTL,
+		UpstreamPurgeDelay: upstreamPurgeDelay,
+		PurgeInterval:      purgeInterval,
+
+		KafkaProducerMode:   getEnv("KAFKA_PRODUCER_MODE", "async"),
+		KafkaCompression:    getEnv("KAFKA_COMPRESSION", "snappy"),
+		KafkaFlushFrequency: kafkaFlushFrequency,
+		KafkaFlushMessages:  kafkaFlushMessages,
+		KafkaBufferSize:     kafkaBufferSize,
+		KafkaPublishRetries: kafkaPublishRetries,
+
+		WebhookDispatchInterval: webhookDispatchInterval,
+		WebhookTimeout:          webhookTimeout,
+		WebhookMaxAttempts:      webhookMaxAttempts,
+		WebhookDisableAfter:     webhookDisableAfter,
+		WebhookLogTTL:           webhookLogTTL,
+		WebhookSecretGrace:      webhookSecretGrace,
+
+		RetentionInterval:     retentionInterval,
+		RetentionBatchSize:    retentionBatchSize,
+		RetentionArchiveClass: getEnv("RETENTION_ARCHIVE_CLASS", "GLACIER_IR"),
+		RetentionPurgeDelay:   retentionPurgeDelay,
+
+		TrashRetention: trashRetention,
+
+		ExpirySweepInterval: expirySweepInterval,
 	}
 }
 
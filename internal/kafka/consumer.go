@@ -0,0 +1,152 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	consumerMinBackoff = time.Second
+	consumerMaxBackoff = time.Minute
+)
+
+// Message is a consumed Kafka message.
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Timestamp time.Time
+}
+
+// Handler processes a message. It may be called more than once for the
+// same message, so it must be idempotent.
+type Handler func(ctx context.Context, msg *Message) error
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handler error as one that retrying won't fix, such as
+// a malformed message. The message is logged and skipped.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Consumer consumes topics as a member of a consumer group, passing each
+// message to its topic's handler. A message's offset is committed only
+// once it has been handled, so messages in flight when a replica stops are
+// redelivered. A failing message is retried with backoff, holding up its
+// partition, until it succeeds or its error is Permanent.
+type Consumer struct {
+	group    sarama.ConsumerGroup
+	handlers map[string]Handler
+}
+
+func NewConsumer(brokers []string, groupID string, handlers map[string]Handler) (*Consumer, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	config.Consumer.Offsets.AutoCommit.Enable = false
+
+	group, err := sarama.NewConsumerGroup(brokers, groupID, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Consumer{group: group, handlers: handlers}, nil
+}
+
+// Run consumes until ctx is done, rejoining the group after errors.
+func (c *Consumer) Run(ctx context.Context) {
+	topics := make([]string, 0, len(c.handlers))
+	for topic := range c.handlers {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+
+	for {
+		if err := c.group.Consume(ctx, topics, c); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return
+			}
+			log.Printf("Kafka consumer failed: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (c *Consumer) Close() error {
+	return c.group.Close()
+}
+
+func (c *Consumer) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (c *Consumer) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+// ConsumeClaim handles a partition's messages in order, committing each
+// offset after its message is handled.
+func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	handle := c.handlers[claim.Topic()]
+	for {
+		select {
+		case m, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if !c.process(session.Context(), handle, m) {
+				// Rebalancing or stopping; the next owner redelivers it
+				return nil
+			}
+			session.MarkMessage(m, "")
+			session.Commit()
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// process handles m, retrying until it succeeds or fails permanently. It
+// reports false if ctx ended first.
+func (c *Consumer) process(ctx context.Context, handle Handler, m *sarama.ConsumerMessage) bool {
+	msg := &Message{
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Key:       m.Key,
+		Value:     m.Value,
+		Timestamp: m.Timestamp,
+	}
+	backoff := consumerMinBackoff
+	for {
+		err := handle(ctx, msg)
+		if err == nil {
+			return true
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			log.Printf("Skipping %s message %d/%d: %v", m.Topic, m.Partition, m.Offset, err)
+			return true
+		}
+		log.Printf("Failed to handle %s message %d/%d, retrying in %s: %v", m.Topic, m.Partition, m.Offset, backoff, err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, consumerMaxBackoff)
+	}
+}
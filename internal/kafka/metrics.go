@@ -0,0 +1,57 @@
+package kafka
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProducerStats are a producer's delivery counts since it started.
+// Latency runs from publish to the brokers' acknowledgement.
+type ProducerStats struct {
+	Mode         string  `json:"mode"`
+	Produced     int64   `json:"produced"`
+	Failed       int64   `json:"failed"`
+	Retried      int64   `json:"retried"`
+	Dropped      int64   `json:"dropped"`
+	Buffered     int     `json:"buffered"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	MaxLatencyMs float64 `json:"max_latency_ms"`
+}
+
+type producerMetrics struct {
+	mode    string
+	failed  atomic.Int64
+	retried atomic.Int64
+	dropped atomic.Int64
+
+	mu         sync.Mutex
+	count      int64
+	latency    time.Duration
+	maxLatency time.Duration
+}
+
+func (m *producerMetrics) produced(latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.count++
+	m.latency += latency
+	m.maxLatency = max(m.maxLatency, latency)
+}
+
+func (m *producerMetrics) snapshot() ProducerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := ProducerStats{
+		Mode:         m.mode,
+		Produced:     m.count,
+		Failed:       m.failed.Load(),
+		Retried:      m.retried.Load(),
+		Dropped:      m.dropped.Load(),
+		MaxLatencyMs: float64(m.maxLatency) / float64(time.Millisecond),
+	}
+	if m.count > 0 {
+		stats.AvgLatencyMs = float64(m.latency) / float64(m.count) / float64(time.Millisecond)
+	}
+	return stats
+}
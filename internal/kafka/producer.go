@@ -1,50 +1,259 @@
 package kafka
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
+	"time"
+
+	"attachment-service/internal/config"
 
 	"github.com/IBM/sarama"
 )
 
+// Producer modes
+const (
+	ModeSync  = "sync"
+	ModeAsync = "async"
+)
+
+var (
+	ErrBufferFull     = errors.New("kafka producer buffer is full")
+	ErrProducerClosed = errors.New("kafka producer is closed")
+)
+
+// Producer publishes JSON messages to Kafka. In sync mode each publish
+// waits for the brokers to acknowledge it. In async mode messages are
+// buffered, batched and compressed, and Publish returns once the message
+// is queued; failed deliveries are retried by an idempotent producer with
+// one request in flight per broker, up to cfg.KafkaPublishRetries times,
+// so retries keep messages with the same key in order. A full buffer
+// rejects messages rather than blocking the caller.
 type Producer struct {
-	producer sarama.SyncProducer
+	syncProducer  sarama.SyncProducer
+	asyncProducer sarama.AsyncProducer
+	retries       int
+	metrics       *producerMetrics
+
+	// mu guards against sending on the async input after Close
+	mu     sync.RWMutex
+	closed bool
+	done   sync.WaitGroup
+}
+
+// delivery tracks an async message until it is acknowledged or fails.
+type delivery struct {
+	queued time.Time
+	// result, when set, receives the outcome
+	result chan error
 }
 
-func NewProducer(brokers []string) (*Producer, error) {
+func NewProducer(cfg *config.Config) (*Producer, error) {
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
 	config.Producer.RequiredAcks = sarama.WaitForAll
 	config.Producer.Retry.Max = 3
 
-	producer, err := sarama.NewSyncProducer(brokers, config)
+	compression, err := compressionCodec(cfg.KafkaCompression)
 	if err != nil {
 		return nil, err
 	}
+	config.Producer.Compression = compression
+	if compression == sarama.CompressionZSTD {
+		config.Version = sarama.V2_1_0_0
+	}
+
+	p := &Producer{retries: max(cfg.KafkaPublishRetries, 1), metrics: &producerMetrics{mode: cfg.KafkaProducerMode}}
+
+	switch cfg.KafkaProducerMode {
+	case ModeSync:
+		if p.syncProducer, err = sarama.NewSyncProducer(cfg.KafkaBrokers, config); err != nil {
+			return nil, err
+		}
+	case ModeAsync:
+		config.Producer.Idempotent = true
+		config.Net.MaxOpenRequests = 1
+		// The idempotent producer needs at least one retry
+		config.Producer.Retry.Max = max(cfg.KafkaPublishRetries, 1)
+		config.Producer.Retry.BackoffFunc = func(retries, _ int) time.Duration {
+			p.metrics.retried.Add(1)
+			return time.Duration(retries) * time.Second
+		}
+		if !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+			config.Version = sarama.V0_11_0_0
+		}
+		config.Producer.Return.Errors = true
+		config.Producer.Flush.Frequency = cfg.KafkaFlushFrequency
+		config.Producer.Flush.Messages = cfg.KafkaFlushMessages
+		config.ChannelBufferSize = cfg.KafkaBufferSize
+		if p.asyncProducer, err = sarama.NewAsyncProducer(cfg.KafkaBrokers, config); err != nil {
+			return nil, err
+		}
+		p.done.Add(2)
+		go p.handleSuccesses()
+		go p.handleErrors()
+	default:
+		return nil, fmt.Errorf("unknown kafka producer mode %q", cfg.KafkaProducerMode)
+	}
 
-	return &Producer{producer: producer}, nil
+	return p, nil
+}
+
+func compressionCodec(name string) (sarama.CompressionCodec, error) {
+	switch name {
+	case "", "none":
+		return sarama.CompressionNone, nil
+	case "gzip":
+		return sarama.CompressionGZIP, nil
+	case "snappy":
+		return sarama.CompressionSnappy, nil
+	case "lz4":
+		return sarama.CompressionLZ4, nil
+	case "zstd":
+		return sarama.CompressionZSTD, nil
+	}
+	return 0, fmt.Errorf("unknown kafka compression %q", name)
 }
 
 func (p *Producer) Publish(topic string, message any) error {
-	data, err := json.Marshal(message)
+	return p.PublishWithKey(topic, "", message)
+}
+
+// PublishWithKey publishes message under key, so that messages with the
+// same key land on the same partition and are consumed in order. In async
+// mode it returns once the message is queued.
+func (p *Producer) PublishWithKey(topic, key string, message any) error {
+	msg, err := newMessage(topic, key, message)
 	if err != nil {
 		return err
 	}
+	if p.asyncProducer == nil {
+		return p.sendSync(msg)
+	}
+	return p.enqueue(msg, &delivery{queued: time.Now()})
+}
+
+// Deliver publishes message under key like PublishWithKey, but waits until
+// the brokers acknowledge it in either mode, or until ctx is done. A message
+// given up on may still be delivered.
+func (p *Producer) Deliver(ctx context.Context, topic, key string, message any) error {
+	msg, err := newMessage(topic, key, message)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if p.asyncProducer == nil {
+		return p.sendSync(msg)
+	}
+	result := make(chan error, 1)
+	if err := p.enqueue(msg, &delivery{queued: time.Now(), result: result}); err != nil {
+		return err
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func newMessage(topic, key string, message any) (*sarama.ProducerMessage, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
 
 	msg := &sarama.ProducerMessage{
 		Topic: topic,
 		Value: sarama.ByteEncoder(data),
 	}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+	return msg, nil
+}
 
-	_, _, err = p.producer.SendMessage(msg)
+func (p *Producer) sendSync(msg *sarama.ProducerMessage) error {
+	start := time.Now()
+	_, _, err := p.syncProducer.SendMessage(msg)
 	if err != nil {
-		log.Printf("Failed to publish to topic %s: %v", topic, err)
+		p.metrics.failed.Add(1)
+		log.Printf("Failed to publish to topic %s: %v", msg.Topic, err)
 		return err
 	}
-
+	p.metrics.produced(time.Since(start))
 	return nil
 }
 
+// enqueue queues msg for the async producer without blocking.
+func (p *Producer) enqueue(msg *sarama.ProducerMessage, d *delivery) error {
+	msg.Metadata = d
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrProducerClosed
+	}
+	select {
+	case p.asyncProducer.Input() <- msg:
+		return nil
+	default:
+		p.metrics.dropped.Add(1)
+		return ErrBufferFull
+	}
+}
+
+func (p *Producer) handleSuccesses() {
+	defer p.done.Done()
+	for msg := range p.asyncProducer.Successes() {
+		d := msg.Metadata.(*delivery)
+		p.metrics.produced(time.Since(d.queued))
+		d.finish(nil)
+	}
+}
+
+// handleErrors counts messages the producer gave up on after its retries.
+func (p *Producer) handleErrors() {
+	defer p.done.Done()
+	for perr := range p.asyncProducer.Errors() {
+		p.metrics.failed.Add(1)
+		log.Printf("Failed to publish to topic %s after %d attempts: %v", perr.Msg.Topic, p.retries+1, perr.Err)
+		perr.Msg.Metadata.(*delivery).finish(perr.Err)
+	}
+}
+
+func (d *delivery) finish(err error) {
+	if d.result != nil {
+		d.result <- err
+	}
+}
+
+// Stats returns the producer's delivery metrics.
+func (p *Producer) Stats() ProducerStats {
+	if p == nil {
+		return ProducerStats{}
+	}
+	stats := p.metrics.snapshot()
+	if p.asyncProducer != nil {
+		stats.Buffered = len(p.asyncProducer.Input())
+	}
+	return stats
+}
+
+// Close flushes buffered messages and stops the producer.
 func (p *Producer) Close() error {
-	return p.producer.Close()
+	if p.syncProducer != nil {
+		return p.syncProducer.Close()
+	}
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	// The handlers drain the producer's channels until they close
+	p.asyncProducer.AsyncClose()
+	p.done.Wait()
+	return nil
 }
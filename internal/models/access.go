@@ -0,0 +1,119 @@
+package models
+
+// AccessAction is something a caller can do to an attachment.
+type AccessAction string
+
+const (
+	AccessView     AccessAction = "view"
+	AccessDownload AccessAction = "download"
+	AccessEdit     AccessAction = "edit"
+	AccessDelete   AccessAction = "delete"
+	AccessShare    AccessAction = "share"
+	// AccessRestore takes a deleted attachment out of the trash. Whoever
+	// may delete an attachment may restore it.
+	AccessRestore AccessAction = "restore"
+)
+
+// AllAccessActions lists every action, strongest last.
+var AllAccessActions = []AccessAction{AccessView, AccessDownload, AccessEdit, AccessDelete, AccessShare}
+
+// Sources an access grant can come from
+const (
+	GrantService        = "service"
+	GrantOwner          = "owner"
+	GrantWorkspaceAdmin = "workspace_admin"
+	GrantChannelMember  = "channel_member"
+	GrantPermission     = "permission"
+	GrantShare          = "share"
+	GrantAPIKey         = "api_key"
+)
+
+// AccessDecision is what a caller may do to an attachment and why.
+type AccessDecision struct {
+	AttachmentID string   `json:"attachment_id"`
+	UserID       string   `json:"user_id"`
+	CanView      bool     `json:"can_view"`
+	CanDownload  bool     `json:"can_download"`
+	CanEdit      bool     `json:"can_edit"`
+	CanDelete    bool     `json:"can_delete"`
+	CanShare     bool     `json:"can_share"`
+	Grants       []string `json:"grants"`
+}
+
+// Grant records that source allows the given actions.
+func (d *AccessDecision) Grant(source string, actions ...AccessAction) {
+	if len(actions) == 0 {
+		return
+	}
+	for _, action := range actions {
+		switch action {
+		case AccessView:
+			d.CanView = true
+		case AccessDownload:
+			d.CanDownload = true
+		case AccessEdit:
+			d.CanEdit = true
+		case AccessDelete:
+			d.CanDelete = true
+		case AccessShare:
+			d.CanShare = true
+		}
+	}
+	d.Grants = append(d.Grants, source)
+}
+
+func (d *AccessDecision) Allows(action AccessAction) bool {
+	switch action {
+	case AccessView:
+		return d.CanView
+	case AccessDownload:
+		return d.CanDownload
+	case AccessEdit:
+		return d.CanEdit
+	case AccessDelete, AccessRestore:
+		return d.CanDelete
+	case AccessShare:
+		return d.CanShare
+	}
+	return false
+}
+
+// SharePermission levels of AttachmentShare.Permission
+const (
+	ShareView     = "view"
+	ShareDownload = "download"
+	ShareEdit     = "edit"
+)
+
+// ShareActions maps a share's permission level to the actions it grants.
+// Each level includes the ones below it. Shares made before levels existed
+// have none and keep the view access they always gave.
+func ShareActions(permission string) []AccessAction {
+	switch permission {
+	case ShareView, "":
+		return []AccessAction{AccessView}
+	case ShareDownload:
+		return []AccessAction{AccessView, AccessDownload}
+	case ShareEdit:
+		return []AccessAction{AccessView, AccessDownload, AccessEdit}
+	}
+	return nil
+}
+
+// PermissionActions maps explicit permission flags to the actions they grant.
+func PermissionActions(p *AttachmentPermission) []AccessAction {
+	var actions []AccessAction
+	if p.CanView || p.CanDownload {
+		actions = append(actions, AccessView)
+	}
+	if p.CanDownload {
+		actions = append(actions, AccessDownload)
+	}
+	if p.CanDelete {
+		actions = append(actions, AccessDelete)
+	}
+	if p.CanShare {
+		actions = append(actions, AccessShare)
+	}
+	return actions
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ── API Keys ──
+
+// APIKey is a workspace-scoped credential for other services. Only a hash
+// of the secret is stored; Prefix identifies the key in logs and lookups.
+type APIKey struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WorkspaceID string             `bson:"workspace_id" json:"workspace_id"`
+	Name        string             `bson:"name" json:"name"`
+	Prefix      string             `bson:"prefix" json:"prefix"`
+	Hash        string             `bson:"hash" json:"-"`
+	Scopes      []string           `bson:"scopes" json:"scopes"`
+	CreatedBy   string             `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt   *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	LastUsedIP  string             `bson:"last_used_ip,omitempty" json:"last_used_ip,omitempty"`
+	RevokedAt   *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	RevokedBy   string             `bson:"revoked_by,omitempty" json:"revoked_by,omitempty"`
+	// RotatedFrom is the key this one replaced
+	RotatedFrom string `bson:"rotated_from,omitempty" json:"rotated_from,omitempty"`
+}
+
+// Active reports whether the key may still authenticate at now.
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// APIKeyAudit records one request made with an API key.
+type APIKeyAudit struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	KeyID       string             `bson:"key_id" json:"key_id"`
+	WorkspaceID string             `bson:"workspace_id" json:"workspace_id"`
+	Method      string             `bson:"method" json:"method"`
+	Route       string             `bson:"route" json:"route"`
+	Path        string             `bson:"path" json:"path"`
+	Status      int                `bson:"status" json:"status"`
+	IP          string             `bson:"ip" json:"ip"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt   time.Time          `bson:"expires_at" json:"-"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string   `json:"name" binding:"required"`
+	Scopes    []string `json:"scopes" binding:"required,min=1,dive,oneof=read write admin"`
+	ExpiresAt string   `json:"expires_at"`
+}
+
+// CreatedAPIKey is returned once when a key is created or rotated; Key is
+// the only time the secret is shown.
+type CreatedAPIKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
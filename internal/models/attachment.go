@@ -33,6 +33,7 @@ type Attachment struct {
 	WorkspaceID  string             `bson:"workspace_id" json:"workspace_id"`
 	ChannelID    string             `bson:"channel_id,omitempty" json:"channel_id,omitempty"`
 	MessageID    string             `bson:"message_id,omitempty" json:"message_id,omitempty"`
+	SessionID    string             `bson:"session_id,omitempty" json:"session_id,omitempty"`
 	FileName     string             `bson:"file_name" json:"file_name"`
 	OriginalName string             `bson:"original_name" json:"original_name"`
 	MimeType     string             `bson:"mime_type" json:"mime_type"`
@@ -45,7 +46,25 @@ type Attachment struct {
 	Metadata     *AttachmentMeta    `bson:"metadata,omitempty" json:"metadata,omitempty"`
 	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
-	ExpiresAt    *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	// ExpiresAt is set on ephemeral attachments, which are gone once it passes
+	ExpiresAt *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	// A deleted attachment stays in the trash, and can be restored to the
+	// status it was deleted from, until its stored content is removed at
+	// PurgeAt. PurgingAt is set while a purge is removing it.
+	DeletedAt   *time.Time       `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy   string           `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+	DeletedFrom AttachmentStatus `bson:"deleted_from,omitempty" json:"-"`
+	PurgeAt     *time.Time       `bson:"purge_at,omitempty" json:"purge_at,omitempty"`
+	PurgingAt   *time.Time       `bson:"purging_at,omitempty" json:"-"`
+	PurgedAt    *time.Time       `bson:"purged_at,omitempty" json:"purged_at,omitempty"`
+	// StorageClass is set once a retention policy has archived the content
+	StorageClass string     `bson:"storage_class,omitempty" json:"storage_class,omitempty"`
+	ArchivedAt   *time.Time `bson:"archived_at,omitempty" json:"archived_at,omitempty"`
+}
+
+// Expired reports whether an ephemeral attachment's lifetime is over.
+func (a *Attachment) Expired(now time.Time) bool {
+	return a.ExpiresAt != nil && !now.Before(*a.ExpiresAt)
 }
 
 type AttachmentMeta struct {
@@ -58,10 +77,13 @@ type AttachmentMeta struct {
 }
 
 type UploadRequest struct {
-	UserID      string `form:"user_id" binding:"required"`
-	WorkspaceID string `form:"workspace_id" binding:"required"`
+	UserID      string `form:"user_id"`
+	WorkspaceID string `form:"workspace_id"`
 	ChannelID   string `form:"channel_id"`
 	MessageID   string `form:"message_id"`
+	// ExpiresIn makes the attachment ephemeral: it expires that many
+	// seconds after upload
+	ExpiresIn int `form:"expires_in"`
 }
 
 type UploadResponse struct {
@@ -70,12 +92,16 @@ type UploadResponse struct {
 }
 
 type InitiateUploadRequest struct {
-	UserID      string `json:"user_id" binding:"required"`
-	WorkspaceID string `json:"workspace_id" binding:"required"`
+	UserID      string `json:"user_id"`
+	WorkspaceID string `json:"workspace_id"`
 	ChannelID   string `json:"channel_id"`
+	MessageID   string `json:"message_id"`
 	FileName    string `json:"file_name" binding:"required"`
 	MimeType    string `json:"mime_type" binding:"required"`
 	Size        int64  `json:"size" binding:"required"`
+	// ExpiresIn makes the attachment ephemeral: it expires that many
+	// seconds after the upload is initiated
+	ExpiresIn int `json:"expires_in,omitempty"`
 }
 
 type CompleteUploadRequest struct {
@@ -0,0 +1,273 @@
+package models
+
+import "time"
+
+// ── Lifecycle Events ──
+
+// Event types, which are also the Kafka topics they are published to
+const (
+	EventUploaded       = "attachments.uploaded"
+	EventReady          = "attachments.ready"
+	EventFailed         = "attachments.failed"
+	EventDeleted        = "attachments.deleted"
+	EventRestored       = "attachments.restored"
+	EventMoved          = "attachments.moved"
+	EventRenamed        = "attachments.renamed"
+	EventShared         = "attachments.shared"
+	EventShareExpired   = "attachments.share_expired"
+	EventVersionAdded   = "attachments.version_added"
+	EventCommented      = "attachments.commented"
+	EventScanned        = "attachments.scanned"
+	EventArchived       = "attachments.archived"
+	EventPreviewsPurged = "attachments.previews_purged"
+	EventExpired        = "attachments.expired"
+)
+
+// EventTypes lists every lifecycle event type.
+var EventTypes = []string{
+	EventUploaded, EventReady, EventFailed, EventDeleted, EventRestored, EventMoved,
+	EventRenamed, EventShared, EventShareExpired, EventVersionAdded, EventCommented,
+	EventScanned, EventArchived, EventPreviewsPurged, EventExpired,
+}
+
+// Actor types
+const (
+	ActorUser    = "user"
+	ActorAPIKey  = "api_key"
+	ActorService = "service"
+	ActorSystem  = "system"
+)
+
+// Event is the envelope every lifecycle event is published in. Its JSON
+// form is a CloudEvents 1.0 structured-mode event; schemaversion, actor,
+// actortype and workspaceid are extension attributes.
+type Event struct {
+	SpecVersion     string `json:"specversion"`
+	ID              string `json:"id"`
+	Source          string `json:"source"`
+	Type            string `json:"type"`
+	Subject         string `json:"subject"`
+	DataContentType string `json:"datacontenttype"`
+	// OccurredAt is when the change happened (CloudEvents time)
+	OccurredAt    time.Time `json:"time"`
+	SchemaVersion int       `json:"schemaversion"`
+	Actor         string    `json:"actor"`
+	ActorType     string    `json:"actortype"`
+	WorkspaceID   string    `json:"workspaceid,omitempty"`
+	Data          EventData `json:"data"`
+}
+
+// EventData is the typed payload of an event. Each type's SchemaVersion
+// is bumped on incompatible changes to its fields.
+type EventData interface {
+	EventType() string
+	SchemaVersion() int
+	// Subject is the attachment the event is about
+	Subject() string
+	Workspace() string
+}
+
+// AttachmentRef identifies the attachment an event is about.
+type AttachmentRef struct {
+	AttachmentID string `json:"attachment_id"`
+	WorkspaceID  string `json:"workspace_id"`
+}
+
+func (r AttachmentRef) Subject() string   { return r.AttachmentID }
+func (r AttachmentRef) Workspace() string { return r.WorkspaceID }
+
+// RefOf returns the reference to an attachment.
+func RefOf(a *Attachment) AttachmentRef {
+	return AttachmentRef{AttachmentID: a.ID.Hex(), WorkspaceID: a.WorkspaceID}
+}
+
+// AttachmentUploaded: a file's content has been stored.
+type AttachmentUploaded struct {
+	AttachmentRef
+	UserID    string `json:"user_id"`
+	ChannelID string `json:"channel_id,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	FileName  string `json:"file_name"`
+	MimeType  string `json:"mime_type"`
+	Size      int64  `json:"size"`
+	Checksum  string `json:"checksum,omitempty"`
+}
+
+func (AttachmentUploaded) EventType() string  { return EventUploaded }
+func (AttachmentUploaded) SchemaVersion() int { return 1 }
+
+// AttachmentReady: an attachment can be downloaded.
+type AttachmentReady struct {
+	AttachmentRef
+	Type     AttachmentType `json:"type"`
+	MimeType string         `json:"mime_type"`
+	Size     int64          `json:"size"`
+}
+
+func (AttachmentReady) EventType() string  { return EventReady }
+func (AttachmentReady) SchemaVersion() int { return 1 }
+
+// AttachmentFailed: an upload was abandoned before completing.
+type AttachmentFailed struct {
+	AttachmentRef
+	UserID string `json:"user_id"`
+	Reason string `json:"reason"`
+}
+
+func (AttachmentFailed) EventType() string  { return EventFailed }
+func (AttachmentFailed) SchemaVersion() int { return 1 }
+
+// AttachmentDeleted: an attachment was deleted. Reason is set when it
+// was deleted by the service rather than a user.
+type AttachmentDeleted struct {
+	AttachmentRef
+	UserID    string `json:"user_id"`
+	ChannelID string `json:"channel_id,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	Size      int64  `json:"size"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Reasons the service deletes attachments
+const DeleteReasonRetention = "retention"
+
+func (AttachmentDeleted) EventType() string  { return EventDeleted }
+func (AttachmentDeleted) SchemaVersion() int { return 1 }
+
+// AttachmentRestored: a deleted attachment was brought back.
+type AttachmentRestored struct {
+	AttachmentRef
+	UserID string `json:"user_id"`
+}
+
+func (AttachmentRestored) EventType() string  { return EventRestored }
+func (AttachmentRestored) SchemaVersion() int { return 1 }
+
+// AttachmentMoved: an attachment changed channel or message.
+type AttachmentMoved struct {
+	AttachmentRef
+	FromChannelID string `json:"from_channel_id,omitempty"`
+	ToChannelID   string `json:"to_channel_id"`
+	FromMessageID string `json:"from_message_id,omitempty"`
+	ToMessageID   string `json:"to_message_id,omitempty"`
+}
+
+func (AttachmentMoved) EventType() string  { return EventMoved }
+func (AttachmentMoved) SchemaVersion() int { return 1 }
+
+// AttachmentRenamed: an attachment's display name changed.
+type AttachmentRenamed struct {
+	AttachmentRef
+	OldName string `json:"old_name"`
+	NewName string `json:"new_name"`
+}
+
+func (AttachmentRenamed) EventType() string  { return EventRenamed }
+func (AttachmentRenamed) SchemaVersion() int { return 1 }
+
+// Ways an attachment can be shared
+const (
+	ShareKindUser       = "user"
+	ShareKindLink       = "link"
+	ShareKindPermission = "permission"
+)
+
+// AttachmentShared: access to an attachment was granted to a user, or
+// through a share link.
+type AttachmentShared struct {
+	AttachmentRef
+	Kind       string     `json:"kind"`
+	ShareID    string     `json:"share_id"`
+	SharedWith string     `json:"shared_with,omitempty"`
+	Permission string     `json:"permission,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+func (AttachmentShared) EventType() string  { return EventShared }
+func (AttachmentShared) SchemaVersion() int { return 1 }
+
+// AttachmentShareExpired: a user-to-user share reached its expiry and was
+// removed.
+type AttachmentShareExpired struct {
+	AttachmentRef
+	ShareID    string    `json:"share_id"`
+	SharedBy   string    `json:"shared_by"`
+	SharedWith string    `json:"shared_with"`
+	Permission string    `json:"permission,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func (AttachmentShareExpired) EventType() string  { return EventShareExpired }
+func (AttachmentShareExpired) SchemaVersion() int { return 1 }
+
+// AttachmentVersionAdded: a new version of an attachment was stored.
+type AttachmentVersionAdded struct {
+	AttachmentRef
+	VersionID string `json:"version_id"`
+	Version   int    `json:"version"`
+	Size      int64  `json:"size"`
+}
+
+func (AttachmentVersionAdded) EventType() string  { return EventVersionAdded }
+func (AttachmentVersionAdded) SchemaVersion() int { return 1 }
+
+// AttachmentCommented: a comment was added to an attachment.
+type AttachmentCommented struct {
+	AttachmentRef
+	CommentID string `json:"comment_id"`
+	UserID    string `json:"user_id"`
+	ParentID  string `json:"parent_id,omitempty"`
+}
+
+func (AttachmentCommented) EventType() string  { return EventCommented }
+func (AttachmentCommented) SchemaVersion() int { return 1 }
+
+// AttachmentScanned: a virus scan of an attachment finished.
+type AttachmentScanned struct {
+	AttachmentRef
+	ScanID  string `json:"scan_id"`
+	Status  string `json:"status"`
+	Engine  string `json:"engine"`
+	Details string `json:"details,omitempty"`
+}
+
+func (AttachmentScanned) EventType() string  { return EventScanned }
+func (AttachmentScanned) SchemaVersion() int { return 1 }
+
+// AttachmentArchived: a retention policy moved an attachment's content to
+// a cold storage class.
+type AttachmentArchived struct {
+	AttachmentRef
+	PolicyID     string `json:"policy_id"`
+	StorageClass string `json:"storage_class"`
+	Size         int64  `json:"size"`
+}
+
+func (AttachmentArchived) EventType() string  { return EventArchived }
+func (AttachmentArchived) SchemaVersion() int { return 1 }
+
+// AttachmentPreviewsPurged: a retention policy removed an attachment's
+// previews.
+type AttachmentPreviewsPurged struct {
+	AttachmentRef
+	PolicyID string `json:"policy_id"`
+	Count    int    `json:"count"`
+}
+
+func (AttachmentPreviewsPurged) EventType() string  { return EventPreviewsPurged }
+func (AttachmentPreviewsPurged) SchemaVersion() int { return 1 }
+
+// AttachmentExpired: an ephemeral attachment's lifetime ended and it was
+// removed.
+type AttachmentExpired struct {
+	AttachmentRef
+	UserID    string    `json:"user_id"`
+	ChannelID string    `json:"channel_id,omitempty"`
+	MessageID string    `json:"message_id,omitempty"`
+	Size      int64     `json:"size"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (AttachmentExpired) EventType() string  { return EventExpired }
+func (AttachmentExpired) SchemaVersion() int { return 1 }
@@ -126,11 +126,16 @@ type ShareLink struct {
 	AttachmentID string             `bson:"attachment_id" json:"attachment_id"`
 	Code         string             `bson:"code" json:"code"`
 	CreatedBy    string             `bson:"created_by" json:"created_by"`
-	Password     string             `bson:"password,omitempty" json:"password,omitempty"`
+	// Password holds a bcrypt hash; links created before hashing may still
+	// hold plaintext until their first successful unlock.
+	Password     string             `bson:"password,omitempty" json:"-"`
+	HasPassword  bool               `bson:"has_password" json:"has_password"`
 	MaxDownloads int                `bson:"max_downloads" json:"max_downloads"`
 	DownloadCount int               `bson:"download_count" json:"download_count"`
 	ExpiresAt    *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
 	IsActive     bool               `bson:"is_active" json:"is_active"`
+	RevokedAt    *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	RevokedBy    string             `bson:"revoked_by,omitempty" json:"revoked_by,omitempty"`
 	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
 }
 
@@ -161,11 +166,12 @@ type AttachmentPreview struct {
 // ── Quota & Stats ──
 
 type UserQuota struct {
-	UserID     string `json:"user_id"`
-	UsedBytes  int64  `json:"used_bytes"`
-	MaxBytes   int64  `json:"max_bytes"`
-	FileCount  int64  `json:"file_count"`
-	MaxFiles   int64  `json:"max_files"`
+	UserID        string `json:"user_id"`
+	UsedBytes     int64  `json:"used_bytes"`
+	MaxBytes      int64  `json:"max_bytes"`
+	FileCount     int64  `json:"file_count"`
+	MaxFiles      int64  `json:"max_files"`
+	ReservedBytes int64  `json:"reserved_bytes"`
 }
 
 type AttachmentStats struct {
@@ -200,7 +206,7 @@ type AddTagRequest struct {
 
 type CreateShareRequest struct {
 	SharedWith string `json:"shared_with" binding:"required"`
-	Permission string `json:"permission" binding:"required"`
+	Permission string `json:"permission" binding:"required,oneof=view download edit"`
 	ExpiresAt  string `json:"expires_at"`
 }
 
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ── Idempotency Keys ──
+
+type IdempotencyStatus string
+
+const (
+	IdempotencyProcessing IdempotencyStatus = "processing"
+	IdempotencyCompleted  IdempotencyStatus = "completed"
+)
+
+// IdempotencyRecord remembers the request an Idempotency-Key was first used
+// with and, once it finished, the response to replay for retries.
+type IdempotencyRecord struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Key         string             `bson:"key" json:"key"`
+	Fingerprint string             `bson:"fingerprint" json:"fingerprint"`
+	Status      IdempotencyStatus  `bson:"status" json:"status"`
+	StatusCode  int                `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	ContentType string             `bson:"content_type,omitempty" json:"content_type,omitempty"`
+	Body        []byte             `bson:"body,omitempty" json:"-"`
+	LockedAt    time.Time          `bson:"locked_at" json:"locked_at"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt   time.Time          `bson:"expires_at" json:"expires_at"`
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ── Legal Holds ──
+
+// What a legal hold covers
+type LegalHoldScope string
+
+const (
+	HoldScopeAttachment LegalHoldScope = "attachment"
+	HoldScopeUser       LegalHoldScope = "user"
+	HoldScopeChannel    LegalHoldScope = "channel"
+	HoldScopeWorkspace  LegalHoldScope = "workspace"
+)
+
+// Legal hold statuses
+const (
+	HoldActive   = "active"
+	HoldReleased = "released"
+)
+
+// LegalHold freezes the attachments in its scope: an attachment, a user's
+// or a channel's attachments, or a whole workspace. Nothing covered by an
+// active hold may be deleted, by users or by retention, until the hold is
+// released. Custodian is who the hold was placed on behalf of.
+type LegalHold struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WorkspaceID   string             `bson:"workspace_id" json:"workspace_id"`
+	Scope         LegalHoldScope     `bson:"scope" json:"scope"`
+	ScopeID       string             `bson:"scope_id" json:"scope_id"`
+	Reason        string             `bson:"reason" json:"reason"`
+	Custodian     string             `bson:"custodian" json:"custodian"`
+	Status        string             `bson:"status" json:"status"`
+	CreatedBy     string             `bson:"created_by" json:"created_by"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	ReleasedAt    *time.Time         `bson:"released_at,omitempty" json:"released_at,omitempty"`
+	ReleasedBy    string             `bson:"released_by,omitempty" json:"released_by,omitempty"`
+	ReleaseReason string             `bson:"release_reason,omitempty" json:"release_reason,omitempty"`
+}
+
+// Covers reports whether the hold applies to a.
+func (h *LegalHold) Covers(a *Attachment) bool {
+	if h.Status != HoldActive || h.WorkspaceID != a.WorkspaceID {
+		return false
+	}
+	switch h.Scope {
+	case HoldScopeAttachment:
+		return h.ScopeID == a.ID.Hex()
+	case HoldScopeUser:
+		return h.ScopeID == a.UserID
+	case HoldScopeChannel:
+		return h.ScopeID == a.ChannelID
+	case HoldScopeWorkspace:
+		return true
+	}
+	return false
+}
+
+// Legal hold audit actions
+const (
+	HoldAuditCreated  = "created"
+	HoldAuditReleased = "released"
+	HoldAuditBlocked  = "blocked"
+)
+
+// Operations a legal hold blocks
+const (
+	HoldOpDelete        = "delete"
+	HoldOpBulkDelete    = "bulk_delete"
+	HoldOpRetention     = "retention"
+	HoldOpVersionDelete = "version_delete"
+	HoldOpPurge         = "purge"
+	HoldOpExpiry        = "expiry"
+)
+
+// LegalHoldAudit records a change to a hold, or an operation it blocked
+// and the attachments that operation would have removed. Entries are kept
+// indefinitely.
+type LegalHoldAudit struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	HoldID        string             `bson:"hold_id" json:"hold_id"`
+	WorkspaceID   string             `bson:"workspace_id" json:"workspace_id"`
+	Action        string             `bson:"action" json:"action"`
+	Actor         string             `bson:"actor" json:"actor"`
+	ActorType     string             `bson:"actor_type" json:"actor_type"`
+	Operation     string             `bson:"operation,omitempty" json:"operation,omitempty"`
+	AttachmentIDs []string           `bson:"attachment_ids,omitempty" json:"attachment_ids,omitempty"`
+	Details       string             `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type CreateLegalHoldRequest struct {
+	Scope     LegalHoldScope `json:"scope" binding:"required,oneof=attachment user channel workspace"`
+	ScopeID   string         `json:"scope_id"`
+	Reason    string         `json:"reason" binding:"required"`
+	Custodian string         `json:"custodian" binding:"required"`
+}
+
+type ReleaseLegalHoldRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type OutboxStatus string
+
+const (
+	OutboxPending OutboxStatus = "pending"
+	OutboxSent    OutboxStatus = "sent"
+)
+
+// OutboxEvent is a Kafka message written alongside the change it
+// announces and published later by the outbox relay. Events with the same
+// Key are published in the order they were created.
+type OutboxEvent struct {
+	ID            primitive.ObjectID `bson:"_id" json:"id"`
+	Topic         string             `bson:"topic" json:"topic"`
+	Key           string             `bson:"key" json:"key"`
+	Payload       string             `bson:"payload" json:"payload"`
+	Status        OutboxStatus       `bson:"status" json:"status"`
+	Attempts      int                `bson:"attempts" json:"attempts"`
+	LastError     string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at" json:"next_attempt_at"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	SentAt        *time.Time         `bson:"sent_at,omitempty" json:"sent_at,omitempty"`
+	// ExpiresAt is set once sent so the TTL index removes the event
+	ExpiresAt *time.Time `bson:"expires_at,omitempty" json:"-"`
+}
+
+// NewOutboxEvent encodes payload as JSON for publishing to topic under key.
+func NewOutboxEvent(topic, key string, payload any) (*OutboxEvent, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &OutboxEvent{
+		ID:            primitive.NewObjectID(),
+		Topic:         topic,
+		Key:           key,
+		Payload:       string(data),
+		Status:        OutboxPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}, nil
+}
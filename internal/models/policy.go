@@ -0,0 +1,76 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ── Workspace Upload Policies ──
+
+// WorkspacePolicy holds per-workspace overrides for upload limits. Zero values
+// mean "not set" and fall back to the process-wide defaults in config.Config.
+type WorkspacePolicy struct {
+	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WorkspaceID        string             `bson:"workspace_id" json:"workspace_id"`
+	AllowedTypes       []string           `bson:"allowed_types,omitempty" json:"allowed_types,omitempty"`
+	BlockedTypes       []string           `bson:"blocked_types,omitempty" json:"blocked_types,omitempty"`
+	MaxFileSize        int64              `bson:"max_file_size,omitempty" json:"max_file_size,omitempty"`
+	MaxFilesPerMessage int                `bson:"max_files_per_message,omitempty" json:"max_files_per_message,omitempty"`
+	StripMetadata      *bool              `bson:"strip_metadata,omitempty" json:"strip_metadata,omitempty"`
+	RetentionDays      *int               `bson:"retention_days,omitempty" json:"retention_days,omitempty"`
+	MaxExpiresIn       *int               `bson:"max_expires_in,omitempty" json:"max_expires_in,omitempty"`
+	UpdatedBy          string             `bson:"updated_by" json:"updated_by"`
+	CreatedAt          time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt          time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// EffectivePolicy is a workspace policy merged with the config defaults.
+type EffectivePolicy struct {
+	WorkspaceID        string   `json:"workspace_id"`
+	AllowedTypes       []string `json:"allowed_types"`
+	BlockedTypes       []string `json:"blocked_types"`
+	MaxFileSize        int64    `json:"max_file_size"`
+	MaxFilesPerMessage int      `json:"max_files_per_message"`
+	StripMetadata      bool     `json:"strip_metadata"`
+	RetentionDays      int      `json:"retention_days"`
+	MaxExpiresIn       int      `json:"max_expires_in"` // seconds; 0 disallows ephemeral uploads
+	Source             string   `json:"source"`         // workspace, default
+}
+
+// AllowsType reports whether mimeType may be uploaded. Entries may use a
+// "type/*" wildcard, and blocked types win over allowed ones.
+func (p *EffectivePolicy) AllowsType(mimeType string) bool {
+	for _, blocked := range p.BlockedTypes {
+		if matchMimeType(blocked, mimeType) {
+			return false
+		}
+	}
+	for _, allowed := range p.AllowedTypes {
+		if matchMimeType(allowed, mimeType) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchMimeType(pattern, mimeType string) bool {
+	if pattern == "*/*" || pattern == mimeType {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mimeType, prefix+"/")
+	}
+	return false
+}
+
+type UpsertPolicyRequest struct {
+	AllowedTypes       []string `json:"allowed_types"`
+	BlockedTypes       []string `json:"blocked_types"`
+	MaxFileSize        int64    `json:"max_file_size"`
+	MaxFilesPerMessage int      `json:"max_files_per_message"`
+	StripMetadata      *bool    `json:"strip_metadata"`
+	RetentionDays      *int     `json:"retention_days"`
+	MaxExpiresIn       *int     `json:"max_expires_in"`
+}
@@ -0,0 +1,37 @@
+package models
+
+// ── Storage Quotas ──
+
+// QuotaThresholds are the usage percentages announced via quota.threshold_crossed.
+var QuotaThresholds = []int{80, 95, 100}
+
+// QuotaStatus is a user or workspace usage counter with its effective
+// limits resolved. A limit of 0 means unlimited.
+type QuotaStatus struct {
+	Scope         UsageScope `json:"scope"`
+	ScopeID       string     `json:"scope_id"`
+	MaxBytes      int64      `json:"max_bytes"`
+	MaxFiles      int64      `json:"max_files"`
+	UsedBytes     int64      `json:"used_bytes"`
+	UsedFiles     int64      `json:"used_files"`
+	ReservedBytes int64      `json:"reserved_bytes"`
+	ReservedFiles int64      `json:"reserved_files"`
+	Overridden    bool       `json:"overridden"`
+}
+
+// UsagePercent returns the higher of the byte and file usage percentages.
+func (q *QuotaStatus) UsagePercent() int {
+	pct := 0
+	if q.MaxBytes > 0 {
+		pct = int(q.UsedBytes * 100 / q.MaxBytes)
+	}
+	if q.MaxFiles > 0 {
+		pct = max(pct, int(q.UsedFiles*100/q.MaxFiles))
+	}
+	return pct
+}
+
+type SetQuotaRequest struct {
+	MaxBytes *int64 `json:"max_bytes"`
+	MaxFiles *int64 `json:"max_files"`
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Retention actions
+const (
+	RetentionDelete        = "delete"
+	RetentionArchive       = "archive"
+	RetentionPurgePreviews = "purge_previews"
+)
+
+// ValidRetentionAction reports whether action is a known retention action.
+func ValidRetentionAction(action string) bool {
+	switch action {
+	case RetentionDelete, RetentionArchive, RetentionPurgePreviews:
+		return true
+	}
+	return false
+}
+
+// AttachmentRetention applies Action to a workspace's ready attachments
+// older than MaxAgeDays. MimeType is an exact type, a "type/*" prefix, or
+// empty for every type.
+type AttachmentRetention struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WorkspaceID string             `bson:"workspace_id" json:"workspace_id"`
+	MimeType    string             `bson:"mime_type" json:"mime_type"`
+	MaxAgeDays  int                `bson:"max_age_days" json:"max_age_days"`
+	Action      string             `bson:"action" json:"action"`
+	IsActive    bool               `bson:"is_active" json:"is_active"`
+	CreatedBy   string             `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	// LastEnforcedAt and LastAffected describe the policy's latest run
+	LastEnforcedAt *time.Time `bson:"last_enforced_at,omitempty" json:"last_enforced_at,omitempty"`
+	LastAffected   int        `bson:"last_affected" json:"last_affected"`
+}
+
+// Cutoff returns the creation time before which attachments are affected
+// at now.
+func (p *AttachmentRetention) Cutoff(now time.Time) time.Time {
+	return now.AddDate(0, 0, -p.MaxAgeDays)
+}
+
+// MimePrefix returns the prefix MimeType matches for a "type/*" pattern,
+// or false for an exact type.
+func (p *AttachmentRetention) MimePrefix() (string, bool) {
+	if prefix, ok := strings.CutSuffix(p.MimeType, "*"); ok {
+		return prefix, true
+	}
+	return "", false
+}
+
+// RetentionMatch is an attachment a retention policy would act on.
+type RetentionMatch struct {
+	AttachmentID string    `json:"attachment_id"`
+	UserID       string    `json:"user_id"`
+	ChannelID    string    `json:"channel_id,omitempty"`
+	FileName     string    `json:"file_name"`
+	MimeType     string    `json:"mime_type"`
+	Size         int64     `json:"size"`
+	StorageClass string    `json:"storage_class,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	// Previews is how many previews would be purged
+	Previews int `json:"previews,omitempty"`
+}
+
+// RetentionPreview lists what a policy would act on if enforced now. Held
+// counts the matches seen that legal holds protect.
+type RetentionPreview struct {
+	Policy  *AttachmentRetention `json:"policy"`
+	Cutoff  time.Time            `json:"cutoff"`
+	Matches []*RetentionMatch    `json:"matches"`
+	HasMore bool                 `json:"has_more"`
+	Held    int                  `json:"held"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ── Share Link Access History ──
+
+type ShareLinkOutcome string
+
+const (
+	ShareLinkOK               ShareLinkOutcome = "ok"
+	ShareLinkPasswordRequired ShareLinkOutcome = "password_required"
+	ShareLinkWrongPassword    ShareLinkOutcome = "wrong_password"
+	ShareLinkRateLimited      ShareLinkOutcome = "rate_limited"
+	ShareLinkExpired          ShareLinkOutcome = "expired"
+	ShareLinkLimitReached     ShareLinkOutcome = "limit_reached"
+	ShareLinkUnavailable      ShareLinkOutcome = "unavailable"
+)
+
+// ShareLinkVisitor describes the anonymous client resolving a share link.
+type ShareLinkVisitor struct {
+	IP        string `bson:"ip" json:"ip"`
+	UserAgent string `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	Referer   string `bson:"referer,omitempty" json:"referer,omitempty"`
+}
+
+// ShareLinkAccess records one resolution of a share link and its outcome.
+type ShareLinkAccess struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	LinkID           string             `bson:"link_id" json:"link_id"`
+	AttachmentID     string             `bson:"attachment_id" json:"attachment_id"`
+	ShareLinkVisitor `bson:",inline"`
+	Outcome          ShareLinkOutcome `bson:"outcome" json:"outcome"`
+	CreatedAt        time.Time        `bson:"created_at" json:"created_at"`
+}
+
+// ShareLinkDailyCount is the number of resolutions of a link on one UTC day.
+type ShareLinkDailyCount struct {
+	Date      string `bson:"_id" json:"date"`
+	Total     int64  `bson:"total" json:"total"`
+	Downloads int64  `bson:"downloads" json:"downloads"`
+}
+
+// ShareLinkAnalytics summarises a link's access history since Since.
+type ShareLinkAnalytics struct {
+	Link           *ShareLink                 `json:"link"`
+	Since          time.Time                  `json:"since"`
+	Total          int64                      `json:"total"`
+	Downloads      int64                      `json:"downloads"`
+	UniqueVisitors int64                      `json:"unique_visitors"`
+	ByOutcome      map[ShareLinkOutcome]int64 `json:"by_outcome"`
+	Daily          []ShareLinkDailyCount      `json:"daily"`
+	Recent         []*ShareLinkAccess         `json:"recent"`
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ── Upload Sessions ──
+
+type UploadSessionStatus string
+
+const (
+	SessionOpen       UploadSessionStatus = "open"
+	SessionCompleting UploadSessionStatus = "completing"
+	SessionCompleted  UploadSessionStatus = "completed"
+	SessionCancelled  UploadSessionStatus = "cancelled"
+	SessionExpired    UploadSessionStatus = "expired"
+)
+
+type SessionFileStatus string
+
+const (
+	SessionFilePending   SessionFileStatus = "pending"
+	SessionFileUploading SessionFileStatus = "uploading"
+	SessionFileUploaded  SessionFileStatus = "uploaded"
+	SessionFileReady     SessionFileStatus = "ready"
+	SessionFileFailed    SessionFileStatus = "failed"
+)
+
+// UploadSession groups the presigned uploads of a multi-file message. Its
+// attachments stay pending and unlinked from the message until the whole
+// session completes.
+type UploadSession struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID        string              `bson:"user_id" json:"user_id"`
+	WorkspaceID   string              `bson:"workspace_id" json:"workspace_id"`
+	ChannelID     string              `bson:"channel_id,omitempty" json:"channel_id,omitempty"`
+	MessageID     string              `bson:"message_id,omitempty" json:"message_id,omitempty"`
+	Status        UploadSessionStatus `bson:"status" json:"status"`
+	Files         []UploadSessionFile `bson:"files" json:"files"`
+	TotalBytes    int64               `bson:"total_bytes" json:"total_bytes"`
+	UploadedBytes int64               `bson:"-" json:"uploaded_bytes"`
+	CreatedAt     time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time           `bson:"updated_at" json:"updated_at"`
+	ExpiresAt     time.Time           `bson:"expires_at" json:"expires_at"`
+	CompletedAt   *time.Time          `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+type UploadSessionFile struct {
+	AttachmentID  string            `bson:"attachment_id" json:"attachment_id"`
+	FileName      string            `bson:"file_name" json:"file_name"`
+	MimeType      string            `bson:"mime_type" json:"mime_type"`
+	Size          int64             `bson:"size" json:"size"`
+	UploadedBytes int64             `bson:"uploaded_bytes" json:"uploaded_bytes"`
+	Status        SessionFileStatus `bson:"status" json:"status"`
+	Error         string            `bson:"error,omitempty" json:"error,omitempty"`
+	UploadURL     string            `bson:"-" json:"upload_url,omitempty"`
+}
+
+type SessionFileRequest struct {
+	FileName string `json:"file_name" binding:"required"`
+	MimeType string `json:"mime_type" binding:"required"`
+	Size     int64  `json:"size" binding:"required"`
+}
+
+type CreateUploadSessionRequest struct {
+	UserID      string               `json:"user_id"`
+	WorkspaceID string               `json:"workspace_id"`
+	ChannelID   string               `json:"channel_id"`
+	MessageID   string               `json:"message_id"`
+	Files       []SessionFileRequest `json:"files" binding:"required,min=1,dive"`
+	// ExpiresIn makes every file in the session ephemeral: they expire
+	// that many seconds after the session is created
+	ExpiresIn int `json:"expires_in,omitempty"`
+}
+
+type CompleteUploadSessionRequest struct {
+	MessageID string `json:"message_id"`
+}
+
+type SessionFileProgressRequest struct {
+	UploadedBytes int64 `json:"uploaded_bytes"`
+}
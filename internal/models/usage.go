@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ── Usage Counters ──
+
+type UsageScope string
+
+const (
+	UsageScopeUser          UsageScope = "user"
+	UsageScopeWorkspace     UsageScope = "workspace"
+	UsageScopeChannel       UsageScope = "channel"
+	UsageScopeWorkspaceUser UsageScope = "workspace_user"
+)
+
+// UsageCounter is the incrementally maintained usage of one scope. Files
+// count as used while uploading, processing or ready; pending presigned
+// uploads and unclaimed QuotaReservations count as reserved. For user and workspace scopes the counter also
+// serves as the quota ledger, with MaxBytes/MaxFiles holding admin overrides.
+type UsageCounter struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Scope             UsageScope         `bson:"scope" json:"scope"`
+	ScopeID           string             `bson:"scope_id" json:"scope_id"`
+	WorkspaceID       string             `bson:"workspace_id,omitempty" json:"workspace_id,omitempty"`
+	UsedBytes         int64              `bson:"used_bytes" json:"used_bytes"`
+	UsedFiles         int64              `bson:"used_files" json:"used_files"`
+	ByType            map[string]int64   `bson:"by_type,omitempty" json:"by_type,omitempty"`
+	ReservedBytes     int64              `bson:"reserved_bytes" json:"reserved_bytes"`
+	ReservedFiles     int64              `bson:"reserved_files" json:"reserved_files"`
+	MaxBytes          *int64             `bson:"max_bytes,omitempty" json:"max_bytes,omitempty"`
+	MaxFiles          *int64             `bson:"max_files,omitempty" json:"max_files,omitempty"`
+	NotifiedThreshold int                `bson:"notified_threshold" json:"notified_threshold"`
+	UpdatedBy         string             `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// UsageCounterRef names one usage counter.
+type UsageCounterRef struct {
+	Scope   UsageScope `bson:"scope" json:"scope"`
+	ScopeID string     `bson:"scope_id" json:"scope_id"`
+}
+
+// QuotaReservation is space reserved on Counters that no attachment holds
+// yet. The attachment written for it claims it; otherwise it is released
+// when the upload is given up, or once ExpiresAt passes.
+type QuotaReservation struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Counters  []UsageCounterRef  `bson:"counters" json:"counters"`
+	Bytes     int64              `bson:"bytes" json:"bytes"`
+	Files     int64              `bson:"files" json:"files"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// UsageDrift is one counter field that disagreed with the attachments collection.
+type UsageDrift struct {
+	Scope    UsageScope `json:"scope"`
+	ScopeID  string     `json:"scope_id"`
+	Field    string     `json:"field"`
+	Expected int64      `json:"expected"`
+	Actual   int64      `json:"actual"`
+}
+
+// ReconcileReport summarises one reconciliation run. Drifts lists at most
+// MaxReportedDrifts entries; DriftCount is the full count.
+type ReconcileReport struct {
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt time.Time    `json:"finished_at"`
+	Counters   int          `json:"counters"`
+	DriftCount int          `json:"drift_count"`
+	Drifts     []UsageDrift `json:"drifts"`
+	Fixed      bool         `json:"fixed"`
+}
+
+const MaxReportedDrifts = 100
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AttachmentWebhook subscribes a URL to a workspace's lifecycle events.
+// Events lists event types, with or without the "attachments." prefix;
+// an empty list or "*" subscribes to every type. A webhook whose deliveries
+// keep failing is disabled until it is re-enabled. After the secret is
+// rotated, deliveries are also signed with the previous secret until
+// PreviousSecretExpiresAt.
+type AttachmentWebhook struct {
+	ID                      primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WorkspaceID             string             `bson:"workspace_id" json:"workspace_id"`
+	Name                    string             `bson:"name" json:"name"`
+	URL                     string             `bson:"url" json:"url"`
+	Events                  []string           `bson:"events" json:"events"`
+	IsActive                bool               `bson:"is_active" json:"is_active"`
+	Secret                  string             `bson:"secret" json:"-"`
+	PreviousSecret          string             `bson:"previous_secret,omitempty" json:"-"`
+	PreviousSecretExpiresAt *time.Time         `bson:"previous_secret_expires_at,omitempty" json:"previous_secret_expires_at,omitempty"`
+	ConsecutiveFailures     int                `bson:"consecutive_failures" json:"consecutive_failures"`
+	LastSuccessAt           *time.Time         `bson:"last_success_at,omitempty" json:"last_success_at,omitempty"`
+	LastFailureAt           *time.Time         `bson:"last_failure_at,omitempty" json:"last_failure_at,omitempty"`
+	DisabledAt              *time.Time         `bson:"disabled_at,omitempty" json:"disabled_at,omitempty"`
+	DisabledReason          string             `bson:"disabled_reason,omitempty" json:"disabled_reason,omitempty"`
+	CreatedBy               string             `bson:"created_by" json:"created_by"`
+	CreatedAt               time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt               time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// IssuedWebhook is a webhook along with its signing secret, which is only
+// shown when the secret is issued: on creation and on rotation.
+type IssuedWebhook struct {
+	*AttachmentWebhook
+	Secret string `json:"secret"`
+}
+
+// Issued returns w with its secret shown.
+func (w *AttachmentWebhook) Issued() *IssuedWebhook {
+	return &IssuedWebhook{AttachmentWebhook: w, Secret: w.Secret}
+}
+
+// Secrets returns the secrets deliveries are signed with at now, current
+// first.
+func (w *AttachmentWebhook) Secrets(now time.Time) []string {
+	secrets := []string{w.Secret}
+	if w.PreviousSecret != "" && w.PreviousSecretExpiresAt != nil && now.Before(*w.PreviousSecretExpiresAt) {
+		secrets = append(secrets, w.PreviousSecret)
+	}
+	return secrets
+}
+
+// Subscribes reports whether the webhook wants events of eventType.
+func (w *AttachmentWebhook) Subscribes(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	short := strings.TrimPrefix(eventType, "attachments.")
+	for _, e := range w.Events {
+		if e == "*" || e == eventType || e == short {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	DeliveryPending   WebhookDeliveryStatus = "pending"
+	DeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	DeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one event to be POSTed to one webhook. Pending
+// deliveries are retried with backoff until they succeed or run out of
+// attempts. Finished deliveries are removed once ExpiresAt passes.
+type WebhookDelivery struct {
+	ID            primitive.ObjectID    `bson:"_id,omitempty" json:"id"`
+	WebhookID     string                `bson:"webhook_id" json:"webhook_id"`
+	WorkspaceID   string                `bson:"workspace_id" json:"workspace_id"`
+	EventID       string                `bson:"event_id" json:"event_id"`
+	EventType     string                `bson:"event_type" json:"event_type"`
+	Payload       string                `bson:"payload" json:"-"`
+	Status        WebhookDeliveryStatus `bson:"status" json:"status"`
+	Attempts      int                   `bson:"attempts" json:"attempts"`
+	NextAttemptAt time.Time             `bson:"next_attempt_at" json:"next_attempt_at"`
+	LastAttemptAt *time.Time            `bson:"last_attempt_at,omitempty" json:"last_attempt_at,omitempty"`
+	StatusCode    int                   `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	LastError     string                `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	DeliveredAt   *time.Time            `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	CreatedAt     time.Time             `bson:"created_at" json:"created_at"`
+	ExpiresAt     *time.Time            `bson:"expires_at,omitempty" json:"-"`
+}
+
+// WebhookAttempt records one attempt to deliver a WebhookDelivery, kept
+// until ExpiresAt for debugging.
+type WebhookAttempt struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	DeliveryID  string             `bson:"delivery_id" json:"delivery_id"`
+	WebhookID   string             `bson:"webhook_id" json:"webhook_id"`
+	WorkspaceID string             `bson:"workspace_id" json:"-"`
+	EventType   string             `bson:"event_type" json:"event_type"`
+	Attempt     int                `bson:"attempt" json:"attempt"`
+	// Manual is set for redeliveries requested through the API
+	Manual      bool      `bson:"manual,omitempty" json:"manual,omitempty"`
+	URL         string    `bson:"url" json:"url"`
+	RequestBody string    `bson:"request_body" json:"request_body"`
+	StatusCode  int       `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	DurationMs  int64     `bson:"duration_ms" json:"duration_ms"`
+	Error       string    `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+	ExpiresAt   time.Time `bson:"expires_at" json:"-"`
+}
+
+// WebhookDeliveryDetail is a delivery with its payload and attempts.
+type WebhookDeliveryDetail struct {
+	*WebhookDelivery
+	Payload string            `json:"payload"`
+	Log     []*WebhookAttempt `json:"attempt_log"`
+}
+
+// WebhookResult is the outcome of one delivery attempt.
+type WebhookResult struct {
+	StatusCode int    `json:"status_code,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// OK reports whether the endpoint accepted the delivery.
+func (r *WebhookResult) OK() bool {
+	return r.Error == "" && r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// EventWebhookTest is the type of the event sent to test a webhook
+const EventWebhookTest = "webhooks.test"
+
+// WebhookTest is the payload of a test delivery.
+type WebhookTest struct {
+	WebhookID   string `json:"webhook_id"`
+	WorkspaceID string `json:"workspace_id"`
+}
+
+func (WebhookTest) EventType() string   { return EventWebhookTest }
+func (WebhookTest) SchemaVersion() int  { return 1 }
+func (t WebhookTest) Subject() string   { return t.WebhookID }
+func (t WebhookTest) Workspace() string { return t.WorkspaceID }
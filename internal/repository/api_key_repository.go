@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"attachment-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APIKeyRepository stores API keys and the audit log of their use. Audit
+// entries are removed by a TTL index once they expire.
+type APIKeyRepository struct {
+	keys  *mongo.Collection
+	audit *mongo.Collection
+}
+
+func NewAPIKeyRepository(client *mongo.Client, dbName string) *APIKeyRepository {
+	db := client.Database(dbName)
+	r := &APIKeyRepository{
+		keys:  db.Collection("api_keys"),
+		audit: db.Collection("api_key_audit"),
+	}
+
+	ctx := context.Background()
+	r.keys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "prefix", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	r.audit.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+
+	return r
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, k *models.APIKey) error {
+	if !InTenant(ctx, k.WorkspaceID) {
+		return ErrOutsideTenant
+	}
+	k.CreatedAt = time.Now()
+	result, err := r.keys.InsertOne(ctx, k)
+	if err != nil {
+		return err
+	}
+	k.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *APIKeyRepository) Get(ctx context.Context, workspaceID, id string) (*models.APIKey, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var k models.APIKey
+	if err := r.keys.FindOne(ctx, Scoped(ctx, bson.M{"_id": objID, "workspace_id": workspaceID})).Decode(&k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// GetByPrefix finds a key for authentication, across all workspaces.
+func (r *APIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	var k models.APIKey
+	if err := r.keys.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (r *APIKeyRepository) List(ctx context.Context, workspaceID string) ([]*models.APIKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.keys.Find(ctx, Scoped(ctx, bson.M{"workspace_id": workspaceID}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	keys := []*models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Revoke marks a key revoked. It returns mongo.ErrNoDocuments if the key
+// does not exist or was already revoked.
+func (r *APIKeyRepository) Revoke(ctx context.Context, id primitive.ObjectID, revokedBy string) error {
+	result, err := r.keys.UpdateOne(ctx, bson.M{"_id": id, "revoked_at": nil}, bson.M{"$set": bson.M{
+		"revoked_at": time.Now(),
+		"revoked_by": revokedBy,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// ExpireBy shortens a key's lifetime to end no later than at.
+func (r *APIKeyRepository) ExpireBy(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := r.keys.UpdateOne(ctx, bson.M{
+		"_id": id,
+		"$or": bson.A{bson.M{"expires_at": nil}, bson.M{"expires_at": bson.M{"$gt": at}}},
+	}, bson.M{"$set": bson.M{"expires_at": at}})
+	return err
+}
+
+// TouchLastUsed records a use of the key, at most once per interval.
+func (r *APIKeyRepository) TouchLastUsed(ctx context.Context, id primitive.ObjectID, ip string, interval time.Duration) error {
+	now := time.Now()
+	_, err := r.keys.UpdateOne(ctx, bson.M{
+		"_id": id,
+		"$or": bson.A{bson.M{"last_used_at": nil}, bson.M{"last_used_at": bson.M{"$lt": now.Add(-interval)}}},
+	}, bson.M{"$set": bson.M{"last_used_at": now, "last_used_ip": ip}})
+	return err
+}
+
+// ── Audit ──
+
+func (r *APIKeyRepository) RecordAudit(ctx context.Context, a *models.APIKeyAudit) error {
+	_, err := r.audit.InsertOne(ctx, a)
+	return err
+}
+
+func (r *APIKeyRepository) ListAudit(ctx context.Context, keyID string, limit, offset int) ([]*models.APIKeyAudit, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)).SetSkip(int64(offset))
+	cursor, err := r.audit.Find(ctx, Scoped(ctx, bson.M{"key_id": keyID}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	entries := []*models.APIKeyAudit{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"attachment-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExpiryRepository finds ephemeral attachments whose lifetime is over and
+// removes them, with their versions and previews, for the expiry sweeper.
+type ExpiryRepository struct {
+	attachments *mongo.Collection
+	versions    *mongo.Collection
+	previews    *mongo.Collection
+	usage       *UsageRepository
+}
+
+func NewExpiryRepository(client *mongo.Client, dbName string) *ExpiryRepository {
+	db := client.Database(dbName)
+	r := &ExpiryRepository{
+		attachments: db.Collection("attachments"),
+		versions:    db.Collection("attachment_versions"),
+		previews:    db.Collection("attachment_previews"),
+		usage:       NewUsageRepository(client, dbName),
+	}
+
+	r.attachments.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+
+	return r
+}
+
+// Expired returns up to limit attachments that expired by now and are not
+// postponed, in ID order after the given ID.
+func (r *ExpiryRepository) Expired(ctx context.Context, now time.Time, after primitive.ObjectID, limit int) ([]*models.Attachment, error) {
+	filter := bson.M{
+		"expires_at": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"expiry_retry_at": nil},
+			bson.M{"expiry_retry_at": bson.M{"$lte": now}},
+		},
+	}
+	if !after.IsZero() {
+		filter["_id"] = bson.M{"$gt": after}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := r.attachments.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var attachments []*models.Attachment
+	if err := cursor.All(ctx, &attachments); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// Postpone leaves the given expired attachments alone until until. They
+// stay expired meanwhile.
+func (r *ExpiryRepository) Postpone(ctx context.Context, ids []primitive.ObjectID, until time.Time) error {
+	_, err := r.attachments.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{
+		"$set": bson.M{"expiry_retry_at": until},
+	})
+	return err
+}
+
+// StoredPaths returns the storage paths of an attachment's versions and
+// previews.
+func (r *ExpiryRepository) StoredPaths(ctx context.Context, attachmentID string) ([]string, error) {
+	return storedPaths(ctx, attachmentID, r.versions, r.previews)
+}
+
+// Remove deletes an expired attachment, its versions and its previews, and
+// reports whether it was still there.
+func (r *ExpiryRepository) Remove(ctx context.Context, a *models.Attachment) (bool, error) {
+	if err := deleteDependents(ctx, a.ID.Hex(), r.versions, r.previews); err != nil {
+		return false, err
+	}
+	return r.usage.deleteAttachment(ctx, bson.M{
+		"_id":        a.ID,
+		"expires_at": bson.M{"$lte": time.Now()},
+	})
+}
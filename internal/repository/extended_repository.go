@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"attachment-service/internal/models"
@@ -29,6 +30,7 @@ type ExtendedRepository struct {
 	scans      *mongo.Collection
 	previews   *mongo.Collection
 	attachments *mongo.Collection
+	usage       *UsageRepository
 }
 
 func NewExtendedRepository(client *mongo.Client, dbName string) *ExtendedRepository {
@@ -49,6 +51,7 @@ func NewExtendedRepository(client *mongo.Client, dbName string) *ExtendedReposit
 		scans:       db.Collection("scan_results"),
 		previews:    db.Collection("attachment_previews"),
 		attachments: db.Collection("attachments"),
+		usage:       NewUsageRepository(client, dbName),
 	}
 
 	ctx := context.Background()
@@ -73,6 +76,7 @@ func NewExtendedRepository(client *mongo.Client, dbName string) *ExtendedReposit
 	r.shares.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{Keys: bson.D{{Key: "attachment_id", Value: 1}}},
 		{Keys: bson.D{{Key: "shared_with", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetSparse(true)},
 	})
 	r.collections.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{Keys: bson.D{{Key: "workspace_id", Value: 1}}},
@@ -107,17 +111,29 @@ func NewExtendedRepository(client *mongo.Client, dbName string) *ExtendedReposit
 
 // ── Version Operations ──
 
+// CreateVersion stores v with the attachments.version_added event attached
+// to ctx, which it requires.
 func (r *ExtendedRepository) CreateVersion(ctx context.Context, v *models.AttachmentVersion) error {
-	v.CreatedAt = time.Now()
-	result, err := r.versions.InsertOne(ctx, v)
-	if err != nil {
+	if err := requireEvent(ctx, models.EventVersionAdded); err != nil {
 		return err
 	}
-	v.ID = result.InsertedID.(primitive.ObjectID)
-	return nil
+	if err := r.checkAttachment(ctx, v.AttachmentID); err != nil {
+		return err
+	}
+	v.CreatedAt = time.Now()
+	if v.ID.IsZero() {
+		v.ID = primitive.NewObjectID()
+	}
+	return r.usage.withOutbox(ctx, func(ctx context.Context) error {
+		_, err := r.versions.InsertOne(ctx, v)
+		return err
+	})
 }
 
 func (r *ExtendedRepository) ListVersions(ctx context.Context, attachmentID string) ([]*models.AttachmentVersion, error) {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return nil, err
+	}
 	cursor, err := r.versions.Find(ctx, bson.M{"attachment_id": attachmentID},
 		options.Find().SetSort(bson.D{{Key: "version_num", Value: -1}}))
 	if err != nil {
@@ -132,6 +148,9 @@ func (r *ExtendedRepository) ListVersions(ctx context.Context, attachmentID stri
 }
 
 func (r *ExtendedRepository) GetVersionByNum(ctx context.Context, attachmentID string, versionNum int) (*models.AttachmentVersion, error) {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return nil, err
+	}
 	var v models.AttachmentVersion
 	err := r.versions.FindOne(ctx, bson.M{"attachment_id": attachmentID, "version_num": versionNum}).Decode(&v)
 	if err != nil {
@@ -141,6 +160,9 @@ func (r *ExtendedRepository) GetVersionByNum(ctx context.Context, attachmentID s
 }
 
 func (r *ExtendedRepository) GetLatestVersionNum(ctx context.Context, attachmentID string) (int, error) {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return 0, err
+	}
 	var v models.AttachmentVersion
 	err := r.versions.FindOne(ctx, bson.M{"attachment_id": attachmentID},
 		options.FindOne().SetSort(bson.D{{Key: "version_num", Value: -1}})).Decode(&v)
@@ -153,26 +175,39 @@ func (r *ExtendedRepository) GetLatestVersionNum(ctx context.Context, attachment
 	return v.VersionNum, nil
 }
 
-func (r *ExtendedRepository) DeleteVersion(ctx context.Context, id string) error {
+func (r *ExtendedRepository) DeleteVersion(ctx context.Context, attachmentID, id string) error {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return err
+	}
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return err
 	}
-	_, err = r.versions.DeleteOne(ctx, bson.M{"_id": objID})
-	return err
+	result, err := r.versions.DeleteOne(ctx, bson.M{"_id": objID, "attachment_id": attachmentID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
 }
 
 // ── Comment Operations ──
 
 func (r *ExtendedRepository) CreateComment(ctx context.Context, c *models.AttachmentComment) error {
+	if err := r.checkAttachment(ctx, c.AttachmentID); err != nil {
+		return err
+	}
 	c.CreatedAt = time.Now()
 	c.UpdatedAt = time.Now()
-	result, err := r.comments.InsertOne(ctx, c)
-	if err != nil {
-		return err
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
 	}
-	c.ID = result.InsertedID.(primitive.ObjectID)
-	return nil
+	return r.usage.withOutbox(ctx, func(ctx context.Context) error {
+		_, err := r.comments.InsertOne(ctx, c)
+		return err
+	})
 }
 
 func (r *ExtendedRepository) GetComment(ctx context.Context, id string) (*models.AttachmentComment, error) {
@@ -181,14 +216,16 @@ func (r *ExtendedRepository) GetComment(ctx context.Context, id string) (*models
 		return nil, err
 	}
 	var c models.AttachmentComment
-	err = r.comments.FindOne(ctx, bson.M{"_id": objID}).Decode(&c)
-	if err != nil {
+	if err := r.findChild(ctx, r.comments, bson.M{"_id": objID}, &c); err != nil {
 		return nil, err
 	}
 	return &c, nil
 }
 
 func (r *ExtendedRepository) ListComments(ctx context.Context, attachmentID string, limit, offset int) ([]*models.AttachmentComment, error) {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return nil, err
+	}
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)).SetSkip(int64(offset))
 	cursor, err := r.comments.Find(ctx, bson.M{"attachment_id": attachmentID}, opts)
 	if err != nil {
@@ -203,10 +240,11 @@ func (r *ExtendedRepository) ListComments(ctx context.Context, attachmentID stri
 }
 
 func (r *ExtendedRepository) UpdateComment(ctx context.Context, id, content string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
+	comment, err := r.GetComment(ctx, id)
 	if err != nil {
 		return err
 	}
+	objID := comment.ID
 	_, err = r.comments.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
 		"content":    content,
 		"is_edited":  true,
@@ -216,10 +254,11 @@ func (r *ExtendedRepository) UpdateComment(ctx context.Context, id, content stri
 }
 
 func (r *ExtendedRepository) DeleteComment(ctx context.Context, id string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
+	comment, err := r.GetComment(ctx, id)
 	if err != nil {
 		return err
 	}
+	objID := comment.ID
 	_, err = r.comments.DeleteOne(ctx, bson.M{"_id": objID})
 	return err
 }
@@ -227,6 +266,9 @@ func (r *ExtendedRepository) DeleteComment(ctx context.Context, id string) error
 // ── Tag Operations ──
 
 func (r *ExtendedRepository) AddTag(ctx context.Context, t *models.AttachmentTag) error {
+	if err := r.checkAttachment(ctx, t.AttachmentID); err != nil {
+		return err
+	}
 	t.CreatedAt = time.Now()
 	result, err := r.tags.InsertOne(ctx, t)
 	if err != nil {
@@ -237,11 +279,17 @@ func (r *ExtendedRepository) AddTag(ctx context.Context, t *models.AttachmentTag
 }
 
 func (r *ExtendedRepository) RemoveTag(ctx context.Context, attachmentID, tag string) error {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return err
+	}
 	_, err := r.tags.DeleteOne(ctx, bson.M{"attachment_id": attachmentID, "tag": tag})
 	return err
 }
 
 func (r *ExtendedRepository) ListTags(ctx context.Context, attachmentID string) ([]*models.AttachmentTag, error) {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return nil, err
+	}
 	cursor, err := r.tags.Find(ctx, bson.M{"attachment_id": attachmentID})
 	if err != nil {
 		return nil, err
@@ -255,14 +303,8 @@ func (r *ExtendedRepository) ListTags(ctx context.Context, attachmentID string)
 }
 
 func (r *ExtendedRepository) SearchByTag(ctx context.Context, tag string, limit, offset int) ([]*models.AttachmentTag, error) {
-	opts := options.Find().SetLimit(int64(limit)).SetSkip(int64(offset))
-	cursor, err := r.tags.Find(ctx, bson.M{"tag": tag}, opts)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
 	var tags []*models.AttachmentTag
-	if err := cursor.All(ctx, &tags); err != nil {
+	if err := FindInTenant(ctx, r.tags, bson.M{"tag": tag}, nil, limit, offset, &tags); err != nil {
 		return nil, err
 	}
 	return tags, nil
@@ -271,6 +313,9 @@ func (r *ExtendedRepository) SearchByTag(ctx context.Context, tag string, limit,
 // ── Favorite Operations ──
 
 func (r *ExtendedRepository) AddFavorite(ctx context.Context, f *models.AttachmentFavorite) error {
+	if err := r.checkAttachment(ctx, f.AttachmentID); err != nil {
+		return err
+	}
 	f.CreatedAt = time.Now()
 	result, err := r.favorites.InsertOne(ctx, f)
 	if err != nil {
@@ -281,25 +326,25 @@ func (r *ExtendedRepository) AddFavorite(ctx context.Context, f *models.Attachme
 }
 
 func (r *ExtendedRepository) RemoveFavorite(ctx context.Context, attachmentID, userID string) error {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return err
+	}
 	_, err := r.favorites.DeleteOne(ctx, bson.M{"attachment_id": attachmentID, "user_id": userID})
 	return err
 }
 
 func (r *ExtendedRepository) ListFavorites(ctx context.Context, userID string, limit, offset int) ([]*models.AttachmentFavorite, error) {
-	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)).SetSkip(int64(offset))
-	cursor, err := r.favorites.Find(ctx, bson.M{"user_id": userID}, opts)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
 	var favs []*models.AttachmentFavorite
-	if err := cursor.All(ctx, &favs); err != nil {
+	if err := FindInTenant(ctx, r.favorites, bson.M{"user_id": userID}, bson.D{{Key: "created_at", Value: -1}}, limit, offset, &favs); err != nil {
 		return nil, err
 	}
 	return favs, nil
 }
 
 func (r *ExtendedRepository) IsFavorited(ctx context.Context, attachmentID, userID string) (bool, error) {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return false, err
+	}
 	count, err := r.favorites.CountDocuments(ctx, bson.M{"attachment_id": attachmentID, "user_id": userID})
 	return count > 0, err
 }
@@ -307,17 +352,24 @@ func (r *ExtendedRepository) IsFavorited(ctx context.Context, attachmentID, user
 // ── Share Operations ──
 
 func (r *ExtendedRepository) CreateShare(ctx context.Context, s *models.AttachmentShare) error {
-	s.CreatedAt = time.Now()
-	result, err := r.shares.InsertOne(ctx, s)
-	if err != nil {
+	if err := r.checkAttachment(ctx, s.AttachmentID); err != nil {
 		return err
 	}
-	s.ID = result.InsertedID.(primitive.ObjectID)
-	return nil
+	s.CreatedAt = time.Now()
+	if s.ID.IsZero() {
+		s.ID = primitive.NewObjectID()
+	}
+	return r.usage.withOutbox(ctx, func(ctx context.Context) error {
+		_, err := r.shares.InsertOne(ctx, s)
+		return err
+	})
 }
 
 func (r *ExtendedRepository) ListShares(ctx context.Context, attachmentID string) ([]*models.AttachmentShare, error) {
-	cursor, err := r.shares.Find(ctx, bson.M{"attachment_id": attachmentID})
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return nil, err
+	}
+	cursor, err := r.shares.Find(ctx, bson.M{"attachment_id": attachmentID, "$or": unexpired(time.Now())})
 	if err != nil {
 		return nil, err
 	}
@@ -330,31 +382,99 @@ func (r *ExtendedRepository) ListShares(ctx context.Context, attachmentID string
 }
 
 func (r *ExtendedRepository) ListSharedWith(ctx context.Context, userID string, limit, offset int) ([]*models.AttachmentShare, error) {
-	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)).SetSkip(int64(offset))
-	cursor, err := r.shares.Find(ctx, bson.M{"shared_with": userID}, opts)
+	var shares []*models.AttachmentShare
+	filter := bson.M{"shared_with": userID, "$or": unexpired(time.Now())}
+	if err := FindInTenant(ctx, r.shares, filter, bson.D{{Key: "created_at", Value: -1}}, limit, offset, &shares); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+func (r *ExtendedRepository) GetShare(ctx context.Context, id string) (*models.AttachmentShare, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
+	var s models.AttachmentShare
+	if err := r.findChild(ctx, r.shares, bson.M{"_id": objID}, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ListActiveShares returns the unexpired shares of the given attachments
+// with a user.
+func (r *ExtendedRepository) ListActiveShares(ctx context.Context, userID string, attachmentIDs []string) ([]*models.AttachmentShare, error) {
+	filter := bson.M{
+		"shared_with":   userID,
+		"attachment_id": bson.M{"$in": attachmentIDs},
+		"$or":           unexpired(time.Now()),
+	}
 	var shares []*models.AttachmentShare
-	if err := cursor.All(ctx, &shares); err != nil {
+	if err := FindInTenant(ctx, r.shares, filter, nil, 0, 0, &shares); err != nil {
 		return nil, err
 	}
 	return shares, nil
 }
 
 func (r *ExtendedRepository) DeleteShare(ctx context.Context, id string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
+	share, err := r.GetShare(ctx, id)
 	if err != nil {
 		return err
 	}
-	_, err = r.shares.DeleteOne(ctx, bson.M{"_id": objID})
+	_, err = r.shares.DeleteOne(ctx, bson.M{"_id": share.ID})
 	return err
 }
 
+// unexpired matches documents whose expires_at is unset or after now.
+func unexpired(now time.Time) bson.A {
+	return bson.A{
+		bson.M{"expires_at": nil},
+		bson.M{"expires_at": bson.M{"$gt": now}},
+	}
+}
+
+// ListExpiredShares returns up to limit shares that expired before now,
+// soonest expired first.
+func (r *ExtendedRepository) ListExpiredShares(ctx context.Context, now time.Time, limit int) ([]*models.AttachmentShare, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "expires_at", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := r.shares.Find(ctx, bson.M{"expires_at": bson.M{"$lte": now}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var expired []*models.AttachmentShare
+	if err := cursor.All(ctx, &expired); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+var errShareGone = errors.New("share already removed")
+
+// DeleteExpiredShare removes a share that expired before now, together
+// with the events attached to ctx. It reports false, recording no events,
+// if the share was already gone.
+func (r *ExtendedRepository) DeleteExpiredShare(ctx context.Context, id primitive.ObjectID, now time.Time) (bool, error) {
+	// Another sweeper may get to a share first; only report our deletions
+	err := r.usage.withOutbox(ctx, func(ctx context.Context) error {
+		result, err := r.shares.DeleteOne(ctx, bson.M{"_id": id, "expires_at": bson.M{"$lte": now}})
+		if err == nil && result.DeletedCount == 0 {
+			err = errShareGone
+		}
+		return err
+	})
+	if errors.Is(err, errShareGone) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // ── Collection Operations ──
 
 func (r *ExtendedRepository) CreateCollection(ctx context.Context, c *models.AttachmentCollection) error {
+	if !InTenant(ctx, c.WorkspaceID) {
+		return ErrOutsideTenant
+	}
 	c.CreatedAt = time.Now()
 	c.UpdatedAt = time.Now()
 	result, err := r.collections.InsertOne(ctx, c)
@@ -371,7 +491,7 @@ func (r *ExtendedRepository) GetCollection(ctx context.Context, id string) (*mod
 		return nil, err
 	}
 	var c models.AttachmentCollection
-	err = r.collections.FindOne(ctx, bson.M{"_id": objID}).Decode(&c)
+	err = r.collections.FindOne(ctx, Scoped(ctx, bson.M{"_id": objID})).Decode(&c)
 	if err != nil {
 		return nil, err
 	}
@@ -380,7 +500,7 @@ func (r *ExtendedRepository) GetCollection(ctx context.Context, id string) (*mod
 
 func (r *ExtendedRepository) ListCollections(ctx context.Context, workspaceID string, limit, offset int) ([]*models.AttachmentCollection, error) {
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)).SetSkip(int64(offset))
-	cursor, err := r.collections.Find(ctx, bson.M{"workspace_id": workspaceID}, opts)
+	cursor, err := r.collections.Find(ctx, Scoped(ctx, bson.M{"workspace_id": workspaceID}), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -398,7 +518,7 @@ func (r *ExtendedRepository) UpdateCollection(ctx context.Context, id string, up
 		return err
 	}
 	update["updated_at"] = time.Now()
-	_, err = r.collections.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
+	_, err = r.collections.UpdateOne(ctx, Scoped(ctx, bson.M{"_id": objID}), bson.M{"$set": update})
 	return err
 }
 
@@ -407,16 +527,25 @@ func (r *ExtendedRepository) DeleteCollection(ctx context.Context, id string) er
 	if err != nil {
 		return err
 	}
-	_, err = r.collections.DeleteOne(ctx, bson.M{"_id": objID})
+	result, err := r.collections.DeleteOne(ctx, Scoped(ctx, bson.M{"_id": objID}))
 	if err != nil {
 		return err
 	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
 	// Delete all items in this collection
 	_, err = r.collItems.DeleteMany(ctx, bson.M{"collection_id": id})
 	return err
 }
 
 func (r *ExtendedRepository) AddToCollection(ctx context.Context, item *models.CollectionItem) error {
+	if _, err := r.GetCollection(ctx, item.CollectionID); err != nil {
+		return err
+	}
+	if err := r.checkAttachment(ctx, item.AttachmentID); err != nil {
+		return err
+	}
 	item.AddedAt = time.Now()
 	result, err := r.collItems.InsertOne(ctx, item)
 	if err != nil {
@@ -430,6 +559,9 @@ func (r *ExtendedRepository) AddToCollection(ctx context.Context, item *models.C
 }
 
 func (r *ExtendedRepository) RemoveFromCollection(ctx context.Context, collectionID, attachmentID string) error {
+	if _, err := r.GetCollection(ctx, collectionID); err != nil {
+		return err
+	}
 	_, err := r.collItems.DeleteOne(ctx, bson.M{"collection_id": collectionID, "attachment_id": attachmentID})
 	if err != nil {
 		return err
@@ -440,6 +572,9 @@ func (r *ExtendedRepository) RemoveFromCollection(ctx context.Context, collectio
 }
 
 func (r *ExtendedRepository) ListCollectionItems(ctx context.Context, collectionID string, limit, offset int) ([]*models.CollectionItem, error) {
+	if _, err := r.GetCollection(ctx, collectionID); err != nil {
+		return nil, err
+	}
 	opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}}).SetLimit(int64(limit)).SetSkip(int64(offset))
 	cursor, err := r.collItems.Find(ctx, bson.M{"collection_id": collectionID}, opts)
 	if err != nil {
@@ -456,12 +591,18 @@ func (r *ExtendedRepository) ListCollectionItems(ctx context.Context, collection
 // ── Activity Operations ──
 
 func (r *ExtendedRepository) LogActivity(ctx context.Context, a *models.AttachmentActivity) error {
+	if err := r.checkAttachment(ctx, a.AttachmentID); err != nil {
+		return err
+	}
 	a.CreatedAt = time.Now()
 	_, err := r.activities.InsertOne(ctx, a)
 	return err
 }
 
 func (r *ExtendedRepository) ListActivity(ctx context.Context, attachmentID string, limit, offset int) ([]*models.AttachmentActivity, error) {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return nil, err
+	}
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)).SetSkip(int64(offset))
 	cursor, err := r.activities.Find(ctx, bson.M{"attachment_id": attachmentID}, opts)
 	if err != nil {
@@ -476,14 +617,8 @@ func (r *ExtendedRepository) ListActivity(ctx context.Context, attachmentID stri
 }
 
 func (r *ExtendedRepository) ListUserActivity(ctx context.Context, userID string, limit, offset int) ([]*models.AttachmentActivity, error) {
-	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)).SetSkip(int64(offset))
-	cursor, err := r.activities.Find(ctx, bson.M{"user_id": userID}, opts)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
 	var acts []*models.AttachmentActivity
-	if err := cursor.All(ctx, &acts); err != nil {
+	if err := FindInTenant(ctx, r.activities, bson.M{"user_id": userID}, bson.D{{Key: "created_at", Value: -1}}, limit, offset, &acts); err != nil {
 		return nil, err
 	}
 	return acts, nil
@@ -492,6 +627,9 @@ func (r *ExtendedRepository) ListUserActivity(ctx context.Context, userID string
 // ── Permission Operations ──
 
 func (r *ExtendedRepository) SetPermission(ctx context.Context, p *models.AttachmentPermission) error {
+	if err := r.checkAttachment(ctx, p.AttachmentID); err != nil {
+		return err
+	}
 	p.CreatedAt = time.Now()
 	p.UpdatedAt = time.Now()
 	filter := bson.M{"attachment_id": p.AttachmentID, "user_id": p.UserID}
@@ -506,11 +644,16 @@ func (r *ExtendedRepository) SetPermission(ctx context.Context, p *models.Attach
 		"created_at": time.Now(),
 	}}
 	opts := options.Update().SetUpsert(true)
-	_, err := r.permissions.UpdateOne(ctx, filter, update, opts)
-	return err
+	return r.usage.withOutbox(ctx, func(ctx context.Context) error {
+		_, err := r.permissions.UpdateOne(ctx, filter, update, opts)
+		return err
+	})
 }
 
 func (r *ExtendedRepository) GetPermission(ctx context.Context, attachmentID, userID string) (*models.AttachmentPermission, error) {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return nil, err
+	}
 	var p models.AttachmentPermission
 	err := r.permissions.FindOne(ctx, bson.M{"attachment_id": attachmentID, "user_id": userID}).Decode(&p)
 	if err != nil {
@@ -519,7 +662,20 @@ func (r *ExtendedRepository) GetPermission(ctx context.Context, attachmentID, us
 	return &p, nil
 }
 
+// ListUserPermissions returns a user's explicit permissions on the given attachments.
+func (r *ExtendedRepository) ListUserPermissions(ctx context.Context, userID string, attachmentIDs []string) ([]*models.AttachmentPermission, error) {
+	var perms []*models.AttachmentPermission
+	filter := bson.M{"user_id": userID, "attachment_id": bson.M{"$in": attachmentIDs}}
+	if err := FindInTenant(ctx, r.permissions, filter, nil, 0, 0, &perms); err != nil {
+		return nil, err
+	}
+	return perms, nil
+}
+
 func (r *ExtendedRepository) ListPermissions(ctx context.Context, attachmentID string) ([]*models.AttachmentPermission, error) {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return nil, err
+	}
 	cursor, err := r.permissions.Find(ctx, bson.M{"attachment_id": attachmentID})
 	if err != nil {
 		return nil, err
@@ -533,6 +689,9 @@ func (r *ExtendedRepository) ListPermissions(ctx context.Context, attachmentID s
 }
 
 func (r *ExtendedRepository) DeletePermission(ctx context.Context, attachmentID, userID string) error {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return err
+	}
 	_, err := r.permissions.DeleteOne(ctx, bson.M{"attachment_id": attachmentID, "user_id": userID})
 	return err
 }
@@ -540,26 +699,44 @@ func (r *ExtendedRepository) DeletePermission(ctx context.Context, attachmentID,
 // ── Share Link Operations ──
 
 func (r *ExtendedRepository) CreateShareLink(ctx context.Context, link *models.ShareLink) error {
+	if err := r.checkAttachment(ctx, link.AttachmentID); err != nil {
+		return err
+	}
 	link.CreatedAt = time.Now()
 	link.IsActive = true
-	result, err := r.shareLinks.InsertOne(ctx, link)
-	if err != nil {
-		return err
+	if link.ID.IsZero() {
+		link.ID = primitive.NewObjectID()
 	}
-	link.ID = result.InsertedID.(primitive.ObjectID)
-	return nil
+	return r.usage.withOutbox(ctx, func(ctx context.Context) error {
+		_, err := r.shareLinks.InsertOne(ctx, link)
+		return err
+	})
 }
 
 func (r *ExtendedRepository) GetShareLinkByCode(ctx context.Context, code string) (*models.ShareLink, error) {
 	var link models.ShareLink
-	err := r.shareLinks.FindOne(ctx, bson.M{"code": code, "is_active": true}).Decode(&link)
+	if err := r.findChild(ctx, r.shareLinks, bson.M{"code": code, "is_active": true}, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (r *ExtendedRepository) GetShareLink(ctx context.Context, id string) (*models.ShareLink, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
+	var link models.ShareLink
+	if err := r.findChild(ctx, r.shareLinks, bson.M{"_id": objID}, &link); err != nil {
+		return nil, err
+	}
 	return &link, nil
 }
 
 func (r *ExtendedRepository) ListShareLinks(ctx context.Context, attachmentID string) ([]*models.ShareLink, error) {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return nil, err
+	}
 	cursor, err := r.shareLinks.Find(ctx, bson.M{"attachment_id": attachmentID})
 	if err != nil {
 		return nil, err
@@ -572,33 +749,81 @@ func (r *ExtendedRepository) ListShareLinks(ctx context.Context, attachmentID st
 	return links, nil
 }
 
-func (r *ExtendedRepository) IncrementShareLinkDownloads(ctx context.Context, code string) error {
-	_, err := r.shareLinks.UpdateOne(ctx, bson.M{"code": code}, bson.M{"$inc": bson.M{"download_count": 1}})
+// IncrementShareLinkDownloads counts a download against an active link,
+// provided it has not expired or reached its download limit, in a single
+// update so concurrent downloads cannot overshoot the limit. It returns
+// mongo.ErrNoDocuments if the link can no longer be downloaded.
+func (r *ExtendedRepository) IncrementShareLinkDownloads(ctx context.Context, code string, now time.Time) (*models.ShareLink, error) {
+	var link models.ShareLink
+	if err := r.findChild(ctx, r.shareLinks, bson.M{"code": code}, &link); err != nil {
+		return nil, err
+	}
+	filter := bson.M{
+		"_id":       link.ID,
+		"is_active": true,
+		"$and": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"expires_at": nil},
+				bson.M{"expires_at": bson.M{"$gt": now}},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"max_downloads": bson.M{"$lte": 0}},
+				bson.M{"$expr": bson.M{"$lt": bson.A{"$download_count", "$max_downloads"}}},
+			}},
+		},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.shareLinks.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"download_count": 1}}, opts).Decode(&link)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// SetShareLinkPassword replaces a link's stored password hash.
+func (r *ExtendedRepository) SetShareLinkPassword(ctx context.Context, id primitive.ObjectID, hash string) error {
+	_, err := r.shareLinks.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"password": hash, "has_password": hash != ""}})
 	return err
 }
 
-func (r *ExtendedRepository) DeactivateShareLink(ctx context.Context, id string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
+func (r *ExtendedRepository) DeactivateShareLink(ctx context.Context, id, revokedBy string) error {
+	link, err := r.GetShareLink(ctx, id)
 	if err != nil {
 		return err
 	}
-	_, err = r.shareLinks.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"is_active": false}})
+	_, err = r.shareLinks.UpdateOne(ctx, bson.M{"_id": link.ID, "is_active": true}, bson.M{"$set": bson.M{
+		"is_active":  false,
+		"revoked_at": time.Now(),
+		"revoked_by": revokedBy,
+	}})
 	return err
 }
 
 // ── Scan Operations ──
 
+// CreateScanResult stores s with the attachments.scanned event attached to
+// ctx, which it requires.
 func (r *ExtendedRepository) CreateScanResult(ctx context.Context, s *models.ScanResult) error {
-	s.ScannedAt = time.Now()
-	result, err := r.scans.InsertOne(ctx, s)
-	if err != nil {
+	if err := requireEvent(ctx, models.EventScanned); err != nil {
 		return err
 	}
-	s.ID = result.InsertedID.(primitive.ObjectID)
-	return nil
+	if err := r.checkAttachment(ctx, s.AttachmentID); err != nil {
+		return err
+	}
+	s.ScannedAt = time.Now()
+	if s.ID.IsZero() {
+		s.ID = primitive.NewObjectID()
+	}
+	return r.usage.withOutbox(ctx, func(ctx context.Context) error {
+		_, err := r.scans.InsertOne(ctx, s)
+		return err
+	})
 }
 
 func (r *ExtendedRepository) GetScanResult(ctx context.Context, attachmentID string) (*models.ScanResult, error) {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return nil, err
+	}
 	var s models.ScanResult
 	err := r.scans.FindOne(ctx, bson.M{"attachment_id": attachmentID},
 		options.FindOne().SetSort(bson.D{{Key: "scanned_at", Value: -1}})).Decode(&s)
@@ -611,6 +836,9 @@ func (r *ExtendedRepository) GetScanResult(ctx context.Context, attachmentID str
 // ── Preview Operations ──
 
 func (r *ExtendedRepository) CreatePreview(ctx context.Context, p *models.AttachmentPreview) error {
+	if err := r.checkAttachment(ctx, p.AttachmentID); err != nil {
+		return err
+	}
 	p.CreatedAt = time.Now()
 	result, err := r.previews.InsertOne(ctx, p)
 	if err != nil {
@@ -621,6 +849,9 @@ func (r *ExtendedRepository) CreatePreview(ctx context.Context, p *models.Attach
 }
 
 func (r *ExtendedRepository) ListPreviews(ctx context.Context, attachmentID string) ([]*models.AttachmentPreview, error) {
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return nil, err
+	}
 	cursor, err := r.previews.Find(ctx, bson.M{"attachment_id": attachmentID})
 	if err != nil {
 		return nil, err
@@ -635,93 +866,41 @@ func (r *ExtendedRepository) ListPreviews(ctx context.Context, attachmentID stri
 
 // ── Stats & Search Operations ──
 
+// GetAttachmentStats reads the workspace usage counter; only the recent
+// upload count still queries attachments, and it is served by the
+// (workspace_id, created_at) index.
 func (r *ExtendedRepository) GetAttachmentStats(ctx context.Context, workspaceID string) (*models.AttachmentStats, error) {
-	stats := &models.AttachmentStats{
-		ByType:   make(map[string]int64),
-		ByStatus: make(map[string]int64),
-	}
-
-	filter := bson.M{"workspace_id": workspaceID, "status": bson.M{"$ne": "deleted"}}
-
-	totalCount, err := r.attachments.CountDocuments(ctx, filter)
-	if err != nil {
-		return nil, err
-	}
-	stats.TotalFiles = totalCount
-
-	// Aggregate by type
-	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: filter}},
-		{{Key: "$group", Value: bson.D{
-			{Key: "_id", Value: "$type"},
-			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
-			{Key: "total_size", Value: bson.D{{Key: "$sum", Value: "$size"}}},
-		}}},
+	if !InTenant(ctx, workspaceID) {
+		return nil, ErrOutsideTenant
 	}
-	cursor, err := r.attachments.Aggregate(ctx, pipeline)
+	counter, err := r.usage.GetCounter(ctx, models.UsageScopeWorkspace, workspaceID)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	for cursor.Next(ctx) {
-		var result struct {
-			ID        string `bson:"_id"`
-			Count     int64  `bson:"count"`
-			TotalSize int64  `bson:"total_size"`
-		}
-		if err := cursor.Decode(&result); err == nil {
-			stats.ByType[result.ID] = result.Count
-			stats.TotalSize += result.TotalSize
+	stats := &models.AttachmentStats{
+		TotalFiles: counter.UsedFiles,
+		TotalSize:  counter.UsedBytes,
+		ByType:     make(map[string]int64),
+		ByStatus:   make(map[string]int64),
+	}
+	for t, n := range counter.ByType {
+		if n > 0 {
+			stats.ByType[t] = n
 		}
 	}
 
 	// Recent uploads (last 24h)
 	dayAgo := time.Now().Add(-24 * time.Hour)
-	recentCount, _ := r.attachments.CountDocuments(ctx, bson.M{
+	recentCount, _ := r.attachments.CountDocuments(ctx, Scoped(ctx, bson.M{
 		"workspace_id": workspaceID,
 		"created_at":   bson.M{"$gte": dayAgo},
-	})
+	}))
 	stats.RecentUploads = recentCount
 
 	return stats, nil
 }
 
-func (r *ExtendedRepository) GetUserQuota(ctx context.Context, userID string) (*models.UserQuota, error) {
-	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{"user_id": userID, "status": bson.M{"$ne": "deleted"}}}},
-		{{Key: "$group", Value: bson.D{
-			{Key: "_id", Value: nil},
-			{Key: "used_bytes", Value: bson.D{{Key: "$sum", Value: "$size"}}},
-			{Key: "file_count", Value: bson.D{{Key: "$sum", Value: 1}}},
-		}}},
-	}
-
-	cursor, err := r.attachments.Aggregate(ctx, pipeline)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
-
-	quota := &models.UserQuota{
-		UserID:   userID,
-		MaxBytes: 5 * 1024 * 1024 * 1024, // 5GB default
-		MaxFiles: 10000,
-	}
-	if cursor.Next(ctx) {
-		var result struct {
-			UsedBytes int64 `bson:"used_bytes"`
-			FileCount int64 `bson:"file_count"`
-		}
-		if err := cursor.Decode(&result); err == nil {
-			quota.UsedBytes = result.UsedBytes
-			quota.FileCount = result.FileCount
-		}
-	}
-
-	return quota, nil
-}
-
 func (r *ExtendedRepository) SearchAttachments(ctx context.Context, workspaceID string, query string, fileType string, limit, offset int) ([]*models.Attachment, error) {
 	filter := bson.M{
 		"workspace_id": workspaceID,
@@ -736,7 +915,7 @@ func (r *ExtendedRepository) SearchAttachments(ctx context.Context, workspaceID
 	}
 
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)).SetSkip(int64(offset))
-	cursor, err := r.attachments.Find(ctx, filter, opts)
+	cursor, err := r.attachments.Find(ctx, Scoped(ctx, filter), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -751,10 +930,10 @@ func (r *ExtendedRepository) SearchAttachments(ctx context.Context, workspaceID
 
 func (r *ExtendedRepository) GetRecentAttachments(ctx context.Context, userID string, limit int) ([]*models.Attachment, error) {
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
-	cursor, err := r.attachments.Find(ctx, bson.M{
+	cursor, err := r.attachments.Find(ctx, Scoped(ctx, bson.M{
 		"user_id": userID,
 		"status":  bson.M{"$ne": "deleted"},
-	}, opts)
+	}), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -767,44 +946,30 @@ func (r *ExtendedRepository) GetRecentAttachments(ctx context.Context, userID st
 }
 
 func (r *ExtendedRepository) GetWorkspaceStats(ctx context.Context, workspaceID string) (*models.WorkspaceStats, error) {
-	filter := bson.M{"workspace_id": workspaceID, "status": bson.M{"$ne": "deleted"}}
-
-	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: filter}},
-		{{Key: "$group", Value: bson.D{
-			{Key: "_id", Value: nil},
-			{Key: "total_files", Value: bson.D{{Key: "$sum", Value: 1}}},
-			{Key: "total_size", Value: bson.D{{Key: "$sum", Value: "$size"}}},
-			{Key: "users", Value: bson.D{{Key: "$addToSet", Value: "$user_id"}}},
-		}}},
+	if !InTenant(ctx, workspaceID) {
+		return nil, ErrOutsideTenant
 	}
-
-	cursor, err := r.attachments.Aggregate(ctx, pipeline)
+	counter, err := r.usage.GetCounter(ctx, models.UsageScopeWorkspace, workspaceID)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
-
-	stats := &models.WorkspaceStats{WorkspaceID: workspaceID}
-	if cursor.Next(ctx) {
-		var result struct {
-			TotalFiles int64    `bson:"total_files"`
-			TotalSize  int64    `bson:"total_size"`
-			Users      []string `bson:"users"`
-		}
-		if err := cursor.Decode(&result); err == nil {
-			stats.TotalFiles = result.TotalFiles
-			stats.TotalSize = result.TotalSize
-			stats.UserCount = int64(len(result.Users))
-		}
+	users, err := r.usage.CountWorkspaceUploaders(ctx, workspaceID)
+	if err != nil {
+		return nil, err
 	}
 
-	return stats, nil
+	return &models.WorkspaceStats{
+		WorkspaceID: workspaceID,
+		TotalFiles:  counter.UsedFiles,
+		TotalSize:   counter.UsedBytes,
+		UserCount:   users,
+	}, nil
 }
 
 // ── Bulk Operations ──
 
-func (r *ExtendedRepository) BulkDelete(ctx context.Context, ids []string) error {
+// BulkDelete moves the given attachments to the trash until purgeAt.
+func (r *ExtendedRepository) BulkDelete(ctx context.Context, ids []string, deletedBy string, purgeAt time.Time) error {
 	var objIDs []primitive.ObjectID
 	for _, id := range ids {
 		objID, err := primitive.ObjectIDFromHex(id)
@@ -813,10 +978,10 @@ func (r *ExtendedRepository) BulkDelete(ctx context.Context, ids []string) error
 		}
 		objIDs = append(objIDs, objID)
 	}
-	_, err := r.attachments.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": objIDs}}, bson.M{"$set": bson.M{
-		"status":     "deleted",
-		"updated_at": time.Now(),
-	}})
+	_, err := r.usage.trashAttachments(ctx, bson.M{
+		"_id":    bson.M{"$in": objIDs},
+		"status": bson.M{"$ne": models.StatusDeleted},
+	}, trashSet(deletedBy, purgeAt))
 	return err
 }
 
@@ -829,19 +994,19 @@ func (r *ExtendedRepository) BulkMove(ctx context.Context, ids []string, channel
 		}
 		objIDs = append(objIDs, objID)
 	}
-	_, err := r.attachments.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": objIDs}}, bson.M{"$set": bson.M{
+	_, err := r.usage.updateAttachments(ctx, bson.M{"_id": bson.M{"$in": objIDs}}, bson.M{
 		"channel_id": channelID,
 		"updated_at": time.Now(),
-	}})
+	})
 	return err
 }
 
 func (r *ExtendedRepository) GetByWorkspaceID(ctx context.Context, workspaceID string, limit, offset int) ([]*models.Attachment, error) {
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)).SetSkip(int64(offset))
-	cursor, err := r.attachments.Find(ctx, bson.M{
+	cursor, err := r.attachments.Find(ctx, Scoped(ctx, bson.M{
 		"workspace_id": workspaceID,
 		"status":       bson.M{"$ne": "deleted"},
-	}, opts)
+	}), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -858,10 +1023,10 @@ func (r *ExtendedRepository) RenameAttachment(ctx context.Context, id, newName s
 	if err != nil {
 		return err
 	}
-	_, err = r.attachments.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
+	_, err = r.usage.updateAttachment(ctx, bson.M{"_id": objID}, bson.M{
 		"original_name": newName,
 		"updated_at":    time.Now(),
-	}})
+	})
 	return err
 }
 
@@ -877,7 +1042,7 @@ func (r *ExtendedRepository) MoveAttachment(ctx context.Context, id, channelID,
 	if messageID != "" {
 		update["message_id"] = messageID
 	}
-	_, err = r.attachments.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
+	_, err = r.usage.updateAttachment(ctx, bson.M{"_id": objID}, update)
 	return err
 }
 
@@ -885,12 +1050,11 @@ func (r *ExtendedRepository) CloneAttachment(ctx context.Context, attachment *mo
 	attachment.ID = primitive.NewObjectID()
 	attachment.CreatedAt = time.Now()
 	attachment.UpdatedAt = time.Now()
-	_, err := r.attachments.InsertOne(ctx, attachment)
-	return err
+	return r.usage.insertAttachment(ctx, attachment)
 }
 
 func (r *ExtendedRepository) AggregateAttachments(ctx context.Context, pipeline mongo.Pipeline) (*mongo.Cursor, error) {
-	return r.attachments.Aggregate(ctx, pipeline)
+	return r.attachments.Aggregate(ctx, ScopedPipeline(ctx, pipeline))
 }
 
 func (r *ExtendedRepository) FindAttachmentByID(ctx context.Context, id string, result *models.Attachment) error {
@@ -898,7 +1062,62 @@ func (r *ExtendedRepository) FindAttachmentByID(ctx context.Context, id string,
 	if err != nil {
 		return err
 	}
-	return r.attachments.FindOne(ctx, bson.M{"_id": objID}).Decode(result)
+	return r.attachments.FindOne(ctx, Scoped(ctx, bson.M{"_id": objID})).Decode(result)
+}
+
+func (r *ExtendedRepository) FindAttachmentsByIDs(ctx context.Context, ids []string) ([]*models.Attachment, error) {
+	var objIDs []primitive.ObjectID
+	for _, id := range ids {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			continue
+		}
+		objIDs = append(objIDs, objID)
+	}
+	cursor, err := r.attachments.Find(ctx, Scoped(ctx, bson.M{"_id": bson.M{"$in": objIDs}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var attachments []*models.Attachment
+	if err := cursor.All(ctx, &attachments); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// checkAttachment returns mongo.ErrNoDocuments unless the attachment is in
+// the ctx tenant. Unscoped contexts skip the lookup.
+func (r *ExtendedRepository) checkAttachment(ctx context.Context, attachmentID string) error {
+	if _, ok := TenantFrom(ctx); !ok {
+		return nil
+	}
+	objID, err := primitive.ObjectIDFromHex(attachmentID)
+	if err != nil {
+		return mongo.ErrNoDocuments
+	}
+	n, err := r.attachments.CountDocuments(ctx, Scoped(ctx, bson.M{"_id": objID}), options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// findChild decodes the first document matching filter in a collection keyed
+// by attachment_id, provided its attachment is in the ctx tenant.
+func (r *ExtendedRepository) findChild(ctx context.Context, coll *mongo.Collection, filter bson.M, result any) error {
+	raw, err := coll.FindOne(ctx, filter).Raw()
+	if err != nil {
+		return err
+	}
+	attachmentID, _ := raw.Lookup("attachment_id").StringValueOK()
+	if err := r.checkAttachment(ctx, attachmentID); err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, result)
 }
 
 func (r *ExtendedRepository) Database() *mongo.Database {
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"attachment-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IdempotencyRepository stores Idempotency-Key records. Records are removed
+// by a TTL index once they expire.
+type IdempotencyRepository struct {
+	records *mongo.Collection
+}
+
+func NewIdempotencyRepository(client *mongo.Client, dbName string) *IdempotencyRepository {
+	r := &IdempotencyRepository{
+		records: client.Database(dbName).Collection("idempotency_keys"),
+	}
+
+	r.records.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+
+	return r
+}
+
+// Begin claims rec.Key for a new request. If the key is already taken it
+// returns the existing record instead, unless that record has expired or
+// its request has been processing for longer than lockTimeout, in which
+// case the key is taken over.
+func (r *IdempotencyRepository) Begin(ctx context.Context, rec *models.IdempotencyRecord, lockTimeout time.Duration) (*models.IdempotencyRecord, error) {
+	rec.Status = models.IdempotencyProcessing
+	_, err := r.records.InsertOne(ctx, rec)
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+
+	var existing models.IdempotencyRecord
+	err = r.records.FindOne(ctx, bson.M{"key": rec.Key}).Decode(&existing)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Expired and removed in between; claim it again
+		return r.Begin(ctx, rec, lockTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	stale := existing.Status == models.IdempotencyProcessing && now.Sub(existing.LockedAt) > lockTimeout
+	if !now.After(existing.ExpiresAt) && !stale {
+		return &existing, nil
+	}
+
+	result, err := r.records.ReplaceOne(ctx, bson.M{"_id": existing.ID, "locked_at": existing.LockedAt}, rec)
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		// Another retry took it over first
+		existing.Status = models.IdempotencyProcessing
+		existing.LockedAt = now
+		return &existing, nil
+	}
+	return nil, nil
+}
+
+// Complete stores the response to replay for the key.
+func (r *IdempotencyRepository) Complete(ctx context.Context, key string, statusCode int, contentType string, body []byte) error {
+	_, err := r.records.UpdateOne(ctx, bson.M{"key": key, "status": models.IdempotencyProcessing}, bson.M{"$set": bson.M{
+		"status":       models.IdempotencyCompleted,
+		"status_code":  statusCode,
+		"content_type": contentType,
+		"body":         body,
+	}})
+	return err
+}
+
+// Abort releases a key whose request did not finish, so it can be retried.
+func (r *IdempotencyRepository) Abort(ctx context.Context, key string) error {
+	_, err := r.records.DeleteOne(ctx, bson.M{"key": key, "status": models.IdempotencyProcessing})
+	return err
+}
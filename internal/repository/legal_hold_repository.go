@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"attachment-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LegalHoldRepository stores legal holds and their audit trail.
+type LegalHoldRepository struct {
+	holds *mongo.Collection
+	audit *mongo.Collection
+}
+
+func NewLegalHoldRepository(client *mongo.Client, dbName string) *LegalHoldRepository {
+	db := client.Database(dbName)
+	r := &LegalHoldRepository{
+		holds: db.Collection("legal_holds"),
+		audit: db.Collection("legal_hold_audit"),
+	}
+
+	ctx := context.Background()
+	r.holds.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "status", Value: 1}, {Key: "scope", Value: 1}, {Key: "scope_id", Value: 1}}},
+		{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	r.audit.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "hold_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+
+	return r
+}
+
+func (r *LegalHoldRepository) Create(ctx context.Context, h *models.LegalHold) error {
+	if !InTenant(ctx, h.WorkspaceID) {
+		return ErrOutsideTenant
+	}
+	h.Status = models.HoldActive
+	h.CreatedAt = time.Now()
+	result, err := r.holds.InsertOne(ctx, h)
+	if err != nil {
+		return err
+	}
+	h.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *LegalHoldRepository) Get(ctx context.Context, workspaceID, id string) (*models.LegalHold, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var h models.LegalHold
+	if err := r.holds.FindOne(ctx, Scoped(ctx, bson.M{"_id": objID, "workspace_id": workspaceID})).Decode(&h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// List returns a workspace's holds, newest first, optionally only those
+// with the given status.
+func (r *LegalHoldRepository) List(ctx context.Context, workspaceID, status string, limit, offset int) ([]*models.LegalHold, error) {
+	filter := bson.M{"workspace_id": workspaceID}
+	if status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)).SetSkip(int64(offset))
+	cursor, err := r.holds.Find(ctx, Scoped(ctx, filter), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	holds := []*models.LegalHold{}
+	if err := cursor.All(ctx, &holds); err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
+
+// Release ends a hold. It returns mongo.ErrNoDocuments if the hold does
+// not exist or was already released.
+func (r *LegalHoldRepository) Release(ctx context.Context, id primitive.ObjectID, releasedBy, reason string) (*models.LegalHold, error) {
+	var h models.LegalHold
+	err := r.holds.FindOneAndUpdate(ctx, bson.M{"_id": id, "status": models.HoldActive}, bson.M{"$set": bson.M{
+		"status":         models.HoldReleased,
+		"released_at":    time.Now(),
+		"released_by":    releasedBy,
+		"release_reason": reason,
+	}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&h)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// Covering returns the active holds that may cover any of attachments;
+// LegalHold.Covers tells which. Holds apply regardless of the caller's
+// tenant.
+func (r *LegalHoldRepository) Covering(ctx context.Context, attachments []*models.Attachment) ([]*models.LegalHold, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+	workspaces := map[string]bool{}
+	var ids, users, channels []string
+	for _, a := range attachments {
+		workspaces[a.WorkspaceID] = true
+		ids = append(ids, a.ID.Hex())
+		users = append(users, a.UserID)
+		if a.ChannelID != "" {
+			channels = append(channels, a.ChannelID)
+		}
+	}
+	workspaceIDs := make([]string, 0, len(workspaces))
+	for ws := range workspaces {
+		workspaceIDs = append(workspaceIDs, ws)
+	}
+	cursor, err := r.holds.Find(ctx, bson.M{
+		"workspace_id": bson.M{"$in": workspaceIDs},
+		"status":       models.HoldActive,
+		"$or": bson.A{
+			bson.M{"scope": models.HoldScopeWorkspace},
+			bson.M{"scope": models.HoldScopeAttachment, "scope_id": bson.M{"$in": ids}},
+			bson.M{"scope": models.HoldScopeUser, "scope_id": bson.M{"$in": users}},
+			bson.M{"scope": models.HoldScopeChannel, "scope_id": bson.M{"$in": channels}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var holds []*models.LegalHold
+	if err := cursor.All(ctx, &holds); err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
+
+// ── Audit ──
+
+func (r *LegalHoldRepository) RecordAudit(ctx context.Context, entries ...*models.LegalHoldAudit) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	now := time.Now()
+	docs := make([]any, len(entries))
+	for i, e := range entries {
+		e.CreatedAt = now
+		docs[i] = e
+	}
+	_, err := r.audit.InsertMany(ctx, docs)
+	return err
+}
+
+func (r *LegalHoldRepository) ListAudit(ctx context.Context, holdID string, limit, offset int) ([]*models.LegalHoldAudit, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)).SetSkip(int64(offset))
+	cursor, err := r.audit.Find(ctx, Scoped(ctx, bson.M{"hold_id": holdID}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	entries := []*models.LegalHoldAudit{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"attachment-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LifecycleRepository applies upstream lifecycle events, such as a deleted
+// message or workspace, to attachments. Handled events are remembered
+// until a TTL index removes them, so redelivered events are skipped.
+type LifecycleRepository struct {
+	attachments *mongo.Collection
+	handled     *mongo.Collection
+	usage       *UsageRepository
+}
+
+func NewLifecycleRepository(client *mongo.Client, dbName string) *LifecycleRepository {
+	db := client.Database(dbName)
+	r := &LifecycleRepository{
+		attachments: db.Collection("attachments"),
+		handled:     db.Collection("handled_events"),
+		usage:       NewUsageRepository(client, dbName),
+	}
+
+	ctx := context.Background()
+	r.handled.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	return r
+}
+
+// Handled reports whether the event with the given ID was already handled.
+func (r *LifecycleRepository) Handled(ctx context.Context, eventID string) (bool, error) {
+	err := r.handled.FindOne(ctx, bson.M{"_id": eventID}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// MarkHandled remembers an event for ttl.
+func (r *LifecycleRepository) MarkHandled(ctx context.Context, eventID, topic string, ttl time.Duration) error {
+	now := time.Now()
+	_, err := r.handled.UpdateOne(ctx, bson.M{"_id": eventID}, bson.M{"$set": bson.M{
+		"topic":      topic,
+		"handled_at": now,
+		"expires_at": now.Add(ttl),
+	}}, options.Update().SetUpsert(true))
+	return err
+}
+
+// ListLive returns up to limit attachments matching filter that are not
+// deleted.
+func (r *LifecycleRepository) ListLive(ctx context.Context, filter bson.M, limit int) ([]*models.Attachment, error) {
+	live := bson.M{"status": bson.M{"$ne": models.StatusDeleted}}
+	for k, v := range filter {
+		live[k] = v
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := r.attachments.Find(ctx, Scoped(ctx, live), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var attachments []*models.Attachment
+	if err := cursor.All(ctx, &attachments); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// DeleteAll moves the given attachments to the trash, releasing their
+// usage, until their content is purged at purgeAt.
+func (r *LifecycleRepository) DeleteAll(ctx context.Context, ids []primitive.ObjectID, purgeAt time.Time) (int64, error) {
+	return r.usage.trashAttachments(ctx, bson.M{
+		"_id":    bson.M{"$in": ids},
+		"status": bson.M{"$ne": models.StatusDeleted},
+	}, trashSet(models.ActorSystem, purgeAt))
+}
+
+// ReassignAll moves the given attachments, and their usage, from one user
+// to another.
+func (r *LifecycleRepository) ReassignAll(ctx context.Context, ids []primitive.ObjectID, from, to string) (int64, error) {
+	return r.usage.updateAttachments(ctx, bson.M{
+		"_id":     bson.M{"$in": ids},
+		"user_id": from,
+	}, bson.M{
+		"user_id":    to,
+		"updated_at": time.Now(),
+	})
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"attachment-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ── Outbox Context ──
+
+type outboxKey struct{}
+
+type outboxBatch struct {
+	events  []*models.OutboxEvent
+	written bool
+}
+
+// WithOutbox attaches events to ctx. The next attachment write, or other
+// write that announces events, made with the returned context inserts them
+// into the outbox in the same transaction, so they are recorded exactly
+// when the write succeeds.
+func WithOutbox(ctx context.Context, events ...*models.OutboxEvent) context.Context {
+	return context.WithValue(ctx, outboxKey{}, &outboxBatch{events: events})
+}
+
+// writeOutbox inserts the events attached to ctx, unless an earlier write
+// already did. It runs inside the attachment write's transaction.
+func writeOutbox(ctx context.Context, outbox *mongo.Collection) error {
+	batch, ok := ctx.Value(outboxKey{}).(*outboxBatch)
+	if !ok || batch.written || len(batch.events) == 0 {
+		return nil
+	}
+	docs := make([]any, len(batch.events))
+	for i, e := range batch.events {
+		docs[i] = e
+	}
+	_, err := outbox.InsertMany(ctx, docs)
+	return err
+}
+
+// outboxWritten marks the events attached to ctx as written once their
+// transaction has committed. A transaction that is retried writes them
+// again, since the aborted attempt's inserts were rolled back.
+func outboxWritten(ctx context.Context) {
+	if batch, ok := ctx.Value(outboxKey{}).(*outboxBatch); ok {
+		batch.written = true
+	}
+}
+
+// ErrEventMissing is returned by writes that must announce themselves
+// when ctx carries no event of the required type.
+var ErrEventMissing = errors.New("write has no event attached")
+
+// requireEvent fails unless an event of type topic is attached to ctx.
+func requireEvent(ctx context.Context, topic string) error {
+	if batch, ok := ctx.Value(outboxKey{}).(*outboxBatch); ok {
+		for _, e := range batch.events {
+			if e.Topic == topic {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrEventMissing, topic)
+}
+
+// withOutbox runs write in a transaction with the events attached to ctx,
+// for changes stored outside the attachments collection.
+func (r *UsageRepository) withOutbox(ctx context.Context, write func(ctx context.Context) error) error {
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		if err := write(ctx); err != nil {
+			return err
+		}
+		return writeOutbox(ctx, r.outbox)
+	})
+	if err == nil {
+		outboxWritten(ctx)
+	}
+	return err
+}
+
+// ── Outbox Relay ──
+
+// OutboxRepository reads the outbox for the relay. Sent events are removed
+// by a TTL index; pending ones are kept until published.
+type OutboxRepository struct {
+	events *mongo.Collection
+	leases *mongo.Collection
+}
+
+func NewOutboxRepository(client *mongo.Client, dbName string) *OutboxRepository {
+	db := client.Database(dbName)
+	r := &OutboxRepository{
+		events: db.Collection("outbox"),
+		leases: db.Collection("outbox_leases"),
+	}
+
+	r.events.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+
+	return r
+}
+
+// Pending returns unsent events due by now, oldest first. Keys with an
+// event waiting to be retried are left out entirely, so later events never
+// overtake it.
+func (r *OutboxRepository) Pending(ctx context.Context, now time.Time, limit int) ([]*models.OutboxEvent, error) {
+	waiting, err := r.events.Distinct(ctx, "key", bson.M{
+		"status":          models.OutboxPending,
+		"next_attempt_at": bson.M{"$gt": now},
+	})
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{
+		"status":          models.OutboxPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	if len(waiting) > 0 {
+		filter["key"] = bson.M{"$nin": waiting}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := r.events.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var events []*models.OutboxEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// MarkSent records that an event was published and schedules its removal.
+func (r *OutboxRepository) MarkSent(ctx context.Context, id primitive.ObjectID, now time.Time, retention time.Duration) error {
+	_, err := r.events.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"status": models.OutboxSent, "sent_at": now, "expires_at": now.Add(retention)},
+		"$inc": bson.M{"attempts": 1},
+	})
+	return err
+}
+
+// MarkFailed records a failed publish and when to try again.
+func (r *OutboxRepository) MarkFailed(ctx context.Context, id primitive.ObjectID, reason string, next time.Time) error {
+	_, err := r.events.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"last_error": reason, "next_attempt_at": next},
+		"$inc": bson.M{"attempts": 1},
+	})
+	return err
+}
+
+// AcquireLease takes or renews the named lease for owner until ttl from
+// now. It reports false while another owner holds an unexpired lease.
+func (r *OutboxRepository) AcquireLease(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	_, err := r.leases.UpdateOne(ctx, bson.M{
+		"_id": name,
+		"$or": bson.A{bson.M{"owner": owner}, bson.M{"expires_at": bson.M{"$lt": now}}},
+	}, bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(ttl)}}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
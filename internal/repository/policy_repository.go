@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"attachment-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PolicyRepository stores per-workspace upload policies
+type PolicyRepository struct {
+	policies *mongo.Collection
+}
+
+func NewPolicyRepository(client *mongo.Client, dbName string) *PolicyRepository {
+	r := &PolicyRepository{
+		policies: client.Database(dbName).Collection("workspace_policies"),
+	}
+
+	r.policies.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "workspace_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	return r
+}
+
+func (r *PolicyRepository) GetPolicy(ctx context.Context, workspaceID string) (*models.WorkspacePolicy, error) {
+	var p models.WorkspacePolicy
+	err := r.policies.FindOne(ctx, bson.M{"workspace_id": workspaceID}).Decode(&p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *PolicyRepository) ListPolicies(ctx context.Context, limit, offset int) ([]*models.WorkspacePolicy, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "workspace_id", Value: 1}}).SetLimit(int64(limit)).SetSkip(int64(offset))
+	cursor, err := r.policies.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var policies []*models.WorkspacePolicy
+	if err := cursor.All(ctx, &policies); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// UpsertPolicy replaces the policy for p.WorkspaceID, creating it if needed.
+func (r *PolicyRepository) UpsertPolicy(ctx context.Context, p *models.WorkspacePolicy) error {
+	now := time.Now()
+	p.UpdatedAt = now
+	filter := bson.M{"workspace_id": p.WorkspaceID}
+	update := bson.M{"$set": bson.M{
+		"allowed_types":         p.AllowedTypes,
+		"blocked_types":         p.BlockedTypes,
+		"max_file_size":         p.MaxFileSize,
+		"max_files_per_message": p.MaxFilesPerMessage,
+		"strip_metadata":        p.StripMetadata,
+		"retention_days":        p.RetentionDays,
+		"max_expires_in":        p.MaxExpiresIn,
+		"updated_by":            p.UpdatedBy,
+		"updated_at":            now,
+	}, "$setOnInsert": bson.M{
+		"created_at": now,
+	}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	return r.policies.FindOneAndUpdate(ctx, filter, update, opts).Decode(p)
+}
+
+func (r *PolicyRepository) DeletePolicy(ctx context.Context, workspaceID string) error {
+	result, err := r.policies.DeleteOne(ctx, bson.M{"workspace_id": workspaceID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RateLimitRepository keeps token buckets shared by every replica. Buckets
+// are removed by a TTL index once they would have refilled.
+type RateLimitRepository struct {
+	buckets *mongo.Collection
+}
+
+func NewRateLimitRepository(client *mongo.Client, dbName string) *RateLimitRepository {
+	r := &RateLimitRepository{
+		buckets: client.Database(dbName).Collection("rate_limits"),
+	}
+
+	r.buckets.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	return r
+}
+
+type tokenBucket struct {
+	Tokens  float64 `bson:"tokens"`
+	Allowed bool    `bson:"allowed"`
+}
+
+// Take refills the bucket for key at perSecond up to capacity, then removes
+// cost tokens if that many are available, in a single atomic update. It
+// returns the tokens left and whether cost was taken.
+func (r *RateLimitRepository) Take(ctx context.Context, key string, cost, capacity, perSecond float64, now time.Time, ttl time.Duration) (float64, bool, error) {
+	elapsed := bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updated_at", now}}}}, 1000}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"tokens": bson.M{"$min": bson.A{capacity, bson.M{"$add": bson.A{
+				bson.M{"$ifNull": bson.A{"$tokens", capacity}},
+				bson.M{"$multiply": bson.A{bson.M{"$max": bson.A{elapsed, 0}}, perSecond}},
+			}}}},
+			"updated_at": now,
+			"expires_at": now.Add(ttl),
+		}}},
+		{{Key: "$set", Value: bson.M{"allowed": bson.M{"$gte": bson.A{"$tokens", cost}}}}},
+		{{Key: "$set", Value: bson.M{"tokens": bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", cost}}, "$tokens"}}}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var b tokenBucket
+	if err := r.buckets.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).Decode(&b); err != nil {
+		return 0, false, err
+	}
+	return b.Tokens, b.Allowed, nil
+}
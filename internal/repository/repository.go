@@ -18,15 +18,22 @@ type Repository interface {
 	GetByMessageID(ctx context.Context, messageID string) ([]*models.Attachment, error)
 	GetByChannelID(ctx context.Context, channelID string, limit, offset int) ([]*models.Attachment, error)
 	GetByUserID(ctx context.Context, userID string, limit, offset int) ([]*models.Attachment, error)
+	CountByMessageID(ctx context.Context, messageID string) (int64, error)
+	ListByStatusBefore(ctx context.Context, status models.AttachmentStatus, before time.Time, limit int) ([]*models.Attachment, error)
 	Update(ctx context.Context, id string, update bson.M) error
+	UpdateIfStatus(ctx context.Context, id string, status models.AttachmentStatus, update bson.M) (bool, error)
+	UpdateAllIfStatus(ctx context.Context, updates map[string]bson.M, status models.AttachmentStatus) (bool, error)
 	UpdateStatus(ctx context.Context, id string, status models.AttachmentStatus) error
-	Delete(ctx context.Context, id string) error
+	Delete(ctx context.Context, id, deletedBy string, purgeAt time.Time) (bool, error)
+	Restore(ctx context.Context, id string, from models.AttachmentStatus) (bool, error)
+	GetTrashByUserID(ctx context.Context, userID string, limit, offset int) ([]*models.Attachment, error)
 	Close() error
 }
 
 type MongoRepository struct {
 	client     *mongo.Client
 	collection *mongo.Collection
+	usage      *UsageRepository
 }
 
 func NewMongoRepository(url, dbName string) (*MongoRepository, error) {
@@ -42,36 +49,38 @@ func NewMongoRepository(url, dbName string) (*MongoRepository, error) {
 		return nil, err
 	}
 
+	return NewMongoRepositoryWithClient(client, dbName), nil
+}
+
+// NewMongoRepositoryWithClient is NewMongoRepository for a client that is
+// already connected.
+func NewMongoRepositoryWithClient(client *mongo.Client, dbName string) *MongoRepository {
 	collection := client.Database(dbName).Collection("attachments")
 
 	// Create indexes
 	indexes := []mongo.IndexModel{
 		{Keys: bson.D{{Key: "user_id", Value: 1}}},
 		{Keys: bson.D{{Key: "workspace_id", Value: 1}}},
+		{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "created_at", Value: -1}}},
 		{Keys: bson.D{{Key: "channel_id", Value: 1}}},
 		{Keys: bson.D{{Key: "message_id", Value: 1}}},
 		{Keys: bson.D{{Key: "status", Value: 1}}},
 		{Keys: bson.D{{Key: "created_at", Value: -1}}},
 	}
-	_, _ = collection.Indexes().CreateMany(ctx, indexes)
+	_, _ = collection.Indexes().CreateMany(context.Background(), indexes)
 
 	return &MongoRepository{
 		client:     client,
 		collection: collection,
-	}, nil
+		usage:      NewUsageRepository(client, dbName),
+	}
 }
 
 func (r *MongoRepository) Create(ctx context.Context, attachment *models.Attachment) error {
 	attachment.CreatedAt = time.Now()
 	attachment.UpdatedAt = time.Now()
 
-	result, err := r.collection.InsertOne(ctx, attachment)
-	if err != nil {
-		return err
-	}
-
-	attachment.ID = result.InsertedID.(primitive.ObjectID)
-	return nil
+	return r.usage.insertAttachment(ctx, attachment)
 }
 
 func (r *MongoRepository) GetByID(ctx context.Context, id string) (*models.Attachment, error) {
@@ -81,7 +90,7 @@ func (r *MongoRepository) GetByID(ctx context.Context, id string) (*models.Attac
 	}
 
 	var attachment models.Attachment
-	err = r.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&attachment)
+	err = r.collection.FindOne(ctx, Scoped(ctx, bson.M{"_id": objID})).Decode(&attachment)
 	if err != nil {
 		return nil, err
 	}
@@ -90,10 +99,11 @@ func (r *MongoRepository) GetByID(ctx context.Context, id string) (*models.Attac
 }
 
 func (r *MongoRepository) GetByMessageID(ctx context.Context, messageID string) ([]*models.Attachment, error) {
-	cursor, err := r.collection.Find(ctx, bson.M{
+	cursor, err := r.collection.Find(ctx, Scoped(ctx, bson.M{
 		"message_id": messageID,
 		"status":     bson.M{"$ne": models.StatusDeleted},
-	})
+		"$or":        unexpired(time.Now()),
+	}))
 	if err != nil {
 		return nil, err
 	}
@@ -113,10 +123,11 @@ func (r *MongoRepository) GetByChannelID(ctx context.Context, channelID string,
 		SetLimit(int64(limit)).
 		SetSkip(int64(offset))
 
-	cursor, err := r.collection.Find(ctx, bson.M{
+	cursor, err := r.collection.Find(ctx, Scoped(ctx, bson.M{
 		"channel_id": channelID,
 		"status":     models.StatusReady,
-	}, opts)
+		"$or":        unexpired(time.Now()),
+	}), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -136,10 +147,40 @@ func (r *MongoRepository) GetByUserID(ctx context.Context, userID string, limit,
 		SetLimit(int64(limit)).
 		SetSkip(int64(offset))
 
-	cursor, err := r.collection.Find(ctx, bson.M{
+	cursor, err := r.collection.Find(ctx, Scoped(ctx, bson.M{
 		"user_id": userID,
 		"status":  bson.M{"$ne": models.StatusDeleted},
-	}, opts)
+		"$or":     unexpired(time.Now()),
+	}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var attachments []*models.Attachment
+	if err := cursor.All(ctx, &attachments); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
+
+func (r *MongoRepository) CountByMessageID(ctx context.Context, messageID string) (int64, error) {
+	return r.collection.CountDocuments(ctx, Scoped(ctx, bson.M{
+		"message_id": messageID,
+		"status":     bson.M{"$ne": models.StatusDeleted},
+	}))
+}
+
+func (r *MongoRepository) ListByStatusBefore(ctx context.Context, status models.AttachmentStatus, before time.Time, limit int) ([]*models.Attachment, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, Scoped(ctx, bson.M{
+		"status":     status,
+		"created_at": bson.M{"$lt": before},
+	}), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -160,16 +201,117 @@ func (r *MongoRepository) Update(ctx context.Context, id string, update bson.M)
 	}
 
 	update["updated_at"] = time.Now()
-	_, err = r.collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
+	_, err = r.usage.updateAttachment(ctx, bson.M{"_id": objID}, update)
 	return err
 }
 
+// UpdateIfStatus applies update only while the attachment is still in the
+// given status, and reports whether it did.
+func (r *MongoRepository) UpdateIfStatus(ctx context.Context, id string, status models.AttachmentStatus, update bson.M) (bool, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	update["updated_at"] = time.Now()
+	return r.usage.updateAttachment(ctx, bson.M{"_id": objID, "status": status}, update)
+}
+
+// UpdateAllIfStatus applies each update to the attachment with that ID, all
+// or nothing: if any of them has left the given status, none is changed and
+// it reports false.
+func (r *MongoRepository) UpdateAllIfStatus(ctx context.Context, updates map[string]bson.M, status models.AttachmentStatus) (bool, error) {
+	now := time.Now()
+	byID := make(map[primitive.ObjectID]bson.M, len(updates))
+	for id, update := range updates {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return false, err
+		}
+		update["updated_at"] = now
+		byID[objID] = update
+	}
+	return r.usage.updateAllIfStatus(ctx, byID, status)
+}
+
 func (r *MongoRepository) UpdateStatus(ctx context.Context, id string, status models.AttachmentStatus) error {
 	return r.Update(ctx, id, bson.M{"status": status})
 }
 
-func (r *MongoRepository) Delete(ctx context.Context, id string) error {
-	return r.UpdateStatus(ctx, id, models.StatusDeleted)
+// Delete moves an attachment to the trash until its content is purged at
+// purgeAt, and reports whether it was not there already.
+func (r *MongoRepository) Delete(ctx context.Context, id, deletedBy string, purgeAt time.Time) (bool, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := r.usage.trashAttachments(ctx, bson.M{
+		"_id":    objID,
+		"status": bson.M{"$ne": models.StatusDeleted},
+	}, trashSet(deletedBy, purgeAt))
+	return deleted > 0, err
+}
+
+// Restore takes an attachment deleted from status from out of the trash,
+// back into that status, and reports whether it was there with its content
+// neither purged nor being purged. Attachments trashed without a recorded
+// status come back ready.
+func (r *MongoRepository) Restore(ctx context.Context, id string, from models.AttachmentStatus) (bool, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.M{
+		"_id":          objID,
+		"status":       models.StatusDeleted,
+		"deleted_from": from,
+		"purging_at":   nil,
+		"purged_at":    bson.M{"$exists": false},
+	}
+	to := from
+	if from == "" {
+		filter["deleted_from"] = nil
+		to = models.StatusReady
+	}
+	return r.usage.updateAttachment(ctx, filter, bson.M{
+		"status":       to,
+		"deleted_at":   nil,
+		"deleted_by":   "",
+		"deleted_from": "",
+		"purge_at":     nil,
+		"updated_at":   time.Now(),
+	})
+}
+
+// GetTrashByUserID returns a user's trashed attachments that can still be
+// restored, most recently deleted first. Expired ones are left out.
+func (r *MongoRepository) GetTrashByUserID(ctx context.Context, userID string, limit, offset int) ([]*models.Attachment, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "deleted_at", Value: -1}}).
+		SetLimit(int64(limit)).
+		SetSkip(int64(offset))
+
+	cursor, err := r.collection.Find(ctx, Scoped(ctx, bson.M{
+		"user_id":    userID,
+		"status":     models.StatusDeleted,
+		"deleted_at": bson.M{"$exists": true},
+		"purging_at": nil,
+		"purged_at":  bson.M{"$exists": false},
+		"$or":        unexpired(time.Now()),
+	}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var attachments []*models.Attachment
+	if err := cursor.All(ctx, &attachments); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
 }
 
 func (r *MongoRepository) Close() error {
@@ -179,3 +321,8 @@ func (r *MongoRepository) Close() error {
 func (r *MongoRepository) Client() *mongo.Client {
 	return r.client
 }
+
+// Usage returns the usage counters kept in step with this repository's writes.
+func (r *MongoRepository) Usage() *UsageRepository {
+	return r.usage
+}
@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"attachment-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RetentionRepository finds the attachments workspace retention policies
+// apply to and applies their actions. It works across tenants, for the
+// retention enforcer.
+type RetentionRepository struct {
+	policies    *mongo.Collection
+	attachments *mongo.Collection
+	previews    *mongo.Collection
+	activities  *mongo.Collection
+	usage       *UsageRepository
+}
+
+func NewRetentionRepository(client *mongo.Client, dbName string) *RetentionRepository {
+	db := client.Database(dbName)
+	r := &RetentionRepository{
+		policies:    db.Collection("attachment_retention"),
+		attachments: db.Collection("attachments"),
+		previews:    db.Collection("attachment_previews"),
+		activities:  db.Collection("attachment_activities"),
+		usage:       NewUsageRepository(client, dbName),
+	}
+
+	ctx := context.Background()
+	r.policies.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "is_active", Value: 1}, {Key: "workspace_id", Value: 1}},
+	})
+	r.attachments.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+
+	return r
+}
+
+// Get returns a workspace's retention policy.
+func (r *RetentionRepository) Get(ctx context.Context, workspaceID, id string) (*models.AttachmentRetention, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	var p models.AttachmentRetention
+	if err := r.policies.FindOne(ctx, Scoped(ctx, bson.M{"_id": oid, "workspace_id": workspaceID})).Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListActive returns every workspace's active policies.
+func (r *RetentionRepository) ListActive(ctx context.Context) ([]*models.AttachmentRetention, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "workspace_id", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.policies.Find(ctx, bson.M{"is_active": true}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var policies []*models.AttachmentRetention
+	if err := cursor.All(ctx, &policies); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// MarkEnforced records a policy's latest run.
+func (r *RetentionRepository) MarkEnforced(ctx context.Context, id primitive.ObjectID, at time.Time, affected int) error {
+	_, err := r.policies.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"last_enforced_at": at,
+		"last_affected":    affected,
+	}})
+	return err
+}
+
+// retentionFilter matches the ready attachments p applies to at cutoff.
+// Archiving skips attachments already in archiveClass.
+func retentionFilter(p *models.AttachmentRetention, cutoff time.Time, archiveClass string) bson.M {
+	filter := bson.M{
+		"workspace_id": p.WorkspaceID,
+		"status":       models.StatusReady,
+		"created_at":   bson.M{"$lt": cutoff},
+	}
+	if prefix, ok := p.MimePrefix(); ok {
+		if prefix != "" {
+			filter["mime_type"] = bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}
+		}
+	} else if p.MimeType != "" {
+		filter["mime_type"] = p.MimeType
+	}
+	if p.Action == models.RetentionArchive {
+		filter["storage_class"] = bson.M{"$ne": archiveClass}
+	}
+	return filter
+}
+
+// Matching returns up to limit attachments p applies to at cutoff, in ID
+// order after the given ID.
+func (r *RetentionRepository) Matching(ctx context.Context, p *models.AttachmentRetention, cutoff time.Time, archiveClass string, after primitive.ObjectID, limit int) ([]*models.Attachment, error) {
+	filter := retentionFilter(p, cutoff, archiveClass)
+	if !after.IsZero() {
+		filter["_id"] = bson.M{"$gt": after}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := r.attachments.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var attachments []*models.Attachment
+	if err := cursor.All(ctx, &attachments); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// DeleteAll moves the given attachments to the trash, releasing their
+// usage, until their content is purged at purgeAt.
+func (r *RetentionRepository) DeleteAll(ctx context.Context, ids []primitive.ObjectID, purgeAt time.Time) (int64, error) {
+	return r.usage.trashAttachments(ctx, bson.M{
+		"_id":    bson.M{"$in": ids},
+		"status": models.StatusReady,
+	}, trashSet(models.ActorSystem, purgeAt))
+}
+
+// MarkArchived records that an attachment's content was moved to class.
+func (r *RetentionRepository) MarkArchived(ctx context.Context, id primitive.ObjectID, class string) (bool, error) {
+	now := time.Now()
+	return r.usage.updateAttachment(ctx, bson.M{"_id": id, "status": models.StatusReady}, bson.M{
+		"storage_class": class,
+		"archived_at":   now,
+		"updated_at":    now,
+	})
+}
+
+// PreviewCounts returns how many previews each of the given attachments
+// has, omitting those with none.
+func (r *RetentionRepository) PreviewCounts(ctx context.Context, attachmentIDs []string) (map[string]int, error) {
+	cursor, err := r.previews.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"attachment_id": bson.M{"$in": attachmentIDs}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$attachment_id", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var rows []struct {
+		ID    string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.ID] = row.Count
+	}
+	return counts, nil
+}
+
+// ListPreviews returns an attachment's previews.
+func (r *RetentionRepository) ListPreviews(ctx context.Context, attachmentID string) ([]*models.AttachmentPreview, error) {
+	cursor, err := r.previews.Find(ctx, bson.M{"attachment_id": attachmentID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var previews []*models.AttachmentPreview
+	if err := cursor.All(ctx, &previews); err != nil {
+		return nil, err
+	}
+	return previews, nil
+}
+
+// DeletePreviews removes the given previews of an attachment and clears
+// its thumbnail.
+func (r *RetentionRepository) DeletePreviews(ctx context.Context, attachmentID primitive.ObjectID, ids []primitive.ObjectID) (bool, error) {
+	if _, err := r.previews.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return false, err
+	}
+	return r.usage.updateAttachment(ctx, bson.M{"_id": attachmentID}, bson.M{
+		"thumbnail_url": "",
+		"updated_at":    time.Now(),
+	})
+}
+
+// LogActivities adds entries to the attachments' activity logs.
+func (r *RetentionRepository) LogActivities(ctx context.Context, activities []*models.AttachmentActivity) error {
+	if len(activities) == 0 {
+		return nil
+	}
+	now := time.Now()
+	docs := make([]any, len(activities))
+	for i, a := range activities {
+		a.CreatedAt = now
+		docs[i] = a
+	}
+	_, err := r.activities.InsertMany(ctx, docs)
+	return err
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"attachment-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ShareLinkAccessRepository stores the access history of share links.
+type ShareLinkAccessRepository struct {
+	accesses *mongo.Collection
+}
+
+func NewShareLinkAccessRepository(client *mongo.Client, dbName string) *ShareLinkAccessRepository {
+	r := &ShareLinkAccessRepository{
+		accesses: client.Database(dbName).Collection("share_link_accesses"),
+	}
+
+	r.accesses.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "link_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+
+	return r
+}
+
+func (r *ShareLinkAccessRepository) Record(ctx context.Context, a *models.ShareLinkAccess) error {
+	a.CreatedAt = time.Now()
+	_, err := r.accesses.InsertOne(ctx, a)
+	return err
+}
+
+// Analytics summarises the accesses of a link since the given time, with
+// up to recent of the latest accesses. The caller fills in Link.
+func (r *ShareLinkAccessRepository) Analytics(ctx context.Context, linkID string, since time.Time, recent int) (*models.ShareLinkAnalytics, error) {
+	ok := bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$outcome", models.ShareLinkOK}}, 1, 0}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"link_id": linkID, "created_at": bson.M{"$gte": since}}}},
+		{{Key: "$facet", Value: bson.M{
+			"by_outcome": bson.A{
+				bson.M{"$group": bson.M{"_id": "$outcome", "count": bson.M{"$sum": 1}}},
+			},
+			"unique": bson.A{
+				bson.M{"$group": bson.M{"_id": "$ip"}},
+				bson.M{"$count": "count"},
+			},
+			"daily": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":       bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at"}},
+					"total":     bson.M{"$sum": 1},
+					"downloads": bson.M{"$sum": ok},
+				}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+			"recent": bson.A{
+				bson.M{"$sort": bson.M{"created_at": -1}},
+				bson.M{"$limit": recent},
+			},
+		}}},
+	}
+	cursor, err := r.accesses.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	var facets []struct {
+		ByOutcome []struct {
+			Outcome models.ShareLinkOutcome `bson:"_id"`
+			Count   int64                   `bson:"count"`
+		} `bson:"by_outcome"`
+		Unique []struct {
+			Count int64 `bson:"count"`
+		} `bson:"unique"`
+		Daily  []models.ShareLinkDailyCount `bson:"daily"`
+		Recent []*models.ShareLinkAccess    `bson:"recent"`
+	}
+	if err := cursor.All(ctx, &facets); err != nil {
+		return nil, err
+	}
+
+	a := &models.ShareLinkAnalytics{
+		Since:     since,
+		ByOutcome: map[models.ShareLinkOutcome]int64{},
+		Daily:     []models.ShareLinkDailyCount{},
+		Recent:    []*models.ShareLinkAccess{},
+	}
+	if len(facets) == 0 {
+		return a, nil
+	}
+	f := facets[0]
+	for _, o := range f.ByOutcome {
+		a.ByOutcome[o.Outcome] = o.Count
+		a.Total += o.Count
+	}
+	a.Downloads = a.ByOutcome[models.ShareLinkOK]
+	if len(f.Unique) > 0 {
+		a.UniqueVisitors = f.Unique[0].Count
+	}
+	if f.Daily != nil {
+		a.Daily = f.Daily
+	}
+	if f.Recent != nil {
+		a.Recent = f.Recent
+	}
+	return a, nil
+}
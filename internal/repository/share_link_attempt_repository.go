@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ShareLinkAttemptRepository counts failed share-link password attempts in
+// fixed windows. Counters are removed by a TTL index once their window ends.
+type ShareLinkAttemptRepository struct {
+	attempts *mongo.Collection
+}
+
+func NewShareLinkAttemptRepository(client *mongo.Client, dbName string) *ShareLinkAttemptRepository {
+	r := &ShareLinkAttemptRepository{
+		attempts: client.Database(dbName).Collection("share_link_attempts"),
+	}
+
+	r.attempts.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	return r
+}
+
+type attemptWindow struct {
+	ID        string    `bson:"_id"`
+	Count     int       `bson:"count"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// windowID keys the counter for key in the window containing now, and
+// returns when that window ends.
+func windowID(key string, now time.Time, window time.Duration) (string, time.Time) {
+	start := now.Truncate(window)
+	return key + "@" + start.UTC().Format(time.RFC3339), start.Add(window)
+}
+
+// Failures returns the failures recorded for key in the current window and
+// when the window ends.
+func (r *ShareLinkAttemptRepository) Failures(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	id, end := windowID(key, time.Now(), window)
+	var w attemptWindow
+	err := r.attempts.FindOne(ctx, bson.M{"_id": id}).Decode(&w)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, end, nil
+	}
+	if err != nil {
+		return 0, end, err
+	}
+	return w.Count, end, nil
+}
+
+// RecordFailure counts a failed attempt for key and returns the new total
+// for the current window.
+func (r *ShareLinkAttemptRepository) RecordFailure(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	id, end := windowID(key, time.Now(), window)
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var w attemptWindow
+	err := r.attempts.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{
+		"$inc":         bson.M{"count": 1},
+		"$setOnInsert": bson.M{"expires_at": end},
+	}, opts).Decode(&w)
+	if err != nil {
+		return 0, end, err
+	}
+	return w.Count, end, nil
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrOutsideTenant is returned when a write names a workspace the context
+// is not scoped to.
+var ErrOutsideTenant = errors.New("workspace is outside the caller's tenant")
+
+type tenantKey struct{}
+
+// WithTenant scopes every repository call made with ctx to the given
+// workspaces: reads outside them find nothing and writes into them fail.
+// An empty list matches nothing. Contexts without a tenant are unscoped,
+// which is what background jobs and admin tooling use.
+func WithTenant(ctx context.Context, workspaceIDs ...string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, append([]string{}, workspaceIDs...))
+}
+
+// TenantFrom returns the workspaces ctx is scoped to, if any.
+func TenantFrom(ctx context.Context) ([]string, bool) {
+	ws, ok := ctx.Value(tenantKey{}).([]string)
+	return ws, ok
+}
+
+// InTenant reports whether ctx may touch the workspace.
+func InTenant(ctx context.Context, workspaceID string) bool {
+	ws, ok := TenantFrom(ctx)
+	return !ok || slices.Contains(ws, workspaceID)
+}
+
+// Scoped adds the ctx tenant to a filter on a collection with a
+// workspace_id field. The filter is modified and returned.
+func Scoped(ctx context.Context, filter bson.M) bson.M {
+	ws, ok := TenantFrom(ctx)
+	if !ok {
+		return filter
+	}
+	cond := bson.M{"workspace_id": bson.M{"$in": ws}}
+	if existing, clash := filter["workspace_id"]; clash {
+		delete(filter, "workspace_id")
+		filter["$and"] = append(andClauses(filter), bson.M{"workspace_id": existing}, cond)
+		return filter
+	}
+	filter["workspace_id"] = cond["workspace_id"]
+	return filter
+}
+
+func andClauses(filter bson.M) bson.A {
+	if and, ok := filter["$and"].(bson.A); ok {
+		return and
+	}
+	return bson.A{}
+}
+
+// ScopedPipeline prefixes an attachments aggregation with the ctx tenant.
+func ScopedPipeline(ctx context.Context, pipeline mongo.Pipeline) mongo.Pipeline {
+	if _, ok := TenantFrom(ctx); !ok {
+		return pipeline
+	}
+	match := bson.D{{Key: "$match", Value: Scoped(ctx, bson.M{})}}
+	return append(mongo.Pipeline{match}, pipeline...)
+}
+
+// FindInTenant runs a find on a collection keyed by attachment_id, keeping
+// only documents whose attachment is in the ctx tenant. Results are sorted,
+// skipped and limited after that filter so pages stay full.
+func FindInTenant(ctx context.Context, coll *mongo.Collection, filter bson.M, sort bson.D, limit, offset int, results any) error {
+	ws, ok := TenantFrom(ctx)
+	if !ok {
+		opts := options.Find()
+		if sort != nil {
+			opts.SetSort(sort)
+		}
+		if limit > 0 {
+			opts.SetLimit(int64(limit))
+		}
+		if offset > 0 {
+			opts.SetSkip(int64(offset))
+		}
+		cursor, err := coll.Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, results)
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": "attachments",
+			"let":  bson.M{"attachment_id": "$attachment_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{
+					"$_id",
+					bson.M{"$convert": bson.M{"input": "$$attachment_id", "to": "objectId", "onError": nil, "onNull": nil}},
+				}}}},
+				bson.M{"$project": bson.M{"workspace_id": 1}},
+			},
+			"as": "_tenant",
+		}}},
+		{{Key: "$match", Value: bson.M{"_tenant.workspace_id": bson.M{"$in": ws}}}},
+		{{Key: "$project", Value: bson.M{"_tenant": 0}}},
+	}
+	if sort != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: sort}})
+	}
+	if offset > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$skip", Value: int64(offset)}})
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: int64(limit)}})
+	}
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
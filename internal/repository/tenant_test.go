@@ -0,0 +1,203 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestScopedWithoutTenant(t *testing.T) {
+	filter := bson.M{"user_id": "u1"}
+	got := Scoped(context.Background(), filter)
+	if !reflect.DeepEqual(got, bson.M{"user_id": "u1"}) {
+		t.Fatalf("unscoped filter changed: %v", got)
+	}
+}
+
+func TestScopedAddsTenant(t *testing.T) {
+	ctx := WithTenant(context.Background(), "ws-a", "ws-b")
+	got := Scoped(ctx, bson.M{"user_id": "u1"})
+	want := bson.M{
+		"user_id":      "u1",
+		"workspace_id": bson.M{"$in": []string{"ws-a", "ws-b"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Scoped = %v, want %v", got, want)
+	}
+}
+
+func TestScopedKeepsRequestedWorkspace(t *testing.T) {
+	ctx := WithTenant(context.Background(), "ws-a")
+	got := Scoped(ctx, bson.M{
+		"workspace_id": "ws-b",
+		"$and":         bson.A{bson.M{"size": bson.M{"$gt": 0}}},
+	})
+	// Both the requested workspace and the tenant must match, so a
+	// workspace outside the tenant finds nothing
+	want := bson.M{"$and": bson.A{
+		bson.M{"size": bson.M{"$gt": 0}},
+		bson.M{"workspace_id": "ws-b"},
+		bson.M{"workspace_id": bson.M{"$in": []string{"ws-a"}}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Scoped = %v, want %v", got, want)
+	}
+}
+
+func TestScopedEmptyTenantMatchesNothing(t *testing.T) {
+	got := Scoped(WithTenant(context.Background()), bson.M{})
+	want := bson.M{"workspace_id": bson.M{"$in": []string{}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Scoped = %v, want %v", got, want)
+	}
+}
+
+func TestWithTenantCopiesWorkspaces(t *testing.T) {
+	ws := []string{"ws-a"}
+	ctx := WithTenant(context.Background(), ws...)
+	ws[0] = "ws-b"
+	if got, _ := TenantFrom(ctx); got[0] != "ws-a" {
+		t.Fatalf("tenant changed with the caller's slice: %v", got)
+	}
+}
+
+func TestInTenant(t *testing.T) {
+	ctx := WithTenant(context.Background(), "ws-a")
+	if !InTenant(ctx, "ws-a") || InTenant(ctx, "ws-b") {
+		t.Fatal("InTenant does not follow the ctx tenant")
+	}
+	if !InTenant(context.Background(), "ws-b") {
+		t.Fatal("unscoped ctx should reach every workspace")
+	}
+}
+
+func TestScopedPipeline(t *testing.T) {
+	pipeline := mongo.Pipeline{{{Key: "$group", Value: bson.M{"_id": "$type"}}}}
+	if got := ScopedPipeline(context.Background(), pipeline); len(got) != 1 {
+		t.Fatalf("unscoped pipeline changed: %v", got)
+	}
+
+	got := ScopedPipeline(WithTenant(context.Background(), "ws-a"), pipeline)
+	want := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"workspace_id": bson.M{"$in": []string{"ws-a"}}}}},
+		pipeline[0],
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ScopedPipeline = %v, want %v", got, want)
+	}
+}
+
+func TestFindInTenant(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	sort := bson.D{{Key: "created_at", Value: -1}}
+
+	mt.Run("unscoped finds directly", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.tags", mtest.FirstBatch,
+			bson.D{{Key: "tag", Value: "x"}}))
+
+		var results []bson.M
+		err := FindInTenant(context.Background(), mt.Coll, bson.M{"tag": "x"}, sort, 10, 20, &results)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if len(results) != 1 {
+			mt.Fatalf("got %d results, want 1", len(results))
+		}
+		cmd := mt.GetStartedEvent()
+		if cmd.CommandName != "find" {
+			mt.Fatalf("sent %s, want find", cmd.CommandName)
+		}
+		if tag := cmd.Command.Lookup("filter", "tag").StringValue(); tag != "x" {
+			mt.Fatalf("filter tag = %q", tag)
+		}
+		if limit := cmd.Command.Lookup("limit").AsInt64(); limit != 10 {
+			mt.Fatalf("limit = %d, want 10", limit)
+		}
+		if skip := cmd.Command.Lookup("skip").AsInt64(); skip != 20 {
+			mt.Fatalf("skip = %d, want 20", skip)
+		}
+	})
+
+	mt.Run("scoped joins the attachment tenant", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.tags", mtest.FirstBatch))
+
+		ctx := WithTenant(context.Background(), "ws-a")
+		var results []bson.M
+		if err := FindInTenant(ctx, mt.Coll, bson.M{"tag": "x"}, sort, 10, 20, &results); err != nil {
+			mt.Fatal(err)
+		}
+		cmd := mt.GetStartedEvent()
+		if cmd.CommandName != "aggregate" {
+			mt.Fatalf("sent %s, want aggregate", cmd.CommandName)
+		}
+
+		stages := pipelineStages(mt.T, cmd.Command)
+		want := []string{"$match", "$lookup", "$match", "$project", "$sort", "$skip", "$limit"}
+		if !reflect.DeepEqual(stages, want) {
+			// Paging must come after the tenant match so pages stay full
+			mt.Fatalf("stages = %v, want %v", stages, want)
+		}
+		if from := cmd.Command.Lookup("pipeline", "1", "$lookup", "from").StringValue(); from != "attachments" {
+			mt.Fatalf("lookup from %q, want attachments", from)
+		}
+		in := cmd.Command.Lookup("pipeline", "2", "$match", "_tenant.workspace_id", "$in")
+		if got := stringValues(mt.T, in); !reflect.DeepEqual(got, []string{"ws-a"}) {
+			mt.Fatalf("tenant match = %v, want [ws-a]", got)
+		}
+	})
+}
+
+func TestGetByIDScoped(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("other workspace is not found", func(mt *mtest.T) {
+		repo := NewMongoRepositoryWithClient(mt.Client, "test")
+		mt.ClearEvents()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.attachments", mtest.FirstBatch))
+
+		ctx := WithTenant(context.Background(), "ws-b")
+		_, err := repo.GetByID(ctx, "65f000000000000000000001")
+		if err != mongo.ErrNoDocuments {
+			mt.Fatalf("GetByID err = %v, want ErrNoDocuments", err)
+		}
+		in := mt.GetStartedEvent().Command.Lookup("filter", "workspace_id", "$in")
+		if got := stringValues(mt.T, in); !reflect.DeepEqual(got, []string{"ws-b"}) {
+			mt.Fatalf("filter workspace_id $in = %v, want [ws-b]", got)
+		}
+	})
+}
+
+// pipelineStages returns the operator of each stage in an aggregate command.
+func pipelineStages(t *testing.T, cmd bson.Raw) []string {
+	t.Helper()
+	values, err := cmd.Lookup("pipeline").Array().Values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stages []string
+	for _, v := range values {
+		elems, err := v.Document().Elements()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stages = append(stages, elems[0].Key())
+	}
+	return stages
+}
+
+func stringValues(t *testing.T, v bson.RawValue) []string {
+	t.Helper()
+	values, err := v.Array().Values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, s := range values {
+		out = append(out, s.StringValue())
+	}
+	return out
+}
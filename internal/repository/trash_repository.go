@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"attachment-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// trashSet is the update that moves attachments to the trash. Their
+// content is kept until purgeAt.
+func trashSet(deletedBy string, purgeAt time.Time) bson.M {
+	now := time.Now()
+	return bson.M{
+		"status":     models.StatusDeleted,
+		"deleted_at": now,
+		"deleted_by": deletedBy,
+		"purge_at":   purgeAt,
+		"updated_at": now,
+	}
+}
+
+// TrashRepository finds trashed attachments whose purge is due and removes
+// what they leave behind: versions and previews.
+type TrashRepository struct {
+	attachments *mongo.Collection
+	versions    *mongo.Collection
+	previews    *mongo.Collection
+}
+
+func NewTrashRepository(client *mongo.Client, dbName string) *TrashRepository {
+	db := client.Database(dbName)
+	r := &TrashRepository{
+		attachments: db.Collection("attachments"),
+		versions:    db.Collection("attachment_versions"),
+		previews:    db.Collection("attachment_previews"),
+	}
+
+	ctx := context.Background()
+	r.attachments.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "purge_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}, {Key: "deleted_at", Value: -1}}},
+	})
+
+	return r
+}
+
+// duePurge matches trashed attachments whose purge is due by now and that
+// no purge has claimed since staleBefore.
+func duePurge(now, staleBefore time.Time) bson.M {
+	return bson.M{
+		"status":    models.StatusDeleted,
+		"purge_at":  bson.M{"$lte": now},
+		"purged_at": bson.M{"$exists": false},
+		"$or": bson.A{
+			bson.M{"purging_at": nil},
+			bson.M{"purging_at": bson.M{"$lte": staleBefore}},
+		},
+	}
+}
+
+// DuePurges returns up to limit trashed attachments whose purge is due and
+// not claimed since staleBefore.
+func (r *TrashRepository) DuePurges(ctx context.Context, now, staleBefore time.Time, limit int) ([]*models.Attachment, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "purge_at", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := r.attachments.Find(ctx, duePurge(now, staleBefore), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var attachments []*models.Attachment
+	if err := cursor.All(ctx, &attachments); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// Claim marks a trashed attachment as being purged, and reports whether its
+// purge was still due and unclaimed since staleBefore. A claimed attachment
+// can no longer be restored; a claim left by a purge that failed lapses at
+// staleBefore and the purge is retried.
+func (r *TrashRepository) Claim(ctx context.Context, id primitive.ObjectID, now, staleBefore time.Time) (bool, error) {
+	filter := duePurge(now, staleBefore)
+	filter["_id"] = id
+	result, err := r.attachments.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"purging_at": now, "updated_at": now},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// Postpone moves the purge of the given trashed attachments to until.
+func (r *TrashRepository) Postpone(ctx context.Context, ids []primitive.ObjectID, until time.Time) error {
+	_, err := r.attachments.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}, "status": models.StatusDeleted}, bson.M{
+		"$set": bson.M{"purge_at": until},
+	})
+	return err
+}
+
+// StoredPaths returns the storage paths of an attachment's versions and
+// previews.
+func (r *TrashRepository) StoredPaths(ctx context.Context, attachmentID string) ([]string, error) {
+	return storedPaths(ctx, attachmentID, r.versions, r.previews)
+}
+
+// MarkPurged removes an attachment's versions and previews and records
+// that its content is gone. The attachment itself is kept as a tombstone.
+func (r *TrashRepository) MarkPurged(ctx context.Context, id primitive.ObjectID) error {
+	if err := deleteDependents(ctx, id.Hex(), r.versions, r.previews); err != nil {
+		return err
+	}
+	_, err := r.attachments.UpdateOne(ctx, bson.M{"_id": id, "status": models.StatusDeleted}, bson.M{
+		"$unset": bson.M{"purge_at": "", "purging_at": ""},
+		"$set":   bson.M{"purged_at": time.Now(), "updated_at": time.Now()},
+	})
+	return err
+}
+
+// storedPaths returns the storage paths of the documents in colls that
+// belong to an attachment.
+func storedPaths(ctx context.Context, attachmentID string, colls ...*mongo.Collection) ([]string, error) {
+	var paths []string
+	for _, coll := range colls {
+		cursor, err := coll.Find(ctx, bson.M{"attachment_id": attachmentID},
+			options.Find().SetProjection(bson.M{"storage_path": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var docs []struct {
+			StoragePath string `bson:"storage_path"`
+		}
+		if err := cursor.All(ctx, &docs); err != nil {
+			return nil, err
+		}
+		for _, d := range docs {
+			if d.StoragePath != "" {
+				paths = append(paths, d.StoragePath)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// deleteDependents removes the documents in colls that belong to an
+// attachment.
+func deleteDependents(ctx context.Context, attachmentID string, colls ...*mongo.Collection) error {
+	for _, coll := range colls {
+		if _, err := coll.DeleteMany(ctx, bson.M{"attachment_id": attachmentID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
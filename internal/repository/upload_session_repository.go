@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"attachment-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UploadSessionRepository stores batch upload sessions
+type UploadSessionRepository struct {
+	sessions *mongo.Collection
+}
+
+func NewUploadSessionRepository(client *mongo.Client, dbName string) *UploadSessionRepository {
+	r := &UploadSessionRepository{
+		sessions: client.Database(dbName).Collection("upload_sessions"),
+	}
+
+	r.sessions.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+	})
+
+	return r
+}
+
+func (r *UploadSessionRepository) Create(ctx context.Context, s *models.UploadSession) error {
+	if !InTenant(ctx, s.WorkspaceID) {
+		return ErrOutsideTenant
+	}
+	now := time.Now()
+	s.CreatedAt = now
+	s.UpdatedAt = now
+	result, err := r.sessions.InsertOne(ctx, s)
+	if err != nil {
+		return err
+	}
+	s.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *UploadSessionRepository) Get(ctx context.Context, id string) (*models.UploadSession, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var s models.UploadSession
+	if err := r.sessions.FindOne(ctx, Scoped(ctx, bson.M{"_id": objID})).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Transition moves a session from one status to another, applying set as
+// well, and reports false if the session was no longer in status from.
+func (r *UploadSessionRepository) Transition(ctx context.Context, id primitive.ObjectID, from, to models.UploadSessionStatus, set bson.M) (bool, error) {
+	if set == nil {
+		set = bson.M{}
+	}
+	set["status"] = to
+	set["updated_at"] = time.Now()
+	result, err := r.sessions.UpdateOne(ctx, bson.M{"_id": id, "status": from}, bson.M{"$set": set})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// UpdateFile updates one file's progress while the session is still open.
+func (r *UploadSessionRepository) UpdateFile(ctx context.Context, id primitive.ObjectID, attachmentID string, uploadedBytes int64, status models.SessionFileStatus) (bool, error) {
+	result, err := r.sessions.UpdateOne(ctx, bson.M{
+		"_id":                 id,
+		"status":              models.SessionOpen,
+		"files.attachment_id": attachmentID,
+	}, bson.M{"$set": bson.M{
+		"files.$.uploaded_bytes": uploadedBytes,
+		"files.$.status":         status,
+		"updated_at":             time.Now(),
+	}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// SetFiles replaces the file list, used to record checks made on completion.
+func (r *UploadSessionRepository) SetFiles(ctx context.Context, id primitive.ObjectID, files []models.UploadSessionFile) error {
+	_, err := r.sessions.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"files":      files,
+		"updated_at": time.Now(),
+	}})
+	return err
+}
+
+// ListExpired returns open sessions past their expiry.
+func (r *UploadSessionRepository) ListExpired(ctx context.Context, now time.Time, limit int) ([]*models.UploadSession, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "expires_at", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := r.sessions.Find(ctx, bson.M{
+		"status":     models.SessionOpen,
+		"expires_at": bson.M{"$lt": now},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var sessions []*models.UploadSession
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
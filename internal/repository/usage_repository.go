@@ -0,0 +1,1069 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"attachment-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrQuotaExceeded is returned when a reservation would exceed the given limits
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrReservationExpired is returned when a pending attachment is written
+	// for a reservation that was already released
+	ErrReservationExpired = errors.New("quota reservation expired")
+)
+
+// CounterFullError reports the counter that had no room for a reservation.
+// It matches ErrQuotaExceeded.
+type CounterFullError struct {
+	Counter models.UsageCounterRef
+}
+
+func (e *CounterFullError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Counter.Scope, e.Counter.ScopeID, ErrQuotaExceeded)
+}
+
+func (e *CounterFullError) Unwrap() error { return ErrQuotaExceeded }
+
+// UsageRepository maintains per-user, per-workspace and per-channel usage
+// counters. Attachment writes that can change usage go through it so the
+// attachment and its counters are updated in one transaction, together with
+// any outbox events attached to the context.
+type UsageRepository struct {
+	client       *mongo.Client
+	counters     *mongo.Collection
+	reservations *mongo.Collection
+	attachments  *mongo.Collection
+	outbox       *mongo.Collection
+	noTxn        atomic.Bool
+}
+
+func NewUsageRepository(client *mongo.Client, dbName string) *UsageRepository {
+	db := client.Database(dbName)
+	r := &UsageRepository{
+		client:       client,
+		counters:     db.Collection("usage_counters"),
+		reservations: db.Collection("quota_reservations"),
+		attachments:  db.Collection("attachments"),
+		outbox:       db.Collection("outbox"),
+	}
+
+	r.counters.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "scope", Value: 1}, {Key: "scope_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "scope", Value: 1}, {Key: "workspace_id", Value: 1}}},
+	})
+	// Not a TTL index: an expired reservation must be released from its
+	// counters, not just forgotten
+	r.reservations.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}},
+		{Keys: bson.D{{Key: "counters.scope", Value: 1}, {Key: "counters.scope_id", Value: 1}}},
+	})
+
+	return r
+}
+
+func counterFilter(scope models.UsageScope, scopeID string) bson.M {
+	return bson.M{"scope": scope, "scope_id": scopeID}
+}
+
+// GetCounter returns the counter for a scope, or an empty one if nothing
+// has been counted yet.
+func (r *UsageRepository) GetCounter(ctx context.Context, scope models.UsageScope, scopeID string) (*models.UsageCounter, error) {
+	var c models.UsageCounter
+	err := r.counters.FindOne(ctx, counterFilter(scope, scopeID)).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &models.UsageCounter{Scope: scope, ScopeID: scopeID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// CountWorkspaceUploaders returns how many users have live files in a workspace.
+func (r *UsageRepository) CountWorkspaceUploaders(ctx context.Context, workspaceID string) (int64, error) {
+	return r.counters.CountDocuments(ctx, bson.M{
+		"scope":        models.UsageScopeWorkspaceUser,
+		"workspace_id": workspaceID,
+		"used_files":   bson.M{"$gt": 0},
+	})
+}
+
+// ── Quota Ledger ──
+
+func (r *UsageRepository) ensureCounter(ctx context.Context, scope models.UsageScope, scopeID string) error {
+	now := time.Now()
+	_, err := r.counters.UpdateOne(ctx, counterFilter(scope, scopeID), bson.M{"$setOnInsert": bson.M{
+		"used_bytes":         0,
+		"used_files":         0,
+		"reserved_bytes":     0,
+		"reserved_files":     0,
+		"notified_threshold": 0,
+		"created_at":         now,
+		"updated_at":         now,
+	}}, options.Update().SetUpsert(true))
+	return err
+}
+
+// ReservationLimit is a counter to reserve space on and its limits; 0 means
+// unlimited.
+type ReservationLimit struct {
+	Counter  models.UsageCounterRef
+	MaxBytes int64
+	MaxFiles int64
+}
+
+// Reserve adds res.Bytes and res.Files to the reserved figures of each
+// counter in limits, provided used + reserved stays within its limits, and
+// records res in the ledger. Either every counter is reserved or none is;
+// a counter without room is reported as a *CounterFullError.
+func (r *UsageRepository) Reserve(ctx context.Context, res *models.QuotaReservation, limits []ReservationLimit) error {
+	res.ID = primitive.NewObjectID()
+	res.Counters = nil
+	for _, l := range limits {
+		if err := r.ensureCounter(ctx, l.Counter.Scope, l.Counter.ScopeID); err != nil {
+			return err
+		}
+		res.Counters = append(res.Counters, l.Counter)
+	}
+	res.CreatedAt = time.Now()
+
+	return r.withTransaction(ctx, func(ctx context.Context) error {
+		for i, l := range limits {
+			err := r.reserveCounter(ctx, l, res.Bytes, res.Files)
+			if err != nil {
+				// Without a transaction, take back what was already reserved
+				if mongo.SessionFromContext(ctx) == nil {
+					r.releaseCounters(ctx, res.Counters[:i], res.Bytes, res.Files)
+				}
+				return err
+			}
+		}
+		if _, err := r.reservations.InsertOne(ctx, res); err != nil {
+			if mongo.SessionFromContext(ctx) == nil {
+				r.releaseCounters(ctx, res.Counters, res.Bytes, res.Files)
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (r *UsageRepository) reserveCounter(ctx context.Context, l ReservationLimit, bytes, files int64) error {
+	conds := bson.A{}
+	if l.MaxBytes > 0 {
+		conds = append(conds, bson.M{"$lte": bson.A{
+			bson.M{"$add": bson.A{"$used_bytes", "$reserved_bytes", bytes}}, l.MaxBytes,
+		}})
+	}
+	if l.MaxFiles > 0 {
+		conds = append(conds, bson.M{"$lte": bson.A{
+			bson.M{"$add": bson.A{"$used_files", "$reserved_files", files}}, l.MaxFiles,
+		}})
+	}
+	filter := counterFilter(l.Counter.Scope, l.Counter.ScopeID)
+	if len(conds) > 0 {
+		filter["$expr"] = bson.M{"$and": conds}
+	}
+
+	result, err := r.counters.UpdateOne(ctx, filter, bson.M{
+		"$inc": bson.M{"reserved_bytes": bytes, "reserved_files": files},
+		"$set": bson.M{"updated_at": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &CounterFullError{Counter: l.Counter}
+	}
+	return nil
+}
+
+// releaseCounters takes back reservations made by reserveCounter when
+// there is no transaction to abort. It is best effort.
+func (r *UsageRepository) releaseCounters(ctx context.Context, counters []models.UsageCounterRef, bytes, files int64) {
+	for _, c := range counters {
+		if err := r.decReserved(ctx, c, bytes, files); err != nil {
+			log.Printf("Failed to release %s quota reservation for %s: %v", c.Scope, c.ScopeID, err)
+		}
+	}
+}
+
+func (r *UsageRepository) decReserved(ctx context.Context, c models.UsageCounterRef, bytes, files int64) error {
+	_, err := r.counters.UpdateOne(ctx, counterFilter(c.Scope, c.ScopeID), bson.M{
+		"$inc": bson.M{"reserved_bytes": -bytes, "reserved_files": -files},
+		"$set": bson.M{"updated_at": time.Now()},
+	})
+	return err
+}
+
+// Release drops a reservation that no attachment claimed. Releasing it
+// again, or after it was claimed, does nothing.
+func (r *UsageRepository) Release(ctx context.Context, id primitive.ObjectID) error {
+	return r.withTransaction(ctx, func(ctx context.Context) error {
+		var res models.QuotaReservation
+		err := r.reservations.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&res)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, c := range res.Counters {
+			if err := r.decReserved(ctx, c, res.Bytes, res.Files); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReleaseExpired releases up to limit reservations that expired before now
+// without being claimed, left by uploads that crashed or failed to clean
+// up, and returns how many it released.
+func (r *UsageRepository) ReleaseExpired(ctx context.Context, now time.Time, limit int) (int, error) {
+	cursor, err := r.reservations.Find(ctx, bson.M{"expires_at": bson.M{"$lte": now}},
+		options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(int64(limit)))
+	if err != nil {
+		return 0, err
+	}
+	var expired []models.QuotaReservation
+	if err := cursor.All(ctx, &expired); err != nil {
+		return 0, err
+	}
+	for i, res := range expired {
+		if err := r.Release(ctx, res.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
+
+type reservationKey struct{}
+
+// WithReservation attaches a reservation to ctx. The next attachment write
+// made with the returned context claims it in the same transaction: a
+// pending attachment takes the reservation over, any other drops it from
+// the counters now that the attachment is counted itself.
+func WithReservation(ctx context.Context, id primitive.ObjectID) context.Context {
+	return context.WithValue(ctx, reservationKey{}, id)
+}
+
+// claimReservation claims the reservation attached to ctx, if any, for an
+// attachment written with status. It runs inside the attachment write's
+// transaction.
+func (r *UsageRepository) claimReservation(ctx context.Context, status models.AttachmentStatus) error {
+	id, ok := ctx.Value(reservationKey{}).(primitive.ObjectID)
+	if !ok {
+		return nil
+	}
+	pending := status == models.StatusPending
+	var res models.QuotaReservation
+	err := r.reservations.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&res)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if pending {
+			// The attachment would count a reservation the counters no
+			// longer hold
+			return ErrReservationExpired
+		}
+		return nil
+	}
+	if err != nil || pending {
+		return err
+	}
+	for _, c := range res.Counters {
+		if err := r.decReserved(ctx, c, res.Bytes, res.Files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetLimits stores admin overrides. A nil limit removes the override.
+func (r *UsageRepository) SetLimits(ctx context.Context, scope models.UsageScope, scopeID string, maxBytes, maxFiles *int64, updatedBy string) (*models.UsageCounter, error) {
+	if err := r.ensureCounter(ctx, scope, scopeID); err != nil {
+		return nil, err
+	}
+	set := bson.M{"updated_by": updatedBy, "updated_at": time.Now()}
+	unset := bson.M{}
+	if maxBytes != nil {
+		set["max_bytes"] = *maxBytes
+	} else {
+		unset["max_bytes"] = ""
+	}
+	if maxFiles != nil {
+		set["max_files"] = *maxFiles
+	} else {
+		unset["max_files"] = ""
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var c models.UsageCounter
+	err := r.counters.FindOneAndUpdate(ctx, counterFilter(scope, scopeID), update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// SetNotifiedThreshold moves the announced threshold from "from" to "to".
+// It reports false when another writer already moved it.
+func (r *UsageRepository) SetNotifiedThreshold(ctx context.Context, scope models.UsageScope, scopeID string, from, to int) (bool, error) {
+	filter := counterFilter(scope, scopeID)
+	filter["notified_threshold"] = from
+	result, err := r.counters.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"notified_threshold": to}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// ── Attachment Writes ──
+
+// withTransaction runs fn in a transaction. Standalone servers don't support
+// transactions; after the first such failure fn runs without one.
+func (r *UsageRepository) withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if r.noTxn.Load() {
+		return fn(ctx)
+	}
+	session, err := r.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 20 { // IllegalOperation: not a replica set
+		if r.noTxn.CompareAndSwap(false, true) {
+			log.Printf("MongoDB does not support transactions, usage counters are updated without them")
+		}
+		return fn(ctx)
+	}
+	return err
+}
+
+// insertAttachment inserts an attachment and counts it.
+func (r *UsageRepository) insertAttachment(ctx context.Context, attachment *models.Attachment) error {
+	if !InTenant(ctx, attachment.WorkspaceID) {
+		return ErrOutsideTenant
+	}
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		result, err := r.attachments.InsertOne(ctx, attachment)
+		if err != nil {
+			return err
+		}
+		attachment.ID = result.InsertedID.(primitive.ObjectID)
+		d := usageDeltas{}
+		d.transition(nil, attachment)
+		if err := r.apply(ctx, d); err != nil {
+			return err
+		}
+		if err := r.claimReservation(ctx, attachment.Status); err != nil {
+			return err
+		}
+		return writeOutbox(ctx, r.outbox)
+	})
+	if err == nil {
+		outboxWritten(ctx)
+	}
+	return err
+}
+
+// updateAttachment applies set to the first attachment matching filter and
+// adjusts the counters for any status or ownership change. It reports
+// whether an attachment matched.
+func (r *UsageRepository) updateAttachment(ctx context.Context, filter, set bson.M) (bool, error) {
+	matched := false
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		before, err := r.updateOne(ctx, filter, set)
+		matched = before != nil
+		if err != nil || !matched {
+			return err
+		}
+		status := before.Status
+		if to, ok := set["status"].(models.AttachmentStatus); ok {
+			status = to
+		}
+		if err := r.claimReservation(ctx, status); err != nil {
+			return err
+		}
+		return writeOutbox(ctx, r.outbox)
+	})
+	if err == nil && matched {
+		outboxWritten(ctx)
+	}
+	return matched, err
+}
+
+// deleteAttachment removes the first attachment matching filter and stops
+// counting it. It reports whether an attachment matched.
+func (r *UsageRepository) deleteAttachment(ctx context.Context, filter bson.M) (bool, error) {
+	matched := false
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		var before models.Attachment
+		err := r.attachments.FindOneAndDelete(ctx, Scoped(ctx, filter)).Decode(&before)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		matched = true
+		d := usageDeltas{}
+		d.transition(&before, nil)
+		if err := r.apply(ctx, d); err != nil {
+			return err
+		}
+		return writeOutbox(ctx, r.outbox)
+	})
+	if err == nil && matched {
+		outboxWritten(ctx)
+	}
+	return matched, err
+}
+
+// updateAllIfStatus applies each update to its attachment, but only if every
+// attachment is still in the given status; otherwise nothing is changed and
+// it reports false.
+func (r *UsageRepository) updateAllIfStatus(ctx context.Context, updates map[primitive.ObjectID]bson.M, status models.AttachmentStatus) (bool, error) {
+	errConflict := errors.New("attachment status changed")
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		var applied []*models.Attachment
+		var appliedSets []bson.M
+		for id, set := range updates {
+			before, err := r.updateOne(ctx, bson.M{"_id": id, "status": status}, set)
+			if err == nil && before == nil {
+				err = errConflict
+			}
+			if err != nil {
+				// Inside a transaction the abort undoes everything; without
+				// one, put back what was already changed
+				if mongo.SessionFromContext(ctx) == nil {
+					for i, b := range applied {
+						r.revert(ctx, b, appliedSets[i])
+					}
+				}
+				return err
+			}
+			applied = append(applied, before)
+			appliedSets = append(appliedSets, set)
+		}
+		return writeOutbox(ctx, r.outbox)
+	})
+	if errors.Is(err, errConflict) {
+		return false, nil
+	}
+	if err == nil {
+		outboxWritten(ctx)
+	}
+	return err == nil, err
+}
+
+// updateOne is updateAttachment without the transaction. It returns the
+// attachment as it was before the update, or nil if none matched.
+func (r *UsageRepository) updateOne(ctx context.Context, filter, set bson.M) (*models.Attachment, error) {
+	var before models.Attachment
+	err := r.attachments.FindOneAndUpdate(ctx, Scoped(ctx, filter), bson.M{"$set": set}).Decode(&before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	after, err := applySet(&before, set)
+	if err != nil {
+		return nil, err
+	}
+	d := usageDeltas{}
+	d.transition(&before, after)
+	return &before, r.apply(ctx, d)
+}
+
+// revert restores the fields set changed on an attachment to their values
+// in before.
+func (r *UsageRepository) revert(ctx context.Context, before *models.Attachment, set bson.M) {
+	raw, err := bson.Marshal(before)
+	if err == nil {
+		var doc bson.M
+		if err = bson.Unmarshal(raw, &doc); err == nil {
+			restore, unset := bson.M{}, bson.M{}
+			for k := range set {
+				if v, ok := doc[k]; ok {
+					restore[k] = v
+				} else {
+					unset[k] = ""
+				}
+			}
+			update := bson.M{"$set": restore}
+			if len(unset) > 0 {
+				update["$unset"] = unset
+			}
+			var after models.Attachment
+			err = r.attachments.FindOneAndUpdate(ctx, bson.M{"_id": before.ID}, update,
+				options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&after)
+			if err == nil {
+				changed, _ := applySet(before, set)
+				d := usageDeltas{}
+				d.transition(changed, &after)
+				err = r.apply(ctx, d)
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to revert attachment %s: %v", before.ID.Hex(), err)
+	}
+}
+
+// updateAttachments is updateAttachment for every attachment matching filter.
+func (r *UsageRepository) updateAttachments(ctx context.Context, filter, set bson.M) (int64, error) {
+	var modified int64
+	filter = Scoped(ctx, filter)
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		cursor, err := r.attachments.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		var before []*models.Attachment
+		if err := cursor.All(ctx, &before); err != nil {
+			return err
+		}
+		result, err := r.attachments.UpdateMany(ctx, filter, bson.M{"$set": set})
+		if err != nil {
+			return err
+		}
+		modified = result.ModifiedCount
+
+		d := usageDeltas{}
+		for _, b := range before {
+			after, err := applySet(b, set)
+			if err != nil {
+				return err
+			}
+			d.transition(b, after)
+		}
+		if err := r.apply(ctx, d); err != nil || modified == 0 {
+			return err
+		}
+		return writeOutbox(ctx, r.outbox)
+	})
+	if err == nil && modified > 0 {
+		outboxWritten(ctx)
+	}
+	return modified, err
+}
+
+// trashAttachments is updateAttachments for moving attachments to the
+// trash: each also keeps the status it had in deleted_from, so a restore
+// can put it back.
+func (r *UsageRepository) trashAttachments(ctx context.Context, filter, set bson.M) (int64, error) {
+	var modified int64
+	filter = Scoped(ctx, filter)
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		modified = 0
+		cursor, err := r.attachments.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		var before []*models.Attachment
+		if err := cursor.All(ctx, &before); err != nil {
+			return err
+		}
+
+		d := usageDeltas{}
+		sets := map[models.AttachmentStatus]bson.M{}
+		for _, b := range before {
+			statusSet, ok := sets[b.Status]
+			if !ok {
+				statusSet = maps.Clone(set)
+				statusSet["deleted_from"] = b.Status
+				sets[b.Status] = statusSet
+				result, err := r.attachments.UpdateMany(ctx,
+					bson.M{"$and": bson.A{filter, bson.M{"status": b.Status}}},
+					bson.M{"$set": statusSet})
+				if err != nil {
+					return err
+				}
+				modified += result.ModifiedCount
+			}
+			after, err := applySet(b, statusSet)
+			if err != nil {
+				return err
+			}
+			d.transition(b, after)
+		}
+		if err := r.apply(ctx, d); err != nil || modified == 0 {
+			return err
+		}
+		return writeOutbox(ctx, r.outbox)
+	})
+	if err == nil && modified > 0 {
+		outboxWritten(ctx)
+	}
+	return modified, err
+}
+
+// applySet returns a copy of a with the $set fields applied.
+func applySet(a *models.Attachment, set bson.M) (*models.Attachment, error) {
+	raw, err := bson.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	for k, v := range set {
+		doc[k] = v
+	}
+	if raw, err = bson.Marshal(doc); err != nil {
+		return nil, err
+	}
+	var after models.Attachment
+	if err := bson.Unmarshal(raw, &after); err != nil {
+		return nil, err
+	}
+	return &after, nil
+}
+
+// ── Counter Deltas ──
+
+type usageKey struct {
+	scope models.UsageScope
+	id    string
+}
+
+type usageDelta struct {
+	workspaceID   string
+	usedBytes     int64
+	usedFiles     int64
+	reservedBytes int64
+	reservedFiles int64
+	byType        map[string]int64
+}
+
+type usageDeltas map[usageKey]*usageDelta
+
+func usedStatus(status models.AttachmentStatus) bool {
+	switch status {
+	case models.StatusUploading, models.StatusProcessing, models.StatusReady:
+		return true
+	}
+	return false
+}
+
+func (d usageDeltas) get(scope models.UsageScope, id, workspaceID string) *usageDelta {
+	k := usageKey{scope, id}
+	if d[k] == nil {
+		d[k] = &usageDelta{workspaceID: workspaceID, byType: map[string]int64{}}
+	}
+	return d[k]
+}
+
+func (d usageDeltas) addUsed(a *models.Attachment, sign int64) {
+	scopes := []*usageDelta{
+		d.get(models.UsageScopeUser, a.UserID, ""),
+		d.get(models.UsageScopeWorkspace, a.WorkspaceID, a.WorkspaceID),
+		d.get(models.UsageScopeWorkspaceUser, a.WorkspaceID+":"+a.UserID, a.WorkspaceID),
+	}
+	if a.ChannelID != "" {
+		scopes = append(scopes, d.get(models.UsageScopeChannel, a.ChannelID, a.WorkspaceID))
+	}
+	for _, s := range scopes {
+		s.usedBytes += sign * a.Size
+		s.usedFiles += sign
+		s.byType[string(a.Type)] += sign
+	}
+}
+
+func (d usageDeltas) addReserved(a *models.Attachment, sign int64) {
+	for _, s := range []*usageDelta{
+		d.get(models.UsageScopeUser, a.UserID, ""),
+		d.get(models.UsageScopeWorkspace, a.WorkspaceID, a.WorkspaceID),
+	} {
+		s.reservedBytes += sign * a.Size
+		s.reservedFiles += sign
+	}
+}
+
+// transition records the counter changes for an attachment going from
+// before to after; nil means the attachment does not exist on that side.
+// Pending attachments hold a reservation taken by the quota service before
+// they were created, so leaving pending releases it but entering doesn't
+// take one.
+func (d usageDeltas) transition(before, after *models.Attachment) {
+	if before != nil && usedStatus(before.Status) {
+		d.addUsed(before, -1)
+	}
+	if before != nil && before.Status == models.StatusPending && (after == nil || after.Status != models.StatusPending) {
+		d.addReserved(before, -1)
+	}
+	if after != nil && usedStatus(after.Status) {
+		d.addUsed(after, 1)
+	}
+}
+
+func (r *UsageRepository) apply(ctx context.Context, d usageDeltas) error {
+	now := time.Now()
+	var writes []mongo.WriteModel
+	for k, v := range d {
+		inc := bson.M{}
+		for field, n := range map[string]int64{
+			"used_bytes": v.usedBytes, "used_files": v.usedFiles,
+			"reserved_bytes": v.reservedBytes, "reserved_files": v.reservedFiles,
+		} {
+			if n != 0 {
+				inc[field] = n
+			}
+		}
+		for t, n := range v.byType {
+			if n != 0 {
+				inc["by_type."+t] = n
+			}
+		}
+		if len(inc) == 0 {
+			continue
+		}
+		onInsert := bson.M{"notified_threshold": 0, "created_at": now}
+		if v.workspaceID != "" {
+			onInsert["workspace_id"] = v.workspaceID
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(counterFilter(k.scope, k.id)).
+			SetUpdate(bson.M{"$inc": inc, "$set": bson.M{"updated_at": now}, "$setOnInsert": onInsert}).
+			SetUpsert(true))
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+	_, err := r.counters.BulkWrite(ctx, writes)
+	return err
+}
+
+// ── Reconciliation ──
+
+type usageTotals struct {
+	workspaceID   string
+	usedBytes     int64
+	usedFiles     int64
+	reservedBytes int64
+	reservedFiles int64
+	byType        map[string]int64
+}
+
+// Reconcile recomputes every counter from the attachments collection and
+// the reservation ledger and reports the fields that disagree. With fix
+// set, each drifted counter is recomputed again inside a transaction and
+// corrected by the difference, so writes that land while the scan runs
+// are neither lost nor overwritten.
+func (r *UsageRepository) Reconcile(ctx context.Context, fix bool) (*models.ReconcileReport, error) {
+	report := &models.ReconcileReport{StartedAt: time.Now(), Drifts: []models.UsageDrift{}}
+
+	expected, err := r.computeTotals(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.addReservations(ctx, expected, bson.M{}); err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.counters.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	seen := map[usageKey]bool{}
+	var drifted []usageKey
+	for cursor.Next(ctx) {
+		var c models.UsageCounter
+		if err := cursor.Decode(&c); err != nil {
+			return nil, err
+		}
+		k := usageKey{c.Scope, c.ScopeID}
+		seen[k] = true
+		report.Counters++
+		want := expected[k]
+		if want == nil {
+			want = &usageTotals{}
+		}
+		if r.compare(report, k, want, &c) {
+			drifted = append(drifted, k)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	for k, want := range expected {
+		if !seen[k] && r.compare(report, k, want, &models.UsageCounter{}) {
+			drifted = append(drifted, k)
+		}
+	}
+
+	if fix {
+		for _, k := range drifted {
+			if err := r.settle(ctx, k); err != nil {
+				return nil, err
+			}
+		}
+		report.Fixed = true
+	}
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+func (r *UsageRepository) compare(report *models.ReconcileReport, k usageKey, want *usageTotals, got *models.UsageCounter) bool {
+	drift := false
+	check := func(field string, expected, actual int64) {
+		if expected == actual {
+			return
+		}
+		drift = true
+		report.DriftCount++
+		if len(report.Drifts) < models.MaxReportedDrifts {
+			report.Drifts = append(report.Drifts, models.UsageDrift{
+				Scope: k.scope, ScopeID: k.id, Field: field, Expected: expected, Actual: actual,
+			})
+		}
+	}
+	check("used_bytes", want.usedBytes, got.UsedBytes)
+	check("used_files", want.usedFiles, got.UsedFiles)
+	check("reserved_bytes", want.reservedBytes, got.ReservedBytes)
+	check("reserved_files", want.reservedFiles, got.ReservedFiles)
+	for t, n := range want.byType {
+		check("by_type."+t, n, got.ByType[t])
+	}
+	for t, n := range got.ByType {
+		if _, ok := want.byType[t]; !ok {
+			check("by_type."+t, 0, n)
+		}
+	}
+	return drift
+}
+
+// settle corrects a counter by the difference between the attachments and
+// reservations it covers and what it holds, all read inside one
+// transaction.
+func (r *UsageRepository) settle(ctx context.Context, k usageKey) error {
+	return r.withTransaction(ctx, func(ctx context.Context) error {
+		totals, err := r.computeTotals(ctx, coveredBy(k))
+		if err != nil {
+			return err
+		}
+		held := bson.M{"counters": bson.M{"$elemMatch": bson.M{"scope": k.scope, "scope_id": k.id}}}
+		if err := r.addReservations(ctx, totals, held); err != nil {
+			return err
+		}
+		want := totals[k]
+		if want == nil {
+			want = &usageTotals{}
+		}
+		got, err := r.GetCounter(ctx, k.scope, k.id)
+		if err != nil {
+			return err
+		}
+
+		d := usageDeltas{}
+		v := d.get(k.scope, k.id, want.workspaceID)
+		v.usedBytes = want.usedBytes - got.UsedBytes
+		v.usedFiles = want.usedFiles - got.UsedFiles
+		v.reservedBytes = want.reservedBytes - got.ReservedBytes
+		v.reservedFiles = want.reservedFiles - got.ReservedFiles
+		for t, n := range want.byType {
+			v.byType[t] = n - got.ByType[t]
+		}
+		for t, n := range got.ByType {
+			if _, ok := want.byType[t]; !ok {
+				v.byType[t] = -n
+			}
+		}
+		return r.apply(ctx, d)
+	})
+}
+
+// coveredBy returns the filter for the attachments a counter covers.
+func coveredBy(k usageKey) bson.M {
+	switch k.scope {
+	case models.UsageScopeUser:
+		return bson.M{"user_id": k.id}
+	case models.UsageScopeWorkspace:
+		return bson.M{"workspace_id": k.id}
+	case models.UsageScopeChannel:
+		return bson.M{"channel_id": k.id}
+	}
+	workspaceID, userID, _ := strings.Cut(k.id, ":")
+	return bson.M{"workspace_id": workspaceID, "user_id": userID}
+}
+
+// computeTotals aggregates the attachments matching filter into the
+// figures each counter should hold.
+func (r *UsageRepository) computeTotals(ctx context.Context, filter bson.M) (map[usageKey]*usageTotals, error) {
+	totals := map[usageKey]*usageTotals{}
+	get := func(k usageKey, workspaceID string) *usageTotals {
+		if totals[k] == nil {
+			totals[k] = &usageTotals{workspaceID: workspaceID, byType: map[string]int64{}}
+		}
+		return totals[k]
+	}
+
+	used := bson.M{"$in": bson.A{models.StatusUploading, models.StatusProcessing, models.StatusReady}}
+	scopes := []struct {
+		scope     models.UsageScope
+		key       any
+		reserved  bool
+		workspace bool
+		match     bson.M
+	}{
+		{models.UsageScopeUser, "$user_id", true, false, nil},
+		{models.UsageScopeWorkspace, "$workspace_id", true, true, nil},
+		{models.UsageScopeChannel, "$channel_id", false, true, bson.M{"channel_id": bson.M{"$ne": ""}}},
+		{models.UsageScopeWorkspaceUser, bson.M{"$concat": bson.A{"$workspace_id", ":", "$user_id"}}, false, true, nil},
+	}
+
+	for _, sc := range scopes {
+		match := bson.M{"status": used}
+		for k, v := range sc.match {
+			match[k] = v
+		}
+		match = bson.M{"$and": bson.A{filter, match}}
+		cursor, err := r.attachments.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: match}},
+			{{Key: "$group", Value: bson.M{
+				"_id":          bson.M{"key": sc.key, "type": "$type"},
+				"workspace_id": bson.M{"$first": "$workspace_id"},
+				"bytes":        bson.M{"$sum": "$size"},
+				"files":        bson.M{"$sum": 1},
+			}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for cursor.Next(ctx) {
+			var row struct {
+				ID struct {
+					Key  string `bson:"key"`
+					Type string `bson:"type"`
+				} `bson:"_id"`
+				WorkspaceID string `bson:"workspace_id"`
+				Bytes       int64  `bson:"bytes"`
+				Files       int64  `bson:"files"`
+			}
+			if err := cursor.Decode(&row); err != nil {
+				cursor.Close(ctx)
+				return nil, err
+			}
+			workspaceID := ""
+			if sc.workspace {
+				workspaceID = row.WorkspaceID
+			}
+			t := get(usageKey{sc.scope, row.ID.Key}, workspaceID)
+			t.usedBytes += row.Bytes
+			t.usedFiles += row.Files
+			t.byType[row.ID.Type] += row.Files
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if !sc.reserved {
+			continue
+		}
+		cursor, err = r.attachments.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"$and": bson.A{filter, bson.M{"status": models.StatusPending}}}}},
+			{{Key: "$group", Value: bson.M{
+				"_id":          sc.key,
+				"workspace_id": bson.M{"$first": "$workspace_id"},
+				"bytes":        bson.M{"$sum": "$size"},
+				"files":        bson.M{"$sum": 1},
+			}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for cursor.Next(ctx) {
+			var row struct {
+				Key         string `bson:"_id"`
+				WorkspaceID string `bson:"workspace_id"`
+				Bytes       int64  `bson:"bytes"`
+				Files       int64  `bson:"files"`
+			}
+			if err := cursor.Decode(&row); err != nil {
+				cursor.Close(ctx)
+				return nil, err
+			}
+			workspaceID := ""
+			if sc.workspace {
+				workspaceID = row.WorkspaceID
+			}
+			t := get(usageKey{sc.scope, row.Key}, workspaceID)
+			t.reservedBytes += row.Bytes
+			t.reservedFiles += row.Files
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return totals, nil
+}
+
+// addReservations adds the ledger reservations matching filter to the
+// reserved figures in totals.
+func (r *UsageRepository) addReservations(ctx context.Context, totals map[usageKey]*usageTotals, filter bson.M) error {
+	cursor, err := r.reservations.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$unwind", Value: "$counters"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"scope": "$counters.scope", "scope_id": "$counters.scope_id"},
+			"bytes": bson.M{"$sum": "$bytes"},
+			"files": bson.M{"$sum": "$files"},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var row struct {
+			Counter models.UsageCounterRef `bson:"_id"`
+			Bytes   int64                  `bson:"bytes"`
+			Files   int64                  `bson:"files"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return err
+		}
+		k := usageKey{row.Counter.Scope, row.Counter.ScopeID}
+		if totals[k] == nil {
+			workspaceID := ""
+			if k.scope == models.UsageScopeWorkspace {
+				workspaceID = k.id
+			}
+			totals[k] = &usageTotals{workspaceID: workspaceID, byType: map[string]int64{}}
+		}
+		totals[k].reservedBytes += row.Bytes
+		totals[k].reservedFiles += row.Files
+	}
+	return cursor.Err()
+}
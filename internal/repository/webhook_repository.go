@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"attachment-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WebhookRepository stores workspace webhooks, their deliveries and a log
+// of delivery attempts. Finished deliveries and logged attempts are
+// removed by TTL indexes.
+type WebhookRepository struct {
+	webhooks   *mongo.Collection
+	deliveries *mongo.Collection
+	attempts   *mongo.Collection
+}
+
+func NewWebhookRepository(client *mongo.Client, dbName string) *WebhookRepository {
+	db := client.Database(dbName)
+	r := &WebhookRepository{
+		webhooks:   db.Collection("attachment_webhooks"),
+		deliveries: db.Collection("webhook_deliveries"),
+		attempts:   db.Collection("webhook_delivery_attempts"),
+	}
+
+	ctx := context.Background()
+	r.webhooks.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "is_active", Value: 1}},
+	})
+	r.deliveries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	r.attempts.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "delivery_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+
+	return r
+}
+
+// Get returns a webhook in the workspace.
+func (r *WebhookRepository) Get(ctx context.Context, workspaceID, id string) (*models.AttachmentWebhook, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	var w models.AttachmentWebhook
+	err = r.webhooks.FindOne(ctx, Scoped(ctx, bson.M{"_id": oid, "workspace_id": workspaceID})).Decode(&w)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// ListActive returns the workspace's enabled webhooks.
+func (r *WebhookRepository) ListActive(ctx context.Context, workspaceID string) ([]*models.AttachmentWebhook, error) {
+	cursor, err := r.webhooks.Find(ctx, bson.M{"workspace_id": workspaceID, "is_active": true})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var webhooks []*models.AttachmentWebhook
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// Enqueue stores deliveries, skipping any already stored for the same
+// webhook and event.
+func (r *WebhookRepository) Enqueue(ctx context.Context, deliveries []*models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	docs := make([]any, len(deliveries))
+	for i, d := range deliveries {
+		docs[i] = d
+	}
+	_, err := r.deliveries.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulk mongo.BulkWriteException
+	if errors.As(err, &bulk) && bulk.WriteConcernError == nil {
+		for _, e := range bulk.WriteErrors {
+			if !mongo.IsDuplicateKeyError(e) {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// ClaimDue takes the oldest pending delivery that is due, hiding it from
+// other claims for lease. It returns nil when none is due.
+func (r *WebhookRepository) ClaimDue(ctx context.Context, now time.Time, lease time.Duration) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	err := r.deliveries.FindOneAndUpdate(ctx, bson.M{
+		"status":          models.DeliveryPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}, bson.M{
+		"$set": bson.M{"next_attempt_at": now.Add(lease)},
+	}, options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// RecordAttempt stores the outcome of a delivery attempt. A failed
+// delivery stays pending until next, or fails for good if next is nil.
+// Finished deliveries are kept for retention.
+func (r *WebhookRepository) RecordAttempt(ctx context.Context, id primitive.ObjectID, result *models.WebhookResult, next *time.Time, retention time.Duration) error {
+	now := time.Now()
+	set := bson.M{"last_attempt_at": now, "status_code": result.StatusCode, "last_error": result.Error}
+	switch {
+	case result.OK():
+		set["status"] = models.DeliverySucceeded
+		set["delivered_at"] = now
+		set["expires_at"] = now.Add(retention)
+	case next != nil:
+		set["next_attempt_at"] = *next
+	default:
+		set["status"] = models.DeliveryFailed
+		set["expires_at"] = now.Add(retention)
+	}
+	_, err := r.deliveries.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": set,
+		"$inc": bson.M{"attempts": 1},
+	})
+	return err
+}
+
+// FailPending fails a webhook's pending deliveries, as when it is disabled.
+func (r *WebhookRepository) FailPending(ctx context.Context, webhookID, reason string, retention time.Duration) error {
+	_, err := r.deliveries.UpdateMany(ctx, bson.M{"webhook_id": webhookID, "status": models.DeliveryPending}, bson.M{
+		"$set": bson.M{"status": models.DeliveryFailed, "last_error": reason, "expires_at": time.Now().Add(retention)},
+	})
+	return err
+}
+
+// ListDeliveries returns a webhook's deliveries, newest first, optionally
+// only those with the given status.
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, webhookID string, status models.WebhookDeliveryStatus, limit, offset int) ([]*models.WebhookDelivery, error) {
+	filter := bson.M{"webhook_id": webhookID}
+	if status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(int64(limit)).
+		SetSkip(int64(offset))
+	cursor, err := r.deliveries.Find(ctx, Scoped(ctx, filter), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var deliveries []*models.WebhookDelivery
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// GetDelivery returns one of a webhook's deliveries.
+func (r *WebhookRepository) GetDelivery(ctx context.Context, webhookID, id string) (*models.WebhookDelivery, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	var d models.WebhookDelivery
+	if err := r.deliveries.FindOne(ctx, Scoped(ctx, bson.M{"_id": oid, "webhook_id": webhookID})).Decode(&d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// LogAttempt adds an attempt to the delivery log.
+func (r *WebhookRepository) LogAttempt(ctx context.Context, a *models.WebhookAttempt) error {
+	a.CreatedAt = time.Now()
+	_, err := r.attempts.InsertOne(ctx, a)
+	return err
+}
+
+// ListAttempts returns the logged attempts of a delivery, oldest first.
+func (r *WebhookRepository) ListAttempts(ctx context.Context, deliveryID string) ([]*models.WebhookAttempt, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := r.attempts.Find(ctx, Scoped(ctx, bson.M{"delivery_id": deliveryID}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var attempts []*models.WebhookAttempt
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// RotateSecret replaces a webhook's secret, keeping the old one valid
+// until graceUntil. It returns the updated webhook.
+func (r *WebhookRepository) RotateSecret(ctx context.Context, workspaceID, id, secret string, graceUntil time.Time) (*models.AttachmentWebhook, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	var w models.AttachmentWebhook
+	err = r.webhooks.FindOneAndUpdate(ctx, Scoped(ctx, bson.M{"_id": oid, "workspace_id": workspaceID}), mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"previous_secret":            "$secret",
+			"previous_secret_expires_at": graceUntil,
+			"secret":                     secret,
+			"updated_at":                 time.Now(),
+		}}},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&w)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// RecordSuccess resets a webhook's failure count.
+func (r *WebhookRepository) RecordSuccess(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.webhooks.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"consecutive_failures": 0,
+		"last_success_at":      time.Now(),
+	}})
+	return err
+}
+
+// RecordFailure counts a failed attempt against a webhook and disables it
+// once disableAfter attempts in a row have failed. It reports whether this
+// failure disabled the webhook.
+func (r *WebhookRepository) RecordFailure(ctx context.Context, id primitive.ObjectID, reason string, disableAfter int) (bool, error) {
+	now := time.Now()
+	var w models.AttachmentWebhook
+	err := r.webhooks.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{
+		"$inc": bson.M{"consecutive_failures": 1},
+		"$set": bson.M{"last_failure_at": now},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&w)
+	if err != nil || disableAfter <= 0 || w.ConsecutiveFailures < disableAfter {
+		return false, err
+	}
+	result, err := r.webhooks.UpdateOne(ctx, bson.M{"_id": id, "is_active": true}, bson.M{"$set": bson.M{
+		"is_active":       false,
+		"disabled_at":     now,
+		"disabled_reason": reason,
+		"updated_at":      now,
+	}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"attachment-service/internal/auth"
+	"attachment-service/internal/config"
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("unknown API key")
+	ErrAPIKeyInactive = errors.New("API key has expired or been revoked")
+	ErrAPIKeyExpiry   = errors.New("expires_at must be a future RFC 3339 time")
+)
+
+// lastUsedInterval limits how often a key's last use is written back.
+const lastUsedInterval = time.Minute
+
+// APIKeyService issues workspace API keys and resolves them to identities
+// for the auth middleware. Keys look like qak_<prefix>_<secret>; only a
+// SHA-256 hash of the whole key is stored.
+type APIKeyService struct {
+	repo *repository.APIKeyRepository
+	cfg  *config.Config
+}
+
+func NewAPIKeyService(repo *repository.APIKeyRepository, cfg *config.Config) *APIKeyService {
+	return &APIKeyService{repo: repo, cfg: cfg}
+}
+
+// Create issues a key for the workspace. The returned secret is not stored
+// and cannot be shown again.
+func (s *APIKeyService) Create(ctx context.Context, workspaceID, createdBy string, req *models.CreateAPIKeyRequest) (*models.CreatedAPIKey, error) {
+	key := &models.APIKey{
+		WorkspaceID: workspaceID,
+		Name:        req.Name,
+		Scopes:      req.Scopes,
+		CreatedBy:   createdBy,
+	}
+	if req.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil || !expiresAt.After(time.Now()) {
+			return nil, ErrAPIKeyExpiry
+		}
+		key.ExpiresAt = &expiresAt
+	}
+	return s.issue(ctx, key)
+}
+
+func (s *APIKeyService) issue(ctx context.Context, key *models.APIKey) (*models.CreatedAPIKey, error) {
+	prefix := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key.Prefix = hex.EncodeToString(prefix)
+	plaintext := auth.APIKeyPrefix + key.Prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	key.Hash = hashAPIKey(plaintext)
+	if err := s.repo.Create(ctx, key); err != nil {
+		return nil, err
+	}
+	return &models.CreatedAPIKey{APIKey: key, Key: plaintext}, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *APIKeyService) List(ctx context.Context, workspaceID string) ([]*models.APIKey, error) {
+	return s.repo.List(ctx, workspaceID)
+}
+
+func (s *APIKeyService) Get(ctx context.Context, workspaceID, id string) (*models.APIKey, error) {
+	return s.repo.Get(ctx, workspaceID, id)
+}
+
+// Revoke stops a key from authenticating immediately.
+func (s *APIKeyService) Revoke(ctx context.Context, workspaceID, id, revokedBy string) error {
+	key, err := s.repo.Get(ctx, workspaceID, id)
+	if err != nil {
+		return err
+	}
+	return s.repo.Revoke(ctx, key.ID, revokedBy)
+}
+
+// Rotate issues a replacement for an active key with the same name, scopes
+// and expiry. The old key keeps working for the configured grace period so
+// callers can switch over.
+func (s *APIKeyService) Rotate(ctx context.Context, workspaceID, id, rotatedBy string) (*models.CreatedAPIKey, error) {
+	old, err := s.repo.Get(ctx, workspaceID, id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !old.Active(now) {
+		return nil, ErrAPIKeyInactive
+	}
+	created, err := s.issue(ctx, &models.APIKey{
+		WorkspaceID: old.WorkspaceID,
+		Name:        old.Name,
+		Scopes:      old.Scopes,
+		CreatedBy:   rotatedBy,
+		ExpiresAt:   old.ExpiresAt,
+		RotatedFrom: old.ID.Hex(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ExpireBy(ctx, old.ID, now.Add(s.cfg.APIKeyRotationGrace)); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// VerifyAPIKey implements auth.APIKeyVerifier. The key acts as a member of
+// its workspace, and as a workspace admin with the admin scope.
+func (s *APIKeyService) VerifyAPIKey(ctx context.Context, plaintext, ip string) (*auth.Identity, error) {
+	rest, ok := strings.CutPrefix(plaintext, auth.APIKeyPrefix)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	prefix, _, ok := strings.Cut(rest, "_")
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	key, err := s.repo.GetByPrefix(ctx, prefix)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(plaintext)), []byte(key.Hash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if !key.Active(time.Now()) {
+		return nil, ErrAPIKeyInactive
+	}
+	if err := s.repo.TouchLastUsed(ctx, key.ID, ip, lastUsedInterval); err != nil {
+		log.Printf("Failed to record use of API key %s: %v", key.Prefix, err)
+	}
+
+	id := &auth.Identity{
+		UserID:      "apikey:" + key.ID.Hex(),
+		WorkspaceID: key.WorkspaceID,
+		Workspaces:  []string{key.WorkspaceID},
+		Method:      auth.MethodAPIKey,
+		APIKeyID:    key.ID.Hex(),
+		Scopes:      key.Scopes,
+	}
+	if id.HasScope(auth.ScopeAdmin) {
+		id.WorkspaceRoles = map[string][]string{key.WorkspaceID: {auth.RoleAdmin}}
+	}
+	return id, nil
+}
+
+// Audit records a request made with an API key.
+func (s *APIKeyService) Audit(ctx context.Context, entry *models.APIKeyAudit) {
+	entry.CreatedAt = time.Now()
+	entry.ExpiresAt = entry.CreatedAt.Add(s.cfg.APIKeyAuditTTL)
+	if err := s.repo.RecordAudit(ctx, entry); err != nil {
+		log.Printf("Failed to audit API key %s: %v", entry.KeyID, err)
+	}
+}
+
+// ListAudit returns the most recent requests made with a key.
+func (s *APIKeyService) ListAudit(ctx context.Context, workspaceID, id string, limit, offset int) ([]*models.APIKeyAudit, error) {
+	key, err := s.repo.Get(ctx, workspaceID, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.ListAudit(ctx, key.ID.Hex(), limit, offset)
+}
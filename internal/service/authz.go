@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"attachment-service/internal/auth"
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// ForbiddenError reports an action the caller may not take.
+type ForbiddenError struct {
+	Action       models.AccessAction
+	AttachmentID string
+	ChannelID    string
+}
+
+func (e *ForbiddenError) Error() string {
+	if e.ChannelID != "" {
+		return fmt.Sprintf("not a member of channel %s", e.ChannelID)
+	}
+	return fmt.Sprintf("not allowed to %s attachment %s", e.Action, e.AttachmentID)
+}
+
+func (e *ForbiddenError) Is(target error) bool {
+	return target == ErrForbidden
+}
+
+// Authorizer decides what a caller may do to attachments. Access comes from,
+// in order: service callers and uploaders (everything), workspace admins
+// (everything), channel members (view and download), explicit permissions
+// and unexpired shares.
+//
+// Without a ChannelMembership every workspace member counts as a member of
+// the workspace's channels.
+type Authorizer struct {
+	repo     repository.Repository
+	ext      *repository.ExtendedRepository
+	channels ChannelMembership
+}
+
+func NewAuthorizer(repo repository.Repository, ext *repository.ExtendedRepository, channels ChannelMembership) *Authorizer {
+	return &Authorizer{repo: repo, ext: ext, channels: channels}
+}
+
+// Access returns what the caller may do to att.
+func (a *Authorizer) Access(ctx context.Context, id *auth.Identity, att *models.Attachment) (*models.AccessDecision, error) {
+	decisions, err := a.decide(ctx, id, []*models.Attachment{att})
+	if err != nil {
+		return nil, err
+	}
+	return decisions[0], nil
+}
+
+// Load fetches an attachment and checks the caller may perform action on it.
+// On a ForbiddenError the attachment and decision are still returned.
+// Deleted attachments are only found for AccessRestore.
+func (a *Authorizer) Load(ctx context.Context, id *auth.Identity, attachmentID string, action models.AccessAction) (*models.Attachment, *models.AccessDecision, error) {
+	att, err := a.repo.GetByID(ctx, attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if att.Status == models.StatusDeleted && action != models.AccessRestore {
+		return nil, nil, mongo.ErrNoDocuments
+	}
+	if att.Expired(time.Now()) {
+		return nil, nil, ErrExpired
+	}
+	decision, err := a.Access(ctx, id, att)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !decision.Allows(action) {
+		return att, decision, &ForbiddenError{Action: action, AttachmentID: attachmentID}
+	}
+	return att, decision, nil
+}
+
+// FilterVisible drops the attachments the caller may not view.
+func (a *Authorizer) FilterVisible(ctx context.Context, id *auth.Identity, attachments []*models.Attachment) ([]*models.Attachment, error) {
+	decisions, err := a.decide(ctx, id, attachments)
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]*models.Attachment, 0, len(attachments))
+	for i, att := range attachments {
+		if decisions[i].CanView {
+			visible = append(visible, att)
+		}
+	}
+	return visible, nil
+}
+
+// Allowed returns the subset of attachmentIDs the caller may perform action
+// on. IDs that don't exist are never allowed.
+func (a *Authorizer) Allowed(ctx context.Context, id *auth.Identity, attachmentIDs []string, action models.AccessAction) (map[string]bool, error) {
+	allowed := make(map[string]bool, len(attachmentIDs))
+	if len(attachmentIDs) == 0 {
+		return allowed, nil
+	}
+	attachments, err := a.ext.FindAttachmentsByIDs(ctx, attachmentIDs)
+	if err != nil {
+		return nil, err
+	}
+	decisions, err := a.decide(ctx, id, attachments)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range decisions {
+		if d.Allows(action) {
+			allowed[d.AttachmentID] = true
+		}
+	}
+	return allowed, nil
+}
+
+// Forbidden returns the attachmentIDs the caller may not perform action on.
+func (a *Authorizer) Forbidden(ctx context.Context, id *auth.Identity, attachmentIDs []string, action models.AccessAction) ([]string, error) {
+	allowed, err := a.Allowed(ctx, id, attachmentIDs, action)
+	if err != nil {
+		return nil, err
+	}
+	var forbidden []string
+	for _, attachmentID := range attachmentIDs {
+		if !allowed[attachmentID] {
+			forbidden = append(forbidden, attachmentID)
+		}
+	}
+	return forbidden, nil
+}
+
+// ChannelAccess checks the caller may post into a channel of a workspace.
+func (a *Authorizer) ChannelAccess(ctx context.Context, id *auth.Identity, workspaceID, channelID string) error {
+	if id.HasRole(auth.RoleService) || id.IsWorkspaceAdmin(workspaceID) {
+		return nil
+	}
+	member, err := a.isChannelMember(ctx, id, workspaceID, channelID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return &ForbiddenError{ChannelID: channelID}
+	}
+	return nil
+}
+
+func (a *Authorizer) decide(ctx context.Context, id *auth.Identity, attachments []*models.Attachment) ([]*models.AccessDecision, error) {
+	decisions := make([]*models.AccessDecision, len(attachments))
+	var pending []string
+	for i, att := range attachments {
+		d := &models.AccessDecision{AttachmentID: att.ID.Hex(), UserID: id.UserID, Grants: []string{}}
+		decisions[i] = d
+		switch {
+		case id.HasRole(auth.RoleService):
+			d.Grant(models.GrantService, models.AllAccessActions...)
+		case id.UserID != "" && att.UserID == id.UserID:
+			d.Grant(models.GrantOwner, models.AllAccessActions...)
+		case id.IsWorkspaceAdmin(att.WorkspaceID):
+			d.Grant(models.GrantWorkspaceAdmin, models.AllAccessActions...)
+		case id.IsAPIKey() && id.IsMember(att.WorkspaceID):
+			// Write keys can upload, comment and edit; deleting and
+			// sharing someone else's attachment takes an admin key
+			switch {
+			case id.HasScope(auth.ScopeAdmin):
+				d.Grant(models.GrantAPIKey, models.AllAccessActions...)
+			case id.HasScope(auth.ScopeWrite):
+				d.Grant(models.GrantAPIKey, models.AccessView, models.AccessDownload, models.AccessEdit)
+			default:
+				d.Grant(models.GrantAPIKey, models.AccessView, models.AccessDownload)
+			}
+		default:
+			pending = append(pending, d.AttachmentID)
+		}
+	}
+	if len(pending) == 0 || id.UserID == "" {
+		return decisions, nil
+	}
+
+	perms, err := a.ext.ListUserPermissions(ctx, id.UserID, pending)
+	if err != nil {
+		return nil, err
+	}
+	shares, err := a.ext.ListActiveShares(ctx, id.UserID, pending)
+	if err != nil {
+		return nil, err
+	}
+	permsByAttachment := make(map[string]*models.AttachmentPermission, len(perms))
+	for _, p := range perms {
+		permsByAttachment[p.AttachmentID] = p
+	}
+	sharesByAttachment := make(map[string][]*models.AttachmentShare, len(shares))
+	for _, s := range shares {
+		sharesByAttachment[s.AttachmentID] = append(sharesByAttachment[s.AttachmentID], s)
+	}
+
+	channelMember := make(map[string]bool)
+	for i, att := range attachments {
+		d := decisions[i]
+		if len(d.Grants) > 0 {
+			continue
+		}
+		if att.ChannelID != "" {
+			key := att.WorkspaceID + "/" + att.ChannelID
+			member, seen := channelMember[key]
+			if !seen {
+				member, err = a.isChannelMember(ctx, id, att.WorkspaceID, att.ChannelID)
+				if err != nil {
+					// Fail closed; other grants may still apply
+					log.Printf("Channel membership check for %s failed: %v", att.ChannelID, err)
+				}
+				channelMember[key] = member
+			}
+			if member {
+				d.Grant(models.GrantChannelMember, models.AccessView, models.AccessDownload)
+			}
+		}
+		if p, ok := permsByAttachment[d.AttachmentID]; ok {
+			d.Grant(models.GrantPermission, models.PermissionActions(p)...)
+		}
+		for _, s := range sharesByAttachment[d.AttachmentID] {
+			d.Grant(models.GrantShare, models.ShareActions(s.Permission)...)
+		}
+	}
+	return decisions, nil
+}
+
+func (a *Authorizer) isChannelMember(ctx context.Context, id *auth.Identity, workspaceID, channelID string) (bool, error) {
+	if !id.IsMember(workspaceID) {
+		return false, nil
+	}
+	if a.channels == nil {
+		return true, nil
+	}
+	return a.channels.IsChannelMember(ctx, channelID, id.UserID)
+}
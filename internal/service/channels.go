@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChannelMembership answers whether a user belongs to a channel.
+type ChannelMembership interface {
+	IsChannelMember(ctx context.Context, channelID, userID string) (bool, error)
+}
+
+// HTTPChannelMembership asks the channel service, caching answers for ttl.
+type HTTPChannelMembership struct {
+	baseURL string
+	client  *http.Client
+	ttl     time.Duration
+	mu      sync.RWMutex
+	cache   map[string]cachedMembership
+}
+
+type cachedMembership struct {
+	member    bool
+	expiresAt time.Time
+}
+
+func NewHTTPChannelMembership(baseURL string, ttl time.Duration) *HTTPChannelMembership {
+	return &HTTPChannelMembership{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: 5 * time.Second},
+		ttl:     ttl,
+		cache:   make(map[string]cachedMembership),
+	}
+}
+
+func (m *HTTPChannelMembership) IsChannelMember(ctx context.Context, channelID, userID string) (bool, error) {
+	key := channelID + "|" + userID
+	m.mu.RLock()
+	entry, ok := m.cache[key]
+	m.mu.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.member, nil
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v1/channels/%s/members/%s", m.baseURL, url.PathEscape(channelID), url.PathEscape(userID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("channel membership lookup: %w", err)
+	}
+	resp.Body.Close()
+
+	var member bool
+	switch resp.StatusCode {
+	case http.StatusOK:
+		member = true
+	case http.StatusNotFound, http.StatusForbidden:
+		member = false
+	default:
+		return false, fmt.Errorf("channel membership lookup: unexpected status %d", resp.StatusCode)
+	}
+
+	m.mu.Lock()
+	m.cache[key] = cachedMembership{member: member, expiresAt: time.Now().Add(m.ttl)}
+	m.mu.Unlock()
+	return member, nil
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"attachment-service/internal/auth"
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+const eventSource = "/attachment-service"
+
+// NewEvent wraps data in an event envelope attributed to the caller in
+// ctx, or to the system for background work.
+func NewEvent(ctx context.Context, data models.EventData) *models.Event {
+	actor, actorType := eventActor(ctx)
+	return &models.Event{
+		SpecVersion:     "1.0",
+		ID:              uuid.New().String(),
+		Source:          eventSource,
+		Type:            data.EventType(),
+		Subject:         data.Subject(),
+		DataContentType: "application/json",
+		OccurredAt:      time.Now().UTC(),
+		SchemaVersion:   data.SchemaVersion(),
+		Actor:           actor,
+		ActorType:       actorType,
+		WorkspaceID:     data.Workspace(),
+		Data:            data,
+	}
+}
+
+func eventActor(ctx context.Context) (string, string) {
+	id, ok := auth.FromContext(ctx)
+	switch {
+	case !ok || id.UserID == "":
+		return "attachment-service", models.ActorSystem
+	case id.IsAPIKey():
+		return id.APIKeyID, models.ActorAPIKey
+	case id.HasRole(auth.RoleService):
+		return id.UserID, models.ActorService
+	default:
+		return id.UserID, models.ActorUser
+	}
+}
+
+// WithEvents attaches lifecycle events to ctx. The repository write made
+// with the returned context records them in the outbox in the same
+// transaction, keyed by attachment so each attachment's events are
+// published in order.
+func WithEvents(ctx context.Context, data ...models.EventData) context.Context {
+	events := make([]*models.OutboxEvent, 0, len(data))
+	for _, d := range data {
+		e := NewEvent(ctx, d)
+		o, err := models.NewOutboxEvent(e.Type, e.Subject, e)
+		if err != nil {
+			// Event data are plain structs; this is a programming error
+			log.Printf("Failed to encode %s event for %s: %v", e.Type, e.Subject, err)
+			continue
+		}
+		events = append(events, o)
+	}
+	return repository.WithOutbox(ctx, events...)
+}
+
+func uploadedEvent(a *models.Attachment) *models.AttachmentUploaded {
+	e := &models.AttachmentUploaded{
+		AttachmentRef: models.RefOf(a),
+		UserID:        a.UserID,
+		ChannelID:     a.ChannelID,
+		MessageID:     a.MessageID,
+		SessionID:     a.SessionID,
+		FileName:      a.OriginalName,
+		MimeType:      a.MimeType,
+		Size:          a.Size,
+	}
+	if a.Metadata != nil {
+		e.Checksum = a.Metadata.Checksum
+	}
+	return e
+}
+
+func readyEvent(a *models.Attachment) *models.AttachmentReady {
+	return &models.AttachmentReady{
+		AttachmentRef: models.RefOf(a),
+		Type:          a.Type,
+		MimeType:      a.MimeType,
+		Size:          a.Size,
+	}
+}
+
+// DeletedEvent describes the deletion of a.
+func DeletedEvent(a *models.Attachment) *models.AttachmentDeleted {
+	return &models.AttachmentDeleted{
+		AttachmentRef: models.RefOf(a),
+		UserID:        a.UserID,
+		ChannelID:     a.ChannelID,
+		MessageID:     a.MessageID,
+		Size:          a.Size,
+	}
+}
+
+func expiredEvent(a *models.Attachment) *models.AttachmentExpired {
+	return &models.AttachmentExpired{
+		AttachmentRef: models.RefOf(a),
+		UserID:        a.UserID,
+		ChannelID:     a.ChannelID,
+		MessageID:     a.MessageID,
+		Size:          a.Size,
+		ExpiresAt:     *a.ExpiresAt,
+	}
+}
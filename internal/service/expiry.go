@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+	"attachment-service/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrExpired is returned when an ephemeral attachment is read after it
+// expired.
+var ErrExpired = errors.New("attachment has expired")
+
+const (
+	expirySweepBatch = 500
+	// heldExpiryDelay is how long the removal of a held attachment is put
+	// off before the hold is checked again.
+	heldExpiryDelay = 24 * time.Hour
+)
+
+// expiryFor returns when an upload asking to live expiresIn seconds
+// expires, or nil for a permanent upload. The lifetime must be within the
+// policy's MaxExpiresIn.
+func expiryFor(policy *models.EffectivePolicy, expiresIn int, now time.Time) (*time.Time, error) {
+	switch {
+	case expiresIn == 0:
+		return nil, nil
+	case expiresIn < 0:
+		return nil, fmt.Errorf("expires_in must be a positive number of seconds")
+	case policy.MaxExpiresIn <= 0:
+		return nil, fmt.Errorf("ephemeral uploads are not allowed in this workspace")
+	case expiresIn > policy.MaxExpiresIn:
+		return nil, fmt.Errorf("expires_in too long: %d seconds (max: %d)", expiresIn, policy.MaxExpiresIn)
+	}
+	expiresAt := now.Add(time.Duration(expiresIn) * time.Second)
+	return &expiresAt, nil
+}
+
+// ExpirySweeper removes ephemeral attachments once they expire: their
+// object, versions and previews are deleted from storage, then their
+// records, and attachments.expired is emitted for each. Reads already
+// refuse expired attachments, so the sweeper only has to catch up
+// eventually. Attachments under legal hold are kept, and checked again
+// later.
+type ExpirySweeper struct {
+	repo    *repository.ExpiryRepository
+	holds   *LegalHoldService
+	storage storage.Storage
+}
+
+func NewExpirySweeper(repo *repository.ExpiryRepository, holds *LegalHoldService, storage storage.Storage) *ExpirySweeper {
+	return &ExpirySweeper{repo: repo, holds: holds, storage: storage}
+}
+
+// Sweep removes every attachment that has expired by now and returns how
+// many it removed.
+func (s *ExpirySweeper) Sweep(ctx context.Context) (int, error) {
+	now := time.Now()
+	total := 0
+	var after primitive.ObjectID
+	for {
+		expired, err := s.repo.Expired(ctx, now, after, expirySweepBatch)
+		if err != nil || len(expired) == 0 {
+			return total, err
+		}
+		after = expired[len(expired)-1].ID
+
+		allowed, err := s.unheld(ctx, expired, now.Add(heldExpiryDelay))
+		if err != nil {
+			return total, err
+		}
+		for _, a := range allowed {
+			if !s.deleteContent(ctx, a) {
+				continue
+			}
+			removed, err := s.repo.Remove(WithEvents(ctx, expiredEvent(a)), a)
+			if err != nil {
+				return total, err
+			}
+			if removed {
+				total++
+			}
+		}
+		if len(expired) < expirySweepBatch {
+			return total, nil
+		}
+	}
+}
+
+// unheld returns the attachments in expired no legal hold covers, putting
+// off the removal of the others until retryAt.
+func (s *ExpirySweeper) unheld(ctx context.Context, expired []*models.Attachment, retryAt time.Time) ([]*models.Attachment, error) {
+	held, err := s.holds.Held(ctx, models.HoldOpExpiry, "ephemeral attachment expired", expired...)
+	if err != nil || len(held) == 0 {
+		return expired, err
+	}
+	isHeld := make(map[primitive.ObjectID]bool, len(held))
+	for _, a := range held {
+		isHeld[a.ID] = true
+	}
+	if err := s.repo.Postpone(ctx, idsOf(held), retryAt); err != nil {
+		return nil, err
+	}
+	allowed := make([]*models.Attachment, 0, len(expired)-len(held))
+	for _, a := range expired {
+		if !isHeld[a.ID] {
+			allowed = append(allowed, a)
+		}
+	}
+	return allowed, nil
+}
+
+// deleteContent removes an attachment's object, versions and previews from
+// storage, and reports whether all of them are gone.
+func (s *ExpirySweeper) deleteContent(ctx context.Context, a *models.Attachment) bool {
+	paths, err := s.repo.StoredPaths(ctx, a.ID.Hex())
+	if err != nil {
+		log.Printf("Failed to list stored content of %s: %v", a.ID.Hex(), err)
+		return false
+	}
+	return deleteObjects(ctx, s.storage, append([]string{a.StoragePath}, paths...))
+}
+
+// Run sweeps expired attachments every interval until ctx is done.
+func (s *ExpirySweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := s.Sweep(ctx); err != nil {
+				log.Printf("Expiry sweeper failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Expiry sweeper removed %d expired attachments", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrLegalHold        = errors.New("attachment is under legal hold")
+	ErrHoldScopeID      = errors.New("scope_id is required for attachment, user and channel holds")
+	ErrHoldAttachmentID = errors.New("scope_id must be an attachment ID")
+	ErrHoldReleased     = errors.New("legal hold was already released")
+)
+
+// LegalHoldError is returned when legal holds refuse an operation. It
+// matches ErrLegalHold.
+type LegalHoldError struct {
+	AttachmentIDs []string
+	HoldIDs       []string
+}
+
+func (e *LegalHoldError) Error() string {
+	if len(e.AttachmentIDs) == 1 {
+		return fmt.Sprintf("attachment %s is under legal hold", e.AttachmentIDs[0])
+	}
+	return fmt.Sprintf("%d attachments are under legal hold", len(e.AttachmentIDs))
+}
+
+func (e *LegalHoldError) Is(target error) bool { return target == ErrLegalHold }
+
+// LegalHoldService places and releases legal holds and enforces them:
+// operations that would remove held data ask Permit or Held first, and
+// every refusal is recorded in the hold's audit trail.
+type LegalHoldService struct {
+	repo *repository.LegalHoldRepository
+}
+
+func NewLegalHoldService(repo *repository.LegalHoldRepository) *LegalHoldService {
+	return &LegalHoldService{repo: repo}
+}
+
+// Create places a hold in the workspace. A workspace hold always covers
+// the workspace it is created in.
+func (s *LegalHoldService) Create(ctx context.Context, workspaceID, createdBy string, req *models.CreateLegalHoldRequest) (*models.LegalHold, error) {
+	h := &models.LegalHold{
+		WorkspaceID: workspaceID,
+		Scope:       req.Scope,
+		ScopeID:     strings.TrimSpace(req.ScopeID),
+		Reason:      req.Reason,
+		Custodian:   req.Custodian,
+		CreatedBy:   createdBy,
+	}
+	switch {
+	case h.Scope == models.HoldScopeWorkspace:
+		h.ScopeID = workspaceID
+	case h.ScopeID == "":
+		return nil, ErrHoldScopeID
+	case h.Scope == models.HoldScopeAttachment && !primitive.IsValidObjectID(h.ScopeID):
+		return nil, ErrHoldAttachmentID
+	}
+	if err := s.repo.Create(ctx, h); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, s.entry(ctx, h, models.HoldAuditCreated, h.Reason))
+	return h, nil
+}
+
+func (s *LegalHoldService) List(ctx context.Context, workspaceID, status string, limit, offset int) ([]*models.LegalHold, error) {
+	return s.repo.List(ctx, workspaceID, status, limit, offset)
+}
+
+func (s *LegalHoldService) Get(ctx context.Context, workspaceID, id string) (*models.LegalHold, error) {
+	return s.repo.Get(ctx, workspaceID, id)
+}
+
+// Release ends a hold; what it covered can be deleted again.
+func (s *LegalHoldService) Release(ctx context.Context, workspaceID, id, releasedBy, reason string) (*models.LegalHold, error) {
+	h, err := s.repo.Get(ctx, workspaceID, id)
+	if err != nil {
+		return nil, err
+	}
+	if h, err = s.repo.Release(ctx, h.ID, releasedBy, reason); errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrHoldReleased
+	} else if err != nil {
+		return nil, err
+	}
+	s.audit(ctx, s.entry(ctx, h, models.HoldAuditReleased, reason))
+	return h, nil
+}
+
+// ListAudit returns a hold's audit trail, newest first.
+func (s *LegalHoldService) ListAudit(ctx context.Context, workspaceID, id string, limit, offset int) ([]*models.LegalHoldAudit, error) {
+	h, err := s.repo.Get(ctx, workspaceID, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.ListAudit(ctx, h.ID.Hex(), limit, offset)
+}
+
+// Holding returns the active holds covering each of attachments that is
+// held, without recording anything.
+func (s *LegalHoldService) Holding(ctx context.Context, attachments []*models.Attachment) (map[primitive.ObjectID][]*models.LegalHold, error) {
+	holds, err := s.repo.Covering(ctx, attachments)
+	if err != nil || len(holds) == 0 {
+		return nil, err
+	}
+	held := map[primitive.ObjectID][]*models.LegalHold{}
+	for _, a := range attachments {
+		for _, h := range holds {
+			if h.Covers(a) {
+				held[a.ID] = append(held[a.ID], h)
+			}
+		}
+	}
+	return held, nil
+}
+
+// Held returns which of attachments are held, recording that operation
+// was refused for them against each hold.
+func (s *LegalHoldService) Held(ctx context.Context, operation, details string, attachments ...*models.Attachment) ([]*models.Attachment, error) {
+	holding, err := s.refuse(ctx, operation, details, attachments)
+	if err != nil || len(holding) == 0 {
+		return nil, err
+	}
+	var held []*models.Attachment
+	for _, a := range attachments {
+		if len(holding[a.ID]) > 0 {
+			held = append(held, a)
+		}
+	}
+	return held, nil
+}
+
+// Permit returns a *LegalHoldError, after recording the refusal, if any
+// of attachments is held.
+func (s *LegalHoldService) Permit(ctx context.Context, operation, details string, attachments ...*models.Attachment) error {
+	holding, err := s.refuse(ctx, operation, details, attachments)
+	if err != nil || len(holding) == 0 {
+		return err
+	}
+	e := &LegalHoldError{}
+	seen := map[primitive.ObjectID]bool{}
+	for _, a := range attachments {
+		holds := holding[a.ID]
+		if len(holds) == 0 {
+			continue
+		}
+		e.AttachmentIDs = append(e.AttachmentIDs, a.ID.Hex())
+		for _, h := range holds {
+			if !seen[h.ID] {
+				seen[h.ID] = true
+				e.HoldIDs = append(e.HoldIDs, h.ID.Hex())
+			}
+		}
+	}
+	return e
+}
+
+// refuse finds the holds covering attachments and records one refusal per
+// hold listing the attachments it covers.
+func (s *LegalHoldService) refuse(ctx context.Context, operation, details string, attachments []*models.Attachment) (map[primitive.ObjectID][]*models.LegalHold, error) {
+	holding, err := s.Holding(ctx, attachments)
+	if err != nil || len(holding) == 0 {
+		return nil, err
+	}
+	entries := map[primitive.ObjectID]*models.LegalHoldAudit{}
+	var order []*models.LegalHoldAudit
+	for _, a := range attachments {
+		for _, h := range holding[a.ID] {
+			e, ok := entries[h.ID]
+			if !ok {
+				e = s.entry(ctx, h, models.HoldAuditBlocked, details)
+				e.Operation = operation
+				entries[h.ID] = e
+				order = append(order, e)
+			}
+			e.AttachmentIDs = append(e.AttachmentIDs, a.ID.Hex())
+		}
+	}
+	s.audit(ctx, order...)
+	return holding, nil
+}
+
+func (s *LegalHoldService) entry(ctx context.Context, h *models.LegalHold, action, details string) *models.LegalHoldAudit {
+	actor, actorType := eventActor(ctx)
+	return &models.LegalHoldAudit{
+		HoldID:      h.ID.Hex(),
+		WorkspaceID: h.WorkspaceID,
+		Action:      action,
+		Actor:       actor,
+		ActorType:   actorType,
+		Details:     details,
+	}
+}
+
+func (s *LegalHoldService) audit(ctx context.Context, entries ...*models.LegalHoldAudit) {
+	if err := s.repo.RecordAudit(context.WithoutCancel(ctx), entries...); err != nil {
+		log.Printf("Failed to record legal hold audit: %v", err)
+	}
+}
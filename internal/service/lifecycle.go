@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"attachment-service/internal/config"
+	"attachment-service/internal/kafka"
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Upstream topics announcing that something attachments belong to is gone
+const (
+	TopicMessagesDeleted   = "messages.deleted"
+	TopicChannelsDeleted   = "channels.deleted"
+	TopicWorkspacesDeleted = "workspaces.deleted"
+	TopicUsersDeleted      = "users.deleted"
+)
+
+const lifecycleBatch = 500
+
+// upstreamEvent is the part of an upstream lifecycle event we use. Fields
+// may be at the top level or, for CloudEvents, under data.
+type upstreamEvent struct {
+	ID          string `json:"id"`
+	EventID     string `json:"event_id"`
+	MessageID   string `json:"message_id"`
+	ChannelID   string `json:"channel_id"`
+	WorkspaceID string `json:"workspace_id"`
+	UserID      string `json:"user_id"`
+	// TransferTo, on users.deleted, is who inherits the user's attachments
+	TransferTo string          `json:"transfer_to"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// LifecycleHandler keeps attachments in step with upstream deletions: the
+// attachments of a deleted message, channel or workspace are deleted, and
+// those of a deleted user are transferred to another user or deleted.
+// Deleted attachments stay in the trash for cfg.UpstreamPurgeDelay before
+// their content is purged. Handling is idempotent; handled event IDs are
+// also remembered so redelivered events are skipped.
+type LifecycleHandler struct {
+	repo *repository.LifecycleRepository
+	cfg  *config.Config
+}
+
+func NewLifecycleHandler(repo *repository.LifecycleRepository, cfg *config.Config) *LifecycleHandler {
+	return &LifecycleHandler{repo: repo, cfg: cfg}
+}
+
+// Handlers returns the consumer handler for each upstream topic.
+func (h *LifecycleHandler) Handlers() map[string]kafka.Handler {
+	return map[string]kafka.Handler{
+		TopicMessagesDeleted:   h.handle(h.messageDeleted),
+		TopicChannelsDeleted:   h.handle(h.channelDeleted),
+		TopicWorkspacesDeleted: h.handle(h.workspaceDeleted),
+		TopicUsersDeleted:      h.handle(h.userDeleted),
+	}
+}
+
+// handle decodes a message for apply, skipping events already handled.
+func (h *LifecycleHandler) handle(apply func(ctx context.Context, e *upstreamEvent) error) kafka.Handler {
+	return func(ctx context.Context, msg *kafka.Message) error {
+		e, err := decodeUpstreamEvent(msg.Value)
+		if err != nil {
+			return kafka.Permanent(err)
+		}
+		id := e.ID
+		if id == "" {
+			id = e.EventID
+		}
+		if id == "" {
+			id = fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+		}
+		id = msg.Topic + ":" + id
+
+		handled, err := h.repo.Handled(ctx, id)
+		if err != nil || handled {
+			return err
+		}
+		if err := apply(ctx, e); err != nil {
+			return err
+		}
+		return h.repo.MarkHandled(ctx, id, msg.Topic, h.cfg.HandledEventTTL)
+	}
+}
+
+func decodeUpstreamEvent(value []byte) (*upstreamEvent, error) {
+	var e upstreamEvent
+	if err := json.Unmarshal(value, &e); err != nil {
+		return nil, err
+	}
+	if len(e.Data) > 0 && e.Data[0] == '{' {
+		id, eventID := e.ID, e.EventID
+		if err := json.Unmarshal(e.Data, &e); err != nil {
+			return nil, err
+		}
+		e.ID, e.EventID = id, eventID
+	}
+	return &e, nil
+}
+
+func (h *LifecycleHandler) messageDeleted(ctx context.Context, e *upstreamEvent) error {
+	if e.MessageID == "" {
+		return kafka.Permanent(errors.New("message_id is required"))
+	}
+	return h.deleteWhere(ctx, bson.M{"message_id": e.MessageID})
+}
+
+func (h *LifecycleHandler) channelDeleted(ctx context.Context, e *upstreamEvent) error {
+	if e.ChannelID == "" {
+		return kafka.Permanent(errors.New("channel_id is required"))
+	}
+	return h.deleteWhere(ctx, bson.M{"channel_id": e.ChannelID})
+}
+
+func (h *LifecycleHandler) workspaceDeleted(ctx context.Context, e *upstreamEvent) error {
+	if e.WorkspaceID == "" {
+		return kafka.Permanent(errors.New("workspace_id is required"))
+	}
+	return h.deleteWhere(ctx, bson.M{"workspace_id": e.WorkspaceID})
+}
+
+// userDeleted transfers the user's attachments to TransferTo, or deletes
+// them if there is no one to transfer them to. A workspace_id limits this
+// to the user's attachments in that workspace.
+func (h *LifecycleHandler) userDeleted(ctx context.Context, e *upstreamEvent) error {
+	if e.UserID == "" {
+		return kafka.Permanent(errors.New("user_id is required"))
+	}
+	filter := bson.M{"user_id": e.UserID}
+	if e.WorkspaceID != "" {
+		filter["workspace_id"] = e.WorkspaceID
+	}
+	if e.TransferTo == "" || e.TransferTo == e.UserID {
+		return h.deleteWhere(ctx, filter)
+	}
+
+	for {
+		attachments, err := h.repo.ListLive(ctx, filter, lifecycleBatch)
+		if err != nil {
+			return err
+		}
+		if len(attachments) == 0 {
+			return nil
+		}
+		if _, err := h.repo.ReassignAll(ctx, idsOf(attachments), e.UserID, e.TransferTo); err != nil {
+			return err
+		}
+	}
+}
+
+// deleteWhere deletes every live attachment matching filter in batches,
+// announcing each deletion.
+func (h *LifecycleHandler) deleteWhere(ctx context.Context, filter bson.M) error {
+	purgeAt := time.Now().Add(h.cfg.UpstreamPurgeDelay)
+	for {
+		attachments, err := h.repo.ListLive(ctx, filter, lifecycleBatch)
+		if err != nil {
+			return err
+		}
+		if len(attachments) == 0 {
+			return nil
+		}
+		events := make([]models.EventData, len(attachments))
+		for i, a := range attachments {
+			events[i] = DeletedEvent(a)
+		}
+		if _, err := h.repo.DeleteAll(WithEvents(ctx, events...), idsOf(attachments), purgeAt); err != nil {
+			return err
+		}
+	}
+}
+
+func idsOf(attachments []*models.Attachment) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(attachments))
+	for i, a := range attachments {
+		ids[i] = a.ID
+	}
+	return ids
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"attachment-service/internal/config"
+	"attachment-service/internal/kafka"
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+)
+
+const (
+	outboxLease      = "outbox-relay"
+	outboxMaxBackoff = 5 * time.Minute
+)
+
+// OutboxRelay publishes outbox events to Kafka, giving consumers
+// at-least-once delivery of events whose changes were committed. Events
+// with the same key are published in order: once one fails, later events
+// for its key wait for it to be retried. Only the replica holding the
+// relay lease publishes.
+type OutboxRelay struct {
+	repo     *repository.OutboxRepository
+	producer *kafka.Producer
+	cfg      *config.Config
+	owner    string
+}
+
+// NewOutboxRelay relays through producer, or connects to Kafka itself when
+// producer is nil.
+func NewOutboxRelay(repo *repository.OutboxRepository, producer *kafka.Producer, cfg *config.Config) *OutboxRelay {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return &OutboxRelay{repo: repo, producer: producer, cfg: cfg, owner: host + "-" + hex.EncodeToString(suffix)}
+}
+
+// Relay publishes pending events until none are ready, and returns how
+// many it sent.
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	if r.producer == nil {
+		p, err := kafka.NewProducer(r.cfg)
+		if err != nil {
+			return 0, err
+		}
+		r.producer = p
+	}
+
+	sent := 0
+	for {
+		now := time.Now()
+		events, err := r.repo.Pending(ctx, now, r.cfg.OutboxBatchSize)
+		if err != nil {
+			return sent, err
+		}
+
+		// Keys are published concurrently, each key's events in order
+		var keys []string
+		byKey := make(map[string][]*models.OutboxEvent)
+		for _, e := range events {
+			if _, ok := byKey[e.Key]; !ok {
+				keys = append(keys, e.Key)
+			}
+			byKey[e.Key] = append(byKey[e.Key], e)
+		}
+		counts := make([]int, len(keys))
+		errs := make([]error, len(keys))
+		var wg sync.WaitGroup
+		for i, key := range keys {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				counts[i], errs[i] = r.relayKey(ctx, byKey[key], now)
+			}()
+		}
+		wg.Wait()
+
+		progressed := false
+		for i := range keys {
+			sent += counts[i]
+			progressed = progressed || counts[i] > 0
+		}
+		if err := errors.Join(errs...); err != nil {
+			return sent, err
+		}
+		if len(events) < r.cfg.OutboxBatchSize || !progressed {
+			return sent, nil
+		}
+	}
+}
+
+// relayKey publishes one key's events in order, stopping at the first that
+// fails, and returns how many it sent.
+func (r *OutboxRelay) relayKey(ctx context.Context, events []*models.OutboxEvent, now time.Time) (int, error) {
+	sent := 0
+	for _, e := range events {
+		if err := r.producer.Deliver(ctx, e.Topic, e.Key, json.RawMessage(e.Payload)); err != nil {
+			return sent, r.repo.MarkFailed(ctx, e.ID, err.Error(), now.Add(outboxBackoff(e.Attempts+1)))
+		}
+		if err := r.repo.MarkSent(ctx, e.ID, now, r.cfg.OutboxRetention); err != nil {
+			// Published but not marked; it will be published again
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// outboxBackoff is the delay before retrying an event that has failed
+// attempts times: doubling from a second up to outboxMaxBackoff.
+func outboxBackoff(attempts int) time.Duration {
+	if attempts > 9 {
+		return outboxMaxBackoff
+	}
+	return min(time.Second<<attempts, outboxMaxBackoff)
+}
+
+// Run relays events every interval until ctx is done.
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	leaseTTL := max(3*interval, 30*time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			held, err := r.repo.AcquireLease(ctx, outboxLease, r.owner, leaseTTL)
+			if err != nil {
+				log.Printf("Outbox relay lease failed: %v", err)
+				continue
+			}
+			if !held {
+				continue
+			}
+			if n, err := r.Relay(ctx); err != nil {
+				log.Printf("Outbox relay failed after %d events: %v", n, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"attachment-service/internal/config"
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PolicyResolver resolves the effective upload policy for a workspace,
+// caching lookups for cfg.PolicyCacheTTL. Changes made through this process
+// are visible immediately; other replicas pick them up when the entry expires.
+type PolicyResolver struct {
+	repo  *repository.PolicyRepository
+	cfg   *config.Config
+	mu    sync.RWMutex
+	cache map[string]cachedPolicy
+}
+
+type cachedPolicy struct {
+	policy    *models.EffectivePolicy
+	expiresAt time.Time
+}
+
+func NewPolicyResolver(repo *repository.PolicyRepository, cfg *config.Config) *PolicyResolver {
+	return &PolicyResolver{
+		repo:  repo,
+		cfg:   cfg,
+		cache: make(map[string]cachedPolicy),
+	}
+}
+
+func (r *PolicyResolver) Resolve(ctx context.Context, workspaceID string) (*models.EffectivePolicy, error) {
+	r.mu.RLock()
+	entry, ok := r.cache[workspaceID]
+	r.mu.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.policy, nil
+	}
+
+	stored, err := r.repo.GetPolicy(ctx, workspaceID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	policy := mergePolicy(r.cfg, workspaceID, stored)
+
+	r.mu.Lock()
+	r.cache[workspaceID] = cachedPolicy{policy: policy, expiresAt: time.Now().Add(r.cfg.PolicyCacheTTL)}
+	r.mu.Unlock()
+
+	return policy, nil
+}
+
+func (r *PolicyResolver) Invalidate(workspaceID string) {
+	r.mu.Lock()
+	delete(r.cache, workspaceID)
+	r.mu.Unlock()
+}
+
+// mergePolicy overlays the fields set on a stored workspace policy onto the
+// config defaults. A nil stored policy yields the defaults.
+func mergePolicy(cfg *config.Config, workspaceID string, stored *models.WorkspacePolicy) *models.EffectivePolicy {
+	p := &models.EffectivePolicy{
+		WorkspaceID:        workspaceID,
+		AllowedTypes:       cfg.AllowedTypes,
+		MaxFileSize:        cfg.MaxFileSize,
+		MaxFilesPerMessage: cfg.MaxFilesPerMessage,
+		StripMetadata:      cfg.StripMetadata,
+		RetentionDays:      cfg.RetentionDays,
+		MaxExpiresIn:       int(cfg.MaxExpiresIn.Seconds()),
+		Source:             "default",
+	}
+	if stored == nil {
+		return p
+	}
+
+	p.Source = "workspace"
+	if len(stored.AllowedTypes) > 0 {
+		p.AllowedTypes = stored.AllowedTypes
+	}
+	p.BlockedTypes = stored.BlockedTypes
+	if stored.MaxFileSize > 0 {
+		p.MaxFileSize = stored.MaxFileSize
+	}
+	if stored.MaxFilesPerMessage > 0 {
+		p.MaxFilesPerMessage = stored.MaxFilesPerMessage
+	}
+	if stored.StripMetadata != nil {
+		p.StripMetadata = *stored.StripMetadata
+	}
+	if stored.RetentionDays != nil {
+		p.RetentionDays = *stored.RetentionDays
+	}
+	if stored.MaxExpiresIn != nil {
+		p.MaxExpiresIn = *stored.MaxExpiresIn
+	}
+	return p
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"attachment-service/internal/config"
+	"attachment-service/internal/kafka"
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+)
+
+// QuotaExceededError reports a rejected reservation together with the
+// quota that rejected it.
+type QuotaExceededError struct {
+	Quota     *models.QuotaStatus
+	Requested int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s storage quota exceeded: requested %d bytes, %d of %d bytes used",
+		e.Quota.Scope, e.Requested, e.Quota.UsedBytes+e.Quota.ReservedBytes, e.Quota.MaxBytes)
+}
+
+// TooLarge reports whether the request could never fit, even with nothing stored.
+func (e *QuotaExceededError) TooLarge() bool {
+	return e.Quota.MaxBytes > 0 && e.Requested > e.Quota.MaxBytes
+}
+
+// QuotaService enforces per-user and per-workspace storage quotas against
+// the usage counters. Uploads reserve space up front; the attachment written
+// for the upload claims the reservation, and the repository moves it into
+// used storage when a pending upload completes and drops it when the upload
+// fails or is abandoned.
+type QuotaService struct {
+	repo     *repository.UsageRepository
+	producer *kafka.Producer
+	cfg      *config.Config
+}
+
+func NewQuotaService(repo *repository.UsageRepository, producer *kafka.Producer, cfg *config.Config) *QuotaService {
+	return &QuotaService{
+		repo:     repo,
+		producer: producer,
+		cfg:      cfg,
+	}
+}
+
+type quotaKey struct {
+	scope models.UsageScope
+	id    string
+}
+
+func quotaKeys(userID, workspaceID string) []quotaKey {
+	return []quotaKey{
+		{models.UsageScopeUser, userID},
+		{models.UsageScopeWorkspace, workspaceID},
+	}
+}
+
+func (s *QuotaService) Status(ctx context.Context, scope models.UsageScope, scopeID string) (*models.QuotaStatus, error) {
+	q, err := s.repo.GetCounter(ctx, scope, scopeID)
+	if err != nil {
+		return nil, err
+	}
+	return s.status(q), nil
+}
+
+func (s *QuotaService) SetLimits(ctx context.Context, scope models.UsageScope, scopeID string, maxBytes, maxFiles *int64, updatedBy string) (*models.QuotaStatus, error) {
+	q, err := s.repo.SetLimits(ctx, scope, scopeID, maxBytes, maxFiles, updatedBy)
+	if err != nil {
+		return nil, err
+	}
+	s.checkThresholds(ctx, q)
+	return s.status(q), nil
+}
+
+// Reserve reserves size bytes and one file against the user's and the
+// workspace's usage counters; either both reservations succeed or neither
+// is kept. The reservation lasts until the attachment written with
+// repository.WithReservation claims it, it is released, or it expires
+// after cfg.UploadReservationTTL.
+func (s *QuotaService) Reserve(ctx context.Context, userID, workspaceID string, size int64) (*models.QuotaReservation, error) {
+	counters := map[models.UsageCounterRef]*models.UsageCounter{}
+	var limits []repository.ReservationLimit
+	for _, k := range quotaKeys(userID, workspaceID) {
+		q, err := s.repo.GetCounter(ctx, k.scope, k.id)
+		if err != nil {
+			return nil, err
+		}
+		ref := models.UsageCounterRef{Scope: k.scope, ScopeID: k.id}
+		counters[ref] = q
+		maxBytes, maxFiles := s.limits(q)
+		limits = append(limits, repository.ReservationLimit{Counter: ref, MaxBytes: maxBytes, MaxFiles: maxFiles})
+	}
+
+	res := &models.QuotaReservation{Bytes: size, Files: 1, ExpiresAt: time.Now().Add(s.cfg.UploadReservationTTL)}
+	err := s.repo.Reserve(ctx, res, limits)
+	var full *repository.CounterFullError
+	if errors.As(err, &full) {
+		return nil, &QuotaExceededError{Quota: s.status(counters[full.Counter]), Requested: size}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Release drops a reservation made by Reserve that no attachment claimed.
+func (s *QuotaService) Release(ctx context.Context, res *models.QuotaReservation) error {
+	return s.repo.Release(ctx, res.ID)
+}
+
+// ReleaseExpired releases reservations that outlived
+// cfg.UploadReservationTTL without being claimed or released, and returns
+// how many it released.
+func (s *QuotaService) ReleaseExpired(ctx context.Context) (int, error) {
+	return s.repo.ReleaseExpired(ctx, time.Now(), 100)
+}
+
+// CheckThresholds re-evaluates the user's and the workspace's thresholds
+// after their usage changed.
+func (s *QuotaService) CheckThresholds(ctx context.Context, userID, workspaceID string) {
+	for _, k := range quotaKeys(userID, workspaceID) {
+		q, err := s.repo.GetCounter(ctx, k.scope, k.id)
+		if err != nil {
+			log.Printf("Failed to load %s usage for %s: %v", k.scope, k.id, err)
+			continue
+		}
+		s.checkThresholds(ctx, q)
+	}
+}
+
+func (s *QuotaService) limits(q *models.UsageCounter) (maxBytes, maxFiles int64) {
+	if q.Scope == models.UsageScopeWorkspace {
+		maxBytes, maxFiles = s.cfg.WorkspaceQuotaBytes, s.cfg.WorkspaceQuotaFiles
+	} else {
+		maxBytes, maxFiles = s.cfg.UserQuotaBytes, s.cfg.UserQuotaFiles
+	}
+	if q.MaxBytes != nil {
+		maxBytes = *q.MaxBytes
+	}
+	if q.MaxFiles != nil {
+		maxFiles = *q.MaxFiles
+	}
+	return maxBytes, maxFiles
+}
+
+func (s *QuotaService) status(q *models.UsageCounter) *models.QuotaStatus {
+	maxBytes, maxFiles := s.limits(q)
+	return &models.QuotaStatus{
+		Scope:         q.Scope,
+		ScopeID:       q.ScopeID,
+		MaxBytes:      maxBytes,
+		MaxFiles:      maxFiles,
+		UsedBytes:     q.UsedBytes,
+		UsedFiles:     q.UsedFiles,
+		ReservedBytes: q.ReservedBytes,
+		ReservedFiles: q.ReservedFiles,
+		Overridden:    q.MaxBytes != nil || q.MaxFiles != nil,
+	}
+}
+
+// checkThresholds publishes quota.threshold_crossed when usage climbs past
+// one of models.QuotaThresholds, and re-arms lower thresholds once usage
+// drops back below them.
+func (s *QuotaService) checkThresholds(ctx context.Context, q *models.UsageCounter) {
+	st := s.status(q)
+	pct := st.UsagePercent()
+
+	reached := 0
+	for _, t := range models.QuotaThresholds {
+		if pct >= t {
+			reached = t
+		}
+	}
+	if reached == q.NotifiedThreshold {
+		return
+	}
+
+	moved, err := s.repo.SetNotifiedThreshold(ctx, q.Scope, q.ScopeID, q.NotifiedThreshold, reached)
+	if err != nil {
+		log.Printf("Failed to update quota threshold for %s %s: %v", q.Scope, q.ScopeID, err)
+		return
+	}
+	if !moved || reached < q.NotifiedThreshold {
+		return
+	}
+
+	if s.producer != nil {
+		s.producer.Publish("quota.threshold_crossed", map[string]any{
+			"scope":         st.Scope,
+			"scope_id":      st.ScopeID,
+			"threshold":     reached,
+			"usage_percent": pct,
+			"used_bytes":    st.UsedBytes,
+			"max_bytes":     st.MaxBytes,
+			"used_files":    st.UsedFiles,
+			"max_files":     st.MaxFiles,
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"attachment-service/internal/repository"
+)
+
+// RateLimit is a token bucket holding up to Capacity tokens that refills
+// completely over Period.
+type RateLimit struct {
+	Name     string
+	Capacity float64
+	Period   time.Duration
+}
+
+func (l RateLimit) perSecond() float64 {
+	return l.Capacity / l.Period.Seconds()
+}
+
+// Enabled reports whether the limit applies at all.
+func (l RateLimit) Enabled() bool {
+	return l.Capacity > 0 && l.Period > 0
+}
+
+// RateDecision is the outcome of taking tokens from a bucket.
+type RateDecision struct {
+	Allowed   bool
+	Limit     RateLimit
+	Remaining float64
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until a denied cost could be taken
+	RetryAfter time.Duration
+}
+
+func newRateDecision(limit RateLimit, tokens, cost float64, allowed bool) *RateDecision {
+	d := &RateDecision{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: max(tokens, 0),
+		Reset:     secondsToDuration((limit.Capacity - tokens) / limit.perSecond()),
+	}
+	if !allowed {
+		d.RetryAfter = secondsToDuration((cost - tokens) / limit.perSecond())
+	}
+	return d
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(math.Max(s, 0) * float64(time.Second))
+}
+
+// RateLimiter takes tokens from named buckets. cost must not exceed the
+// limit's capacity or it will never be allowed.
+type RateLimiter interface {
+	Take(ctx context.Context, key string, cost float64, limit RateLimit) (*RateDecision, error)
+}
+
+// MemoryRateLimiter keeps buckets in process, so each replica enforces the
+// limits on its own.
+type MemoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{buckets: make(map[string]*memoryBucket), lastSweep: time.Now()}
+}
+
+func (m *MemoryRateLimiter) Take(_ context.Context, key string, cost float64, limit RateLimit) (*RateDecision, error) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Drop buckets that have refilled; they behave exactly like new ones
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, b := range m.buckets {
+			if now.After(b.full) {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	key = limit.Name + ":" + key
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: limit.Capacity, updated: now}
+		m.buckets[key] = b
+	}
+	b.tokens = min(limit.Capacity, b.tokens+now.Sub(b.updated).Seconds()*limit.perSecond())
+	b.updated = now
+	allowed := b.tokens >= cost
+	if allowed {
+		b.tokens -= cost
+	}
+	b.full = now.Add(secondsToDuration((limit.Capacity - b.tokens) / limit.perSecond()))
+	return newRateDecision(limit, b.tokens, cost, allowed), nil
+}
+
+// MongoRateLimiter keeps buckets in MongoDB so that all replicas share
+// them.
+type MongoRateLimiter struct {
+	repo *repository.RateLimitRepository
+}
+
+func NewMongoRateLimiter(repo *repository.RateLimitRepository) *MongoRateLimiter {
+	return &MongoRateLimiter{repo: repo}
+}
+
+func (m *MongoRateLimiter) Take(ctx context.Context, key string, cost float64, limit RateLimit) (*RateDecision, error) {
+	tokens, allowed, err := m.repo.Take(ctx, limit.Name+":"+key, cost, limit.Capacity, limit.perSecond(), time.Now(), limit.Period)
+	if err != nil {
+		return nil, err
+	}
+	return newRateDecision(limit, tokens, cost, allowed), nil
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"attachment-service/internal/config"
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+	"attachment-service/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RetentionEnforcer applies workspace retention policies to their ready
+// attachments older than the policy's age: it deletes them (their content
+// is purged after cfg.RetentionPurgeDelay), archives their content to
+// cfg.RetentionArchiveClass, or purges their previews. Each affected
+// attachment gets an activity entry and an event; attachments under legal
+// hold are skipped and the refusal recorded. Enforcement is idempotent, so
+// an interrupted run is finished by the next.
+type RetentionEnforcer struct {
+	repo    *repository.RetentionRepository
+	holds   *LegalHoldService
+	storage storage.Storage
+	cfg     *config.Config
+}
+
+func NewRetentionEnforcer(repo *repository.RetentionRepository, holds *LegalHoldService, storage storage.Storage, cfg *config.Config) *RetentionEnforcer {
+	return &RetentionEnforcer{repo: repo, holds: holds, storage: storage, cfg: cfg}
+}
+
+// Policy returns a workspace's retention policy.
+func (e *RetentionEnforcer) Policy(ctx context.Context, workspaceID, id string) (*models.AttachmentRetention, error) {
+	return e.repo.Get(ctx, workspaceID, id)
+}
+
+func (e *RetentionEnforcer) batchSize() int {
+	return max(e.cfg.RetentionBatchSize, 1)
+}
+
+// Enforce applies every active policy and returns how many attachments
+// were affected. A failing policy is logged and the rest still run.
+func (e *RetentionEnforcer) Enforce(ctx context.Context) (int, error) {
+	policies, err := e.repo.ListActive(ctx)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, p := range policies {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		now := time.Now()
+		n, err := e.Apply(ctx, p, now)
+		total += n
+		if err != nil {
+			log.Printf("Retention policy %s in workspace %s failed after %d attachments: %v", p.ID.Hex(), p.WorkspaceID, n, err)
+		}
+		if err := e.repo.MarkEnforced(ctx, p.ID, now, n); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Apply applies p as of now to every attachment it matches, in batches,
+// and returns how many were affected.
+func (e *RetentionEnforcer) Apply(ctx context.Context, p *models.AttachmentRetention, now time.Time) (int, error) {
+	if !models.ValidRetentionAction(p.Action) {
+		return 0, fmt.Errorf("unknown retention action %q", p.Action)
+	}
+	cutoff := p.Cutoff(now)
+	total := 0
+	var after primitive.ObjectID
+	for {
+		batch, err := e.repo.Matching(ctx, p, cutoff, e.cfg.RetentionArchiveClass, after, e.batchSize())
+		if err != nil || len(batch) == 0 {
+			return total, err
+		}
+		after = batch[len(batch)-1].ID
+
+		allowed, err := e.unheld(ctx, p, batch)
+		if err != nil {
+			return total, err
+		}
+		var n int
+		switch {
+		case len(allowed) == 0:
+		case p.Action == models.RetentionDelete:
+			n, err = e.delete(ctx, p, allowed)
+		case p.Action == models.RetentionArchive:
+			n, err = e.archive(ctx, p, allowed)
+		case p.Action == models.RetentionPurgePreviews:
+			n, err = e.purgePreviews(ctx, p, allowed)
+		}
+		total += n
+		if err != nil || len(batch) < e.batchSize() {
+			return total, err
+		}
+	}
+}
+
+// unheld returns the attachments in batch no legal hold covers, recording
+// that p was refused for the others.
+func (e *RetentionEnforcer) unheld(ctx context.Context, p *models.AttachmentRetention, batch []*models.Attachment) ([]*models.Attachment, error) {
+	details := fmt.Sprintf("retention policy %s: %s after %d days", p.ID.Hex(), p.Action, p.MaxAgeDays)
+	held, err := e.holds.Held(ctx, models.HoldOpRetention, details, batch...)
+	if err != nil || len(held) == 0 {
+		return batch, err
+	}
+	isHeld := make(map[primitive.ObjectID]bool, len(held))
+	for _, a := range held {
+		isHeld[a.ID] = true
+	}
+	allowed := make([]*models.Attachment, 0, len(batch)-len(held))
+	for _, a := range batch {
+		if !isHeld[a.ID] {
+			allowed = append(allowed, a)
+		}
+	}
+	return allowed, nil
+}
+
+// Preview lists up to limit attachments p would act on if enforced now,
+// counting those legal holds would protect instead.
+func (e *RetentionEnforcer) Preview(ctx context.Context, p *models.AttachmentRetention, limit int) (*models.RetentionPreview, error) {
+	now := time.Now()
+	preview := &models.RetentionPreview{Policy: p, Cutoff: p.Cutoff(now), Matches: []*models.RetentionMatch{}}
+	var after primitive.ObjectID
+	for {
+		batch, err := e.repo.Matching(ctx, p, preview.Cutoff, e.cfg.RetentionArchiveClass, after, e.batchSize())
+		if err != nil || len(batch) == 0 {
+			return preview, err
+		}
+		after = batch[len(batch)-1].ID
+
+		var counts map[string]int
+		if p.Action == models.RetentionPurgePreviews {
+			if counts, err = e.repo.PreviewCounts(ctx, hexIDsOf(batch)); err != nil {
+				return nil, err
+			}
+		}
+		holding, err := e.holds.Holding(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range batch {
+			if counts != nil && counts[a.ID.Hex()] == 0 {
+				continue
+			}
+			if len(holding[a.ID]) > 0 {
+				preview.Held++
+				continue
+			}
+			if len(preview.Matches) == limit {
+				preview.HasMore = true
+				return preview, nil
+			}
+			preview.Matches = append(preview.Matches, &models.RetentionMatch{
+				AttachmentID: a.ID.Hex(),
+				UserID:       a.UserID,
+				ChannelID:    a.ChannelID,
+				FileName:     a.OriginalName,
+				MimeType:     a.MimeType,
+				Size:         a.Size,
+				StorageClass: a.StorageClass,
+				CreatedAt:    a.CreatedAt,
+				Previews:     counts[a.ID.Hex()],
+			})
+		}
+		if len(batch) < e.batchSize() {
+			return preview, nil
+		}
+	}
+}
+
+func (e *RetentionEnforcer) delete(ctx context.Context, p *models.AttachmentRetention, batch []*models.Attachment) (int, error) {
+	events := make([]models.EventData, len(batch))
+	for i, a := range batch {
+		d := DeletedEvent(a)
+		d.Reason = models.DeleteReasonRetention
+		events[i] = d
+	}
+	purgeAt := time.Now().Add(e.cfg.RetentionPurgeDelay)
+	n, err := e.repo.DeleteAll(WithEvents(ctx, events...), idsOf(batch), purgeAt)
+	if err != nil {
+		return 0, err
+	}
+	return int(n), e.logActivities(ctx, p, batch, "deleted")
+}
+
+func (e *RetentionEnforcer) archive(ctx context.Context, p *models.AttachmentRetention, batch []*models.Attachment) (int, error) {
+	class := e.cfg.RetentionArchiveClass
+	var archived []*models.Attachment
+	for _, a := range batch {
+		if err := e.storage.SetStorageClass(ctx, a.StoragePath, class); err != nil {
+			log.Printf("Failed to archive %s: %v", a.StoragePath, err)
+			continue
+		}
+		ok, err := e.repo.MarkArchived(WithEvents(ctx, &models.AttachmentArchived{
+			AttachmentRef: models.RefOf(a),
+			PolicyID:      p.ID.Hex(),
+			StorageClass:  class,
+			Size:          a.Size,
+		}), a.ID, class)
+		if err != nil {
+			return len(archived), err
+		}
+		if ok {
+			archived = append(archived, a)
+		}
+	}
+	return len(archived), e.logActivities(ctx, p, archived, "archived")
+}
+
+func (e *RetentionEnforcer) purgePreviews(ctx context.Context, p *models.AttachmentRetention, batch []*models.Attachment) (int, error) {
+	counts, err := e.repo.PreviewCounts(ctx, hexIDsOf(batch))
+	if err != nil {
+		return 0, err
+	}
+	var purged []*models.Attachment
+	for _, a := range batch {
+		if counts[a.ID.Hex()] == 0 {
+			continue
+		}
+		previews, err := e.repo.ListPreviews(ctx, a.ID.Hex())
+		if err != nil {
+			return len(purged), err
+		}
+		var ids []primitive.ObjectID
+		for _, pv := range previews {
+			if pv.StoragePath != "" {
+				if err := e.storage.Delete(ctx, pv.StoragePath); err != nil {
+					log.Printf("Failed to purge preview %s: %v", pv.StoragePath, err)
+					continue
+				}
+			}
+			ids = append(ids, pv.ID)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		ok, err := e.repo.DeletePreviews(WithEvents(ctx, &models.AttachmentPreviewsPurged{
+			AttachmentRef: models.RefOf(a),
+			PolicyID:      p.ID.Hex(),
+			Count:         len(ids),
+		}), a.ID, ids)
+		if err != nil {
+			return len(purged), err
+		}
+		if ok {
+			purged = append(purged, a)
+		}
+	}
+	return len(purged), e.logActivities(ctx, p, purged, "previews_purged")
+}
+
+func (e *RetentionEnforcer) logActivities(ctx context.Context, p *models.AttachmentRetention, attachments []*models.Attachment, action string) error {
+	details := fmt.Sprintf("retention policy %s: older than %d days", p.ID.Hex(), p.MaxAgeDays)
+	activities := make([]*models.AttachmentActivity, len(attachments))
+	for i, a := range attachments {
+		activities[i] = &models.AttachmentActivity{
+			AttachmentID: a.ID.Hex(),
+			UserID:       models.ActorSystem,
+			Action:       action,
+			Details:      details,
+		}
+	}
+	return e.repo.LogActivities(ctx, activities)
+}
+
+func hexIDsOf(attachments []*models.Attachment) []string {
+	ids := make([]string, len(attachments))
+	for i, a := range attachments {
+		ids[i] = a.ID.Hex()
+	}
+	return ids
+}
+
+// Run enforces retention policies every interval until ctx is done.
+func (e *RetentionEnforcer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := e.Enforce(ctx); err != nil {
+				log.Printf("Retention enforcement failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Retention policies affected %d attachments", n)
+			}
+		}
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"net/url"
 	"path/filepath"
 	"strings"
 	"time"
@@ -18,33 +21,50 @@ import (
 
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type AttachmentService struct {
 	repo     repository.Repository
 	storage  storage.Storage
 	producer *kafka.Producer
+	policies *PolicyResolver
+	quotas   *QuotaService
+	signer   *DownloadSigner
+	holds    *LegalHoldService
 	cfg      *config.Config
 }
 
-func NewAttachmentService(repo repository.Repository, storage storage.Storage, producer *kafka.Producer, cfg *config.Config) *AttachmentService {
+func NewAttachmentService(repo repository.Repository, storage storage.Storage, producer *kafka.Producer, policies *PolicyResolver, quotas *QuotaService, signer *DownloadSigner, holds *LegalHoldService, cfg *config.Config) *AttachmentService {
 	return &AttachmentService{
 		repo:     repo,
 		storage:  storage,
 		producer: producer,
+		policies: policies,
+		quotas:   quotas,
+		signer:   signer,
+		holds:    holds,
 		cfg:      cfg,
 	}
 }
 
 func (s *AttachmentService) InitiateUpload(ctx context.Context, req *models.InitiateUploadRequest) (*models.UploadResponse, error) {
-	// Validate file type
-	if !s.isAllowedType(req.MimeType) {
-		return nil, fmt.Errorf("file type not allowed: %s", req.MimeType)
+	expiresAt, err := s.checkPolicy(ctx, req.WorkspaceID, req.MessageID, req.MimeType, req.Size, req.ExpiresIn)
+	if err != nil {
+		return nil, err
 	}
+	return s.initiateUpload(ctx, req, "", expiresAt)
+}
 
-	// Validate file size
-	if req.Size > s.cfg.MaxFileSize {
-		return nil, fmt.Errorf("file too large: %d bytes (max: %d)", req.Size, s.cfg.MaxFileSize)
+// initiateUpload reserves quota, creates the pending attachment and presigns
+// its upload URL. Policy checks are up to the caller; expiresAt, if set,
+// makes the attachment ephemeral.
+func (s *AttachmentService) initiateUpload(ctx context.Context, req *models.InitiateUploadRequest, sessionID string, expiresAt *time.Time) (*models.UploadResponse, error) {
+	// Reserve quota until the upload completes or is abandoned
+	reservation, err := s.quotas.Reserve(ctx, req.UserID, req.WorkspaceID, req.Size)
+	if err != nil {
+		return nil, err
 	}
 
 	// Generate unique filename
@@ -56,6 +76,8 @@ func (s *AttachmentService) InitiateUpload(ctx context.Context, req *models.Init
 		UserID:       req.UserID,
 		WorkspaceID:  req.WorkspaceID,
 		ChannelID:    req.ChannelID,
+		MessageID:    req.MessageID,
+		SessionID:    sessionID,
 		FileName:     fileName,
 		OriginalName: req.FileName,
 		MimeType:     req.MimeType,
@@ -63,15 +85,19 @@ func (s *AttachmentService) InitiateUpload(ctx context.Context, req *models.Init
 		Size:         req.Size,
 		Status:       models.StatusPending,
 		StoragePath:  storagePath,
+		ExpiresAt:    expiresAt,
 	}
 
-	if err := s.repo.Create(ctx, attachment); err != nil {
+	// The pending attachment takes the reservation over
+	if err := s.repo.Create(repository.WithReservation(ctx, reservation.ID), attachment); err != nil {
+		s.releaseQuota(ctx, reservation)
 		return nil, fmt.Errorf("failed to create attachment record: %w", err)
 	}
 
 	// Generate presigned upload URL
 	uploadURL, err := s.storage.GetPresignedUploadURL(ctx, storagePath, req.MimeType, 15*time.Minute)
 	if err != nil {
+		s.abandonUpload(ctx, attachment, "upload_url_failed")
 		return nil, fmt.Errorf("failed to generate upload URL: %w", err)
 	}
 
@@ -82,14 +108,14 @@ func (s *AttachmentService) InitiateUpload(ctx context.Context, req *models.Init
 }
 
 func (s *AttachmentService) Upload(ctx context.Context, req *models.UploadRequest, reader io.Reader, fileName string, mimeType string, size int64) (*models.Attachment, error) {
-	// Validate file type
-	if !s.isAllowedType(mimeType) {
-		return nil, fmt.Errorf("file type not allowed: %s", mimeType)
+	expiresAt, err := s.checkPolicy(ctx, req.WorkspaceID, req.MessageID, mimeType, size, req.ExpiresIn)
+	if err != nil {
+		return nil, err
 	}
 
-	// Validate file size
-	if size > s.cfg.MaxFileSize {
-		return nil, fmt.Errorf("file too large: %d bytes (max: %d)", size, s.cfg.MaxFileSize)
+	reservation, err := s.quotas.Reserve(ctx, req.UserID, req.WorkspaceID, size)
+	if err != nil {
+		return nil, err
 	}
 
 	// Generate unique filename
@@ -103,6 +129,7 @@ func (s *AttachmentService) Upload(ctx context.Context, req *models.UploadReques
 
 	// Upload to storage
 	if err := s.storage.Upload(ctx, storagePath, teeReader, mimeType, size); err != nil {
+		s.releaseQuota(ctx, reservation)
 		return nil, fmt.Errorf("failed to upload file: %w", err)
 	}
 
@@ -110,6 +137,7 @@ func (s *AttachmentService) Upload(ctx context.Context, req *models.UploadReques
 
 	// Create attachment record
 	attachment := &models.Attachment{
+		ID:           primitive.NewObjectID(),
 		UserID:       req.UserID,
 		WorkspaceID:  req.WorkspaceID,
 		ChannelID:    req.ChannelID,
@@ -122,30 +150,24 @@ func (s *AttachmentService) Upload(ctx context.Context, req *models.UploadReques
 		Status:       models.StatusReady,
 		StoragePath:  storagePath,
 		URL:          fmt.Sprintf("%s/files/%s", s.cfg.CDNBaseURL, storagePath),
+		ExpiresAt:    expiresAt,
 		Metadata: &models.AttachmentMeta{
 			Checksum:    checksum,
 			ContentType: mimeType,
 		},
 	}
 
-	if err := s.repo.Create(ctx, attachment); err != nil {
+	// Record the events with the attachment so they can't be lost, and
+	// swap the reservation for the counted attachment in the same write
+	events := WithEvents(repository.WithReservation(ctx, reservation.ID), uploadedEvent(attachment), readyEvent(attachment))
+	if err := s.repo.Create(events, attachment); err != nil {
 		// Try to clean up uploaded file
 		_ = s.storage.Delete(ctx, storagePath)
+		s.releaseQuota(ctx, reservation)
 		return nil, fmt.Errorf("failed to create attachment record: %w", err)
 	}
 
-	// Publish event
-	if s.producer != nil {
-		s.producer.Publish("attachments.uploaded", map[string]any{
-			"attachment_id": attachment.ID.Hex(),
-			"user_id":       attachment.UserID,
-			"workspace_id":  attachment.WorkspaceID,
-			"channel_id":    attachment.ChannelID,
-			"file_name":     attachment.OriginalName,
-			"mime_type":     attachment.MimeType,
-			"size":          attachment.Size,
-		})
-	}
+	s.quotas.CheckThresholds(ctx, attachment.UserID, attachment.WorkspaceID)
 
 	return attachment, nil
 }
@@ -155,27 +177,35 @@ func (s *AttachmentService) CompleteUpload(ctx context.Context, id string) (*mod
 	if err != nil {
 		return nil, err
 	}
+	if attachment.Status == models.StatusReady {
+		return attachment, nil
+	}
+	if attachment.Status != models.StatusPending {
+		return nil, fmt.Errorf("upload is no longer pending: %s", attachment.Status)
+	}
+	if attachment.SessionID != "" {
+		return nil, fmt.Errorf("attachment belongs to upload session %s, complete the session instead", attachment.SessionID)
+	}
 
 	// Update status and URL
 	attachment.Status = models.StatusReady
 	attachment.URL = fmt.Sprintf("%s/files/%s", s.cfg.CDNBaseURL, attachment.StoragePath)
 
-	if err := s.repo.Update(ctx, id, bson.M{
+	events := WithEvents(ctx, uploadedEvent(attachment), readyEvent(attachment))
+	updated, err := s.repo.UpdateIfStatus(events, id, models.StatusPending, bson.M{
 		"status": models.StatusReady,
 		"url":    attachment.URL,
-	}); err != nil {
+	})
+	if err != nil {
 		return nil, err
 	}
-
-	// Publish event
-	if s.producer != nil {
-		s.producer.Publish("attachments.uploaded", map[string]any{
-			"attachment_id": attachment.ID.Hex(),
-			"user_id":       attachment.UserID,
-			"workspace_id":  attachment.WorkspaceID,
-		})
+	if !updated {
+		// Completed or reaped concurrently; report whichever won
+		return s.CompleteUpload(ctx, id)
 	}
 
+	s.quotas.CheckThresholds(ctx, attachment.UserID, attachment.WorkspaceID)
+
 	return attachment, nil
 }
 
@@ -195,55 +225,331 @@ func (s *AttachmentService) GetByUserID(ctx context.Context, userID string, limi
 	return s.repo.GetByUserID(ctx, userID, limit, offset)
 }
 
+// Delete moves an attachment to the trash on behalf of userID. Callers are
+// expected to have authorized the deletion. A held attachment is not
+// deleted and a *LegalHoldError is returned. The stored content is kept,
+// and the attachment can be restored, until it is purged after
+// cfg.TrashRetention.
 func (s *AttachmentService) Delete(ctx context.Context, id string, userID string) error {
 	attachment, err := s.repo.GetByID(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	// Verify ownership
-	if attachment.UserID != userID {
-		return fmt.Errorf("unauthorized")
+	if attachment.Status == models.StatusDeleted {
+		return fmt.Errorf("attachment already deleted")
 	}
 
-	// Delete from storage
-	if err := s.storage.Delete(ctx, attachment.StoragePath); err != nil {
-		// Log but continue with marking as deleted
+	if err := s.holds.Permit(ctx, models.HoldOpDelete, "", attachment); err != nil {
+		return err
 	}
 
-	// Mark as deleted
-	if err := s.repo.Delete(ctx, id); err != nil {
+	events := WithEvents(ctx, DeletedEvent(attachment))
+	deleted, err := s.repo.Delete(events, id, userID, time.Now().Add(s.cfg.TrashRetention))
+	if err != nil {
 		return err
 	}
-
-	// Publish event
-	if s.producer != nil {
-		s.producer.Publish("attachments.deleted", map[string]any{
-			"attachment_id": id,
-			"user_id":       userID,
-			"workspace_id":  attachment.WorkspaceID,
-		})
+	if !deleted {
+		return fmt.Errorf("attachment already deleted")
 	}
 
+	s.quotas.CheckThresholds(ctx, attachment.UserID, attachment.WorkspaceID)
+
 	return nil
 }
 
-func (s *AttachmentService) GetDownloadURL(ctx context.Context, id string) (string, error) {
+var (
+	ErrNotInTrash = errors.New("attachment is not in the trash")
+	ErrPurged     = errors.New("attachment content has been purged")
+)
+
+// ListTrash returns a user's attachments that are in the trash and can
+// still be restored, most recently deleted first.
+func (s *AttachmentService) ListTrash(ctx context.Context, userID string, limit, offset int) ([]*models.Attachment, error) {
+	return s.repo.GetTrashByUserID(ctx, userID, limit, offset)
+}
+
+// Restore takes an attachment out of the trash on behalf of userID, back
+// into the status it was deleted from. Unless it failed, its size counts
+// against the owner's quota again, so a restore can fail with a
+// *QuotaExceededError; one whose content was purged, or is being purged,
+// fails with ErrPurged.
+func (s *AttachmentService) Restore(ctx context.Context, id string, userID string) (*models.Attachment, error) {
 	attachment, err := s.repo.GetByID(ctx, id)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if attachment.Status != models.StatusDeleted {
+		return nil, ErrNotInTrash
+	}
+	if attachment.PurgedAt != nil || attachment.PurgingAt != nil {
+		return nil, ErrPurged
+	}
+	to := attachment.DeletedFrom
+	if to == "" {
+		to = models.StatusReady
+	}
+	if to == models.StatusReady || to == models.StatusProcessing {
+		if _, err := s.storage.Stat(ctx, attachment.StoragePath); errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrPurged
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	// A restored pending upload holds a reservation again until it
+	// completes; other live statuses count as used. Failed ones are free.
+	restoreCtx := ctx
+	var reservation *models.QuotaReservation
+	if to != models.StatusFailed {
+		if reservation, err = s.quotas.Reserve(ctx, attachment.UserID, attachment.WorkspaceID, attachment.Size); err != nil {
+			return nil, err
+		}
+		restoreCtx = repository.WithReservation(ctx, reservation.ID)
+	}
+	events := WithEvents(restoreCtx, &models.AttachmentRestored{
+		AttachmentRef: models.RefOf(attachment),
+		UserID:        userID,
+	})
+	restored, err := s.repo.Restore(events, id, attachment.DeletedFrom)
+	if err != nil || !restored {
+		if reservation != nil {
+			s.releaseQuota(ctx, reservation)
+		}
+		if err == nil {
+			// Purged or restored concurrently
+			err = ErrNotInTrash
+		}
+		return nil, err
+	}
+	if reservation != nil && to != models.StatusPending {
+		s.quotas.CheckThresholds(ctx, attachment.UserID, attachment.WorkspaceID)
+	}
+
+	return s.repo.GetByID(ctx, id)
+}
+
+// DownloadOptions shape a signed download URL. A zero TTL means
+// cfg.DownloadURLTTL; UserID and IP, when set, bind the URL to that caller.
+type DownloadOptions struct {
+	Disposition Disposition
+	TTL         time.Duration
+	UserID      string
+	IP          string
+}
+
+// GetDownloadURL returns a signed CDNBaseURL/files/ URL for the attachment
+// and when it expires.
+func (s *AttachmentService) GetDownloadURL(ctx context.Context, id string, opts DownloadOptions) (string, time.Time, error) {
+	attachment, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if attachment.Status == models.StatusDeleted {
+		return "", time.Time{}, mongo.ErrNoDocuments
+	}
+	now := time.Now()
+	if attachment.Expired(now) {
+		return "", time.Time{}, ErrExpired
+	}
+
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = s.cfg.DownloadURLTTL
+	}
+	grant := &DownloadGrant{
+		AttachmentID: attachment.ID.Hex(),
+		StoragePath:  attachment.StoragePath,
+		Disposition:  opts.Disposition,
+		ExpiresAt:    now.Add(ttl),
+		UserID:       opts.UserID,
+		IP:           opts.IP,
+	}
+	if attachment.ExpiresAt != nil && attachment.ExpiresAt.Before(grant.ExpiresAt) {
+		// The URL never outlives an ephemeral attachment
+		grant.ExpiresAt = *attachment.ExpiresAt
+	}
+	if grant.Disposition == "" {
+		grant.Disposition = DispositionAttachment
+	}
+	return s.signer.URL(grant), grant.ExpiresAt, nil
+}
+
+// Download is a verified download request: the attachment, the object's
+// current metadata and a seekable reader that fetches byte ranges lazily.
+type Download struct {
+	Attachment *models.Attachment
+	Grant      *DownloadGrant
+	Object     *storage.ObjectInfo
+	Body       *storage.ObjectReader
+}
+
+// OpenDownload verifies a signed download request for the object at
+// storagePath made by client (and userID, if signed in) and prepares the
+// object for reading. Access is checked before anything is read. The
+// caller closes the returned body.
+func (s *AttachmentService) OpenDownload(ctx context.Context, storagePath string, query url.Values, client, userID string) (*Download, error) {
+	grant, err := s.signer.Verify(storagePath, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if (grant.UserID != "" && grant.UserID != userID) || (grant.IP != "" && grant.IP != client) {
+		return nil, ErrDownloadTokenBinding
 	}
 
-	return s.storage.GetPresignedURL(ctx, attachment.StoragePath, 1*time.Hour)
+	attachment, err := s.repo.GetByID(ctx, grant.AttachmentID)
+	if err != nil {
+		return nil, err
+	}
+	if attachment.StoragePath != storagePath || attachment.Status != models.StatusReady {
+		return nil, mongo.ErrNoDocuments
+	}
+	if attachment.Expired(time.Now()) {
+		return nil, ErrExpired
+	}
+	info, err := s.storage.Stat(ctx, storagePath)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, mongo.ErrNoDocuments
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Download{
+		Attachment: attachment,
+		Grant:      grant,
+		Object:     info,
+		Body:       storage.NewObjectReader(ctx, s.storage, storagePath, info.Size),
+	}, nil
+}
+
+// ReapAbandonedUploads fails presigned uploads that were never completed
+// within cfg.UploadReservationTTL and releases their reserved quota.
+func (s *AttachmentService) ReapAbandonedUploads(ctx context.Context) (int, error) {
+	stale, err := s.repo.ListByStatusBefore(ctx, models.StatusPending, time.Now().Add(-s.cfg.UploadReservationTTL), 100)
+	if err != nil {
+		return 0, err
+	}
+	reaped := 0
+	for _, attachment := range stale {
+		if s.abandonUpload(ctx, attachment, "reservation_expired") {
+			reaped++
+		}
+	}
+	return reaped, nil
 }
 
-func (s *AttachmentService) isAllowedType(mimeType string) bool {
-	for _, allowed := range s.cfg.AllowedTypes {
-		if allowed == mimeType {
-			return true
+// RunUploadReaper calls ReapAbandonedUploads, and releases expired quota
+// reservations, every interval until ctx is done.
+func (s *AttachmentService) RunUploadReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := s.ReapAbandonedUploads(ctx); err != nil {
+				log.Printf("Upload reaper failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Upload reaper released %d abandoned uploads", n)
+			}
+			if n, err := s.quotas.ReleaseExpired(ctx); err != nil {
+				log.Printf("Releasing expired quota reservations failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Upload reaper released %d expired quota reservations", n)
+			}
 		}
 	}
-	return false
+}
+
+// abandonUpload marks a pending upload as failed for reason, which releases
+// its quota reservation, and removes any partial object. It reports false
+// if the upload was no longer pending.
+func (s *AttachmentService) abandonUpload(ctx context.Context, attachment *models.Attachment, reason string) bool {
+	events := WithEvents(ctx, &models.AttachmentFailed{
+		AttachmentRef: models.RefOf(attachment),
+		UserID:        attachment.UserID,
+		Reason:        reason,
+	})
+	failed, err := s.repo.UpdateIfStatus(events, attachment.ID.Hex(), models.StatusPending, bson.M{"status": models.StatusFailed})
+	if err != nil {
+		log.Printf("Failed to abandon upload %s: %v", attachment.ID.Hex(), err)
+		return false
+	}
+	if !failed {
+		return false
+	}
+	_ = s.storage.Delete(ctx, attachment.StoragePath)
+	return true
+}
+
+// releaseQuota releases a reservation no attachment claimed. If that fails
+// the reservation is left to expire.
+func (s *AttachmentService) releaseQuota(ctx context.Context, reservation *models.QuotaReservation) {
+	if err := s.quotas.Release(ctx, reservation); err != nil {
+		log.Printf("Failed to release quota reservation %s: %v", reservation.ID.Hex(), err)
+	}
+}
+
+// Policy returns the effective upload policy for a workspace, falling back
+// to the config defaults when no resolver is configured.
+func (s *AttachmentService) Policy(ctx context.Context, workspaceID string) (*models.EffectivePolicy, error) {
+	if s.policies == nil {
+		return mergePolicy(s.cfg, workspaceID, nil), nil
+	}
+	return s.policies.Resolve(ctx, workspaceID)
+}
+
+// checkPolicy checks an upload against the workspace policy and returns
+// when it expires if it asks to live expiresIn seconds.
+func (s *AttachmentService) checkPolicy(ctx context.Context, workspaceID, messageID, mimeType string, size int64, expiresIn int) (*time.Time, error) {
+	policy, err := s.Policy(ctx, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve upload policy: %w", err)
+	}
+	if err := checkFile(policy, mimeType, size); err != nil {
+		return nil, err
+	}
+	expiresAt, err := expiryFor(policy, expiresIn, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return expiresAt, s.checkMessageCapacity(ctx, policy, messageID, 1)
+}
+
+func checkFile(policy *models.EffectivePolicy, mimeType string, size int64) error {
+	// Validate file type
+	if !policy.AllowsType(mimeType) {
+		return fmt.Errorf("file type not allowed: %s", mimeType)
+	}
+
+	// Validate file size
+	if size > policy.MaxFileSize {
+		return fmt.Errorf("file too large: %d bytes (max: %d)", size, policy.MaxFileSize)
+	}
+	return nil
+}
+
+// checkMessageCapacity verifies that adding files attachments to the message
+// stays within the policy's per-message limit.
+func (s *AttachmentService) checkMessageCapacity(ctx context.Context, policy *models.EffectivePolicy, messageID string, files int) error {
+	if policy.MaxFilesPerMessage <= 0 {
+		return nil
+	}
+	if files > policy.MaxFilesPerMessage {
+		return fmt.Errorf("too many files for message: max %d", policy.MaxFilesPerMessage)
+	}
+	if messageID == "" {
+		return nil
+	}
+	count, err := s.repo.CountByMessageID(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if count+int64(files) > int64(policy.MaxFilesPerMessage) {
+		return fmt.Errorf("too many files for message: max %d", policy.MaxFilesPerMessage)
+	}
+	return nil
 }
 
 func (s *AttachmentService) determineType(mimeType string) models.AttachmentType {
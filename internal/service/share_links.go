@@ -0,0 +1,306 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"attachment-service/internal/config"
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+	"attachment-service/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrShareLinkNotFound    = errors.New("share link not found")
+	ErrShareLinkExpired     = errors.New("share link has expired")
+	ErrShareLinkExhausted   = errors.New("share link has reached its download limit")
+	ErrShareLinkPassword    = errors.New("share link requires a password")
+	ErrShareLinkBadPassword = errors.New("incorrect share link password")
+	ErrShareLinkUnavailable = errors.New("shared file is no longer available")
+)
+
+// TooManyAttemptsError rejects a password attempt made after the link's
+// failure limit was reached for the caller.
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("too many password attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// ShareDelivery is how a share-link download is served: either a
+// presigned URL to redirect to or the object's content to stream.
+type ShareDelivery struct {
+	Attachment *models.Attachment
+	Link       *models.ShareLink
+	URL        string
+	Body       io.ReadCloser
+}
+
+// ShareLinkService creates share links and serves them to anonymous
+// callers.
+type ShareLinkService struct {
+	repo     repository.Repository
+	ext      *repository.ExtendedRepository
+	attempts *repository.ShareLinkAttemptRepository
+	accesses *repository.ShareLinkAccessRepository
+	storage  storage.Storage
+	cfg      *config.Config
+}
+
+func NewShareLinkService(repo repository.Repository, ext *repository.ExtendedRepository, attempts *repository.ShareLinkAttemptRepository, accesses *repository.ShareLinkAccessRepository, storage storage.Storage, cfg *config.Config) *ShareLinkService {
+	return &ShareLinkService{
+		repo:     repo,
+		ext:      ext,
+		attempts: attempts,
+		accesses: accesses,
+		storage:  storage,
+		cfg:      cfg,
+	}
+}
+
+// Create adds a share link to an attachment, hashing its password.
+func (s *ShareLinkService) Create(ctx context.Context, attachment *models.Attachment, createdBy string, req *models.CreateShareLinkRequest) (*models.ShareLink, error) {
+	link := &models.ShareLink{
+		ID:           primitive.NewObjectID(),
+		AttachmentID: attachment.ID.Hex(),
+		CreatedBy:    createdBy,
+		MaxDownloads: max(req.MaxDownloads, 0),
+	}
+	if req.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("expires_at must be an RFC 3339 time: %w", err)
+		}
+		if !expiresAt.After(time.Now()) {
+			return nil, errors.New("expires_at must be in the future")
+		}
+		link.ExpiresAt = &expiresAt
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		link.Password = string(hash)
+		link.HasPassword = true
+	}
+	code, err := newShareCode()
+	if err != nil {
+		return nil, err
+	}
+	link.Code = code
+	ctx = WithEvents(ctx, &models.AttachmentShared{
+		AttachmentRef: models.RefOf(attachment),
+		Kind:          models.ShareKindLink,
+		ShareID:       link.ID.Hex(),
+		ExpiresAt:     link.ExpiresAt,
+	})
+	if err := s.ext.CreateShareLink(ctx, link); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// newShareCode returns an unguessable URL-safe link code.
+func newShareCode() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Open checks a share link can be downloaded by the visitor with the given
+// password, counts the download and returns how to deliver the file. Every
+// resolution of an existing link is recorded with its outcome. Password
+// failures are limited per link and visitor IP.
+func (s *ShareLinkService) Open(ctx context.Context, code, password string, visitor models.ShareLinkVisitor) (*ShareDelivery, error) {
+	link, err := s.ext.GetShareLinkByCode(ctx, code)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrShareLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	delivery, err := s.open(ctx, link, password, visitor.IP)
+	if outcome, ok := shareLinkOutcome(err); ok {
+		s.recordAccess(ctx, link, visitor, outcome)
+	}
+	return delivery, err
+}
+
+func (s *ShareLinkService) open(ctx context.Context, link *models.ShareLink, password, client string) (*ShareDelivery, error) {
+	now := time.Now()
+	if link.ExpiresAt != nil && !link.ExpiresAt.After(now) {
+		return nil, ErrShareLinkExpired
+	}
+	if link.MaxDownloads > 0 && link.DownloadCount >= link.MaxDownloads {
+		return nil, ErrShareLinkExhausted
+	}
+	if link.Password != "" {
+		if err := s.checkPassword(ctx, link, password, client); err != nil {
+			return nil, err
+		}
+	}
+
+	attachment, err := s.repo.GetByID(ctx, link.AttachmentID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrShareLinkUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	if attachment.Status != models.StatusReady || attachment.Expired(now) {
+		return nil, ErrShareLinkUnavailable
+	}
+
+	// Count the download atomically; a concurrent download may have used
+	// the last one or the link may have expired since it was read.
+	link, err = s.ext.IncrementShareLinkDownloads(ctx, link.Code, now)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrShareLinkExhausted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := &ShareDelivery{Attachment: attachment, Link: link}
+	if s.cfg.ShareLinkDelivery == "stream" {
+		delivery.Body, err = s.storage.Download(ctx, attachment.StoragePath)
+	} else {
+		delivery.URL, err = s.storage.GetPresignedURL(ctx, attachment.StoragePath, s.cfg.ShareLinkURLTTL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// shareLinkOutcome classifies the result of opening a link. Internal
+// errors are not recorded.
+func shareLinkOutcome(err error) (models.ShareLinkOutcome, bool) {
+	var tooMany *TooManyAttemptsError
+	switch {
+	case err == nil:
+		return models.ShareLinkOK, true
+	case errors.As(err, &tooMany):
+		return models.ShareLinkRateLimited, true
+	case errors.Is(err, ErrShareLinkPassword):
+		return models.ShareLinkPasswordRequired, true
+	case errors.Is(err, ErrShareLinkBadPassword):
+		return models.ShareLinkWrongPassword, true
+	case errors.Is(err, ErrShareLinkExpired):
+		return models.ShareLinkExpired, true
+	case errors.Is(err, ErrShareLinkExhausted):
+		return models.ShareLinkLimitReached, true
+	case errors.Is(err, ErrShareLinkUnavailable):
+		return models.ShareLinkUnavailable, true
+	}
+	return "", false
+}
+
+func (s *ShareLinkService) recordAccess(ctx context.Context, link *models.ShareLink, visitor models.ShareLinkVisitor, outcome models.ShareLinkOutcome) {
+	err := s.accesses.Record(ctx, &models.ShareLinkAccess{
+		LinkID:           link.ID.Hex(),
+		AttachmentID:     link.AttachmentID,
+		ShareLinkVisitor: visitor,
+		Outcome:          outcome,
+	})
+	if err != nil {
+		log.Printf("Failed to record access to share link %s: %v", link.ID.Hex(), err)
+	}
+}
+
+// ── Management ──
+
+// Get returns a share link by its ID or its code.
+func (s *ShareLinkService) Get(ctx context.Context, idOrCode string) (*models.ShareLink, error) {
+	if primitive.IsValidObjectID(idOrCode) {
+		return s.ext.GetShareLink(ctx, idOrCode)
+	}
+	return s.ext.GetShareLinkByCode(ctx, idOrCode)
+}
+
+// Analytics summarises a link's access history over the last days days.
+func (s *ShareLinkService) Analytics(ctx context.Context, link *models.ShareLink, days, recent int) (*models.ShareLinkAnalytics, error) {
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+	analytics, err := s.accesses.Analytics(ctx, link.ID.Hex(), since, recent)
+	if err != nil {
+		return nil, err
+	}
+	analytics.Link = link
+	return analytics, nil
+}
+
+// Revoke deactivates a link so it can no longer be resolved.
+func (s *ShareLinkService) Revoke(ctx context.Context, link *models.ShareLink, revokedBy string) error {
+	return s.ext.DeactivateShareLink(ctx, link.ID.Hex(), revokedBy)
+}
+
+func (s *ShareLinkService) checkPassword(ctx context.Context, link *models.ShareLink, password, client string) error {
+	if password == "" {
+		return ErrShareLinkPassword
+	}
+	key := link.ID.Hex() + "|" + client
+	window := s.cfg.ShareLinkAttemptWindow
+	failures, resetAt, err := s.attempts.Failures(ctx, key, window)
+	if err != nil {
+		return err
+	}
+	if failures >= s.cfg.ShareLinkMaxAttempts {
+		return &TooManyAttemptsError{RetryAfter: time.Until(resetAt)}
+	}
+
+	if passwordMatches(link.Password, password) {
+		if !isBcryptHash(link.Password) {
+			s.upgradePassword(ctx, link, password)
+		}
+		return nil
+	}
+	failures, resetAt, err = s.attempts.RecordFailure(ctx, key, window)
+	if err != nil {
+		return err
+	}
+	if failures >= s.cfg.ShareLinkMaxAttempts {
+		return &TooManyAttemptsError{RetryAfter: time.Until(resetAt)}
+	}
+	return ErrShareLinkBadPassword
+}
+
+// passwordMatches compares against a bcrypt hash, or against a plaintext
+// password stored before links were hashed.
+func passwordMatches(stored, password string) bool {
+	if isBcryptHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+func isBcryptHash(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+// upgradePassword replaces a legacy plaintext password with its hash.
+func (s *ShareLinkService) upgradePassword(ctx context.Context, link *models.ShareLink, password string) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err == nil {
+		err = s.ext.SetShareLinkPassword(ctx, link.ID, string(hash))
+	}
+	if err != nil {
+		log.Printf("Failed to hash legacy password of share link %s: %v", link.ID.Hex(), err)
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+)
+
+const shareSweepBatch = 500
+
+// ShareSweeper removes user-to-user shares once they expire and announces
+// each one via attachments.share_expired, recorded in the outbox with the
+// delete. Reads already ignore expired shares, so the sweeper only has to
+// catch up eventually.
+type ShareSweeper struct {
+	ext *repository.ExtendedRepository
+}
+
+func NewShareSweeper(ext *repository.ExtendedRepository) *ShareSweeper {
+	return &ShareSweeper{ext: ext}
+}
+
+// Sweep removes every share that has expired by now.
+func (s *ShareSweeper) Sweep(ctx context.Context) (int, error) {
+	now := time.Now()
+	total := 0
+	for {
+		expired, err := s.ext.ListExpiredShares(ctx, now, shareSweepBatch)
+		if err != nil {
+			return total, err
+		}
+		workspaces, err := s.workspaces(ctx, expired)
+		if err != nil {
+			return total, err
+		}
+		for _, share := range expired {
+			events := WithEvents(ctx, &models.AttachmentShareExpired{
+				AttachmentRef: models.AttachmentRef{AttachmentID: share.AttachmentID, WorkspaceID: workspaces[share.AttachmentID]},
+				ShareID:       share.ID.Hex(),
+				SharedBy:      share.SharedBy,
+				SharedWith:    share.SharedWith,
+				Permission:    share.Permission,
+				ExpiresAt:     *share.ExpiresAt,
+			})
+			deleted, err := s.ext.DeleteExpiredShare(events, share.ID, now)
+			if err != nil {
+				return total, err
+			}
+			if deleted {
+				total++
+			}
+		}
+		if len(expired) < shareSweepBatch {
+			return total, nil
+		}
+	}
+}
+
+// workspaces maps the attachments the shares are for to their workspaces.
+func (s *ShareSweeper) workspaces(ctx context.Context, shares []*models.AttachmentShare) (map[string]string, error) {
+	ids := make([]string, 0, len(shares))
+	for _, share := range shares {
+		ids = append(ids, share.AttachmentID)
+	}
+	attachments, err := s.ext.FindAttachmentsByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	workspaces := make(map[string]string, len(attachments))
+	for _, a := range attachments {
+		workspaces[a.ID.Hex()] = a.WorkspaceID
+	}
+	return workspaces, nil
+}
+
+// Run sweeps expired shares every interval until ctx is done.
+func (s *ShareSweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := s.Sweep(ctx); err != nil {
+				log.Printf("Share sweeper failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Share sweeper revoked %d expired shares", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+	"attachment-service/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	trashPurgeBatch = 500
+	// heldPurgeDelay is how long the purge of a held attachment is put off
+	// before the hold is checked again.
+	heldPurgeDelay = 24 * time.Hour
+	// purgeClaimTimeout is how long a purge that claimed an attachment has
+	// to finish before another one may retry it.
+	purgeClaimTimeout = time.Hour
+)
+
+// TrashPurger removes the stored content of trashed attachments once their
+// grace period is over: the object itself, its versions and its previews.
+// The attachment stays behind as a tombstone and can no longer be restored.
+// Attachments under legal hold are left in the trash and checked again
+// later.
+type TrashPurger struct {
+	repo    *repository.TrashRepository
+	holds   *LegalHoldService
+	storage storage.Storage
+}
+
+func NewTrashPurger(repo *repository.TrashRepository, holds *LegalHoldService, storage storage.Storage) *TrashPurger {
+	return &TrashPurger{repo: repo, holds: holds, storage: storage}
+}
+
+// Purge purges the trashed attachments whose purge is due and returns how
+// many it purged. Each is claimed before its content is deleted, so it
+// cannot be restored meanwhile.
+func (p *TrashPurger) Purge(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		now := time.Now()
+		staleBefore := now.Add(-purgeClaimTimeout)
+		due, err := p.repo.DuePurges(ctx, now, staleBefore, trashPurgeBatch)
+		if err != nil {
+			return total, err
+		}
+		allowed, err := p.unheld(ctx, due, now.Add(heldPurgeDelay))
+		if err != nil {
+			return total, err
+		}
+		purged := 0
+		for _, a := range allowed {
+			claimed, err := p.repo.Claim(ctx, a.ID, now, staleBefore)
+			if err != nil {
+				return total, err
+			}
+			if !claimed || !p.deleteContent(ctx, a) {
+				// Restored or claimed meanwhile, or left claimed to be
+				// retried once the claim lapses
+				continue
+			}
+			if err := p.repo.MarkPurged(ctx, a.ID); err != nil {
+				return total, err
+			}
+			purged++
+		}
+		total += purged
+		if len(due) < trashPurgeBatch || purged == 0 {
+			return total, nil
+		}
+	}
+}
+
+// unheld returns the attachments in due no legal hold covers, putting off
+// the purge of the others until retryAt.
+func (p *TrashPurger) unheld(ctx context.Context, due []*models.Attachment, retryAt time.Time) ([]*models.Attachment, error) {
+	held, err := p.holds.Held(ctx, models.HoldOpPurge, "trash purge", due...)
+	if err != nil || len(held) == 0 {
+		return due, err
+	}
+	isHeld := make(map[primitive.ObjectID]bool, len(held))
+	for _, a := range held {
+		isHeld[a.ID] = true
+	}
+	if err := p.repo.Postpone(ctx, idsOf(held), retryAt); err != nil {
+		return nil, err
+	}
+	allowed := make([]*models.Attachment, 0, len(due)-len(held))
+	for _, a := range due {
+		if !isHeld[a.ID] {
+			allowed = append(allowed, a)
+		}
+	}
+	return allowed, nil
+}
+
+// deleteContent removes an attachment's object, versions and previews from
+// storage, and reports whether all of them are gone.
+func (p *TrashPurger) deleteContent(ctx context.Context, a *models.Attachment) bool {
+	paths, err := p.repo.StoredPaths(ctx, a.ID.Hex())
+	if err != nil {
+		log.Printf("Failed to list stored content of %s: %v", a.ID.Hex(), err)
+		return false
+	}
+	return deleteObjects(ctx, p.storage, append([]string{a.StoragePath}, paths...))
+}
+
+// deleteObjects removes objects from storage, logging failures, and
+// reports whether all of them are gone.
+func deleteObjects(ctx context.Context, store storage.Storage, paths []string) bool {
+	ok := true
+	for _, path := range paths {
+		if err := store.Delete(ctx, path); err != nil {
+			log.Printf("Failed to purge %s: %v", path, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// Run purges due attachments every interval until ctx is done.
+func (p *TrashPurger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := p.Purge(ctx); err != nil {
+				log.Printf("Trash purge failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Purged %d deleted attachments", n)
+			}
+		}
+	}
+}
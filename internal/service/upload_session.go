@@ -0,0 +1,424 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+	"attachment-service/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNotSessionOwner   = errors.New("unauthorized")
+	ErrSessionClosed     = errors.New("upload session is no longer open")
+	ErrSessionIncomplete = errors.New("upload session has files that are not uploaded")
+)
+
+// maxSessionFiles caps a session when the policy sets no per-message limit
+const maxSessionFiles = 100
+
+// UploadSessionService runs batch uploads: every file of a multi-file message
+// is initiated in one call, and the attachments only become ready and linked
+// to the message when the whole session completes.
+type UploadSessionService struct {
+	sessions    *repository.UploadSessionRepository
+	attachments *AttachmentService
+}
+
+func NewUploadSessionService(sessions *repository.UploadSessionRepository, attachments *AttachmentService) *UploadSessionService {
+	return &UploadSessionService{
+		sessions:    sessions,
+		attachments: attachments,
+	}
+}
+
+// Create validates every file against the workspace policy, reserves quota
+// and presigns an upload URL for each. If any file fails, the ones already
+// initiated are abandoned and no session is created.
+func (s *UploadSessionService) Create(ctx context.Context, req *models.CreateUploadSessionRequest) (*models.UploadSession, error) {
+	policy, err := s.attachments.Policy(ctx, req.WorkspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve upload policy: %w", err)
+	}
+	if len(req.Files) > maxSessionFiles {
+		return nil, fmt.Errorf("too many files for session: max %d", maxSessionFiles)
+	}
+	for i, f := range req.Files {
+		if err := checkFile(policy, f.MimeType, f.Size); err != nil {
+			return nil, fmt.Errorf("file %d (%s): %w", i, f.FileName, err)
+		}
+	}
+	if err := s.attachments.checkMessageCapacity(ctx, policy, req.MessageID, len(req.Files)); err != nil {
+		return nil, err
+	}
+	expiresAt, err := expiryFor(policy, req.ExpiresIn, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	session := &models.UploadSession{
+		ID:          primitive.NewObjectID(),
+		UserID:      req.UserID,
+		WorkspaceID: req.WorkspaceID,
+		ChannelID:   req.ChannelID,
+		MessageID:   req.MessageID,
+		Status:      models.SessionOpen,
+		ExpiresAt:   time.Now().Add(s.attachments.cfg.UploadReservationTTL),
+	}
+
+	var initiated []*models.Attachment
+	rollback := func() {
+		for _, a := range initiated {
+			s.attachments.abandonUpload(ctx, a, "session_failed")
+		}
+	}
+	for _, f := range req.Files {
+		resp, err := s.attachments.initiateUpload(ctx, &models.InitiateUploadRequest{
+			UserID:      req.UserID,
+			WorkspaceID: req.WorkspaceID,
+			ChannelID:   req.ChannelID,
+			FileName:    f.FileName,
+			MimeType:    f.MimeType,
+			Size:        f.Size,
+		}, session.ID.Hex(), expiresAt)
+		if err != nil {
+			rollback()
+			return nil, fmt.Errorf("%s: %w", f.FileName, err)
+		}
+		initiated = append(initiated, resp.Attachment)
+		session.TotalBytes += f.Size
+		session.Files = append(session.Files, models.UploadSessionFile{
+			AttachmentID: resp.Attachment.ID.Hex(),
+			FileName:     f.FileName,
+			MimeType:     f.MimeType,
+			Size:         f.Size,
+			Status:       models.SessionFilePending,
+			UploadURL:    resp.UploadURL,
+		})
+	}
+
+	if err := s.sessions.Create(ctx, session); err != nil {
+		rollback()
+		return nil, fmt.Errorf("failed to create upload session: %w", err)
+	}
+	return session, nil
+}
+
+// Get returns the session with each file's progress. Files the client has
+// not reported on are checked against storage.
+func (s *UploadSessionService) Get(ctx context.Context, id string) (*models.UploadSession, error) {
+	session, err := s.sessions.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status == models.SessionOpen && time.Now().After(session.ExpiresAt) {
+		s.expire(ctx, session)
+		return s.withProgress(session), nil
+	}
+	if session.Status == models.SessionOpen {
+		if changed, _ := s.checkUploads(ctx, session); changed {
+			if err := s.sessions.SetFiles(ctx, session.ID, session.Files); err != nil {
+				log.Printf("Failed to record upload session %s progress: %v", id, err)
+			}
+		}
+	}
+	return s.withProgress(session), nil
+}
+
+// UpdateProgress records client-reported progress for one file.
+func (s *UploadSessionService) UpdateProgress(ctx context.Context, id, attachmentID, userID string, uploadedBytes int64) (*models.UploadSession, error) {
+	session, err := s.sessions.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != userID {
+		return nil, ErrNotSessionOwner
+	}
+	if session.Status != models.SessionOpen {
+		return nil, ErrSessionClosed
+	}
+
+	for i := range session.Files {
+		f := &session.Files[i]
+		if f.AttachmentID != attachmentID {
+			continue
+		}
+		f.UploadedBytes = min(max(uploadedBytes, 0), f.Size)
+		f.Status = models.SessionFilePending
+		if f.UploadedBytes > 0 {
+			f.Status = models.SessionFileUploading
+		}
+		updated, err := s.sessions.UpdateFile(ctx, session.ID, attachmentID, f.UploadedBytes, f.Status)
+		if err != nil {
+			return nil, err
+		}
+		if !updated {
+			return nil, ErrSessionClosed
+		}
+		return s.withProgress(session), nil
+	}
+	return nil, fmt.Errorf("attachment %s is not part of upload session %s", attachmentID, id)
+}
+
+// Complete verifies that every file was uploaded and then, in one
+// transaction, marks all attachments ready and links them to the message.
+// If any file is missing nothing changes: the session stays open and is
+// returned along with ErrSessionIncomplete.
+func (s *UploadSessionService) Complete(ctx context.Context, id, userID, messageID string) (*models.UploadSession, error) {
+	session, err := s.sessions.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != userID {
+		return nil, ErrNotSessionOwner
+	}
+	if session.Status == models.SessionCompleted {
+		return s.withProgress(session), nil
+	}
+	if session.Status != models.SessionOpen {
+		return nil, ErrSessionClosed
+	}
+	if time.Now().After(session.ExpiresAt) {
+		s.expire(ctx, session)
+		return nil, ErrSessionClosed
+	}
+
+	if messageID == "" {
+		messageID = session.MessageID
+	}
+	if messageID == "" {
+		return nil, fmt.Errorf("message_id is required")
+	}
+	if session.MessageID != "" && messageID != session.MessageID {
+		return nil, fmt.Errorf("upload session is for message %s", session.MessageID)
+	}
+
+	claimed, err := s.sessions.Transition(ctx, session.ID, models.SessionOpen, models.SessionCompleting, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		// Completed, cancelled or being completed concurrently
+		return s.Complete(ctx, id, userID, messageID)
+	}
+	reopen := func() {
+		if _, err := s.sessions.Transition(ctx, session.ID, models.SessionCompleting, models.SessionOpen, bson.M{"files": session.Files}); err != nil {
+			log.Printf("Failed to reopen upload session %s: %v", id, err)
+		}
+	}
+
+	if _, complete := s.checkUploads(ctx, session); !complete {
+		reopen()
+		return s.withProgress(session), ErrSessionIncomplete
+	}
+
+	policy, err := s.attachments.Policy(ctx, session.WorkspaceID)
+	if err == nil {
+		err = s.attachments.checkMessageCapacity(ctx, policy, messageID, len(session.Files))
+	}
+	if err != nil {
+		reopen()
+		return nil, err
+	}
+
+	// Link everything to the message in one go
+	updates := make(map[string]bson.M, len(session.Files))
+	attachments := make([]*models.Attachment, 0, len(session.Files))
+	events := make([]models.EventData, 0, 2*len(session.Files))
+	for _, f := range session.Files {
+		a, err := s.attachments.repo.GetByID(ctx, f.AttachmentID)
+		if err != nil {
+			reopen()
+			return nil, err
+		}
+		a.Status = models.StatusReady
+		a.MessageID = messageID
+		a.URL = fmt.Sprintf("%s/files/%s", s.attachments.cfg.CDNBaseURL, a.StoragePath)
+		attachments = append(attachments, a)
+		updates[f.AttachmentID] = bson.M{
+			"status":     a.Status,
+			"message_id": a.MessageID,
+			"url":        a.URL,
+		}
+		events = append(events, uploadedEvent(a), readyEvent(a))
+	}
+	linked, err := s.attachments.repo.UpdateAllIfStatus(WithEvents(ctx, events...), updates, models.StatusPending)
+	if err != nil {
+		reopen()
+		return nil, err
+	}
+	if !linked {
+		// An upload was reaped or deleted underneath the session
+		for i := range session.Files {
+			f := &session.Files[i]
+			if a, err := s.attachments.repo.GetByID(ctx, f.AttachmentID); err != nil || a.Status != models.StatusPending {
+				f.Status = models.SessionFileFailed
+				f.Error = "upload is no longer pending"
+			}
+		}
+		reopen()
+		return s.withProgress(session), ErrSessionIncomplete
+	}
+
+	now := time.Now()
+	for i := range session.Files {
+		session.Files[i].Status = models.SessionFileReady
+	}
+	session.MessageID = messageID
+	session.CompletedAt = &now
+	if _, err := s.sessions.Transition(ctx, session.ID, models.SessionCompleting, models.SessionCompleted, bson.M{
+		"files":        session.Files,
+		"message_id":   messageID,
+		"completed_at": now,
+	}); err != nil {
+		log.Printf("Failed to mark upload session %s completed: %v", id, err)
+	}
+	session.Status = models.SessionCompleted
+
+	s.attachments.quotas.CheckThresholds(ctx, session.UserID, session.WorkspaceID)
+
+	return s.withProgress(session), nil
+}
+
+// Cancel closes an open session and abandons its uploads.
+func (s *UploadSessionService) Cancel(ctx context.Context, id, userID string) (*models.UploadSession, error) {
+	session, err := s.sessions.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != userID {
+		return nil, ErrNotSessionOwner
+	}
+	if session.Status == models.SessionCancelled {
+		return s.withProgress(session), nil
+	}
+
+	cancelled, err := s.sessions.Transition(ctx, session.ID, models.SessionOpen, models.SessionCancelled, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !cancelled {
+		return nil, ErrSessionClosed
+	}
+	session.Status = models.SessionCancelled
+	s.abandonFiles(ctx, session, "session_cancelled")
+	return s.withProgress(session), nil
+}
+
+// ReapExpiredSessions expires open sessions that outlived
+// cfg.UploadReservationTTL and abandons their uploads.
+func (s *UploadSessionService) ReapExpiredSessions(ctx context.Context) (int, error) {
+	expired, err := s.sessions.ListExpired(ctx, time.Now(), 100)
+	if err != nil {
+		return 0, err
+	}
+	reaped := 0
+	for _, session := range expired {
+		if s.expire(ctx, session) {
+			reaped++
+		}
+	}
+	return reaped, nil
+}
+
+// RunSessionReaper calls ReapExpiredSessions every interval until ctx is done.
+func (s *UploadSessionService) RunSessionReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := s.ReapExpiredSessions(ctx); err != nil {
+				log.Printf("Upload session reaper failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Upload session reaper expired %d sessions", n)
+			}
+		}
+	}
+}
+
+func (s *UploadSessionService) expire(ctx context.Context, session *models.UploadSession) bool {
+	expired, err := s.sessions.Transition(ctx, session.ID, models.SessionOpen, models.SessionExpired, nil)
+	if err != nil {
+		log.Printf("Failed to expire upload session %s: %v", session.ID.Hex(), err)
+		return false
+	}
+	if !expired {
+		return false
+	}
+	session.Status = models.SessionExpired
+	s.abandonFiles(ctx, session, "session_expired")
+	return true
+}
+
+func (s *UploadSessionService) abandonFiles(ctx context.Context, session *models.UploadSession, reason string) {
+	for i := range session.Files {
+		f := &session.Files[i]
+		a, err := s.attachments.repo.GetByID(ctx, f.AttachmentID)
+		if err != nil {
+			log.Printf("Failed to load upload session %s attachment %s: %v", session.ID.Hex(), f.AttachmentID, err)
+			continue
+		}
+		s.attachments.abandonUpload(ctx, a, reason)
+		f.Status = models.SessionFileFailed
+	}
+	if err := s.sessions.SetFiles(ctx, session.ID, session.Files); err != nil {
+		log.Printf("Failed to record upload session %s files: %v", session.ID.Hex(), err)
+	}
+}
+
+// checkUploads looks up files not yet known to be uploaded in storage. It
+// reports whether any file changed and whether every file is uploaded with
+// its declared size.
+func (s *UploadSessionService) checkUploads(ctx context.Context, session *models.UploadSession) (changed, complete bool) {
+	complete = true
+	for i := range session.Files {
+		f := &session.Files[i]
+		if f.Status == models.SessionFileReady {
+			continue
+		}
+		a, err := s.attachments.repo.GetByID(ctx, f.AttachmentID)
+		if err != nil {
+			complete = false
+			continue
+		}
+		info, err := s.attachments.storage.Stat(ctx, a.StoragePath)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			complete = false
+			if f.Status == models.SessionFileUploaded {
+				f.Status, f.UploadedBytes, changed = models.SessionFilePending, 0, true
+			}
+		case err != nil:
+			complete = false
+		case info.Size != f.Size:
+			complete = false
+			msg := fmt.Sprintf("uploaded %d bytes, expected %d", info.Size, f.Size)
+			if f.Status != models.SessionFileFailed || f.Error != msg {
+				f.Status, f.Error, changed = models.SessionFileFailed, msg, true
+			}
+		default:
+			if f.Status != models.SessionFileUploaded {
+				f.Status, f.UploadedBytes, f.Error, changed = models.SessionFileUploaded, f.Size, "", true
+			}
+		}
+	}
+	return changed, complete
+}
+
+func (s *UploadSessionService) withProgress(session *models.UploadSession) *models.UploadSession {
+	session.UploadedBytes = 0
+	for _, f := range session.Files {
+		session.UploadedBytes += f.UploadedBytes
+	}
+	return session
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+)
+
+// UsageReconciler periodically recomputes the usage counters from the
+// attachments collection and corrects any drift.
+type UsageReconciler struct {
+	repo *repository.UsageRepository
+	mu   sync.Mutex
+}
+
+func NewUsageReconciler(repo *repository.UsageRepository) *UsageReconciler {
+	return &UsageReconciler{repo: repo}
+}
+
+// Reconcile runs one pass. With dryRun set, drift is reported but not fixed.
+// Passes never overlap.
+func (r *UsageReconciler) Reconcile(ctx context.Context, dryRun bool) (*models.ReconcileReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report, err := r.repo.Reconcile(ctx, !dryRun)
+	if err != nil {
+		return nil, err
+	}
+	if report.DriftCount > 0 {
+		log.Printf("Usage reconciliation found %d drifted fields across %d counters (fixed: %v)",
+			report.DriftCount, report.Counters, report.Fixed)
+	}
+	return report, nil
+}
+
+// Run reconciles once immediately and then every interval until ctx is done.
+func (r *UsageReconciler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Reconcile(ctx, false); err != nil && ctx.Err() == nil {
+			log.Printf("Usage reconciliation failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RecordVersion stores v as a new version of a and announces it via
+// attachments.version_added, recorded in the outbox with the insert.
+func RecordVersion(ctx context.Context, ext *repository.ExtendedRepository, a *models.Attachment, v *models.AttachmentVersion) error {
+	if v.ID.IsZero() {
+		v.ID = primitive.NewObjectID()
+	}
+	v.AttachmentID = a.ID.Hex()
+	return ext.CreateVersion(WithEvents(ctx, &models.AttachmentVersionAdded{
+		AttachmentRef: models.RefOf(a),
+		VersionID:     v.ID.Hex(),
+		Version:       v.VersionNum,
+		Size:          v.Size,
+	}), v)
+}
+
+// RecordScan stores the scan result s for a and announces it via
+// attachments.scanned, recorded in the outbox with the insert.
+func RecordScan(ctx context.Context, ext *repository.ExtendedRepository, a *models.Attachment, s *models.ScanResult) error {
+	if s.ID.IsZero() {
+		s.ID = primitive.NewObjectID()
+	}
+	s.AttachmentID = a.ID.Hex()
+	return ext.CreateScanResult(WithEvents(ctx, &models.AttachmentScanned{
+		AttachmentRef: models.RefOf(a),
+		ScanID:        s.ID.Hex(),
+		Status:        s.Status,
+		Engine:        s.Engine,
+		Details:       s.Details,
+	}), s)
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestVersionAndScanWritesRecordEvents(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	a := &models.Attachment{ID: primitive.NewObjectID(), WorkspaceID: "ws-a"}
+
+	mt.Run("write without its event", func(mt *mtest.T) {
+		ext := repository.NewExtendedRepository(mt.Client, "test")
+		mt.ClearEvents()
+
+		if err := ext.CreateVersion(context.Background(), &models.AttachmentVersion{AttachmentID: a.ID.Hex()}); !errors.Is(err, repository.ErrEventMissing) {
+			mt.Fatalf("CreateVersion = %v, want ErrEventMissing", err)
+		}
+		if err := ext.CreateScanResult(context.Background(), &models.ScanResult{AttachmentID: a.ID.Hex()}); !errors.Is(err, repository.ErrEventMissing) {
+			mt.Fatalf("CreateScanResult = %v, want ErrEventMissing", err)
+		}
+		if events := mt.GetAllStartedEvents(); len(events) != 0 {
+			mt.Fatalf("sent %d commands, want none", len(events))
+		}
+	})
+
+	for name, record := range map[string]func(context.Context, *repository.ExtendedRepository) error{
+		models.EventVersionAdded: func(ctx context.Context, ext *repository.ExtendedRepository) error {
+			return RecordVersion(ctx, ext, a, &models.AttachmentVersion{VersionNum: 2, Size: 10})
+		},
+		models.EventScanned: func(ctx context.Context, ext *repository.ExtendedRepository) error {
+			return RecordScan(ctx, ext, a, &models.ScanResult{Status: "clean", Engine: "clamav"})
+		},
+	} {
+		mt.Run(name, func(mt *mtest.T) {
+			ext := repository.NewExtendedRepository(mt.Client, "test")
+			mt.ClearEvents()
+			mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+			if err := record(context.Background(), ext); err != nil {
+				mt.Fatalf("record: %v", err)
+			}
+			var topics []string
+			for _, e := range mt.GetAllStartedEvents() {
+				if e.CommandName != "insert" || e.Command.Lookup("insert").StringValue() != "outbox" {
+					continue
+				}
+				docs, _ := e.Command.Lookup("documents").Array().Values()
+				for _, d := range docs {
+					topics = append(topics, d.Document().Lookup("topic").StringValue())
+				}
+			}
+			if len(topics) != 1 || topics[0] != name {
+				mt.Fatalf("outbox topics = %v, want [%s]", topics, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"attachment-service/internal/config"
+	"attachment-service/internal/kafka"
+	"attachment-service/internal/models"
+	"attachment-service/internal/repository"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrWebhookURL       = errors.New("webhook URL must be an absolute http(s) URL")
+	ErrWebhookAddress   = errors.New("webhook URL must not resolve to a private, loopback or link-local address")
+)
+
+// Headers sent with every webhook delivery
+const (
+	HeaderWebhookID        = "X-Webhook-ID"
+	HeaderWebhookEvent     = "X-Webhook-Event"
+	HeaderWebhookDelivery  = "X-Webhook-Delivery"
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp"
+	HeaderWebhookSignature = "X-Webhook-Signature"
+)
+
+const (
+	webhookLease       = 2 * time.Minute
+	webhookMaxBackoff  = time.Hour
+	webhookConcurrency = 8
+)
+
+// WebhookDispatcher delivers lifecycle events to the webhooks subscribed
+// to them. Events consumed from Kafka are queued as one delivery per
+// matching webhook; queued deliveries are POSTed by Run and retried with
+// backoff up to cfg.WebhookMaxAttempts times. A webhook is disabled after
+// cfg.WebhookDisableAfter failed attempts in a row.
+//
+// Each request body is the event's CloudEvents JSON. It is signed with the
+// webhook's secret: X-Webhook-Signature is "sha256=" and the hex
+// HMAC-SHA256 of the X-Webhook-Timestamp value, a ".", and the body. For
+// cfg.WebhookSecretGrace after the secret is rotated, a second,
+// comma-separated signature is made with the previous secret.
+type WebhookDispatcher struct {
+	repo   *repository.WebhookRepository
+	client *http.Client
+	cfg    *config.Config
+	// wake starts a dispatch pass without waiting for the next tick
+	wake chan struct{}
+}
+
+func NewWebhookDispatcher(repo *repository.WebhookRepository, cfg *config.Config) *WebhookDispatcher {
+	// Every address is checked as it is dialled, so a hostname that
+	// resolved to a public address when the webhook was saved can't be
+	// rebound to an internal one later. Proxies would hide the address.
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: webhookDialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &WebhookDispatcher{
+		repo: repo,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.WebhookTimeout,
+			// A redirect could point a delivery anywhere; report it instead
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		cfg:  cfg,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Handlers returns the consumer handler for each lifecycle event topic.
+func (d *WebhookDispatcher) Handlers() map[string]kafka.Handler {
+	handlers := make(map[string]kafka.Handler, len(models.EventTypes))
+	for _, t := range models.EventTypes {
+		handlers[t] = d.Enqueue
+	}
+	return handlers
+}
+
+// Enqueue queues a consumed event for each webhook subscribed to it.
+// Redelivered events are queued only once per webhook.
+func (d *WebhookDispatcher) Enqueue(ctx context.Context, msg *kafka.Message) error {
+	var event struct {
+		ID          string `json:"id"`
+		Type        string `json:"type"`
+		WorkspaceID string `json:"workspaceid"`
+	}
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		return kafka.Permanent(err)
+	}
+	if event.ID == "" || event.WorkspaceID == "" {
+		return nil
+	}
+
+	webhooks, err := d.repo.ListActive(ctx, event.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var deliveries []*models.WebhookDelivery
+	for _, w := range webhooks {
+		if !w.Subscribes(event.Type) {
+			continue
+		}
+		deliveries = append(deliveries, &models.WebhookDelivery{
+			WebhookID:     w.ID.Hex(),
+			WorkspaceID:   event.WorkspaceID,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			Payload:       string(msg.Value),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	if err := d.repo.Enqueue(ctx, deliveries); err != nil {
+		return err
+	}
+	if len(deliveries) > 0 {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Dispatch attempts every due delivery, a few at a time, and returns how
+// many it attempted.
+func (d *WebhookDispatcher) Dispatch(ctx context.Context) (int, error) {
+	sem := make(chan struct{}, webhookConcurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	attempted := 0
+	for {
+		delivery, err := d.repo.ClaimDue(ctx, time.Now(), webhookLease)
+		if err != nil || delivery == nil {
+			return attempted, err
+		}
+		attempted++
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			if err := d.attempt(ctx, delivery); err != nil {
+				log.Printf("Webhook delivery %s failed to record: %v", delivery.ID.Hex(), err)
+			}
+		}()
+	}
+}
+
+// attempt POSTs a claimed delivery and records the outcome.
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) error {
+	webhook, err := d.repo.Get(ctx, delivery.WorkspaceID, delivery.WebhookID)
+	if err != nil {
+		return d.repo.RecordAttempt(ctx, delivery.ID, &models.WebhookResult{Error: "webhook not found"}, nil, d.cfg.WebhookLogTTL)
+	}
+	if !webhook.IsActive {
+		return d.repo.RecordAttempt(ctx, delivery.ID, &models.WebhookResult{Error: "webhook disabled"}, nil, d.cfg.WebhookLogTTL)
+	}
+
+	result := d.post(ctx, webhook, delivery.ID.Hex(), delivery.EventType, []byte(delivery.Payload))
+	if ctx.Err() != nil {
+		// Shutting down; the claim lapses and the delivery is retried
+		return nil
+	}
+	d.logAttempt(ctx, webhook, delivery, result, false)
+	if result.OK() {
+		if err := d.repo.RecordSuccess(ctx, webhook.ID); err != nil {
+			return err
+		}
+		return d.repo.RecordAttempt(ctx, delivery.ID, result, nil, d.cfg.WebhookLogTTL)
+	}
+
+	var next *time.Time
+	if attempts := delivery.Attempts + 1; attempts < d.cfg.WebhookMaxAttempts {
+		at := time.Now().Add(webhookBackoff(attempts))
+		next = &at
+	}
+	if err := d.repo.RecordAttempt(ctx, delivery.ID, result, next, d.cfg.WebhookLogTTL); err != nil {
+		return err
+	}
+	disabled, err := d.repo.RecordFailure(ctx, webhook.ID, result.Error, d.cfg.WebhookDisableAfter)
+	if err != nil || !disabled {
+		return err
+	}
+	log.Printf("Disabled webhook %s after %d failed deliveries", webhook.ID.Hex(), d.cfg.WebhookDisableAfter)
+	return d.repo.FailPending(ctx, webhook.ID.Hex(), "webhook disabled", d.cfg.WebhookLogTTL)
+}
+
+// logAttempt adds an attempt to the delivery log. Logging is best effort.
+func (d *WebhookDispatcher) logAttempt(ctx context.Context, webhook *models.AttachmentWebhook, delivery *models.WebhookDelivery, result *models.WebhookResult, manual bool) {
+	err := d.repo.LogAttempt(ctx, &models.WebhookAttempt{
+		DeliveryID:  delivery.ID.Hex(),
+		WebhookID:   delivery.WebhookID,
+		WorkspaceID: delivery.WorkspaceID,
+		EventType:   delivery.EventType,
+		Attempt:     delivery.Attempts + 1,
+		Manual:      manual,
+		URL:         webhook.URL,
+		RequestBody: delivery.Payload,
+		StatusCode:  result.StatusCode,
+		DurationMs:  result.DurationMs,
+		Error:       result.Error,
+		ExpiresAt:   time.Now().Add(d.cfg.WebhookLogTTL),
+	})
+	if err != nil {
+		log.Printf("Failed to log webhook delivery %s: %v", delivery.ID.Hex(), err)
+	}
+}
+
+// webhookBackoff is the delay after a delivery has failed attempts times:
+// doubling from 30 seconds up to webhookMaxBackoff.
+func webhookBackoff(attempts int) time.Duration {
+	if attempts > 7 {
+		return webhookMaxBackoff
+	}
+	return min(30*time.Second<<(attempts-1), webhookMaxBackoff)
+}
+
+// Test sends a test event to a webhook, whether or not it is enabled, and
+// returns the endpoint's response. Test deliveries are not retried and
+// don't count towards disabling the webhook.
+func (d *WebhookDispatcher) Test(ctx context.Context, webhook *models.AttachmentWebhook) (*models.WebhookResult, error) {
+	event := NewEvent(ctx, models.WebhookTest{WebhookID: webhook.ID.Hex(), WorkspaceID: webhook.WorkspaceID})
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return d.post(ctx, webhook, event.ID, event.Type, body), nil
+}
+
+// ValidateWebhookURL checks that raw is an absolute http(s) URL whose host
+// only resolves to public addresses.
+func ValidateWebhookURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return ErrWebhookURL
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("webhook URL host can't be resolved: %w", err)
+	}
+	for _, addr := range addrs {
+		if !webhookAddrAllowed(addr) {
+			return ErrWebhookAddress
+		}
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which some clouds use
+// for their metadata endpoints.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// webhookAddrAllowed reports whether deliveries may be sent to addr:
+// anything but loopback, private, link-local (cloud metadata included),
+// shared, multicast and unspecified addresses.
+func webhookAddrAllowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// webhookDialControl refuses connections to addresses deliveries may not
+// be sent to.
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !webhookAddrAllowed(addr) {
+		return ErrWebhookAddress
+	}
+	return nil
+}
+
+// post signs and sends one delivery.
+func (d *WebhookDispatcher) post(ctx context.Context, webhook *models.AttachmentWebhook, deliveryID, eventType string, body []byte) *models.WebhookResult {
+	if u, err := url.Parse(webhook.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return &models.WebhookResult{Error: ErrWebhookURL.Error()}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return &models.WebhookResult{Error: err.Error()}
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	req.Header.Set("User-Agent", "attachment-service-webhooks/1.0")
+	req.Header.Set(HeaderWebhookID, webhook.ID.Hex())
+	req.Header.Set(HeaderWebhookEvent, eventType)
+	req.Header.Set(HeaderWebhookDelivery, deliveryID)
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	// During a secret rotation grace period both secrets sign the body
+	var signatures []string
+	for _, secret := range webhook.Secrets(time.Now()) {
+		signatures = append(signatures, SignWebhook(secret, timestamp, body))
+	}
+	req.Header.Set(HeaderWebhookSignature, strings.Join(signatures, ","))
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	result := &models.WebhookResult{DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+	// Only the status is kept: the body is the endpoint's to keep
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result.Error = fmt.Sprintf("endpoint returned %d", resp.StatusCode)
+	}
+	return result
+}
+
+// SignWebhook returns the X-Webhook-Signature value for a body sent at
+// timestamp.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhook loads a webhook in the workspace.
+func (d *WebhookDispatcher) webhook(ctx context.Context, workspaceID, id string) (*models.AttachmentWebhook, error) {
+	w, err := d.repo.Get(ctx, workspaceID, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrWebhookNotFound
+	}
+	return w, err
+}
+
+// Deliveries lists a webhook's deliveries, newest first, optionally only
+// those with the given status.
+func (d *WebhookDispatcher) Deliveries(ctx context.Context, workspaceID, webhookID string, status models.WebhookDeliveryStatus, limit, offset int) ([]*models.WebhookDelivery, error) {
+	if _, err := d.webhook(ctx, workspaceID, webhookID); err != nil {
+		return nil, err
+	}
+	return d.repo.ListDeliveries(ctx, webhookID, status, limit, offset)
+}
+
+// Delivery returns a delivery with its payload and logged attempts.
+func (d *WebhookDispatcher) Delivery(ctx context.Context, workspaceID, webhookID, deliveryID string) (*models.WebhookDeliveryDetail, error) {
+	_, delivery, err := d.delivery(ctx, workspaceID, webhookID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	attempts, err := d.repo.ListAttempts(ctx, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.WebhookDeliveryDetail{WebhookDelivery: delivery, Payload: delivery.Payload, Log: attempts}, nil
+}
+
+func (d *WebhookDispatcher) delivery(ctx context.Context, workspaceID, webhookID, deliveryID string) (*models.AttachmentWebhook, *models.WebhookDelivery, error) {
+	webhook, err := d.webhook(ctx, workspaceID, webhookID)
+	if err != nil {
+		return nil, nil, err
+	}
+	delivery, err := d.repo.GetDelivery(ctx, webhookID, deliveryID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil, ErrDeliveryNotFound
+	}
+	return webhook, delivery, err
+}
+
+// Redeliver sends a delivery again now, whatever its status and whether or
+// not the webhook is enabled, and returns the endpoint's response. A
+// successful redelivery completes the delivery; a failed one leaves its
+// status and schedule as they were and doesn't count towards disabling
+// the webhook.
+func (d *WebhookDispatcher) Redeliver(ctx context.Context, workspaceID, webhookID, deliveryID string) (*models.WebhookResult, error) {
+	webhook, delivery, err := d.delivery(ctx, workspaceID, webhookID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := d.post(ctx, webhook, delivery.ID.Hex(), delivery.EventType, []byte(delivery.Payload))
+	d.logAttempt(ctx, webhook, delivery, result, true)
+
+	var next *time.Time
+	if delivery.Status == models.DeliveryPending {
+		next = &delivery.NextAttemptAt
+	}
+	if !result.OK() && next == nil {
+		// Already finished; keep it as it was
+		return result, nil
+	}
+	if result.OK() && webhook.IsActive {
+		if err := d.repo.RecordSuccess(ctx, webhook.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.repo.RecordAttempt(ctx, delivery.ID, result, next, d.cfg.WebhookLogTTL); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RotateSecret gives a webhook a new secret. Deliveries are also signed
+// with the old secret for cfg.WebhookSecretGrace so the receiver can
+// switch over without dropping any.
+func (d *WebhookDispatcher) RotateSecret(ctx context.Context, workspaceID, webhookID string) (*models.AttachmentWebhook, error) {
+	w, err := d.repo.RotateSecret(ctx, workspaceID, webhookID, uuid.New().String(), time.Now().Add(d.cfg.WebhookSecretGrace))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrWebhookNotFound
+	}
+	return w, err
+}
+
+// Run dispatches due deliveries every interval, or as soon as events are
+// queued, until ctx is done.
+func (d *WebhookDispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		if _, err := d.Dispatch(ctx); err != nil {
+			log.Printf("Webhook dispatch failed: %v", err)
+		}
+	}
+}
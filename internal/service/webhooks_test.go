@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"attachment-service/internal/config"
+	"attachment-service/internal/models"
+)
+
+func TestWebhookAddrAllowed(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.100.100.200", false},
+		{"fd00:ec2::254", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := webhookAddrAllowed(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("webhookAddrAllowed(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{"https://93.184.216.34/hook", nil},
+		{"ftp://93.184.216.34/hook", ErrWebhookURL},
+		{"/hook", ErrWebhookURL},
+		{"http://127.0.0.1:8080/hook", ErrWebhookAddress},
+		{"http://[::1]/hook", ErrWebhookAddress},
+		{"http://169.254.169.254/latest/meta-data/", ErrWebhookAddress},
+		{"https://10.0.0.5/hook", ErrWebhookAddress},
+	}
+	for _, tt := range tests {
+		if err := ValidateWebhookURL(context.Background(), tt.url); !errors.Is(err, tt.want) {
+			t.Errorf("ValidateWebhookURL(%q) = %v, want %v", tt.url, err, tt.want)
+		}
+	}
+}
+
+func TestWebhookPostRefusesInternalAddress(t *testing.T) {
+	reached := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer srv.Close()
+
+	// The URL passed validation once; the dial must still be refused
+	d := NewWebhookDispatcher(nil, &config.Config{WebhookTimeout: time.Second})
+	result := d.post(context.Background(), &models.AttachmentWebhook{URL: srv.URL, Secret: "s"}, "d1", "attachments.uploaded", []byte("{}"))
+	if reached {
+		t.Fatal("delivery reached a loopback endpoint")
+	}
+	if result.OK() || !strings.Contains(result.Error, ErrWebhookAddress.Error()) {
+		t.Fatalf("result = %+v, want the address refused", result)
+	}
+}
+
+func TestWebhookResultOmitsResponseBody(t *testing.T) {
+	d := NewWebhookDispatcher(nil, &config.Config{WebhookTimeout: time.Second})
+	// Reach the test server despite the dial check, as a public endpoint
+	d.client = http.DefaultClient
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("internal secrets"))
+	}))
+	defer srv.Close()
+
+	result := d.post(context.Background(), &models.AttachmentWebhook{URL: srv.URL, Secret: "s"}, "d1", "attachments.uploaded", []byte("{}"))
+	if result.StatusCode != http.StatusInternalServerError || result.Error != "endpoint returned 500" {
+		t.Fatalf("result = %+v, want only the status", result)
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ObjectReader is an io.ReadSeekCloser over a stored object. Nothing is
+// fetched until the first Read; each Seek to a new offset starts a fresh
+// ranged download, so http.ServeContent can serve Range requests without
+// reading the whole object.
+type ObjectReader struct {
+	ctx     context.Context
+	storage Storage
+	key     string
+	size    int64
+	pos     int64
+	body    io.ReadCloser
+}
+
+// NewObjectReader reads the object at key, whose size must be known.
+func NewObjectReader(ctx context.Context, storage Storage, key string, size int64) *ObjectReader {
+	return &ObjectReader{ctx: ctx, storage: storage, key: key, size: size}
+}
+
+func (r *ObjectReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.storage.DownloadRange(r.ctx, r.key, r.pos, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.size + offset
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+	if pos != r.pos && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+func (r *ObjectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
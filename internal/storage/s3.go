@@ -2,8 +2,10 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"time"
 
 	"attachment-service/internal/config"
@@ -12,14 +14,32 @@ import (
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+}
+
 type Storage interface {
 	Upload(ctx context.Context, key string, reader io.Reader, contentType string, size int64) error
 	Download(ctx context.Context, key string) (io.ReadCloser, error)
+	// DownloadRange reads length bytes from offset, or to the end of the
+	// object if length is negative.
+	DownloadRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
 	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
 	GetPresignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
 	GetPresignedUploadURL(ctx context.Context, key string, contentType string, expiry time.Duration) (string, error)
+	// SetStorageClass moves an object to another storage class in place.
+	SetStorageClass(ctx context.Context, key, class string) error
 }
 
 type S3Storage struct {
@@ -93,6 +113,26 @@ func (s *S3Storage) Download(ctx context.Context, key string) (io.ReadCloser, er
 	return output.Body, nil
 }
 
+func (s *S3Storage) DownloadRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(byteRange),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return output.Body, nil
+}
+
 func (s *S3Storage) Delete(ctx context.Context, key string) error {
 	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
@@ -101,6 +141,27 @@ func (s *S3Storage) Delete(ctx context.Context, key string) error {
 	return err
 }
 
+func (s *S3Storage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	output, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &notFound) || errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &ObjectInfo{
+		Size:         aws.ToInt64(output.ContentLength),
+		ContentType:  aws.ToString(output.ContentType),
+		ETag:         aws.ToString(output.ETag),
+		LastModified: aws.ToTime(output.LastModified),
+	}, nil
+}
+
 func (s *S3Storage) GetPresignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
 	presignResult, err := s.presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
@@ -123,3 +184,22 @@ func (s *S3Storage) GetPresignedUploadURL(ctx context.Context, key string, conte
 	}
 	return presignResult.URL, nil
 }
+
+// SetStorageClass copies the object onto itself with the new class,
+// keeping its metadata.
+func (s *S3Storage) SetStorageClass(ctx context.Context, key, class string) error {
+	_, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(url.PathEscape(s.bucket + "/" + key)),
+		StorageClass:      types.StorageClass(class),
+		MetadataDirective: types.MetadataDirectiveCopy,
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return ErrNotFound
+		}
+	}
+	return err
+}
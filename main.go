@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"maps"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"attachment-service/internal/api"
+	"attachment-service/internal/auth"
 	"attachment-service/internal/config"
 	"attachment-service/internal/kafka"
 	"attachment-service/internal/repository"
@@ -17,6 +25,9 @@ import (
 func main() {
 	cfg := config.Load()
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Initialize MongoDB repository
 	repo, err := repository.NewMongoRepository(cfg.MongoDBURL, cfg.DatabaseName)
 	if err != nil {
@@ -34,7 +45,7 @@ func main() {
 	}
 
 	// Initialize Kafka producer
-	producer, err := kafka.NewProducer(cfg.KafkaBrokers)
+	producer, err := kafka.NewProducer(cfg)
 	if err != nil {
 		log.Printf("Warning: Failed to connect Kafka producer: %v", err)
 	}
@@ -44,22 +55,128 @@ func main() {
 		}
 	}()
 
+	// Initialize workspace upload policies
+	policyRepo := repository.NewPolicyRepository(repo.Client(), cfg.DatabaseName)
+	policyResolver := service.NewPolicyResolver(policyRepo, cfg)
+
+	// Initialize usage counters and storage quotas
+	quotaService := service.NewQuotaService(repo.Usage(), producer, cfg)
+	usageReconciler := service.NewUsageReconciler(repo.Usage())
+
+	// Initialize idempotency key store
+	idempotencyRepo := repository.NewIdempotencyRepository(repo.Client(), cfg.DatabaseName)
+
+	// Initialize legal holds
+	legalHolds := service.NewLegalHoldService(repository.NewLegalHoldRepository(repo.Client(), cfg.DatabaseName))
+
 	// Initialize service
-	attachmentService := service.NewAttachmentService(repo, storageBackend, producer, cfg)
+	downloadSigner := service.NewDownloadSigner(cfg)
+	attachmentService := service.NewAttachmentService(repo, storageBackend, producer, policyResolver, quotaService, downloadSigner, legalHolds, cfg)
+
+	// Initialize batch upload sessions
+	sessionRepo := repository.NewUploadSessionRepository(repo.Client(), cfg.DatabaseName)
+	sessionService := service.NewUploadSessionService(sessionRepo, attachmentService)
+
+	// Initialize authorization
+	var channels service.ChannelMembership
+	if cfg.ChannelServiceURL != "" {
+		channels = service.NewHTTPChannelMembership(cfg.ChannelServiceURL, cfg.ChannelMembershipTTL)
+	}
+	authorizer := service.NewAuthorizer(repo, extRepo, channels)
+
+	// Initialize public share links
+	shareLinkAttempts := repository.NewShareLinkAttemptRepository(repo.Client(), cfg.DatabaseName)
+	shareLinkAccesses := repository.NewShareLinkAccessRepository(repo.Client(), cfg.DatabaseName)
+	shareLinkService := service.NewShareLinkService(repo, extRepo, shareLinkAttempts, shareLinkAccesses, storageBackend, cfg)
+	shareSweeper := service.NewShareSweeper(extRepo)
+
+	// Initialize API keys
+	apiKeyRepo := repository.NewAPIKeyRepository(repo.Client(), cfg.DatabaseName)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo, cfg)
+
+	// Initialize the outbox relay
+	outboxRelay := service.NewOutboxRelay(repository.NewOutboxRepository(repo.Client(), cfg.DatabaseName), producer, cfg)
+
+	// Initialize webhook delivery
+	webhookDispatcher := service.NewWebhookDispatcher(repository.NewWebhookRepository(repo.Client(), cfg.DatabaseName), cfg)
+
+	// Initialize retention policy enforcement
+	retentionEnforcer := service.NewRetentionEnforcer(repository.NewRetentionRepository(repo.Client(), cfg.DatabaseName), legalHolds, storageBackend, cfg)
+
+	// Initialize purging of the trash
+	trashPurger := service.NewTrashPurger(repository.NewTrashRepository(repo.Client(), cfg.DatabaseName), legalHolds, storageBackend)
+
+	// Initialize removal of expired ephemeral attachments
+	expirySweeper := service.NewExpirySweeper(repository.NewExpiryRepository(repo.Client(), cfg.DatabaseName), legalHolds, storageBackend)
+
+	// Initialize the consumer of upstream lifecycle events and of our own
+	// events for webhooks
+	lifecycleHandler := service.NewLifecycleHandler(repository.NewLifecycleRepository(repo.Client(), cfg.DatabaseName), cfg)
+	if cfg.KafkaConsumerGroup != "" {
+		handlers := lifecycleHandler.Handlers()
+		maps.Copy(handlers, webhookDispatcher.Handlers())
+		consumer, err := kafka.NewConsumer(cfg.KafkaBrokers, cfg.KafkaConsumerGroup, handlers)
+		if err != nil {
+			log.Printf("Warning: Failed to connect Kafka consumer: %v", err)
+		} else {
+			defer consumer.Close()
+			go consumer.Run(ctx)
+		}
+	}
+
+	// Background workers
+	go attachmentService.RunUploadReaper(ctx, 5*time.Minute)
+	go sessionService.RunSessionReaper(ctx, 5*time.Minute)
+	go usageReconciler.Run(ctx, cfg.UsageReconcileInterval)
+	go shareSweeper.Run(ctx, cfg.ShareSweepInterval)
+	go outboxRelay.Run(ctx, cfg.OutboxRelayInterval)
+	go trashPurger.Run(ctx, cfg.PurgeInterval)
+	go expirySweeper.Run(ctx, cfg.ExpirySweepInterval)
+	go webhookDispatcher.Run(ctx, cfg.WebhookDispatchInterval)
+	go retentionEnforcer.Run(ctx, cfg.RetentionInterval)
 
 	// Setup HTTP server
 	if os.Getenv("GIN_MODE") == "" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	authenticator, err := auth.NewAuthenticator(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure authentication: %v", err)
+	}
+	authenticator.UseAPIKeys(apiKeyService)
+
+	var limiter service.RateLimiter = service.NewMemoryRateLimiter()
+	if cfg.RateLimitStore == "mongo" {
+		limiter = service.NewMongoRateLimiter(repository.NewRateLimitRepository(repo.Client(), cfg.DatabaseName))
+	}
+	rateLimits := api.NewRateLimits(limiter, cfg)
+
 	router := gin.Default()
-	api.RegisterRoutes(router, attachmentService, cfg)
-	api.RegisterExtendedRoutes(router, extRepo)
-	api.RegisterExtendedRoutes2(router, extRepo, extRepo.Database())
+	router.Use(authenticator.Middleware("/health", "/s/", "/files/"), api.TenantScope(), api.AuditAPIKeys(apiKeyService), rateLimits.Requests("/health"))
+	api.RegisterRoutes(router, attachmentService, authorizer, idempotencyRepo, rateLimits, cfg)
+	api.RegisterExtendedRoutes(router, extRepo, authorizer, legalHolds, cfg)
+	api.RegisterExtendedRoutes2(router, extRepo, authorizer, extRepo.Database(), webhookDispatcher, retentionEnforcer)
+	api.RegisterPolicyRoutes(router, policyRepo, policyResolver)
+	api.RegisterQuotaRoutes(router, quotaService, usageReconciler)
+	api.RegisterUploadSessionRoutes(router, sessionService, authorizer, api.Idempotent(idempotencyRepo, cfg.IdempotencyTTL), rateLimits.UploadBytes())
+	api.RegisterShareLinkRoutes(router, shareLinkService, authorizer, rateLimits.SharePasswords())
+	api.RegisterFileRoutes(router, attachmentService, authenticator.Optional())
+	api.RegisterAPIKeyRoutes(router, apiKeyService)
+	api.RegisterLegalHoldRoutes(router, legalHolds)
+	api.RegisterMetricsRoutes(router, producer)
 
 	port := cfg.Port
+	srv := &http.Server{Addr: ":" + port, Handler: router}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
 	log.Printf("Attachment service starting on port %s", port)
-	if err := router.Run(":" + port); err != nil {
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }